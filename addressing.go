@@ -2,10 +2,14 @@ package emu
 
 import (
 	"fmt"
+
+	"github.com/zorchenhimer/emu-6502/disasm"
 )
 
 type AddressModeMeta struct {
 	Name     string
+	Format   string // operand syntax, with %s in place of the value
+	Mode     disasm.Mode
 	Asm      func(c *Core, oppc uint16) string
 	Address  func(c *Core) (uint16, uint8)
 	Size     func() int
@@ -14,6 +18,8 @@ type AddressModeMeta struct {
 
 var ADDR_Accumulator = AddressModeMeta{
 	Name: "Accumulator",
+	Format: "A",
+	Mode: disasm.Accumulator,
 	Asm: func(c *Core, oppc uint16) string {
 		return "A"
 	},
@@ -28,6 +34,8 @@ var ADDR_Accumulator = AddressModeMeta{
 
 var ADDR_Absolute = AddressModeMeta{
 	Name: "Absolute",
+	Format: "%s",
+	Mode: disasm.Absolute,
 	Asm: func(c *Core, oppc uint16) string {
 		return fmt.Sprintf("$%04X", c.ReadWord(oppc+1))
 	},
@@ -42,6 +50,8 @@ var ADDR_Absolute = AddressModeMeta{
 
 var ADDR_AbsoluteX = AddressModeMeta{
 	Name: "Absolute, X",
+	Format: "%s, X",
+	Mode: disasm.AbsoluteX,
 	Asm: func(c *Core, oppc uint16) string {
 		value := c.ReadWord(oppc + 1)
 		return fmt.Sprintf("$%04X, X @ $%04X",
@@ -60,6 +70,8 @@ var ADDR_AbsoluteX = AddressModeMeta{
 
 var ADDR_AbsoluteY = AddressModeMeta{
 	Name: "Absolute, Y",
+	Format: "%s, Y",
+	Mode: disasm.AbsoluteY,
 	Asm: func(c *Core, oppc uint16) string {
 		value := c.ReadWord(oppc + 1)
 		return fmt.Sprintf("$%04X, Y @ $%04X",
@@ -78,6 +90,8 @@ var ADDR_AbsoluteY = AddressModeMeta{
 
 var ADDR_Immediate = AddressModeMeta{
 	Name: "#Immediate",
+	Format: "#%s",
+	Mode: disasm.Immediate,
 	Asm: func(c *Core, oppc uint16) string {
 		return fmt.Sprintf("#$%02X", c.ReadByte(oppc+1))
 	},
@@ -92,6 +106,8 @@ var ADDR_Immediate = AddressModeMeta{
 
 var ADDR_Implied = AddressModeMeta{
 	Name: "Implied",
+	Format: "",
+	Mode: disasm.Implied,
 	Asm: func(c *Core, oppc uint16) string {
 		return ""
 	},
@@ -106,15 +122,17 @@ var ADDR_Implied = AddressModeMeta{
 
 var ADDR_Indirect = AddressModeMeta{
 	Name: "(Indirect)",
+	Format: "(%s)",
+	Mode: disasm.Indirect,
 	Asm: func(c *Core, oppc uint16) string {
 		value := c.ReadWord(oppc + 1)
 		return fmt.Sprintf("($%04X) @ $%04X",
 			value,
-			c.ReadWord(value),
+			c.indirectWord(value),
 		)
 	},
 	Address: func(c *Core) (uint16, uint8) {
-		return c.ReadWord(c.ReadWord(c.PC + 1)), 3
+		return c.indirectWord(c.ReadWord(c.PC + 1)), 3
 	},
 	Size: func() int { return 3 },
 	Decode: func(c *Core) string {
@@ -124,15 +142,17 @@ var ADDR_Indirect = AddressModeMeta{
 
 var ADDR_IndirectX = AddressModeMeta{
 	Name: "(Indirect, X)",
+	Format: "(%s, X)",
+	Mode: disasm.IndirectX,
 	Asm: func(c *Core, oppc uint16) string {
 		value := c.ReadByte(oppc + 1)
 		return fmt.Sprintf("($%02X, X) @ $%04X",
 			value,
-			c.ReadWord(uint16(value+c.X)),
+			c.zpWord(value+c.X),
 		)
 	},
 	Address: func(c *Core) (uint16, uint8) {
-		return c.ReadWord(uint16(c.ReadByte(c.PC+1) + c.X)), 2
+		return c.zpWord(c.ReadByte(c.PC+1) + c.X), 2
 	},
 	Size: func() int { return 2 },
 	Decode: func(c *Core) string {
@@ -142,15 +162,17 @@ var ADDR_IndirectX = AddressModeMeta{
 
 var ADDR_IndirectY = AddressModeMeta{
 	Name: "(Indirect), Y",
+	Format: "(%s), Y",
+	Mode: disasm.IndirectY,
 	Asm: func(c *Core, oppc uint16) string {
 		value := c.ReadByte(oppc + 1)
 		return fmt.Sprintf("($%02X), Y @ $%04X",
 			value,
-			c.ReadWord(uint16(value))+uint16(c.Y),
+			c.zpWord(value)+uint16(c.Y),
 		)
 	},
 	Address: func(c *Core) (uint16, uint8) {
-		return c.ReadWord(uint16(c.ReadByte(uint16(c.PC+1)))) + uint16(c.Y), 2
+		return c.zpWord(c.ReadByte(c.PC+1)) + uint16(c.Y), 2
 	},
 	Size: func() int { return 2 },
 	Decode: func(c *Core) string {
@@ -160,6 +182,8 @@ var ADDR_IndirectY = AddressModeMeta{
 
 var ADDR_ZeroPage = AddressModeMeta{
 	Name: "ZeroPage",
+	Format: "%s",
+	Mode: disasm.ZeroPage,
 	Asm: func(c *Core, oppc uint16) string {
 		value := c.ReadByte(oppc + 1)
 		return fmt.Sprintf("$%02X = %02X", value, c.ReadByte(uint16(value)))
@@ -175,6 +199,8 @@ var ADDR_ZeroPage = AddressModeMeta{
 
 var ADDR_ZeroPageX = AddressModeMeta{
 	Name: "ZeroPage, X",
+	Format: "%s, X",
+	Mode: disasm.ZeroPageX,
 	Asm: func(c *Core, oppc uint16) string {
 		value := c.ReadByte(oppc + 1)
 		return fmt.Sprintf("$%02X, X   @ $%04X",
@@ -193,6 +219,8 @@ var ADDR_ZeroPageX = AddressModeMeta{
 
 var ADDR_ZeroPageY = AddressModeMeta{
 	Name: "ZeroPage, Y",
+	Format: "%s, Y",
+	Mode: disasm.ZeroPageY,
 	Asm: func(c *Core, oppc uint16) string {
 		value := c.ReadByte(oppc + 1)
 		return fmt.Sprintf("$%02X, Y   @ $%04X",
@@ -211,6 +239,8 @@ var ADDR_ZeroPageY = AddressModeMeta{
 
 var ADDR_Relative = AddressModeMeta{
 	Name: "Relative",
+	Format: "%s",
+	Mode: disasm.Relative,
 	Asm: func(c *Core, oppc uint16) string {
 		value := c.addrRelative(oppc, c.ReadByte(oppc+1))
 		n, neg := TwosCompInv(c.ReadByte(oppc + 1))
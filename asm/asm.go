@@ -0,0 +1,631 @@
+// Package asm is a small two pass 6502 assembler that understands a subset of
+// ca65 syntax: labels, cheap local labels (@name), constants (name = expr),
+// .org, .byte, .word and .res, and expressions with the < and > operators.
+package asm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	emu "github.com/zorchenhimer/emu-6502"
+	"github.com/zorchenhimer/emu-6502/disasm"
+	"github.com/zorchenhimer/emu-6502/labels"
+)
+
+// Error is an error on a specific line of the source.
+type Error struct {
+	Line int
+	Err  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+// Program is the assembled output.
+type Program struct {
+	// Address of the first byte.  Gaps between .org blocks are zero filled.
+	Origin uint16
+	Bytes  []byte
+
+	// Label addresses, keyed by name.  Cheap locals are named
+	// "Parent@local".
+	Labels map[string]uint16
+
+	// Everything defined with name = expr
+	Constants map[string]int
+}
+
+// Image returns a full 64K memory image with the program at its origin.
+func (p *Program) Image() []byte {
+	img := make([]byte, 0x10000)
+	copy(img[p.Origin:], p.Bytes)
+	return img
+}
+
+// LabelMap returns the program's labels in the format used by the memory
+// managers for label lookups.  Cheap locals are left out, and when more than
+// one label has the same address the first alphabetically is used.
+func (p *Program) LabelMap() labels.LabelMap {
+	names := []string{}
+	for name := range p.Labels {
+		if !strings.Contains(name, "@") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	lm := make(labels.LabelMap)
+	for _, name := range names {
+		addr := uint(p.Labels[name])
+		if _, ok := lm[addr]; !ok {
+			lm[addr] = &labels.Label{Name: name, Size: 1}
+		}
+	}
+	return lm
+}
+
+const lastPass = 3
+
+type symbol struct {
+	value int
+	known bool
+	label bool
+}
+
+type assembler struct {
+	ops map[string]map[disasm.Mode]byte
+
+	pass  int
+	line  int
+	pc    int
+	scope string
+
+	symbols map[string]*symbol
+
+	// Addressing mode picked for each line on the first pass.  Forward
+	// references are assumed to be absolute.
+	modes map[int]disasm.Mode
+
+	mem     [0x10000]byte
+	written [0x10000]bool
+}
+
+// Assemble assembles src for the instruction set the emulator implements.
+func Assemble(src string) (*Program, error) {
+	return AssembleVariant(src, emuVariant)
+}
+
+var emuVariant = emu.Variant()
+
+// AssembleVariant assembles src for the given instruction set, eg
+// disasm.CMOS(emu.Variant()) for the 65C02.
+func AssembleVariant(src string, v *disasm.Variant) (*Program, error) {
+	a := &assembler{
+		ops:     make(map[string]map[disasm.Mode]byte),
+		symbols: make(map[string]*symbol),
+		modes:   make(map[int]disasm.Mode),
+	}
+
+	for op := 0; op < 256; op++ {
+		def, ok := v.Lookup(byte(op))
+		if !ok {
+			continue
+		}
+		if _, ok := a.ops[def.Name]; !ok {
+			a.ops[def.Name] = make(map[disasm.Mode]byte)
+		}
+		a.ops[def.Name][def.Mode] = byte(op)
+	}
+
+	// The first pass picks addressing modes, the second resolves constants
+	// that refer to later labels, and the last one writes the output.
+	lines := strings.Split(src, "\n")
+	for a.pass = 1; a.pass <= lastPass; a.pass++ {
+		a.pc = 0
+		a.scope = ""
+		for i, line := range lines {
+			a.line = i + 1
+			if err := a.statement(line); err != nil {
+				return nil, &Error{Line: a.line, Err: err.Error()}
+			}
+		}
+	}
+
+	return a.program(), nil
+}
+
+func (a *assembler) program() *Program {
+	p := &Program{
+		Labels:    make(map[string]uint16),
+		Constants: make(map[string]int),
+	}
+
+	for name, sym := range a.symbols {
+		if sym.label {
+			p.Labels[name] = uint16(sym.value)
+		} else {
+			p.Constants[name] = sym.value
+		}
+	}
+
+	start, end := -1, -1
+	for i := 0; i < len(a.written); i++ {
+		if a.written[i] {
+			if start < 0 {
+				start = i
+			}
+			end = i
+		}
+	}
+
+	if start >= 0 {
+		p.Origin = uint16(start)
+		p.Bytes = make([]byte, end-start+1)
+		copy(p.Bytes, a.mem[start:end+1])
+	}
+	return p
+}
+
+// qualify adds the current scope to cheap local names.
+func (a *assembler) qualify(name string) string {
+	if strings.HasPrefix(name, "@") {
+		return a.scope + name
+	}
+	return name
+}
+
+func (a *assembler) lookup(name string) (int, bool, error) {
+	sym, ok := a.symbols[a.qualify(name)]
+	if ok && sym.known {
+		return sym.value, true, nil
+	}
+
+	if a.pass == lastPass {
+		return 0, false, fmt.Errorf("undefined symbol %q", name)
+	}
+	return 0, false, nil
+}
+
+func (a *assembler) define(name string, value int, known, label bool) error {
+	if label && !strings.HasPrefix(name, "@") {
+		a.scope = name
+	}
+	name = a.qualify(name)
+
+	sym, ok := a.symbols[name]
+	if !ok {
+		a.symbols[name] = &symbol{value: value, known: known, label: label}
+		return nil
+	}
+
+	if a.pass == 1 {
+		return fmt.Errorf("%q is already defined", name)
+	}
+
+	if sym.known && sym.value != value {
+		return fmt.Errorf("value of %q changed between passes", name)
+	}
+	sym.value = value
+	sym.known = known
+	return nil
+}
+
+func (a *assembler) emit(values ...byte) error {
+	for _, b := range values {
+		if a.pc > 0xFFFF {
+			return fmt.Errorf("program counter past $FFFF")
+		}
+
+		if a.pass == lastPass {
+			if a.written[a.pc] {
+				return fmt.Errorf("overlapping output at $%04X", a.pc)
+			}
+			a.mem[a.pc] = b
+			a.written[a.pc] = true
+		}
+		a.pc++
+	}
+	return nil
+}
+
+// stripComment removes a ; comment that isn't in a string or character.
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch {
+		case quote != 0 && line[i] == quote:
+			quote = 0
+		case quote != 0:
+		case line[i] == '"' || line[i] == '\'':
+			quote = line[i]
+		case line[i] == ';':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func (a *assembler) statement(line string) error {
+	line = strings.TrimSpace(stripComment(line))
+
+	// Labels.  More than one may be on a line.
+	for {
+		end := 0
+		for end < len(line) && (end == 0 && isSymbolStart(line[end]) || end > 0 && isSymbolChar(line[end])) {
+			end++
+		}
+		if end == 0 || end >= len(line) || line[end] != ':' {
+			break
+		}
+
+		if err := a.define(line[:end], a.pc, true, true); err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line[end+1:])
+	}
+
+	if line == "" {
+		return nil
+	}
+
+	// Constants
+	if eq := strings.Index(line, "="); eq > 0 && isSymbol(strings.TrimSpace(line[:eq])) {
+		name := strings.TrimSpace(line[:eq])
+		tokens, err := tokenize(line[eq+1:])
+		if err != nil {
+			return err
+		}
+		val, known, err := a.eval(tokens)
+		if err != nil {
+			return err
+		}
+		return a.define(name, val, known, false)
+	}
+
+	word := line
+	operand := ""
+	if idx := strings.IndexAny(line, " \t"); idx >= 0 {
+		word = line[:idx]
+		operand = strings.TrimSpace(line[idx+1:])
+	}
+
+	if strings.HasPrefix(word, ".") {
+		return a.directive(strings.ToLower(word), operand)
+	}
+	return a.instruction(strings.ToUpper(word), operand)
+}
+
+// splitArgs splits on commas that aren't in parentheses or strings.
+func splitArgs(s string) []string {
+	args := []string{}
+	depth := 0
+	quote := byte(0)
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0 && s[i] == quote:
+			quote = 0
+		case quote != 0:
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == '(':
+			depth++
+		case s[i] == ')':
+			depth--
+		case s[i] == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(args, strings.TrimSpace(s[start:]))
+}
+
+// value evaluates a single expression.  Before the last pass unknown values
+// are returned as zero.
+func (a *assembler) value(src string) (int, bool, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return 0, false, err
+	}
+	return a.eval(tokens)
+}
+
+// firstPass evaluates an expression that must be known on the first pass
+// because it changes the size of the output.
+func (a *assembler) firstPass(src string) (int, error) {
+	val, known, err := a.value(src)
+	if err != nil {
+		return 0, err
+	}
+	if !known {
+		return 0, fmt.Errorf("%q must be defined before it is used here", src)
+	}
+	return val, nil
+}
+
+func (a *assembler) directive(name, operand string) error {
+	switch name {
+	case ".org":
+		val, err := a.firstPass(operand)
+		if err != nil {
+			return err
+		}
+		if val < 0 || val > 0xFFFF {
+			return fmt.Errorf("origin out of range: %d", val)
+		}
+		a.pc = val
+
+	case ".byte", ".byt", ".db":
+		for _, arg := range splitArgs(operand) {
+			if strings.HasPrefix(arg, "\"") && strings.HasSuffix(arg, "\"") && len(arg) >= 2 {
+				if err := a.emit([]byte(arg[1 : len(arg)-1])...); err != nil {
+					return err
+				}
+				continue
+			}
+
+			val, _, err := a.value(arg)
+			if err != nil {
+				return err
+			}
+			if val < -128 || val > 0xFF {
+				return fmt.Errorf("byte value out of range: %d", val)
+			}
+			if err := a.emit(byte(val)); err != nil {
+				return err
+			}
+		}
+
+	case ".word", ".addr", ".dw":
+		for _, arg := range splitArgs(operand) {
+			val, _, err := a.value(arg)
+			if err != nil {
+				return err
+			}
+			if val < -0x8000 || val > 0xFFFF {
+				return fmt.Errorf("word value out of range: %d", val)
+			}
+			if err := a.emit(byte(val), byte(val>>8)); err != nil {
+				return err
+			}
+		}
+
+	case ".res":
+		args := splitArgs(operand)
+		if len(args) > 2 {
+			return fmt.Errorf("too many arguments for .res")
+		}
+
+		count, err := a.firstPass(args[0])
+		if err != nil {
+			return err
+		}
+		if count < 0 {
+			return fmt.Errorf("negative .res count")
+		}
+
+		fill := 0
+		if len(args) == 2 {
+			fill, _, err = a.value(args[1])
+			if err != nil {
+				return err
+			}
+		}
+
+		for i := 0; i < count; i++ {
+			if err := a.emit(byte(fill)); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("unknown directive %s", name)
+	}
+	return nil
+}
+
+// Addressing modes that share the same syntax, smallest first.
+var modeGroups = map[string][]disasm.Mode{
+	"":     {disasm.ZeroPage, disasm.Absolute},
+	",X":   {disasm.ZeroPageX, disasm.AbsoluteX},
+	",Y":   {disasm.ZeroPageY, disasm.AbsoluteY},
+	"(,X)": {disasm.IndirectX, disasm.AbsoluteIndirectX},
+	"(),Y": {disasm.IndirectY},
+	"()":   {disasm.ZeroPageIndirect, disasm.Indirect},
+}
+
+// operandSyntax works out which group of addressing modes the operand uses
+// and returns the bare expression.
+func operandSyntax(operand string) (string, string) {
+	upper := strings.ToUpper(strings.Replace(operand, " ", "", -1))
+
+	if strings.HasPrefix(operand, "(") {
+		// Find the parenthesis that closes the first one.
+		depth := 0
+		for i := 0; i < len(operand); i++ {
+			if operand[i] == '(' {
+				depth++
+			} else if operand[i] == ')' {
+				depth--
+				if depth == 0 {
+					inner := strings.TrimSpace(operand[1:i])
+					rest := strings.ToUpper(strings.Replace(operand[i+1:], " ", "", -1))
+					args := splitArgs(inner)
+
+					switch {
+					case rest == "" && len(args) == 2 && strings.EqualFold(args[1], "X"):
+						return "(,X)", args[0]
+					case rest == "":
+						return "()", inner
+					case rest == ",Y":
+						return "(),Y", inner
+					}
+					break
+				}
+			}
+		}
+	}
+
+	args := splitArgs(operand)
+	if len(args) == 2 {
+		if strings.HasSuffix(upper, ",X") {
+			return ",X", args[0]
+		}
+		if strings.HasSuffix(upper, ",Y") {
+			return ",Y", args[0]
+		}
+	}
+	return "", operand
+}
+
+func (a *assembler) instruction(name, operand string) error {
+	modes, ok := a.ops[name]
+	if !ok {
+		return fmt.Errorf("unknown instruction %s", name)
+	}
+
+	has := func(m disasm.Mode) bool {
+		_, ok := modes[m]
+		return ok
+	}
+
+	switch {
+	case operand == "" || strings.EqualFold(operand, "A") && has(disasm.Accumulator):
+		if has(disasm.Accumulator) {
+			return a.emit(modes[disasm.Accumulator])
+		}
+		if has(disasm.Implied) && operand == "" {
+			return a.emit(modes[disasm.Implied])
+		}
+		return fmt.Errorf("%s needs an operand", name)
+
+	case strings.HasPrefix(operand, "#"):
+		if !has(disasm.Immediate) {
+			return fmt.Errorf("%s doesn't have an immediate mode", name)
+		}
+		val, _, err := a.value(operand[1:])
+		if err != nil {
+			return err
+		}
+		if val < -128 || val > 0xFF {
+			return fmt.Errorf("immediate value out of range: %d", val)
+		}
+		return a.emit(modes[disasm.Immediate], byte(val))
+
+	case has(disasm.ZeroPageRelative):
+		args := splitArgs(operand)
+		if len(args) != 2 {
+			return fmt.Errorf("%s needs a zero page address and a branch target", name)
+		}
+		zp, _, err := a.value(args[0])
+		if err != nil {
+			return err
+		}
+		if zp < 0 || zp > 0xFF {
+			return fmt.Errorf("zero page address out of range: %d", zp)
+		}
+		offset, err := a.branch(args[1], 3)
+		if err != nil {
+			return err
+		}
+		return a.emit(modes[disasm.ZeroPageRelative], byte(zp), offset)
+
+	case has(disasm.Relative):
+		offset, err := a.branch(operand, 2)
+		if err != nil {
+			return err
+		}
+		return a.emit(modes[disasm.Relative], offset)
+	}
+
+	syntax, src := operandSyntax(operand)
+
+	// Address size override
+	force := ""
+	if len(src) > 2 && src[1] == ':' && strings.ContainsAny(src[:1], "azAZ") {
+		force = strings.ToLower(src[:1])
+		src = src[2:]
+	}
+
+	val, known, err := a.value(src)
+	if err != nil {
+		return err
+	}
+
+	mode, ok := a.modes[a.line]
+	if a.pass == 1 || !ok {
+		mode, err = a.pickMode(modes, modeGroups[syntax], val, known, force)
+		if err != nil {
+			return fmt.Errorf("%s %s: %v", name, operand, err)
+		}
+		a.modes[a.line] = mode
+	}
+
+	if mode.Size() == 2 {
+		if a.pass == lastPass && (val < 0 || val > 0xFF) {
+			return fmt.Errorf("zero page address out of range: $%X", val)
+		}
+		return a.emit(modes[mode], byte(val))
+	}
+
+	if a.pass == lastPass && (val < 0 || val > 0xFFFF) {
+		return fmt.Errorf("address out of range: $%X", val)
+	}
+	return a.emit(modes[mode], byte(val), byte(val>>8))
+}
+
+// pickMode chooses between the zero page and absolute versions of a mode.
+func (a *assembler) pickMode(modes map[disasm.Mode]byte, group []disasm.Mode, val int, known bool, force string) (disasm.Mode, error) {
+	avail := []disasm.Mode{}
+	for _, m := range group {
+		if _, ok := modes[m]; ok {
+			avail = append(avail, m)
+		}
+	}
+
+	if len(avail) == 0 {
+		return 0, fmt.Errorf("invalid addressing mode")
+	}
+
+	small, large := avail[0], avail[len(avail)-1]
+	switch {
+	case force == "z":
+		if small.Size() != 2 {
+			return 0, fmt.Errorf("no zero page mode")
+		}
+		return small, nil
+	case force == "a":
+		if large.Size() != 3 {
+			return 0, fmt.Errorf("no absolute mode")
+		}
+		return large, nil
+	case small.Size() == 2 && known && val >= 0 && val <= 0xFF:
+		return small, nil
+	case large.Size() == 3:
+		return large, nil
+	}
+	return small, nil
+}
+
+// branch returns the relative offset to target for an instruction of the
+// given size at the current PC.
+func (a *assembler) branch(target string, size int) (byte, error) {
+	val, known, err := a.value(target)
+	if err != nil {
+		return 0, err
+	}
+	if !known {
+		return 0, nil
+	}
+
+	offset := val - (a.pc + size)
+	if offset < -128 || offset > 127 {
+		if a.pass != lastPass {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("branch out of range (%d bytes)", offset)
+	}
+	return byte(offset), nil
+}
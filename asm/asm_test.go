@@ -0,0 +1,141 @@
+package asm_test
+
+import (
+	"bytes"
+	"testing"
+
+	emu "github.com/zorchenhimer/emu-6502"
+	"github.com/zorchenhimer/emu-6502/asm"
+	"github.com/zorchenhimer/emu-6502/disasm"
+)
+
+const testSource = `
+PPUCTRL = $2000
+ptr     = $10
+count   = end - start   ; forward reference
+
+    .org $C000
+start:
+    LDX #count
+    LDA #<table
+    STA ptr
+    LDA #>table
+    STA ptr+1
+@loop:
+    LDA (ptr), Y
+    STA PPUCTRL
+    STA a:ptr
+    DEX
+    BNE @loop
+    JMP later
+other:
+@loop:
+    BEQ @loop
+later:
+    ASL
+    ROL A
+    RTS
+end:
+table:
+    .byte 1, "AB", 'c', -1
+    .word table, $1234
+    .res 2, $EA
+`
+
+func TestAssemble(t *testing.T) {
+	prog, err := asm.Assemble(testSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []byte{
+		0xA2, 0x1D, // LDX #count
+		0xA9, 0x1D, // LDA #<table
+		0x85, 0x10, // STA ptr
+		0xA9, 0xC0, // LDA #>table
+		0x85, 0x11, // STA ptr+1
+		0xB1, 0x10, // @loop: LDA (ptr), Y
+		0x8D, 0x00, 0x20, // STA PPUCTRL
+		0x8D, 0x10, 0x00, // STA a:ptr
+		0xCA,       // DEX
+		0xD0, 0xF5, // BNE @loop
+		0x4C, 0x1A, 0xC0, // JMP later
+		0xF0, 0xFE, // other: @loop: BEQ @loop
+		0x0A, 0x2A, 0x60, // ASL, ROL A, RTS
+		0x01, 0x41, 0x42, 0x63, 0xFF,
+		0x1D, 0xC0, 0x34, 0x12,
+		0xEA, 0xEA,
+	}
+
+	if prog.Origin != 0xC000 {
+		t.Errorf("expected origin $C000, got $%04X", prog.Origin)
+	}
+
+	if !bytes.Equal(prog.Bytes, expect) {
+		t.Errorf("output mismatch\nexpected % X\n     got % X", expect, prog.Bytes)
+	}
+
+	if prog.Labels["other@loop"] != 0xC018 {
+		t.Errorf("expected other@loop at $C018, got $%04X", prog.Labels["other@loop"])
+	}
+
+	lm := prog.LabelMap()
+	if lbl, ok := lm[0xC01A]; !ok || lbl.Name != "later" {
+		t.Errorf("expected label \"later\" at $C01A, got %v", lbl)
+	}
+	if _, ok := lm[0xC00A]; ok {
+		t.Errorf("cheap locals should not be in the label map")
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		line int
+	}{
+		{"  LDA #$100", 1},
+		{"  NOP\n  LDA undefined", 2},
+		{"foo:\nfoo:", 2},
+		{"  BNE far\n  .res 200\nfar:", 1},
+		{"  LDA ($1234), Y", 1},
+		{"  ROR #1", 1},
+		{"  .res later\nlater:", 1},
+		{"  .org $10\n  NOP\n  .org $10\n  NOP", 4},
+		{"  FOO", 1},
+	}
+
+	for _, tc := range tests {
+		_, err := asm.Assemble(tc.src)
+		asmErr, ok := err.(*asm.Error)
+		if !ok {
+			t.Errorf("%q: expected an *asm.Error, got %v", tc.src, err)
+			continue
+		}
+		if asmErr.Line != tc.line {
+			t.Errorf("%q: expected error on line %d, got %v", tc.src, tc.line, asmErr)
+		}
+	}
+}
+
+// Everything the emulator implements must assemble back to the same bytes
+// it disassembles from.
+func TestEmulatorAgreement(t *testing.T) {
+	v := emu.Variant()
+	for op := 0; op < 256; op++ {
+		data := []byte{byte(op), 0x12, 0x34}
+		instr := disasm.Decode(data, 0x8000, v)
+		if !instr.Valid() {
+			continue
+		}
+
+		prog, err := asm.AssembleVariant("  .org $8000\n  "+instr.String(), v)
+		if err != nil {
+			t.Errorf("$%02X %s: %v", op, instr, err)
+			continue
+		}
+
+		if !bytes.Equal(prog.Bytes, instr.Bytes) {
+			t.Errorf("$%02X %s: expected % X got % X", op, instr, instr.Bytes, prog.Bytes)
+		}
+	}
+}
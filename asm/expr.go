@@ -0,0 +1,279 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokNumber tokenKind = iota
+	tokSymbol
+	tokOperator
+	tokString
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	value int
+}
+
+// tokenize splits an operand or expression into tokens.
+func tokenize(src string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(src); {
+		ch := src[i]
+		switch {
+		case ch == ' ' || ch == '\t':
+			i++
+
+		case ch == '$' || ch == '%' || ch >= '0' && ch <= '9':
+			base := 10
+			start := i
+			if ch == '$' {
+				base = 16
+				i++
+				start = i
+			} else if ch == '%' {
+				base = 2
+				i++
+				start = i
+			}
+			for i < len(src) && isDigit(src[i], base) {
+				i++
+			}
+			if start == i {
+				return nil, fmt.Errorf("invalid number at %q", src[start-1:])
+			}
+			val, err := strconv.ParseInt(src[start:i], base, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q", src[start:i])
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[start:i], value: int(val)})
+
+		case ch == '\'':
+			if i+2 >= len(src) || src[i+2] != '\'' {
+				return nil, fmt.Errorf("invalid character constant")
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[i : i+3], value: int(src[i+1])})
+			i += 3
+
+		case ch == '"':
+			end := strings.IndexByte(src[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, token{kind: tokString, text: src[i+1 : i+1+end]})
+			i += end + 2
+
+		case isSymbolStart(ch):
+			start := i
+			i++
+			for i < len(src) && isSymbolChar(src[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokSymbol, text: src[start:i]})
+
+		default:
+			op := string(ch)
+			if i+1 < len(src) && (src[i:i+2] == "<<" || src[i:i+2] == ">>") {
+				op = src[i : i+2]
+			}
+			if !strings.Contains("+-*/&|^~<>(),#", string(ch)) {
+				return nil, fmt.Errorf("unexpected character %q", ch)
+			}
+			tokens = append(tokens, token{kind: tokOperator, text: op})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+func isDigit(ch byte, base int) bool {
+	switch base {
+	case 2:
+		return ch == '0' || ch == '1'
+	case 16:
+		return ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'f' || ch >= 'A' && ch <= 'F'
+	}
+	return ch >= '0' && ch <= '9'
+}
+
+func isSymbolStart(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch == '_' || ch == '@'
+}
+
+func isSymbolChar(ch byte) bool {
+	return isSymbolStart(ch) || ch >= '0' && ch <= '9'
+}
+
+func isSymbol(s string) bool {
+	if s == "" || !isSymbolStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isSymbolChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// expr is an expression being evaluated.  Undefined symbols are only an error
+// on the last pass; before that the value is just unknown.
+type expr struct {
+	tokens []token
+	pos    int
+	a      *assembler
+	known  bool
+}
+
+// eval evaluates tokens.  known is false if a symbol isn't defined yet.
+func (a *assembler) eval(tokens []token) (value int, known bool, err error) {
+	if len(tokens) == 0 {
+		return 0, false, fmt.Errorf("missing expression")
+	}
+
+	e := &expr{tokens: tokens, a: a, known: true}
+	value, err = e.binary(0)
+	if err != nil {
+		return 0, false, err
+	}
+	if e.pos < len(e.tokens) {
+		return 0, false, fmt.Errorf("unexpected %q", e.tokens[e.pos].text)
+	}
+	return value, e.known, nil
+}
+
+var precedence = map[string]int{
+	"|": 1, "^": 2, "&": 3,
+	"<<": 4, ">>": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6,
+}
+
+func (e *expr) peek() *token {
+	if e.pos >= len(e.tokens) {
+		return nil
+	}
+	return &e.tokens[e.pos]
+}
+
+func (e *expr) binary(min int) (int, error) {
+	left, err := e.unary()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		tok := e.peek()
+		if tok == nil || tok.kind != tokOperator {
+			return left, nil
+		}
+
+		prec, ok := precedence[tok.text]
+		if !ok || prec <= min {
+			return left, nil
+		}
+		e.pos++
+
+		right, err := e.binary(prec)
+		if err != nil {
+			return 0, err
+		}
+
+		switch tok.text {
+		case "|":
+			left |= right
+		case "^":
+			left ^= right
+		case "&":
+			left &= right
+		case "<<":
+			left <<= uint(right)
+		case ">>":
+			left >>= uint(right)
+		case "+":
+			left += right
+		case "-":
+			left -= right
+		case "*":
+			left *= right
+		case "/":
+			if right == 0 {
+				if e.known {
+					return 0, fmt.Errorf("division by zero")
+				}
+				right = 1
+			}
+			left /= right
+		}
+	}
+}
+
+func (e *expr) unary() (int, error) {
+	tok := e.peek()
+	if tok == nil {
+		return 0, fmt.Errorf("unexpected end of expression")
+	}
+	e.pos++
+
+	switch tok.kind {
+	case tokNumber:
+		return tok.value, nil
+
+	case tokString:
+		if len(tok.text) != 1 {
+			return 0, fmt.Errorf("string in expression")
+		}
+		return int(tok.text[0]), nil
+
+	case tokSymbol:
+		val, ok, err := e.a.lookup(tok.text)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			e.known = false
+		}
+		return val, nil
+	}
+
+	switch tok.text {
+	case "*":
+		return int(e.a.pc), nil
+
+	case "(":
+		val, err := e.binary(0)
+		if err != nil {
+			return 0, err
+		}
+		if next := e.peek(); next == nil || next.text != ")" {
+			return 0, fmt.Errorf("missing )")
+		}
+		e.pos++
+		return val, nil
+	}
+
+	val, err := e.unary()
+	if err != nil {
+		return 0, err
+	}
+
+	switch tok.text {
+	case "-":
+		return -val, nil
+	case "+":
+		return val, nil
+	case "~":
+		return ^val, nil
+	case "<":
+		return val & 0xFF, nil
+	case ">":
+		return (val >> 8) & 0xFF, nil
+	}
+	return 0, fmt.Errorf("unexpected %q", tok.text)
+}
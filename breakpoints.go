@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"strings"
 	"fmt"
+
+	"github.com/zorchenhimer/emu-6502/mmu"
 )
 
 const (
@@ -34,12 +36,35 @@ type Breakpoint struct {
 	Address uint16
 	Callback BreakpointCallback
 	Name string
+
+	// Only break when PrgOffset is mapped at Address
+	Banked bool
+	PrgOffset uint32
 }
 
 func (b Breakpoint) String() string {
+	if b.Banked {
+		return fmt.Sprintf("$%04X (PRG $%05X) [%s] %s", b.Address, b.PrgOffset, EventToString(b.Type), b.Name)
+	}
 	return fmt.Sprintf("$%04X [%s] %s", b.Address, EventToString(b.Type), b.Name)
 }
 
+// mapped returns true if the breakpoint's bank is mapped in.  Memory
+// without bank switching always matches.
+func (b Breakpoint) mapped(c *Core) bool {
+	if !b.Banked {
+		return true
+	}
+
+	pm, ok := c.memory.(mmu.PrgMapper)
+	if !ok {
+		return true
+	}
+
+	offset, ok := pm.PrgOffset(b.Address)
+	return ok && offset == b.PrgOffset
+}
+
 type Breakpoints struct {
 	registered map[uint16][]Breakpoint
 }
@@ -57,12 +82,30 @@ func (b *Breakpoints) String() string {
 
 func (b *Breakpoints) Register(t uint8, name string, address uint16, fn BreakpointCallback) {
 	fmt.Printf("Registering %s breakpoint at $%04X\n", EventToString(t), address)
-	nbp := Breakpoint{
+	b.add(Breakpoint{
 		Type: t,
 		Address: address,
 		Name: name,
 		Callback: fn,
-	}
+	})
+}
+
+// RegisterBanked adds a breakpoint that only fires when the given PRG ROM
+// offset is mapped at the address.
+func (b *Breakpoints) RegisterBanked(t uint8, name string, address uint16, offset uint32, fn BreakpointCallback) {
+	b.add(Breakpoint{
+		Type: t,
+		Address: address,
+		Name: name,
+		Callback: fn,
+		Banked: true,
+		PrgOffset: offset,
+	})
+}
+
+func (b *Breakpoints) add(nbp Breakpoint) {
+	name := nbp.Name
+	address := nbp.Address
 
 	if b.registered == nil {
 		b.registered = make(map[uint16][]Breakpoint)
@@ -116,13 +159,67 @@ func (b *Breakpoints) runBreakpoints(c *Core, t uint8, address uint16, value uin
 	}
 
 	for _, bp := range bplst {
-		if bp.Type & t == 0 {
+		if bp.Type & t == 0 || !bp.mapped(c) {
 			continue
 		}
 		bp.Callback(c, t, value)
 	}
 }
 
+// clone copies the registered breakpoints.  Callbacks are shared.
+func (b *Breakpoints) clone() *Breakpoints {
+	nb := &Breakpoints{registered: make(map[uint16][]Breakpoint)}
+	for addr, lst := range b.registered {
+		nb.registered[addr] = append([]Breakpoint{}, lst...)
+	}
+	return nb
+}
+
 func (b *Breakpoints) Clear() {
 	b.registered = make(map[uint16][]Breakpoint)
 }
+
+// BreakOnSymbol adds a breakpoint on a label from the debug symbols.  With
+// bank switched ROMs a name can be in more than one bank, so bank picks
+// which one.  A bank of -1 breaks on all of them, each only when its own
+// bank is mapped in.
+func (c *Core) BreakOnSymbol(t uint8, name string, bank int, fn BreakpointCallback) error {
+	if c.Symbols == nil {
+		return fmt.Errorf("No debug symbols loaded")
+	}
+
+	syms := c.Symbols.SymbolsNamed(name)
+	if len(syms) == 0 {
+		return fmt.Errorf("Symbol %q does not exist", name)
+	}
+
+	bankSize := uint32(0)
+	if pm, ok := c.memory.(mmu.PrgMapper); ok {
+		bankSize = pm.PrgBankSize()
+	}
+
+	found := false
+	for _, sym := range syms {
+		offset, ok := c.Symbols.PrgOffset(sym)
+		if !ok {
+			if bank < 0 {
+				c.Breakpoints.Register(t, name, sym.Value, fn)
+				found = true
+			}
+			continue
+		}
+
+		if bank >= 0 && (bankSize == 0 || offset / bankSize != uint32(bank)) {
+			continue
+		}
+
+		bpName := fmt.Sprintf("%s (PRG $%05X)", name, offset)
+		c.Breakpoints.RegisterBanked(t, bpName, sym.Value, offset, fn)
+		found = true
+	}
+
+	if !found {
+		return fmt.Errorf("Symbol %q isn't in bank %d", name, bank)
+	}
+	return nil
+}
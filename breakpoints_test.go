@@ -0,0 +1,200 @@
+package emu
+
+import (
+	"testing"
+
+	"github.com/zorchenhimer/emu-6502/mappers"
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+// An MMC1 ROM matching testdata/banked.dbg.  Init is in banks 0 and 1,
+// and Reset in the last bank calls it.  Bank 2 has a copy of Reset for PRG
+// mode 2, where the last bank isn't fixed.
+func newBankedCore(t *testing.T) (*Core, *mappers.MMC1) {
+	rom := make([]byte, 0x10000)
+	rom[0x0000] = 0x60 // bank 0 Init: RTS
+	rom[0x4000] = 0x60 // bank 1 Init: RTS
+	copy(rom[0xC000:], []byte{0x20, 0x00, 0x80, 0x4C, 0x03, 0xC0})
+	copy(rom[0x8000:], rom[0xC000:0xC006])
+
+	mapper, err := mappers.NewMMC1(rom, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sym, err := NewSymbols("testdata/banked.dbg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCore(mmu.NewNES(mapper))
+	c.UseSymbols(sym)
+	return c, mapper.(*mappers.MMC1)
+}
+
+func TestBankedLabels(t *testing.T) {
+	c, mmc1 := newBankedCore(t)
+
+	expect := []struct {
+		mode    uint8
+		bank    uint8
+		address uint16
+		name    string
+	}{
+		{3, 0, 0x8000, "Init"},
+		{3, 0, 0x8002, "Init+2"},
+		{3, 1, 0x8000, "Init"},
+		{3, 1, 0x8002, "Other"},
+		{3, 1, 0x8003, "Other+1"},
+		{3, 2, 0x8000, "$8000"},
+		{3, 2, 0xC001, "Reset+1"},
+
+		// Bank 0 is fixed at $8000 and the switchable bank is at $C000.
+		{2, 1, 0x8002, "Init+2"},
+		{2, 1, 0xC000, "Init"},
+		{2, 1, 0xC002, "Other"},
+		{2, 3, 0xC000, "Reset"},
+		{2, 3, 0xC001, "Reset+1"},
+	}
+
+	for _, e := range expect {
+		mmc1.PrgBankMode = e.mode
+		mmc1.PrgBank = e.bank
+		if lbl := c.memory.GetLabel(e.address); lbl != e.name {
+			t.Errorf("mode %d bank %d $%04X: expected %q, got %q", e.mode, e.bank, e.address, e.name, lbl)
+		}
+	}
+
+	if n := len(c.Symbols.SymbolsNamed("Init")); n != 2 {
+		t.Errorf("expected two Init symbols, got %d", n)
+	}
+}
+
+func TestBankedBreakpoint(t *testing.T) {
+	c, mmc1 := newBankedCore(t)
+
+	hits := 0
+	err := c.BreakOnSymbol(EXECUTE, "Init", 1, func(c *Core, event uint8, value uint8) { hits++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.BreakOnSymbol(EXECUTE, "Init", 2, nil); err == nil {
+		t.Errorf("expected an error for Init in bank 2")
+	}
+
+	for _, bank := range []uint8{0, 1, 2} {
+		mmc1.PrgBank = bank
+		c.PC = 0xC000
+		for i := 0; i < 2; i++ {
+			if err := c.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if hits != 1 {
+		t.Errorf("expected one hit in bank 1, got %d", hits)
+	}
+}
+
+func TestBankedBreakpointMode2(t *testing.T) {
+	c, mmc1 := newBankedCore(t)
+	mmc1.PrgBankMode = 2
+
+	hits := 0
+	err := c.BreakOnSymbol(EXECUTE, "Reset", -1, func(c *Core, event uint8, value uint8) { hits++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reset's code is in banks 2 and 3, but only bank 3 is Reset.
+	for _, bank := range []uint8{2, 3} {
+		mmc1.PrgBank = bank
+		c.PC = 0xC000
+		for i := 0; i < 2; i++ {
+			if err := c.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if hits != 1 {
+		t.Errorf("expected one hit in bank 3, got %d", hits)
+	}
+
+	if addr, err := c.LabelAddress("Reset"); err != nil || addr != 0xC000 {
+		t.Errorf("expected Reset at $C000, got $%04X %v", addr, err)
+	}
+}
+
+func TestPrgAddress(t *testing.T) {
+	_, mmc1 := newBankedCore(t)
+	nrom, err := mappers.NewNROM(make([]byte, 0x4000), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		mapper  mappers.Mapper
+		mode    uint8
+		offset  uint
+		address uint16
+		ok      bool
+	}{
+		{mmc1, 3, 0x4010, 0x8010, true},
+		{mmc1, 3, 0xC010, 0xC010, true},
+		{mmc1, 3, 0x10000, 0, false},
+		{mmc1, 2, 0x0010, 0x8010, true},
+		{mmc1, 2, 0xC010, 0xC010, true},
+
+		// Mirrored, but the vectors are at $FFFA.
+		{nrom, 0, 0x0010, 0xC010, true},
+	}
+
+	for _, tc := range tests {
+		mmc1.PrgBankMode = tc.mode
+		address, ok := mappers.PrgAddress(tc.mapper, tc.offset)
+		if address != tc.address || ok != tc.ok {
+			t.Errorf("mode %d $%05X: expected $%04X %t, got $%04X %t", tc.mode, tc.offset, tc.address, tc.ok, address, ok)
+		}
+	}
+}
+
+// Labels are looked up in the bank that's mapped in.
+func TestBankedLabelAddress(t *testing.T) {
+	c, mmc1 := newBankedCore(t)
+
+	tests := []struct {
+		mode    uint8
+		bank    uint8
+		address uint16
+		ok      bool
+	}{
+		{3, 0, 0x8000, true},
+		{3, 1, 0x8000, true},
+		{3, 2, 0, false},
+		{2, 1, 0x8000, true}, // bank 0 is fixed at $8000
+	}
+
+	for _, tc := range tests {
+		mmc1.PrgBankMode = tc.mode
+		mmc1.PrgBank = tc.bank
+		addr, err := c.LabelAddress("Init")
+		if addr != tc.address || (err == nil) != tc.ok {
+			t.Errorf("mode %d bank %d: expected Init at $%04X, got $%04X %v", tc.mode, tc.bank, tc.address, addr, err)
+		}
+	}
+
+	// Other is only in bank 1.
+	mmc1.PrgBankMode = 3
+	mmc1.PrgBank = 1
+	addr, err := c.LabelAddress("Other")
+	if err != nil || addr != 0x8002 {
+		t.Errorf("expected Other at $8002 in bank 1, got $%04X %v", addr, err)
+	}
+	mmc1.PrgBank = 0
+	if _, err := c.LabelAddress("Other"); err == nil {
+		t.Errorf("expected an error for Other with bank 0 mapped")
+	}
+}
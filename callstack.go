@@ -0,0 +1,98 @@
+package emu
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Deepest call stack that is tracked.  Code that throws away return
+// addresses (eg, PLA PLA instead of RTS) would otherwise grow the stack
+// forever.
+const maxCallStack int = 256
+
+// StackFrame is a single entry in the call stack.
+type StackFrame struct {
+	// Start address of the routine or interrupt handler
+	Routine uint16
+
+	// Address of the JSR or BRK instruction, or the PC that was interrupted.
+	// This is zero for the routine started by RunRoutine().
+	Caller uint16
+
+	// Name of the interrupt for interrupt handlers.  Empty for JSR.
+	Interrupt string
+
+	// Stack pointer before the return address was pushed
+	SP uint8
+
+	// Used by StackCheck
+	maxDepth int
+}
+
+func (c *Core) pushFrame(routine, caller uint16, interrupt string) {
+	if len(c.callStack) >= maxCallStack {
+		c.callStack = c.callStack[1:]
+	}
+
+	frame := StackFrame{
+		Routine:   routine,
+		Caller:    caller,
+		Interrupt: interrupt,
+		SP:        c.SP,
+	}
+
+	if c.StackCheck != nil {
+		frame.maxDepth = c.StackCheck.depth(c.SP)
+	}
+
+	c.callStack = append(c.callStack, frame)
+}
+
+func (c *Core) popFrame() {
+	if len(c.callStack) == 0 {
+		return
+	}
+
+	frame := c.callStack[len(c.callStack)-1]
+	c.callStack = c.callStack[:len(c.callStack)-1]
+
+	if c.StackCheck != nil {
+		c.StackCheck.frameDone(frame)
+
+		// The caller got at least as deep as the routine it called.
+		if len(c.callStack) > 0 {
+			parent := &c.callStack[len(c.callStack)-1]
+			if frame.maxDepth > parent.maxDepth {
+				parent.maxDepth = frame.maxDepth
+			}
+		}
+	}
+}
+
+// Backtrace returns a copy of the current call stack, innermost frame first.
+func (c *Core) Backtrace() []StackFrame {
+	bt := make([]StackFrame, len(c.callStack))
+	for i, frame := range c.callStack {
+		bt[len(bt)-1-i] = frame
+	}
+	return bt
+}
+
+// BacktraceString formats the given frames, one per line, using the labels
+// known to the memory manager.
+func (c *Core) BacktraceString(frames []StackFrame) string {
+	lines := []string{}
+	for _, frame := range frames {
+		line := fmt.Sprintf("  at %s ($%04X)", c.memory.GetLabel(frame.Routine), frame.Routine)
+		if frame.Interrupt != "" {
+			line += " [" + frame.Interrupt + "]"
+		}
+
+		if frame.Caller != 0 {
+			line += fmt.Sprintf(" from %s ($%04X)", c.memory.GetLabel(frame.Caller), frame.Caller)
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,428 @@
+package emu
+
+// C level debugging for code compiled with cc65
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// Names of cc65's C stack pointer in zero page.  Newer runtimes call it
+// c_sp.
+var cStackPointers = []string{"c_sp", "sp"}
+
+// Zero page bytes used for register variables
+const cRegisterBank string = "regbank"
+
+// Kinds of C types.  These match the GT_TYPE_* values used by cc65.
+type CTypeKind int
+
+const (
+	CVoid   CTypeKind = 0x00
+	CInt    CTypeKind = 0x01
+	CPtr    CTypeKind = 0x02
+	CFloat  CTypeKind = 0x03
+	CArray  CTypeKind = 0x04
+	CFunc   CTypeKind = 0x05
+	CStruct CTypeKind = 0x06
+	CUnion  CTypeKind = 0x07
+)
+
+// Bits in a GT_ type byte
+const (
+	gtTypeMask     byte = 0x07
+	gtSizeMask     byte = 0x18
+	gtBigEndian    byte = 0x20
+	gtUnsigned     byte = 0x40
+	gtSizeShift    uint = 3
+	gtEndOfTypeStr byte = 0xFF
+)
+
+// CType is a decoded cc65 type string.
+type CType struct {
+	Kind      CTypeKind
+	Size      int // in bytes
+	Signed    bool
+	BigEndian bool
+
+	// Pointed to type for pointers, element type for arrays
+	Elem  *CType
+	Count int // number of elements in an array
+}
+
+// ParseCType decodes the hex encoded type string from a dbg type record.
+func ParseCType(value string) (*CType, error) {
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid type string %q: %w", value, err)
+	}
+
+	t, rest, err := parseGenType(data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid type string %q: %w", value, err)
+	}
+
+	if len(rest) > 0 && rest[0] != gtEndOfTypeStr {
+		return nil, fmt.Errorf("Invalid type string %q: extra data", value)
+	}
+	return t, nil
+}
+
+func parseGenType(data []byte) (*CType, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("type string too short")
+	}
+
+	b := data[0]
+	data = data[1:]
+	size := int((b&gtSizeMask)>>gtSizeShift) + 1
+
+	t := &CType{
+		Kind:      CTypeKind(b & gtTypeMask),
+		Signed:    b&gtUnsigned == 0,
+		BigEndian: b&gtBigEndian != 0,
+	}
+
+	switch t.Kind {
+	case CVoid:
+		// nothing
+
+	case CInt, CFloat:
+		t.Size = size
+
+	case CPtr:
+		t.Size = size
+		t.Signed = false
+
+		elem, rest, err := parseGenType(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		t.Elem = elem
+		data = rest
+
+	case CArray:
+		// The size bits are the width of the element count that follows.
+		if len(data) < size {
+			return nil, nil, fmt.Errorf("missing array size")
+		}
+		for i := size - 1; i >= 0; i-- {
+			t.Count = t.Count<<8 | int(data[i])
+		}
+		data = data[size:]
+
+		elem, rest, err := parseGenType(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		t.Elem = elem
+		t.Size = elem.Size * t.Count
+		data = rest
+
+	default:
+		// Functions, structs and unions don't have any more info.
+	}
+
+	return t, data, nil
+}
+
+func (t *CType) String() string {
+	switch t.Kind {
+	case CVoid:
+		return "void"
+
+	case CInt:
+		name := ""
+		switch t.Size {
+		case 1:
+			name = "char"
+		case 2:
+			name = "int"
+		case 4:
+			name = "long"
+		default:
+			name = fmt.Sprintf("int%d", t.Size*8)
+		}
+
+		if !t.Signed {
+			return "unsigned " + name
+		}
+		return name
+
+	case CFloat:
+		return "float"
+
+	case CPtr:
+		return t.Elem.String() + "*"
+
+	case CArray:
+		return fmt.Sprintf("%s[%d]", t.Elem, t.Count)
+
+	case CFunc:
+		return "function"
+
+	case CStruct:
+		return "struct"
+
+	case CUnion:
+		return "union"
+	}
+	return "unknown"
+}
+
+// CVariable is a C symbol resolved to an address.
+type CVariable struct {
+	Name    string
+	Storage string // "auto", "reg", "static" or "ext"
+	Address uint16
+	Symbol  *CSymbol
+
+	// cc65 doesn't always record types.  Void types are read as int.
+	Type *CType
+}
+
+// Size in bytes of the value.
+func (v *CVariable) Size() int {
+	if v.Type == nil || v.Type.Size == 0 {
+		return 2
+	}
+	return v.Type.Size
+}
+
+func (v *CVariable) String() string {
+	typ := "int"
+	if v.Type != nil && v.Type.Kind != CVoid {
+		typ = v.Type.String()
+	}
+	return fmt.Sprintf("%s %s ($%04X, %s)", typ, v.Name, v.Address, v.Storage)
+}
+
+// CValue is the memory behind a C variable.
+type CValue struct {
+	Variable *CVariable
+	Bytes    []byte
+}
+
+// Int returns the value as an integer, with the sign and byte order of the
+// variable's type.
+func (cv *CValue) Int() int64 {
+	var val uint64
+	bigEndian := cv.Variable.Type != nil && cv.Variable.Type.BigEndian
+	for i := range cv.Bytes {
+		b := cv.Bytes[len(cv.Bytes)-1-i]
+		if bigEndian {
+			b = cv.Bytes[i]
+		}
+		val = val<<8 | uint64(b)
+	}
+
+	signed := cv.Variable.Type == nil || cv.Variable.Type.Kind == CVoid ||
+		cv.Variable.Type.Kind == CInt && cv.Variable.Type.Signed
+	bits := uint(len(cv.Bytes) * 8)
+	if signed && bits > 0 && bits < 64 && val&(1<<(bits-1)) != 0 {
+		return int64(val) - int64(1<<bits)
+	}
+	return int64(val)
+}
+
+func (cv *CValue) String() string {
+	t := cv.Variable.Type
+	if t != nil && (t.Kind == CArray || t.Kind == CStruct || t.Kind == CUnion) {
+		return fmt.Sprintf("%s = [% X]", cv.Variable.Name, cv.Bytes)
+	}
+
+	if t != nil && t.Kind == CPtr {
+		return fmt.Sprintf("%s = $%04X", cv.Variable.Name, cv.Int())
+	}
+	return fmt.Sprintf("%s = %d", cv.Variable.Name, cv.Int())
+}
+
+// CStackPointer returns the current value of cc65's C stack pointer.
+func (c *Core) CStackPointer() (uint16, error) {
+	if c.Symbols == nil {
+		return 0, fmt.Errorf("No debug symbols loaded")
+	}
+
+	for _, name := range cStackPointers {
+		if addr, err := c.Symbols.GetAddress(name); err == nil {
+			return c.peekWord(addr), nil
+		}
+	}
+	return 0, fmt.Errorf("C stack pointer not found")
+}
+
+// peekWord reads a word without triggering breakpoints or other checks.
+func (c *Core) peekWord(address uint16) uint16 {
+	return uint16(c.memory.ReadByte(address)) | uint16(c.memory.ReadByte(address+1))<<8
+}
+
+// CFunction returns the scope of the C function at the current PC, or nil.
+func (c *Core) CFunction() *Scope {
+	if c.Symbols == nil {
+		return nil
+	}
+
+	for sc := c.Symbols.ScopeAt(c.PC); sc != nil; sc = sc.Parent {
+		if sc.Parent != nil && len(sc.CSymbols) > 0 {
+			return sc
+		}
+	}
+	return nil
+}
+
+// isCFunction returns true for the symbol of a function itself.
+func (s *Symbols) isCFunction(cs *CSymbol) bool {
+	if cs.Symbol == nil {
+		return false
+	}
+
+	for _, sc := range s.Scopes {
+		if sc.Label == cs.Symbol {
+			return true
+		}
+	}
+	return false
+}
+
+// CLocals returns the locals of the current C function, including
+// parameters and static locals.
+//
+// Autos are relative to the C stack pointer after the function's locals
+// are allocated, which is where it is between statements.  Locals in nested
+// blocks will be off if the block hasn't been entered yet.
+func (c *Core) CLocals() ([]*CVariable, error) {
+	fn := c.CFunction()
+	if fn == nil {
+		return nil, fmt.Errorf("Not in a C function at $%04X", c.PC)
+	}
+
+	// The lowest offset is the top of the stack.
+	var base int
+	hasAutos := false
+	for _, cs := range fn.CSymbols {
+		if cs.Storage != "auto" {
+			continue
+		}
+
+		hasAutos = true
+		if cs.Offset < base {
+			base = cs.Offset
+		}
+	}
+
+	var sp uint16
+	if hasAutos {
+		var err error
+		if sp, err = c.CStackPointer(); err != nil {
+			return nil, err
+		}
+	}
+
+	vars := []*CVariable{}
+	for _, cs := range fn.CSymbols {
+		if cs.Storage == "ext" && c.Symbols.isCFunction(cs) {
+			continue
+		}
+
+		v, err := c.cVariable(cs)
+		if err != nil {
+			return nil, err
+		}
+
+		if cs.Storage == "auto" {
+			v.Address = sp + uint16(cs.Offset-base)
+		}
+		vars = append(vars, v)
+	}
+	return vars, nil
+}
+
+// CGlobals returns all the C variables at file scope.
+func (c *Core) CGlobals() ([]*CVariable, error) {
+	if c.Symbols == nil {
+		return nil, fmt.Errorf("No debug symbols loaded")
+	}
+
+	vars := []*CVariable{}
+	for _, cs := range c.Symbols.CSymbols {
+		if cs.Scope == nil || cs.Scope.Parent != nil || c.Symbols.isCFunction(cs) {
+			continue
+		}
+
+		v, err := c.cVariable(cs)
+		if err != nil {
+			return nil, err
+		}
+		vars = append(vars, v)
+	}
+
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	return vars, nil
+}
+
+// CVariable finds a C variable by name.  Locals are searched before globals.
+func (c *Core) CVariable(name string) (*CVariable, error) {
+	if locals, err := c.CLocals(); err == nil {
+		for _, v := range locals {
+			if v.Name == name {
+				return v, nil
+			}
+		}
+	}
+
+	globals, err := c.CGlobals()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range globals {
+		if v.Name == name {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("C variable %q not found", name)
+}
+
+// ReadCVariable reads the current value of a variable.
+func (c *Core) ReadCVariable(v *CVariable) *CValue {
+	cv := &CValue{Variable: v, Bytes: make([]byte, v.Size())}
+	for i := range cv.Bytes {
+		cv.Bytes[i] = c.memory.ReadByte(v.Address + uint16(i))
+	}
+	return cv
+}
+
+// cVariable resolves everything except the address of autos.
+func (c *Core) cVariable(cs *CSymbol) (*CVariable, error) {
+	v := &CVariable{
+		Name:    cs.Name,
+		Storage: cs.Storage,
+		Symbol:  cs,
+	}
+
+	if cs.Type != nil {
+		t, err := ParseCType(cs.Type.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cs.Name, err)
+		}
+		v.Type = t
+	}
+
+	switch cs.Storage {
+	case "static", "ext":
+		if cs.Symbol == nil {
+			return nil, fmt.Errorf("%s: no assembler symbol", cs.Name)
+		}
+		v.Address = cs.Symbol.Value
+
+	case "reg":
+		bank, err := c.Symbols.GetAddress(cRegisterBank)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cs.Name, err)
+		}
+		v.Address = bank + uint16(cs.Offset)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,150 @@
+package emu
+
+import (
+	"testing"
+
+	"github.com/zorchenhimer/emu-6502/mappers"
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+func newCCore(t *testing.T) *Core {
+	mapper, err := mappers.NewNROM(make([]byte, 0x4000), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCore(mmu.NewNES(mapper))
+	if c.Symbols, err = NewSymbols("testdata/cgame.dbg"); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func pokeWords(c *Core, address uint16, words ...uint16) {
+	for i, w := range words {
+		c.WriteByte(address+uint16(i*2), uint8(w))
+		c.WriteByte(address+uint16(i*2)+1, uint8(w>>8))
+	}
+}
+
+func TestCLocals(t *testing.T) {
+	c := newCCore(t)
+	pokeWords(c, 0x0000, 0x07F0) // sp
+	pokeWords(c, 0x0002, 0x1234) // regbank
+	pokeWords(c, 0x07F0, 0x0301, 0xFFFE)
+	c.PC = 0xC004
+
+	if fn := c.CFunction(); fn == nil || fn.Name != "_main" {
+		t.Fatalf("expected to be in _main, got %v", fn)
+	}
+
+	locals, err := c.CLocals()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []struct {
+		name  string
+		addr  uint16
+		typ   string
+		value string
+	}{
+		{"x", 0x07F2, "int", "x = -2"},
+		{"p", 0x07F0, "unsigned char*", "p = $0301"},
+		{"r", 0x0002, "int", "r = 4660"},
+	}
+
+	if len(locals) != len(expect) {
+		t.Fatalf("expected %d locals, got %v", len(expect), locals)
+	}
+
+	for i, e := range expect {
+		v := locals[i]
+		if v.Name != e.name || v.Address != e.addr || v.Type.String() != e.typ {
+			t.Errorf("expected %s %s at $%04X, got %s", e.typ, e.name, e.addr, v)
+		}
+		if val := c.ReadCVariable(v).String(); val != e.value {
+			t.Errorf("expected %q, got %q", e.value, val)
+		}
+	}
+
+	// Parameters are above the locals.
+	pokeWords(c, 0x0000, 0x07E0)
+	pokeWords(c, 0x07E0, 3, 4)
+	c.PC = 0xC012
+
+	for name, addr := range map[string]uint16{"a": 0x07E2, "b": 0x07E0, "total": 0x0304, "counter": 0x0300} {
+		v, err := c.CVariable(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if v.Address != addr {
+			t.Errorf("%s: expected $%04X, got $%04X", name, addr, v.Address)
+		}
+	}
+
+	if v, _ := c.CVariable("a"); c.ReadCVariable(v).Int() != 4 {
+		t.Errorf("expected a = 4, got %s", c.ReadCVariable(v))
+	}
+
+	if _, err := c.CVariable("x"); err == nil {
+		t.Errorf("x shouldn't be visible in add()")
+	}
+}
+
+func TestCGlobals(t *testing.T) {
+	c := newCCore(t)
+	c.WriteByte(0x0300, 0xF0)
+
+	globals, err := c.CGlobals()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(globals) != 2 || globals[0].Name != "buf" || globals[1].Name != "counter" {
+		t.Fatalf("expected buf and counter, got %v", globals)
+	}
+
+	if globals[0].Size() != 3 || globals[0].Type.String() != "unsigned char[3]" {
+		t.Errorf("unexpected type for buf: %s", globals[0])
+	}
+
+	if val := c.ReadCVariable(globals[1]).Int(); val != 0xF0 {
+		t.Errorf("expected counter = 240, got %d", val)
+	}
+
+	if addr, err := c.Symbols.AddressForLine("main.c", 14); err != nil || addr != 0xC018 {
+		t.Errorf("expected main.c:14 at $C018, got $%04X %v", addr, err)
+	}
+	if line := c.Symbols.LineAt(0xC005); line == nil || line.Type != LineC || line.Line != 6 {
+		t.Errorf("expected C line 6 at $C005, got %v", line)
+	}
+}
+
+func TestParseCType(t *testing.T) {
+	types := map[string]string{
+		"00":       "void",
+		"01":       "char",
+		"41":       "unsigned char",
+		"09":       "int",
+		"19":       "long",
+		"0A41":     "unsigned char*",
+		"0A0A09":   "int**",
+		"040341":   "unsigned char[3]",
+		"0C000101": "char[256]",
+	}
+
+	for val, expect := range types {
+		ct, err := ParseCType(val)
+		if err != nil {
+			t.Errorf("%s: %v", val, err)
+		} else if ct.String() != expect {
+			t.Errorf("%s: expected %s, got %s", val, expect, ct)
+		}
+	}
+
+	for _, val := range []string{"", "zz", "0A", "04", "0901"} {
+		if _, err := ParseCType(val); err == nil {
+			t.Errorf("%q: expected an error", val)
+		}
+	}
+}
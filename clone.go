@@ -0,0 +1,169 @@
+package emu
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// Clone returns a copy of the core with its own registers, memory, mapper
+// state, breakpoints, history and diagnostics.  ROM data, labels and
+// Symbols are shared, as are Output and DebugFile writers and breakpoint
+// callbacks.  The NMI ticker isn't copied and a Footprint starts out empty.
+func (c *Core) Clone() *Core {
+	clone := *c
+	clone.memory = c.memory.Clone()
+	clone.nmiTicker = nil
+	clone.stop = false
+
+	clone.Breakpoints = &Breakpoints{}
+	if c.Breakpoints != nil {
+		clone.Breakpoints = c.Breakpoints.clone()
+	}
+
+	clone.dasmTrees = append([]uint16{}, c.dasmTrees...)
+	clone.callStack = append([]StackFrame{}, c.callStack...)
+
+	if c.UninitializedReads != nil {
+		ur := *c.UninitializedReads
+		ur.Reads = append([]Diagnostic{}, ur.Reads...)
+		clone.UninitializedReads = &ur
+	}
+
+	if c.StackCheck != nil {
+		sc := *c.StackCheck
+		sc.Diagnostics = append([]Diagnostic{}, sc.Diagnostics...)
+		if sc.Routines != nil {
+			sc.Routines = make(map[uint16]*StackUsage)
+			for k, v := range c.StackCheck.Routines {
+				u := *v
+				sc.Routines[k] = &u
+			}
+		}
+		if sc.Interrupts != nil {
+			sc.Interrupts = make(map[string]*StackUsage)
+			for k, v := range c.StackCheck.Interrupts {
+				u := *v
+				sc.Interrupts[k] = &u
+			}
+		}
+		clone.StackCheck = &sc
+	}
+
+	if c.CodeWatch != nil {
+		cw := *c.CodeWatch
+		cw.Writes = append([]Diagnostic{}, cw.Writes...)
+		cw.RamExecution = append([]Diagnostic{}, cw.RamExecution...)
+		clone.CodeWatch = &cw
+	}
+
+	// Clones start with an empty footprint so they can be merged back
+	// without counting the parent's calls twice.
+	if c.Footprint != nil {
+		clone.Footprint = &Footprint{}
+	}
+
+	return &clone
+}
+
+// SweepFunc is called for each point of a sweep with a fresh clone of the
+// core.  point has a value for each dimension.
+type SweepFunc func(c *Core, point []int) (interface{}, error)
+
+// SweepError is returned by Sweep for the first point that failed.
+type SweepError struct {
+	Point []int
+	Err   error
+}
+
+func (e *SweepError) Error() string {
+	return fmt.Sprintf("sweep point %v: %v", e.Point, e.Err)
+}
+
+// Sweep runs fn for every combination of parameter values, each on its own
+// clone of c.  dims has the number of values for each parameter; eg,
+// []int{72, 256, 4} calls fn with every point from [0 0 0] to [71 255 3].
+// The points are split between workers goroutines, or runtime.NumCPU() if
+// workers is less than one.
+//
+// Results are in order with the last dimension changing fastest.  After an
+// error no new points are started, and the results so far are returned
+// with a *SweepError.  c is only cloned, never run, and must not be used
+// until Sweep returns.
+func (c *Core) Sweep(dims []int, workers int, fn SweepFunc) ([]interface{}, error) {
+	total := 1
+	for _, d := range dims {
+		if d < 1 {
+			total = 0
+			break
+		}
+		total *= d
+	}
+
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	type job struct {
+		idx  int
+		core *Core
+	}
+
+	results := make([]interface{}, total)
+	jobs := make(chan job)
+	failed := make(chan struct{})
+
+	var sweepErr error
+	var once sync.Once
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				point := sweepPoint(dims, j.idx)
+				res, err := fn(j.core, point)
+				if err != nil {
+					once.Do(func() {
+						sweepErr = &SweepError{Point: point, Err: err}
+						close(failed)
+					})
+					continue
+				}
+				results[j.idx] = res
+			}
+		}()
+	}
+
+	// Clones are made here, one at a time, so c is only ever touched by
+	// this goroutine.
+dispatch:
+	for idx := 0; idx < total; idx++ {
+		select {
+		case <-failed:
+			break dispatch
+		default:
+		}
+
+		select {
+		case jobs <- job{idx, c.Clone()}:
+		case <-failed:
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results, sweepErr
+}
+
+// sweepPoint turns an index into a value for each dimension.
+func sweepPoint(dims []int, idx int) []int {
+	point := make([]int, len(dims))
+	for d := len(dims) - 1; d >= 0; d-- {
+		point[d] = idx % dims[d]
+		idx /= dims[d]
+	}
+	return point
+}
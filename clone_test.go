@@ -0,0 +1,105 @@
+package emu
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/zorchenhimer/emu-6502/mappers"
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+func TestClone(t *testing.T) {
+	mapper, err := mappers.NewMMC1(make([]byte, 0x10000), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mmc1 := mapper.(*mappers.MMC1)
+
+	c := NewCore(mmu.NewNES(mapper))
+	c.A = 0x12
+	c.WriteByte(0x0010, 0xAA)
+	c.WriteByte(0x6000, 0xBB)
+	c.Breakpoints.Register(WRITE, "orig", 0x0010, func(*Core, uint8, uint8) {})
+	c.StackCheck = &StackCheck{}
+	c.StackCheck.start(0xFF)
+	c.StackCheck.frameDone(StackFrame{Routine: 0x8000})
+
+	clone := c.Clone()
+	if clone.A != 0x12 || clone.ReadByte(0x0010) != 0xAA || clone.ReadByte(0x6000) != 0xBB {
+		t.Fatalf("clone doesn't match: A:%02X $0010:%02X $6000:%02X", clone.A, clone.ReadByte(0x0010), clone.ReadByte(0x6000))
+	}
+
+	clone.A = 0x34
+	clone.WriteByte(0x0010, 0x01)
+	clone.WriteByte(0x6000, 0x02)
+	clone.Breakpoints.Register(READ, "clone", 0x0020, func(*Core, uint8, uint8) {})
+	clone.StackCheck.frameDone(StackFrame{Routine: 0x8000})
+	clone.memory.(*mmu.NES).Mapper().(*mappers.MMC1).PrgBank = 5
+
+	if c.A != 0x12 || c.ReadByte(0x0010) != 0xAA || c.ReadByte(0x6000) != 0xBB {
+		t.Errorf("original changed: A:%02X $0010:%02X $6000:%02X", c.A, c.ReadByte(0x0010), c.ReadByte(0x6000))
+	}
+	if mmc1.PrgBank != 0 {
+		t.Errorf("original PRG bank changed to %d", mmc1.PrgBank)
+	}
+	if _, ok := c.Breakpoints.registered[0x0020]; ok {
+		t.Errorf("breakpoint added to the original")
+	}
+	if calls := c.StackCheck.Routines[0x8000].Calls; calls != 1 {
+		t.Errorf("expected 1 call in the original's stack check, got %d", calls)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	c, bus := newBusCore()
+
+	// LDA $10, CLC, ADC $11, STA $12, RTS
+	bus.poke(testOrigin, 0xA5, 0x10, 0x18, 0x65, 0x11, 0x85, 0x12, 0x60)
+
+	add := func(c *Core, point []int) (interface{}, error) {
+		if c.ReadByte(0x0012) != 0 {
+			return nil, fmt.Errorf("state from another point")
+		}
+
+		c.WriteByte(0x0010, uint8(point[0]))
+		c.WriteByte(0x0011, uint8(point[1]*16))
+		if err := c.RunRoutine(testOrigin); err != nil {
+			return nil, err
+		}
+		return c.ReadByte(0x0012), nil
+	}
+
+	results, err := c.Sweep([]int{3, 4}, 4, add)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 12 {
+		t.Fatalf("expected 12 results, got %d", len(results))
+	}
+	for i, res := range results {
+		expect := uint8(i/4 + i%4*16)
+		if res != expect {
+			t.Errorf("result %d: expected $%02X, got %v", i, expect, res)
+		}
+	}
+
+	if bus.ram[0x12] != 0 {
+		t.Errorf("sweep ran on the original core")
+	}
+
+	_, err = c.Sweep([]int{3, 4}, 2, func(c *Core, point []int) (interface{}, error) {
+		if point[0] == 1 && point[1] == 2 {
+			return nil, fmt.Errorf("bad point")
+		}
+		return add(c, point)
+	})
+
+	serr, ok := err.(*SweepError)
+	if !ok {
+		t.Fatalf("expected a *SweepError, got %v", err)
+	}
+	if fmt.Sprint(serr.Point) != "[1 2]" {
+		t.Errorf("expected the error at [1 2], got %v", serr.Point)
+	}
+}
@@ -1,3 +1,5 @@
+// +build ignore
+
 package main
 
 import (
@@ -14,6 +16,7 @@ import (
 
 	"github.com/zorchenhimer/emu-6502"
 	"github.com/zorchenhimer/emu-6502/mappers"
+	"github.com/zorchenhimer/emu-6502/mmu"
 	//cc "github.com/zorchenhimer/emu-6502/cc65"
 )
 
@@ -57,11 +60,7 @@ func main() {
 		return
 	}
 
-	core, err := emu.NewCore(mapper)
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
+	core := emu.NewCore(mmu.NewNES(mapper))
 
 	//dbg, err := cc.ParseDebugFile("breakout.dbg")
 	//if err != nil {
@@ -110,19 +109,17 @@ func main() {
 	}()
 
 	/*
-		brick position
+		brick position (one per clone)
 			ball position
 				ball direction
 	*/
 	start := time.Now()
-	for brickIdx := uint16(0); brickIdx < (6 * 12) - 1; brickIdx++ {
+	results, err := core.Sweep([]int{(6 * 12) - 1}, 0, func(core *emu.Core, point []int) (interface{}, error) {
+		brickIdx := uint16(point[0])
 		if stop {
-			fmt.Println("Stop received")
-			fmt.Println(core.Registers())
-			fmt.Printf("last brick index: %d\n", brickIdx - 1)
-			break
+			return nil, fmt.Errorf("Stop received")
 		}
-		core.HardReset()
+
 		core.WriteByte(brickIdx + 0x6000, 0x41)
 		core.WriteByte(brickIdx + 0x6000 + 1, 0x80)
 		core.WriteByte(CurrentBoard, 0x80)	// set child board
@@ -139,31 +136,35 @@ func main() {
 
 				for D := uint8(0); D < 4; D++ {
 					core.WriteByte(BallDirection, D)
-					err = core.RunRoutine(CheckPointCollide)
+					err := core.RunRoutine(CheckPointCollide)
 					if err != nil {
-						fmt.Println(err)
-						fmt.Println(core.Registers())
-						return
+						return nil, fmt.Errorf("%v\n%s", err, core.Registers())
 					}
 
 					brickAddr := core.ReadWord(AddressPointer0)
 					if brickAddr != 0 && brickAddr != brickIdx + 0x6000 && brickAddr != brickIdx + 0x6000 + 1 {
-						fmt.Println(core.Registers())
-						fmt.Printf("invalid AddressPointer0.  Brick: $%04X; pointer: $%04X",
-							brickIdx + 0x6000, brickAddr)
-						return
+						return nil, fmt.Errorf("%s\ninvalid AddressPointer0.  Brick: $%04X; pointer: $%04X",
+							core.Registers(), brickIdx + 0x6000, brickAddr)
 					}
 				}
 			}
 		}
-	}
+
+		ram := []byte{}
+		for i := uint16(0x6000); i < 0x6100; i++ {
+			ram = append(ram, core.ReadByte(i))
+		}
+		return ram, nil
+	})
 	fmt.Printf("time: %s\n", time.Now().Sub(start))
 
-	ram := []byte{}
-	for i := uint16(0x6000); i < 0x6100; i++ {
-		ram = append(ram, core.ReadByte(i))
+	if err != nil {
+		fmt.Println(err)
+		return
 	}
 
+	// WRAM from the last brick
+	ram := results[len(results)-1].([]byte)
 	err = ioutil.WriteFile("breakout.ram", ram, 0777)
 	if err != nil {
 		fmt.Println(err)
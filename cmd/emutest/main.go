@@ -0,0 +1,80 @@
+// Run 6502 routine tests from JSON spec files.
+//
+//	emutest [-format text|tap|junit] [-o output] spec.json...
+//
+// Exits with a non-zero status if any test fails.  See the emutest package
+// for the spec file format.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/zorchenhimer/emu-6502/emutest"
+)
+
+func main() {
+	format := flag.String("format", "text", "output format: text, tap or junit")
+	output := flag.String("o", "", "write the report to a file instead of stdout")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] spec.json...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var write func(io.Writer, []emutest.Suite) error
+	switch *format {
+	case "text":
+		write = emutest.WriteText
+	case "tap":
+		write = emutest.WriteTAP
+	case "junit":
+		write = emutest.WriteJUnit
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown format %q\n", *format)
+		os.Exit(2)
+	}
+
+	suites := emutest.RunFiles(flag.Args()...)
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := write(w, suites); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	failed, total := 0, 0
+	for _, s := range suites {
+		failed += s.Failed()
+		total += len(s.Results)
+	}
+
+	if *output != "" || *format != "text" {
+		fmt.Fprintf(os.Stderr, "%d of %d tests passed\n", total-failed, total)
+	}
+
+	if failed > 0 {
+		// Deferred Close() doesn't run with os.Exit()
+		if f, ok := w.(*os.File); ok && f != os.Stdout {
+			f.Close()
+		}
+		os.Exit(1)
+	}
+}
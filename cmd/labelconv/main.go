@@ -0,0 +1,75 @@
+// Convert labels between emulator and assembler formats.
+//
+//	labelconv [-from format] [-to format] input output
+//
+// Formats are picked from the file extensions if they aren't given.  For
+// FCEUX output, an output name that doesn't end in .nl is taken as the ROM
+// name and one file is written for RAM and each PRG bank.
+//
+// PRG ROM labels are placed in CPU address space using the mapper of the ROM
+// given with -rom, in its power on state.  Without it, the last bank with
+// labels is taken to be fixed at $C000 and the others switched in at $8000.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/zorchenhimer/emu-6502/labels"
+	"github.com/zorchenhimer/emu-6502/mappers"
+)
+
+func main() {
+	from := flag.String("from", "", "input format: mesen2, mlb, nl, vice, map")
+	to := flag.String("to", "", "output format: mesen2, mlb, nl, ca65")
+	rom := flag.String("rom", "", "ROM to take the PRG layout from")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] input output\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var prg labels.PrgAddress
+	if *rom != "" {
+		mapper, err := mappers.LoadFromFile(*rom)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		prg = func(offset uint) (uint, bool) {
+			address, ok := mappers.PrgAddress(mapper, offset)
+			return uint(address), ok
+		}
+	}
+
+	err := convert(flag.Arg(0), labels.Format(*from), flag.Arg(1), labels.Format(*to), prg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func convert(input string, from labels.Format, output string, to labels.Format, prg labels.PrgAddress) error {
+	lbls, err := labels.LoadFormat(input, from)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	for _, lm := range lbls {
+		count += len(lm)
+	}
+
+	if err = labels.Save(output, lbls, to, prg); err != nil {
+		return err
+	}
+
+	fmt.Printf("Converted %d labels\n", count)
+	return nil
+}
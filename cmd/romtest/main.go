@@ -0,0 +1,83 @@
+// Run NES test ROMs that report through the blargg protocol, or nestest.
+//
+//	romtest [-mode auto|blargg|nestest] [-limit n] [-j n] rom.nes|dir...
+//
+// A single ROM prints its message and exits with the ROM's result code, or 1
+// if it didn't finish.  More than one ROM (or a directory) runs in parallel
+// and prints a summary table, exiting with 1 if any of them didn't pass.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/zorchenhimer/emu-6502/romtest"
+)
+
+func main() {
+	mode := flag.String("mode", "auto", "result protocol: auto, blargg or nestest")
+	limit := flag.Int64("limit", romtest.DefaultLimit, "most instructions to run per ROM")
+	workers := flag.Int("j", runtime.NumCPU(), "ROMs to run at once")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] rom.nes|dir...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := romtest.Options{Limit: *limit}
+	switch *mode {
+	case "auto":
+		opts.Mode = romtest.Auto
+	case "blargg":
+		opts.Mode = romtest.Blargg
+	case "nestest":
+		opts.Mode = romtest.Nestest
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown mode %q\n", *mode)
+		os.Exit(2)
+	}
+
+	files, err := romtest.Find(flag.Args()...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "No ROMs found")
+		os.Exit(2)
+	}
+
+	if len(files) == 1 && flag.NArg() == 1 {
+		res := romtest.Run(files[0], opts)
+		if res.Message != "" {
+			fmt.Println(res.Message)
+		}
+		fmt.Printf("%s: %s (%d instructions)\n", res.File, res.Status, res.Instructions)
+
+		switch res.Status {
+		case romtest.Passed, romtest.Failed:
+			os.Exit(res.Code)
+		}
+		os.Exit(1)
+	}
+
+	results := romtest.RunAll(files, opts, *workers)
+	if err := romtest.WriteSummary(os.Stdout, results); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	for _, r := range results {
+		if !r.Passed() {
+			os.Exit(1)
+		}
+	}
+}
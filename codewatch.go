@@ -0,0 +1,79 @@
+package emu
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+// CodeWatch keeps track of every address that has been executed.  Writes to
+// those addresses (self-modifying code) are reported, as is execution from
+// RAM when the memory manager implements mmu.MemoryTyper.
+type CodeWatch struct {
+	// If not nil, each diagnostic is written here as it happens.
+	Output io.Writer
+
+	// Writes to addresses that have already been executed.
+	Writes []Diagnostic
+
+	// The first execution from each RAM address.
+	RamExecution []Diagnostic
+
+	executed [0x10000]bool
+	ramSeen  [0x10000]bool
+}
+
+// Executed returns whether the given address has been executed since the
+// last write to it.
+func (cw *CodeWatch) Executed(address uint16) bool {
+	return cw.executed[address]
+}
+
+// isRam returns true if address is RAM, or if the memory manager doesn't know
+// what kind of memory it is.
+func (c *Core) isRam(address uint16) (ram bool, typed bool) {
+	typer, ok := c.memory.(mmu.MemoryTyper)
+	if !ok {
+		return true, false
+	}
+	return mmu.IsRam(typer.MemoryType(address)), true
+}
+
+// watchExecute is called with each instruction before it is executed.
+func (c *Core) watchExecute(address uint16, size uint8) {
+	cw := c.CodeWatch
+	for i := uint16(0); i < uint16(size); i++ {
+		cw.executed[address+i] = true
+	}
+
+	if cw.ramSeen[address] {
+		return
+	}
+
+	if ram, typed := c.isRam(address); ram && typed {
+		cw.ramSeen[address] = true
+		d := c.newDiagnostic("execute from RAM", address, "")
+		cw.RamExecution = append(cw.RamExecution, d)
+		c.writeDiagnostic(cw.Output, d)
+	}
+}
+
+// watchWrite is called before every write.
+func (c *Core) watchWrite(address uint16, value uint8) {
+	cw := c.CodeWatch
+	if !cw.executed[address] {
+		return
+	}
+
+	// Writes to ROM are mapper registers, not code changes.
+	if ram, _ := c.isRam(address); !ram {
+		return
+	}
+
+	cw.executed[address] = false
+	d := c.newDiagnostic("self-modifying code", address,
+		fmt.Sprintf("$%02X -> $%02X", c.memory.ReadByte(address), value))
+	cw.Writes = append(cw.Writes, d)
+	c.writeDiagnostic(cw.Output, d)
+}
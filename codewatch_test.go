@@ -0,0 +1,62 @@
+package emu
+
+import (
+	"testing"
+
+	"github.com/zorchenhimer/emu-6502/labels"
+)
+
+func TestCodeWatch(t *testing.T) {
+	c := newStackCore(t, 0xFF, map[uint16][]byte{
+		0x8000: {
+			0x20, 0x00, 0x03, // JSR RamCode
+			0xA9, 0x42, // LDA #$42
+			0x8D, 0x01, 0x03, // STA RamCode+1
+			0x4C, 0x00, 0x03, // JMP RamCode
+		},
+	})
+	c.StackCheck = nil
+
+	lbls := labels.Set{}
+	lbls.Add(labels.NesInternalRam, 0x0300, &labels.Label{Name: "RamCode", Size: 3})
+	c.memory.SetLabels(lbls)
+
+	// RamCode: LDA #$00, RTS
+	for i, b := range []byte{0xA9, 0x00, 0x60} {
+		c.WriteByte(0x0300+uint16(i), b)
+	}
+
+	c.CodeWatch = &CodeWatch{}
+	if err := c.RunRoutine(0x8000); err != nil {
+		t.Fatal(err)
+	}
+	if c.A != 0x42 {
+		t.Errorf("expected the patched LDA to load $42, got $%02X", c.A)
+	}
+
+	cw := c.CodeWatch
+	if len(cw.Writes) != 1 {
+		t.Fatalf("expected 1 write, got %v", cw.Writes)
+	}
+	if w := cw.Writes[0]; w.PC != 0x8005 || w.Address != 0x0301 || w.Label != "RamCode+1" || w.Message != "$00 -> $42" {
+		t.Errorf("unexpected write: %+v", w)
+	}
+
+	// Each RAM address is only reported the first time.
+	expect := []struct {
+		address uint16
+		label   string
+	}{
+		{0x0300, "RamCode"},
+		{0x0302, "RamCode+2"},
+	}
+	if len(cw.RamExecution) != len(expect) {
+		t.Fatalf("expected %d RAM executions, got %v", len(expect), cw.RamExecution)
+	}
+	for i, e := range expect {
+		d := cw.RamExecution[i]
+		if d.PC != e.address || d.Address != e.address || d.Label != e.label {
+			t.Errorf("RAM execution %d: expected $%04X %s, got %+v", i, e.address, e.label, d)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package emu
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	//"io/ioutil"
@@ -9,11 +10,17 @@ import (
 	"strings"
 	"time"
 
+	"github.com/zorchenhimer/emu-6502/disasm"
+	"github.com/zorchenhimer/emu-6502/labels"
+	"github.com/zorchenhimer/emu-6502/mappers"
 	"github.com/zorchenhimer/emu-6502/mmu"
 )
 
 const HistoryLength int = 100
 
+// ErrInstructionLimit is returned when InstructionLimit runs out.
+var ErrInstructionLimit = errors.New("Instruction limit reached")
+
 const (
 	VECTOR_NMI   uint16 = 0xFFFA
 	VECTOR_RESET uint16 = 0xFFFC
@@ -54,6 +61,13 @@ type Core struct {
 	Debug     bool
 	DebugFile io.Writer
 
+	// Debug info from ld65.  Needed for source level stepping.
+	Symbols *Symbols
+
+	// Prefix DebugFile and history lines with the source file, line and
+	// text.  Needs Symbols.
+	TraceSource bool
+
 	Disassemble bool
 
 	// just the address for now.  probably needs the whole state of the core or something, idk.
@@ -75,6 +89,28 @@ type Core struct {
 
 	EnableCDL bool
 	//cdl *cdlData
+
+	// Report reads of RAM that hasn't been written since reset.  nil to
+	// disable.
+	UninitializedReads *UninitializedReads
+
+	// Watch the stack for overflows, underflows and collisions.  nil to
+	// disable.
+	StackCheck *StackCheck
+
+	// Report self-modifying code and execution from RAM.  nil to disable.
+	CodeWatch *CodeWatch
+
+	// Record the memory and registers used by RunRoutine() and
+	// CallRoutine().  nil to disable.
+	Footprint *Footprint
+
+	callStack []StackFrame
+	opPC      uint16 // address of the instruction being executed
+	executing bool   // true while the CPU itself is accessing memory
+
+	// Mapper state from NewCore(), put back by HardReset().
+	powerOn interface{}
 }
 
 func NewCore(m mmu.Manager) *Core {
@@ -95,18 +131,46 @@ func NewCore(m mmu.Manager) *Core {
 		Breakpoints: &Breakpoints{},
 	}
 
+	if mo, ok := m.(mapperOwner); ok {
+		c.powerOn = mo.Mapper().GetState()
+	}
+
 	c.PC = c.ReadWord(VECTOR_RESET)
 	return c
 }
 
+// mapperOwner is implemented by memory managers backed by a mapper.
+type mapperOwner interface {
+	Mapper() mappers.Mapper
+}
+
 // Read address.  This will read from API registers if needed.
 func (c *Core) ReadByte(addr uint16) uint8 {
 	c.lastReadAddr = addr
+	if c.executing && c.UninitializedReads != nil {
+		c.checkUninitialized(addr)
+	}
+	if c.executing && c.Footprint != nil {
+		c.footprintAccess(addr, false)
+	}
 	val := c.memory.ReadByte(addr)
 	c.Breakpoints.Read(c, addr, val)
 	return val
 }
 
+// zpWord reads a pointer from the zero page.  The high byte of a pointer at
+// $FF comes from $00, not $0100.
+func (c *Core) zpWord(addr uint8) uint16 {
+	return uint16(c.ReadByte(uint16(addr))) | (uint16(c.ReadByte(uint16(addr+1))) << 8)
+}
+
+// indirectWord reads the pointer for JMP ($xxxx).  Like the real 6502 it
+// doesn't cross pages: JMP ($10FF) reads the high byte from $1000.
+func (c *Core) indirectWord(addr uint16) uint16 {
+	hi := (addr & 0xFF00) | uint16(uint8(addr)+1)
+	return uint16(c.ReadByte(addr)) | (uint16(c.ReadByte(hi)) << 8)
+}
+
 func (c *Core) ReadWord(addr uint16) uint16 {
 	defer func() { c.lastReadAddr = addr }() // will this fire off correctly? idk
 	return uint16(c.ReadByte(addr)) | (uint16(c.ReadByte(addr+1)) << 8)
@@ -114,6 +178,12 @@ func (c *Core) ReadWord(addr uint16) uint16 {
 
 // Write to an address.  This will delegate to API if needed.
 func (c *Core) WriteByte(addr uint16, value byte) {
+	if c.CodeWatch != nil {
+		c.watchWrite(addr, value)
+	}
+	if c.executing && c.Footprint != nil {
+		c.footprintAccess(addr, true)
+	}
 	c.Breakpoints.Write(c, addr, value)
 	c.memory.WriteByte(addr, value)
 }
@@ -135,6 +205,10 @@ func (c *Core) Run() error {
 		c.Debug = true
 	}
 
+	if c.StackCheck != nil {
+		c.StackCheck.start(int(c.SP))
+	}
+
 	start := time.Now()
 	defer func() { fmt.Printf("time: %s\n", time.Now().Sub(start)) }()
 
@@ -189,12 +263,29 @@ func (c *Core) RunRoutine(address uint16) error {
 
 	c.routineDepth = 0
 	c.runRoutine = true
+
+	// The final RTS pulls a return address that RunRoutine() never pushed.
+	// Pretend it's there so that isn't reported as an underflow.
+	if c.StackCheck != nil {
+		c.StackCheck.startRoutine(c.SP)
+	}
+
+	// Same for the footprint's stack depth.
+	if c.Footprint != nil {
+		c.Footprint.start(int(c.SP) + 2)
+		defer c.footprintDone()
+	}
+
+	c.callStack = []StackFrame{}
+	c.pushFrame(address, 0, "")
 	c.PC = address
 
 	var err error
 	for c.routineDepth > -1 && !c.stop {
 		err = c.tick()
-		if err != nil {
+		if err == ErrInstructionLimit {
+			return err
+		} else if err != nil {
 			c.DumpHistory()
 			return err
 		}
@@ -203,19 +294,171 @@ func (c *Core) RunRoutine(address uint16) error {
 	return nil
 }
 
-// Just walk through code, i guess?  haphazardly execute shit?
-func (c *Core) StaticDisassembly() error {
-	fmt.Println("len(c.dasmTrees):", len(c.dasmTrees))
-	for idx := 0; idx < len(c.dasmTrees); idx++ {
-		fmt.Printf("$%04X\n", c.dasmTrees[idx])
-		c.InstructionLimit = 1000
-		err := c.RunRoutine(c.dasmTrees[idx])
-		if err != nil {
-			return err
+// romDasm is implemented by memory managers that are backed by a mapper and
+// can take disassembly for banks that aren't currently mapped.
+type romDasm interface {
+	Mapper() mappers.Mapper
+	AddRomDasm(offset uint, instr *mmu.Disassembly)
+}
+
+// StaticDisassembly disassembles code reachable from the interrupt vectors,
+// the given entry points, and any branch targets seen while running with
+// Disassemble enabled.  Nothing is executed.  The result is also added to the
+// memory manager's disassembly.
+func (c *Core) StaticDisassembly(entries ...StaticEntry) *StaticResult {
+	for _, addr := range c.dasmTrees {
+		entries = append(entries, StaticEntry{Address: addr, Bank: -1})
+	}
+
+	rd, banked := c.memory.(romDasm)
+
+	var sd *StaticDisassembler
+	if banked {
+		sd = NewStaticDisassemblerFromMapper(rd.Mapper())
+	} else {
+		data := make([]byte, 0x10000)
+		for i := 0; i < len(data); i++ {
+			data[i] = c.memory.ReadByte(uint16(i))
 		}
+		sd = NewStaticDisassembler(data, []mappers.PrgWindow{{Start: 0, Size: 0x10000, FixedBank: 0}})
 	}
 
-	return nil
+	result := sd.Disassemble(entries...)
+	for _, bank := range result.Banks {
+		for _, instr := range bank.Instructions {
+			if banked {
+				rd.AddRomDasm(instr.Offset, instr.Disassembly())
+			} else {
+				c.memory.AddDasm(instr.Address, instr.Disassembly())
+			}
+		}
+	}
+
+	return result
+}
+
+// Instruction set used for decoding.  Includes the DBG opcode.  This is set
+// in init() because instructionList refers back to the decoder.
+var dasmVariant *disasm.Variant
+
+func init() {
+	dasmVariant = Variant()
+}
+
+// decode the instruction at the given address without side effects.
+func (c *Core) decode(address uint16) disasm.Instruction {
+	data := []byte{}
+	for i := uint16(0); i < 3; i++ {
+		data = append(data, c.memory.ReadByte(address+i))
+	}
+	return disasm.Decode(data, address, dasmVariant)
+}
+
+// symbol is a disasm.Resolver that uses the memory manager's labels.
+func (c *Core) symbol(address uint16, zeroPage bool) string {
+	if zeroPage {
+		return c.memory.GetZpLabel(uint8(address))
+	}
+	return c.memory.GetLabel(address)
+}
+
+// LabelAddress returns the CPU address of a label from the debug symbols or
+// the memory manager's labels.  PRG ROM labels are only found if their bank
+// is mapped in.
+func (c *Core) LabelAddress(name string) (uint16, error) {
+	if c.Symbols != nil {
+		if syms := c.Symbols.SymbolsNamed(name); len(syms) > 0 {
+			return c.symbolAddress(name, syms)
+		}
+	}
+
+	addr, t := c.memory.FindLabel(name)
+	switch t {
+	case labels.NesMemory, labels.NesInternalRam:
+		return uint16(addr), nil
+	case labels.NesWorkRam, labels.NesSaveRam:
+		return uint16(addr + 0x6000), nil
+	case labels.NesPrgRom:
+		if cpu, ok := c.prgAddress(uint32(addr)); ok {
+			return cpu, nil
+		}
+		return 0, fmt.Errorf("Label %q is in a bank that isn't mapped (PRG $%05X)", name, addr)
+	}
+
+	return 0, fmt.Errorf("Label %q does not exist", name)
+}
+
+// symbolAddress picks the symbol that's mapped in from a list of symbols
+// with the same name.  Without a bank switching memory manager the value
+// from the debug file is used as-is.
+func (c *Core) symbolAddress(name string, syms []*SymbolRecord) (uint16, error) {
+	if _, banked := c.memory.(mmu.PrgMapper); !banked {
+		if sym, err := c.Symbols.GetSymbol(name); err == nil {
+			return sym.Value, nil
+		}
+		return syms[0].Value, nil
+	}
+
+	var other *SymbolRecord
+	offset := uint32(0)
+	for _, sym := range syms {
+		prg, ok := c.Symbols.PrgOffset(sym)
+		if !ok {
+			if other == nil {
+				other = sym
+			}
+			continue
+		}
+
+		if cpu, ok := c.prgAddress(prg); ok {
+			return cpu, nil
+		}
+		offset = prg
+	}
+
+	if other != nil {
+		return other.Value, nil
+	}
+	return 0, fmt.Errorf("Label %q is in a bank that isn't mapped (PRG $%05X)", name, offset)
+}
+
+// prgAddress finds where a PRG ROM offset is mapped in CPU space.  Banks
+// are assumed to be at least 4k.
+func (c *Core) prgAddress(offset uint32) (uint16, bool) {
+	pm, ok := c.memory.(mmu.PrgMapper)
+	if !ok {
+		return 0, false
+	}
+
+	for addr := uint32(0x8000); addr < 0x10000; addr += 0x1000 {
+		start, ok := pm.PrgOffset(uint16(addr))
+		if ok && offset >= start && offset < start+0x1000 {
+			return uint16(addr + offset - start), true
+		}
+	}
+	return 0, false
+}
+
+// dasmFor builds the disassembly of the instruction at PC.
+func (c *Core) dasmFor() *mmu.Disassembly {
+	instr := c.decode(c.PC)
+	d := &mmu.Disassembly{
+		OpCode:  instr.OpCode(),
+		Value:   instr.Format(disasm.ResolverFunc(c.symbol)),
+		Size:    uint(instr.Size()),
+		Name:    instr.Name,
+		Format:  instr.Mode.Format(),
+		Operand: instr.Operand,
+		Target:  instr.HasTarget,
+	}
+
+	if instr.HasTarget {
+		d.Operand = instr.Target
+	}
+	if d.OpCode == OP_DEBUG {
+		d.Name = ""
+	}
+	return d
 }
 
 func (c *Core) DumpHistory() {
@@ -238,13 +481,46 @@ func (c *Core) DumpHistory() {
 	}
 }
 
+// History returns up to the last n history lines, oldest first.  Lines are
+// only kept while Debug is on.
+func (c *Core) History(n int) []string {
+	lines := []string{}
+	for i := 0; i < HistoryLength; i++ {
+		line := c.history[(c.historyIdx+i)%HistoryLength]
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if n >= 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+func (c *Core) ClearHistory() {
+	c.history = [HistoryLength]string{}
+	c.historyIdx = 0
+}
+
 func (c *Core) Halt() {
 	c.stop = true
 	fmt.Println("CPU Halt()'d")
 }
 
+// HardReset clears RAM and puts the mapper's registers back the way they
+// were when the core was made.
 func (c *Core) HardReset() {
+	if mo, ok := c.memory.(mapperOwner); ok && c.powerOn != nil {
+		mo.Mapper().SetState(c.powerOn)
+	}
 	c.memory.ClearRam()
+	if c.UninitializedReads != nil && c.UninitializedReads.ClearIsInit {
+		if tracker, ok := c.memory.(mmu.InitTracker); ok {
+			tracker.SetInitialized(true)
+		}
+	}
+	c.callStack = []StackFrame{}
 	c.A = 0
 	c.X = 0
 	c.Y = 0
@@ -257,12 +533,16 @@ func (c *Core) Reset() {
 	c.runInterrupt(VECTOR_RESET)
 }
 
+// IRQ is ignored while the interrupt disable flag is set.
 func (c *Core) IRQ() {
+	if c.Phlags&FLAG_INTERRUPT != 0 {
+		return
+	}
 	c.runInterrupt(VECTOR_IRQ)
 }
 
 func (c *Core) NMI() {
-	c.runInterrupt(VECTOR_IRQ)
+	c.runInterrupt(VECTOR_NMI)
 }
 
 func (c *Core) runInterrupt(interrupt uint16) {
@@ -290,8 +570,7 @@ func (c *Core) tick() error {
 	if c.InstructionLimit > 0 {
 		c.InstructionLimit--
 	} else if c.InstructionLimit == 0 {
-		c.Halt()
-		return nil
+		return ErrInstructionLimit
 	}
 
 	if c.nmiTicker != nil {
@@ -308,7 +587,10 @@ func (c *Core) tick() error {
 	}
 
 	c.Breakpoints.Execute(c, c.PC, 0)
+	c.opPC = c.PC
+	c.executing = true
 	opcode := c.ReadByte(c.PC)
+	c.executing = false
 
 	if opcode == 0xFF && c.testing {
 		c.testDone = true
@@ -321,17 +603,27 @@ func (c *Core) tick() error {
 		return fmt.Errorf("OP Code not implemented: [$%04X] $%02X", c.PC, opcode)
 	}
 
+	if c.CodeWatch != nil {
+		c.watchExecute(c.PC, instr.InstrLength())
+	}
+
+	if c.Footprint != nil {
+		c.footprintInstruction(instr)
+	}
+
 	if c.Disassemble {
 		//fmt.Printf("$%04X: %s\n", c.PC, instr.Decode(c))
-		c.memory.AddDasm(c.PC, instr.Decode(c), uint(instr.AddressMeta().Size()))
+		c.memory.AddDasm(c.PC, c.dasmFor())
 	}
 
 	oppc := c.PC
 	c.ticks++
+	c.executing = true
 	instr.Execute(c)
+	c.executing = false
 
 	if c.Debug {
-		dbgLine := c.HistoryString(oppc, instr)
+		dbgLine := c.HistoryString(oppc)
 
 		c.history[c.historyIdx] = dbgLine
 		c.historyIdx += 1
@@ -347,24 +639,56 @@ func (c *Core) tick() error {
 	return nil
 }
 
-func (c *Core) HistoryString(oppc uint16, instr Instruction) string {
-	l := instr.InstrLength()
+func (c *Core) HistoryString(oppc uint16) string {
+	instr := c.decode(oppc)
 	ops := []string{}
-	for i := uint8(0); i < l; i++ {
-		ops = append(ops, fmt.Sprintf("%02X", c.ReadByte(oppc+uint16(i))))
+	for _, b := range instr.Bytes {
+		ops = append(ops, fmt.Sprintf("%02X", b))
+	}
+
+	prefix := ""
+	if c.TraceSource && c.Symbols != nil {
+		prefix = c.sourcePrefix(oppc)
 	}
 
-	return fmt.Sprintf("[%06d] $%04X: %-9s %s %-17s %s %s",
+	return fmt.Sprintf("%s[%06d] $%04X: %-9s %s %-17s %s %s",
+		prefix,
 		c.ticks,
 		oppc,
 		strings.Join(ops, " "),
-		instr.Name(),
-		instr.AddressMeta().Asm(c, oppc), // oppc == OP code PC
+		instr.Name,
+		strings.TrimSpace(instr.OperandString(disasm.ResolverFunc(c.symbol))+" "+c.effective(instr)),
 		c.Registers(),
 		c.stackString(),
 	)
 }
 
+// effective describes what the operand points to with the current register
+// values.
+func (c *Core) effective(instr disasm.Instruction) string {
+	switch instr.Mode {
+	case disasm.ZeroPage:
+		return fmt.Sprintf("= %02X", c.ReadByte(instr.Operand))
+	case disasm.ZeroPageX:
+		return fmt.Sprintf("@ $%04X", uint16(uint8(instr.Operand)+c.X))
+	case disasm.ZeroPageY:
+		return fmt.Sprintf("@ $%04X", uint16(uint8(instr.Operand)+c.Y))
+	case disasm.AbsoluteX:
+		return fmt.Sprintf("@ $%04X", instr.Operand+uint16(c.X))
+	case disasm.AbsoluteY:
+		return fmt.Sprintf("@ $%04X", instr.Operand+uint16(c.Y))
+	case disasm.Indirect:
+		return fmt.Sprintf("@ $%04X", c.indirectWord(instr.Operand))
+	case disasm.IndirectX:
+		return fmt.Sprintf("@ $%04X", c.zpWord(uint8(instr.Operand)+c.X))
+	case disasm.IndirectY:
+		return fmt.Sprintf("@ $%04X", c.zpWord(uint8(instr.Operand))+uint16(c.Y))
+	case disasm.Relative:
+		return fmt.Sprintf("(%d)", int8(instr.Operand))
+	}
+	return ""
+}
+
 func (c *Core) Instructions() []string {
 	ret := []string{}
 	for _, instr := range instructionList {
@@ -619,11 +943,33 @@ func (c *Core) pullAddress() uint16 {
 }
 
 func (c *Core) pushByte(val uint8) {
+	if c.StackCheck != nil {
+		c.checkPush()
+	}
+
+	if c.Footprint != nil {
+		c.Footprint.stackOp = true
+	}
 	c.WriteByte(uint16(c.SP)|0x0100, val)
 	c.SP -= 1
+	if c.Footprint != nil {
+		c.Footprint.pushed(c.SP)
+	}
+
+	if c.StackCheck != nil {
+		c.pushed()
+	}
 }
 
 func (c *Core) pullByte() uint8 {
+	if c.StackCheck != nil {
+		c.checkPull()
+	}
+
 	c.SP += 1
+	if c.Footprint != nil {
+		c.Footprint.stackOp = true
+		defer func() { c.Footprint.stackOp = false }()
+	}
 	return c.ReadByte(uint16(c.SP) | 0x0100)
 }
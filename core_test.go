@@ -1,551 +1,774 @@
 package emu
 
 import (
+	"errors"
 	"fmt"
-	"strings"
+	"io"
 	"testing"
+
+	"github.com/zorchenhimer/emu-6502/disasm"
+	"github.com/zorchenhimer/emu-6502/labels"
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+// CPU conformance tests.  Every official opcode is run in every addressing
+// mode it has against testBus, which records each read and write.
+//
+// Decimal mode isn't emulated (the NES doesn't have it), so ADC and SBC are
+// checked in binary mode only.  The emulator isn't cycle accurate either, so
+// dummy reads and the double write of read-modify-write instructions aren't
+// expected.
+
+const (
+	testOrigin  uint16 = 0x0200 // where the instruction under test goes
+	testAddress uint16 = 0x1234 // effective address for absolute and indirect modes
+	testZp      uint16 = 0x0044 // effective address for zero page modes
 )
 
-var testsRun int = 0
-
-// Tests that check memory values in addition to register flags
-var memoryBased = []memTest{
-	// DEC
-	memTest{
-		"OP_DEC_AB",
-		[]byte{OP_DEC_AB, 0x00, 0x03},
-		memVal{0x0300, 0xFF},
-		regState{},
-		regState{0x00, 0x00, 0x00, 0x8003, FLAG_NEGATIVE, 0x00}},
-	memTest{
-		"OP_DEC_AX",
-		[]byte{OP_DEC_AX, 0x00, 0x03},
-		memVal{0x0302, 0xFF},
-		regState{x: 2},
-		regState{0x00, 0x02, 0x00, 0x8003, FLAG_NEGATIVE, 0x00}},
-	memTest{
-		"OP_DEC_ZP",
-		[]byte{OP_DEC_ZP, 0x03},
-		memVal{0x0003, 0x02},
-		regState{},
-		regState{0x00, 0x00, 0x00, 0x8002, 0x00, 0x00}},
-	memTest{
-		"OP_DEC_ZX",
-		[]byte{OP_DEC_ZX, 0x03},
-		memVal{0x0005, 0x04},
-		regState{x: 2},
-		regState{0x00, 0x02, 0x00, 0x8002, 0x00, 0x00}},
-
-	// INC
-	memTest{
-		"OP_INC_AB",
-		[]byte{OP_INC_AB, 0x00, 0x03},
-		memVal{0x0300, 0x01},
-		regState{},
-		regState{0x00, 0x00, 0x00, 0x8003, 0x00, 0x00}},
-	memTest{
-		"OP_INC_AX",
-		[]byte{OP_INC_AX, 0x00, 0x03},
-		memVal{0x0302, 0x01},
-		regState{x: 2},
-		regState{0x00, 0x02, 0x00, 0x8003, 0x00, 0x00}},
-	memTest{
-		"OP_INC_ZP",
-		[]byte{OP_INC_ZP, 0x03},
-		memVal{0x0003, 0x04},
-		regState{},
-		regState{0x00, 0x00, 0x00, 0x8002, 0x00, 0x00}},
-	memTest{
-		"OP_INC_ZX",
-		[]byte{OP_INC_ZX, 0x03},
-		memVal{0x0005, 0x06},
-		regState{x: 2},
-		regState{0x00, 0x02, 0x00, 0x8002, 0x00, 0x00}},
-
-	// STA
-	memTest{
-		"OP_STA_AB",
-		[]byte{OP_STA_AB, 0x00, 0x03},
-		memVal{0x0300, OP_STA_AB},
-		regState{a: OP_STA_AB},
-		regState{OP_STA_AB, 0x00, 0x00, 0x8003, 0x00, 0x00}},
-
-	memTest{
-		"OP_STA_ZP",
-		[]byte{OP_STA_ZP, 0x03},
-		memVal{0x0003, OP_STA_ZP},
-		regState{a: OP_STA_ZP},
-		regState{OP_STA_ZP, 0x00, 0x00, 0x8002, 0x00, 0x00}},
-	memTest{
-		"OP_STA_ZX",
-		[]byte{OP_STA_ZX, 0x03},
-		memVal{0x0006, OP_STA_ZX},
-		regState{a: OP_STA_ZX, x: 03},
-		regState{OP_STA_ZX, 0x03, 0x00, 0x8002, 0x00, 0x00}},
-
-	memTest{
-		"OP_STA_AX",
-		[]byte{OP_STA_AX, 0x03, 0x03},
-		memVal{0x0306, OP_STA_AX},
-		regState{a: OP_STA_AX, x: 03},
-		regState{OP_STA_AX, 0x03, 0x00, 0x8003, 0x00, 0x00}},
-	memTest{
-		"OP_STA_AY",
-		[]byte{OP_STA_AY, 0x03, 0x03},
-		memVal{0x0306, OP_STA_AY},
-		regState{a: OP_STA_AY, y: 03},
-		regState{OP_STA_AY, 0x00, 0x03, 0x8003, 0x00, 0x00}},
-
-	memTest{
-		"OP_STA_IX",
-		// pointer is at $0001 + 1 (x) = $0002
-		// should be a pointer val of $0302
-		[]byte{OP_STA_IX, 0x00},
-		memVal{0x0302, OP_STA_IX},
-		regState{a: OP_STA_IX, x: 02},
-		regState{OP_STA_IX, 0x02, 0x00, 0x8002, 0x00, 0x00}},
-	memTest{
-		"OP_STA_IY",
-		// pointer is $0002 = $0302 + 1 (y) = $0303
-		[]byte{OP_STA_IY, 0x02},
-		memVal{0x0303, OP_STA_IY},
-		regState{a: OP_STA_IY, y: 01},
-		regState{OP_STA_IY, 0x00, 0x01, 0x8002, 0x00, 0x00}},
-
-	memTest{
-		"OP_STX_AB",
-		[]byte{OP_STX_AB, 0x00, 0x03},
-		memVal{0x0300, OP_STX_AB},
-		regState{x: OP_STX_AB},
-		regState{x: OP_STX_AB, pc: 0x8003}},
-	memTest{
-		"OP_STX_ZP",
-		[]byte{OP_STX_ZP, 0x03},
-		memVal{0x0003, OP_STX_AB},
-		regState{x: OP_STX_AB},
-		regState{x: OP_STX_AB, pc: 0x8002}},
-	memTest{
-		"OP_STX_ZY",
-		[]byte{OP_STX_ZY, 0x03},
-		memVal{0x0006, OP_STX_AB},
-		regState{x: OP_STX_AB, y: 3},
-		regState{x: OP_STX_AB, y: 3, pc: 0x8002}},
-}
-
-var testData_A = []basicTest{
-
-	// Decrements
-	basicTest{
-		"OP_DEX",
-		[]byte{OP_DEX},
-		regState{},
-		regState{0x00, 0xFF, 0x00, 0x8001, FLAG_NEGATIVE, 0x00}},
-	basicTest{
-		"OP_DEY",
-		[]byte{OP_DEY},
-		regState{},
-		regState{0x00, 0x00, 0xFF, 0x8001, FLAG_NEGATIVE, 0x00}},
-
-	// Increments
-	basicTest{
-		"OP_DEX",
-		[]byte{OP_INX},
-		regState{},
-		regState{0x00, 0x01, 0x00, 0x8001, 0x00, 0x00}},
-	basicTest{
-		"OP_DEY",
-		[]byte{OP_INY},
-		regState{},
-		regState{0x00, 0x00, 0x01, 0x8001, 0x00, 0x00}},
-
-	// LDA
-	basicTest{
-		"OP_LDA_IM",
-		[]byte{OP_LDA_IM, 0x01},
-		regState{},
-		regState{0x01, 0x00, 0x00, 0x8002, 0x00, 0x00}},
-	basicTest{
-		"OP_LDA_AB",
-		[]byte{OP_LDA_AB, 0x00, 0x80},
-		regState{},
-		regState{OP_LDA_AB, 0x00, 0x00, 0x8003, FLAG_NEGATIVE, 0x00}},
-	basicTest{
-		"OP_LDA_ZP",
-		[]byte{OP_LDA_ZP, 0x01},
-		regState{},
-		regState{0x01, 0x00, 0x00, 0x8002, 0x00, 0x00}},
-	basicTest{
-		"OP_LDA_AX",
-		[]byte{OP_LDA_AX, 0xFD, 0x7F}, // address is three before OpCode
-		regState{x: 0x03},
-		regState{OP_LDA_AX, 0x03, 0x00, 0x8003, FLAG_NEGATIVE, 0x00}},
-	basicTest{
-		"OP_LDA_AY",
-		[]byte{OP_LDA_AY, 0xFD, 0x7F}, // address is three before OpCode
-		regState{y: 0x03},
-		regState{OP_LDA_AY, 0x00, 0x03, 0x8003, FLAG_NEGATIVE, 0x00}},
-	basicTest{
-		"OP_LDA_ZX",
-		[]byte{OP_LDA_ZX, 0x03}, // address is three before OpCode
-		regState{x: 0x03},
-		regState{0x06, 0x03, 0x00, 0x8002, 0x00, 0x00}},
-
-	//These will probably need to be in their own tests
-	basicTest{
-		"OP_LDA_IX",
-		[]byte{
-			OP_LDA_IM, 0x00,
-			OP_STA_ZP, 0x01,
-			OP_LDA_IM, 0x80,
-			OP_STA_ZP, 0x02,
-			OP_LDA_IX, 0x00,
-		},
-		regState{x: 1},
-		regState{OP_LDA_IM, 1, 0x00, 0x800A, FLAG_NEGATIVE, 0x00}},
-	basicTest{
-		"OP_LDA_IY",
-		[]byte{OP_LDA_IY, 0x7E}, // pointer should be $7F7E
-		regState{y: 130},        // *should* be OP_LDA_IY
-		regState{OP_LDA_IY, 0x00, 130, 0x8002, FLAG_NEGATIVE, 0x00}},
-
-	// LDX
-	basicTest{
-		"OP_LDX_AB",
-		[]byte{OP_LDX_AB, 0x00, 0x80},
-		regState{},
-		regState{0x00, OP_LDX_AB, 0x00, 0x8003, FLAG_NEGATIVE, 0x00}},
-	basicTest{
-		"OP_LDX_AY",
-		[]byte{OP_LDX_AY, 0xFD, 0x7F},
-		regState{y: 0x03},
-		regState{0x00, OP_LDX_AY, 0x03, 0x8003, FLAG_NEGATIVE, 0x00}},
-	basicTest{
-		"OP_LDX_IM",
-		[]byte{OP_LDX_IM, 0x04},
-		regState{},
-		regState{0x00, 0x04, 0x00, 0x8002, 0x00, 0x00}},
-	basicTest{
-		"OP_LDX_ZP",
-		[]byte{OP_LDX_ZP, 0x04},
-		regState{},
-		regState{0x00, 0x04, 0x00, 0x8002, 0x00, 0x00}},
-	basicTest{
-		"OP_LDX_ZY",
-		[]byte{OP_LDX_ZY, 0x01},
-		regState{y: 0x03},
-		regState{0x00, 0x04, 0x03, 0x8002, 0x00, 0x00}},
-
-	// LDY
-	basicTest{
-		"OP_LDY_IM",
-		[]byte{OP_LDY_IM, 0x04},
-		regState{},
-		regState{0x00, 0x00, 0x04, 0x8002, 0x00, 0x00}},
-	basicTest{
-		"OP_LDY_AB",
-		[]byte{OP_LDY_AB, 0x00, 0x80},
-		regState{},
-		regState{0x00, 0x00, OP_LDY_AB, 0x8003, FLAG_NEGATIVE, 0x00}},
-	basicTest{
-		"OP_LDY_ZP",
-		[]byte{OP_LDY_ZP, 0xF4},
-		regState{},
-		regState{0x00, 0x00, 0xF4, 0x8002, FLAG_NEGATIVE, 0x00}},
-	basicTest{
-		"OP_LDY_ZX",
-		[]byte{OP_LDY_ZX, 0x04},
-		regState{x: 0x03},
-		regState{x: 0x03, y: 0x07, pc: 0x8002}},
-	basicTest{
-		"OP_LDY_ZX",
-		[]byte{OP_LDY_ZX, 0x01},
-		regState{x: 0x03},
-		regState{0x00, 0x03, 0x04, 0x8002, 0x00, 0x00}},
-
-	basicTest{
-		"OP_NOP",
-		[]byte{OP_NOP},
-		regState{},
-		regState{pc: 0x8001}},
-
-	basicTest{
-		"OP_TAX",
-		[]byte{OP_TAX},
-		regState{a: 4},
-		regState{a: 4, x: 4, pc: 0x8001}},
-	basicTest{
-		"OP_TAY",
-		[]byte{OP_TAY},
-		regState{a: 4},
-		regState{a: 4, y: 4, pc: 0x8001}},
-	basicTest{
-		"OP_TSX",
-		[]byte{OP_TSX},
-		regState{stack: 4},
-		regState{x: 0x04, pc: 0x8001, stack: 4}},
-	basicTest{
-		"OP_TXA",
-		[]byte{OP_TXA},
-		regState{x: 4},
-		regState{a: 4, x: 4, pc: 0x8001}},
-	basicTest{
-		"OP_TXS",
-		[]byte{OP_TXS},
-		regState{x: 4},
-		regState{x: 0x04, pc: 0x8001, stack: 0x04}},
-}
-
-type regState struct {
-	a      uint8
-	x      uint8
-	y      uint8
-	pc     uint16
-	phlags uint8
-	stack  uint8
-}
-
-// Figure out how this'll work, lol
-type ExpectedResults interface {
-	GetExpected() regState
-}
-
-//type InitialState interface {
-//    GetInitialState() regState
-//}
-
-// single test case.  single OP code, and register state
-type basicTest struct {
-	name string
-	rom  []byte // should be no more than three bytes
-	//ticks int // ticks to perform
-	regInitial  regState
-	regExpected regState
-}
-
-func (bt basicTest) GetExpected() regState {
-	return bt.regExpected
-}
-
-func padToPage(input []byte) []byte {
-	for len(input)%256 != 0 {
-		input = append(input, 0x00)
+type busAccess struct {
+	Address uint16
+	Value   uint8
+	Write   bool
+}
+
+func (a busAccess) String() string {
+	if a.Write {
+		return fmt.Sprintf("W $%04X = $%02X", a.Address, a.Value)
 	}
+	return fmt.Sprintf("R $%04X = $%02X", a.Address, a.Value)
+}
 
-	return input
+// testBus is 64k of RAM that logs every access.
+type testBus struct {
+	ram [0x10000]uint8
+	log []busAccess
 }
 
-func TestBasic(t *testing.T) {
-	core := newTestCore(t)
-	for _, bt := range testData_A {
-		t.Run(bt.name, func(t *testing.T) {
-			testsRun++
+func (b *testBus) ReadByte(address uint16) uint8 {
+	b.log = append(b.log, busAccess{address, b.ram[address], false})
+	return b.ram[address]
+}
 
-			err := core.resetTest(t, bt.rom, nil)
-			if err != nil {
-				t.Errorf("%s: %v", bt.name, err)
-			}
+func (b *testBus) WriteByte(address uint16, value uint8) {
+	b.log = append(b.log, busAccess{address, value, true})
+	b.ram[address] = value
+}
 
-			core.setRegisters(t, bt.regInitial)
-
-			ticksran := 0
-
-			//for i := 0; i < bt.ticks; i++ {
-			for !core.testDone {
-				err = core.tick()
-				if err != nil {
-					//core.dumpPage(0, t)
-					t.Fatalf("%s: %v", bt.name, err)
-				}
-				ticksran++
-				if ticksran > 1000 {
-					t.Error("Tick limit hit")
-					break
-				}
-			}
-			ticksran -= 1 // remove the 0xFF "test done" tick
+func (b *testBus) GetLabel(address uint16) string                  { return fmt.Sprintf("$%04X", address) }
+func (b *testBus) GetZpLabel(address uint8) string                 { return fmt.Sprintf("$%02X", address) }
+func (b *testBus) FindLabel(name string) (uint, labels.MemoryType) { return 0, labels.NesMemory }
+func (b *testBus) Labels(t labels.MemoryType) labels.LabelMap      { return nil }
+func (b *testBus) SetLabels(p labels.Provider)                     {}
+func (b *testBus) AddDasm(address uint16, instr *mmu.Disassembly)  {}
+func (b *testBus) WriteDasm(w io.Writer) error                     { return nil }
+func (b *testBus) WriteDasmMode(w io.Writer, m mmu.DasmMode) error { return nil }
+func (b *testBus) WriteLinkerConfig(w io.Writer) error             { return nil }
+func (b *testBus) ClearRam()                                       { b.ram = [0x10000]uint8{} }
+func (b *testBus) Clone() mmu.Manager                              { return &testBus{ram: b.ram} }
+
+func (b *testBus) writes() []busAccess {
+	list := []busAccess{}
+	for _, a := range b.log {
+		if a.Write {
+			list = append(list, a)
+		}
+	}
+	return list
+}
 
-			core.checkRegisters(t, bt.name, bt.regExpected)
-			if t.Failed() {
-				core.dumpPage(0, t)
-				core.dumpPage(0x80, t)
-				core.dumpReg(t)
-			}
-			// TODO: phlags
-		})
+func (b *testBus) reads(address uint16) int {
+	count := 0
+	for _, a := range b.log {
+		if !a.Write && a.Address == address {
+			count++
+		}
+	}
+	return count
+}
+
+func (b *testBus) poke(address uint16, values ...uint8) {
+	for i, v := range values {
+		b.ram[address+uint16(i)] = v
 	}
 }
 
-type memVal struct {
-	addr uint16
-	val  byte
+func (b *testBus) pokeWord(address, value uint16) {
+	b.poke(address, word(value)...)
+}
+
+// word returns the little endian bytes of value.
+func word(value uint16) []byte {
+	return []byte{uint8(value), uint8(value >> 8)}
+}
+
+// newBusCore returns a core that starts at testOrigin with an empty bus
+// log.
+func newBusCore() (*Core, *testBus) {
+	bus := &testBus{}
+	bus.pokeWord(VECTOR_RESET, testOrigin)
+	c := NewCore(bus)
+	bus.log = nil
+	return c, bus
 }
 
-type memTest struct {
-	name string
-	rom  []byte
+// step runs a single instruction with a fresh bus log.
+func step(t *testing.T, c *Core, bus *testBus) {
+	t.Helper()
+	bus.log = nil
+	if err := c.tick(); err != nil {
+		t.Fatal(err)
+	}
+}
 
-	// memory locations to check
-	mem memVal
+type regs struct {
+	A, X, Y, SP, P uint8
+}
 
-	regInitial  regState
-	regExpected regState
+func (r regs) String() string {
+	return fmt.Sprintf("A:%02X X:%02X Y:%02X SP:%02X P:%s", r.A, r.X, r.Y, r.SP, flagsToString(r.P))
 }
 
-func (mt memTest) GetExpected() regState {
-	return mt.regExpected
+func (c *Core) setRegs(r regs) {
+	c.A, c.X, c.Y, c.SP, c.Phlags = r.A, r.X, r.Y, r.SP, r.P
 }
 
-//func (mt memTest) GetInitialState() {
-//	return mt.regInitial
-//}
+func (c *Core) regs() regs {
+	return regs{c.A, c.X, c.Y, c.SP, c.Phlags}
+}
 
-func TestMemory(t *testing.T) {
-	core := newTestCore(t)
-	for _, mt := range memoryBased {
-		t.Run(mt.name, func(t *testing.T) {
-			testsRun++
+func checkWrites(t *testing.T, bus *testBus, expect []busAccess) {
+	t.Helper()
+	writes := bus.writes()
+	if fmt.Sprint(writes) != fmt.Sprint(expect) {
+		t.Errorf("expected writes %v, got %v", expect, writes)
+	}
+}
 
-			err := core.resetTest(t, mt.rom, nil)
-			if err != nil {
-				t.Errorf("%s: %v", mt.name, err)
-			}
+func wr(address uint16, value uint8) busAccess {
+	return busAccess{address, value, true}
+}
 
-			core.setRegisters(t, mt.regInitial)
-			ticksran := 0
+type opKind int
 
-			for !core.testDone {
-				err = core.tick()
-				if err != nil {
-					t.Fatalf("%s: %v", mt.name, err)
-				}
-				ticksran++
-			}
-			ticksran -= 1 // remove the 0xFF "test done" tick
+const (
+	readOp   opKind = iota // only reads the operand
+	storeOp                // only writes it
+	modifyOp               // read-modify-write, or the accumulator
+)
 
-			core.checkRegisters(t, mt.name, mt.regExpected)
+// aluCase is run once for each addressing mode of an instruction.  Index
+// registers used for addressing are overwritten.
+type aluCase struct {
+	in  regs
+	m   uint8 // operand
+	out regs
+	mem uint8 // operand afterwards, for stores and read-modify-write
+}
 
-			if core.ReadByte(mt.mem.addr) != mt.mem.val {
-				t.Errorf("%s: Incorrect memory value at $%04X: Exp:$%02X Got:$%02X", mt.name, mt.mem.addr, mt.mem.val, core.ReadByte(mt.mem.addr))
-			}
+var aluTests = []struct {
+	name  string
+	kind  opKind
+	cases []aluCase
+}{
+	{"LDA", readOp, []aluCase{
+		{regs{A: 0xFF}, 0x00, regs{A: 0x00, P: FLAG_ZERO}, 0},
+		{regs{}, 0x80, regs{A: 0x80, P: FLAG_NEGATIVE}, 0},
+		{regs{P: FLAG_ZERO | FLAG_NEGATIVE | FLAG_CARRY}, 0x7F, regs{A: 0x7F, P: FLAG_CARRY}, 0},
+	}},
+	{"LDX", readOp, []aluCase{
+		{regs{X: 0xFF}, 0x00, regs{X: 0x00, P: FLAG_ZERO}, 0},
+		{regs{}, 0x80, regs{X: 0x80, P: FLAG_NEGATIVE}, 0},
+		{regs{P: FLAG_ZERO | FLAG_OVERFLOW}, 0x01, regs{X: 0x01, P: FLAG_OVERFLOW}, 0},
+	}},
+	{"LDY", readOp, []aluCase{
+		{regs{Y: 0xFF}, 0x00, regs{Y: 0x00, P: FLAG_ZERO}, 0},
+		{regs{}, 0x80, regs{Y: 0x80, P: FLAG_NEGATIVE}, 0},
+		{regs{P: FLAG_ZERO | FLAG_INTERRUPT}, 0x01, regs{Y: 0x01, P: FLAG_INTERRUPT}, 0},
+	}},
+
+	{"ADC", readOp, []aluCase{
+		{regs{A: 0x01}, 0x01, regs{A: 0x02}, 0},
+		{regs{A: 0x01, P: FLAG_CARRY}, 0x01, regs{A: 0x03}, 0},
+		{regs{A: 0x50}, 0x50, regs{A: 0xA0, P: FLAG_OVERFLOW | FLAG_NEGATIVE}, 0},
+		{regs{A: 0xFF}, 0x01, regs{A: 0x00, P: FLAG_CARRY | FLAG_ZERO}, 0},
+		{regs{A: 0x80}, 0x80, regs{A: 0x00, P: FLAG_CARRY | FLAG_ZERO | FLAG_OVERFLOW}, 0},
+		{regs{A: 0xFF, P: FLAG_CARRY}, 0xFF, regs{A: 0xFF, P: FLAG_CARRY | FLAG_NEGATIVE}, 0},
+		{regs{A: 0x7F, P: FLAG_CARRY}, 0x00, regs{A: 0x80, P: FLAG_OVERFLOW | FLAG_NEGATIVE}, 0},
+		{regs{A: 0x00, P: FLAG_CARRY}, 0xFF, regs{A: 0x00, P: FLAG_CARRY | FLAG_ZERO}, 0},
+		{regs{A: 0x09, P: FLAG_DECIMAL}, 0x01, regs{A: 0x0A, P: FLAG_DECIMAL}, 0},
+	}},
+	{"SBC", readOp, []aluCase{
+		{regs{A: 0x05, P: FLAG_CARRY}, 0x03, regs{A: 0x02, P: FLAG_CARRY}, 0},
+		{regs{A: 0x05}, 0x03, regs{A: 0x01, P: FLAG_CARRY}, 0},
+		{regs{A: 0x03, P: FLAG_CARRY}, 0x05, regs{A: 0xFE, P: FLAG_NEGATIVE}, 0},
+		{regs{A: 0x80, P: FLAG_CARRY}, 0x01, regs{A: 0x7F, P: FLAG_CARRY | FLAG_OVERFLOW}, 0},
+		{regs{A: 0x7F, P: FLAG_CARRY}, 0xFF, regs{A: 0x80, P: FLAG_OVERFLOW | FLAG_NEGATIVE}, 0},
+		{regs{A: 0x05, P: FLAG_CARRY}, 0x05, regs{A: 0x00, P: FLAG_CARRY | FLAG_ZERO}, 0},
+		{regs{A: 0x00}, 0x00, regs{A: 0xFF, P: FLAG_NEGATIVE}, 0},
+		{regs{A: 0x10, P: FLAG_CARRY | FLAG_DECIMAL}, 0x01, regs{A: 0x0F, P: FLAG_CARRY | FLAG_DECIMAL}, 0},
+	}},
+
+	{"AND", readOp, []aluCase{
+		{regs{A: 0xF0}, 0x0F, regs{A: 0x00, P: FLAG_ZERO}, 0},
+		{regs{A: 0xF0}, 0x90, regs{A: 0x90, P: FLAG_NEGATIVE}, 0},
+		{regs{A: 0xFF, P: FLAG_CARRY | FLAG_OVERFLOW}, 0x3C, regs{A: 0x3C, P: FLAG_CARRY | FLAG_OVERFLOW}, 0},
+	}},
+	{"ORA", readOp, []aluCase{
+		{regs{A: 0x00}, 0x00, regs{A: 0x00, P: FLAG_ZERO}, 0},
+		{regs{A: 0x80}, 0x01, regs{A: 0x81, P: FLAG_NEGATIVE}, 0},
+		{regs{A: 0x10, P: FLAG_ZERO | FLAG_CARRY}, 0x01, regs{A: 0x11, P: FLAG_CARRY}, 0},
+	}},
+	{"EOR", readOp, []aluCase{
+		{regs{A: 0xFF}, 0xFF, regs{A: 0x00, P: FLAG_ZERO}, 0},
+		{regs{A: 0x0F}, 0xFF, regs{A: 0xF0, P: FLAG_NEGATIVE}, 0},
+		{regs{A: 0x0F, P: FLAG_NEGATIVE | FLAG_CARRY}, 0x0A, regs{A: 0x05, P: FLAG_CARRY}, 0},
+	}},
+
+	{"CMP", readOp, []aluCase{
+		{regs{A: 0x40}, 0x40, regs{A: 0x40, P: FLAG_ZERO | FLAG_CARRY}, 0},
+		{regs{A: 0x41}, 0x40, regs{A: 0x41, P: FLAG_CARRY}, 0},
+		{regs{A: 0x40, P: FLAG_CARRY}, 0x41, regs{A: 0x40, P: FLAG_NEGATIVE}, 0},
+		{regs{A: 0x00}, 0x80, regs{A: 0x00, P: FLAG_NEGATIVE}, 0},
+		{regs{A: 0x80}, 0x00, regs{A: 0x80, P: FLAG_CARRY | FLAG_NEGATIVE}, 0},
+		{regs{A: 0x40, P: FLAG_OVERFLOW}, 0x40, regs{A: 0x40, P: FLAG_OVERFLOW | FLAG_ZERO | FLAG_CARRY}, 0},
+	}},
+	{"CPX", readOp, []aluCase{
+		{regs{X: 0x40}, 0x40, regs{X: 0x40, P: FLAG_ZERO | FLAG_CARRY}, 0},
+		{regs{X: 0x41}, 0x40, regs{X: 0x41, P: FLAG_CARRY}, 0},
+		{regs{X: 0x40, P: FLAG_CARRY}, 0x41, regs{X: 0x40, P: FLAG_NEGATIVE}, 0},
+		{regs{X: 0xFF, P: FLAG_OVERFLOW}, 0x00, regs{X: 0xFF, P: FLAG_OVERFLOW | FLAG_CARRY | FLAG_NEGATIVE}, 0},
+	}},
+	{"CPY", readOp, []aluCase{
+		{regs{Y: 0x40}, 0x40, regs{Y: 0x40, P: FLAG_ZERO | FLAG_CARRY}, 0},
+		{regs{Y: 0x41}, 0x40, regs{Y: 0x41, P: FLAG_CARRY}, 0},
+		{regs{Y: 0x40, P: FLAG_CARRY}, 0x41, regs{Y: 0x40, P: FLAG_NEGATIVE}, 0},
+		{regs{Y: 0xFF, P: FLAG_OVERFLOW}, 0x00, regs{Y: 0xFF, P: FLAG_OVERFLOW | FLAG_CARRY | FLAG_NEGATIVE}, 0},
+	}},
+
+	// N and V come from memory, Z from A & memory.
+	{"BIT", readOp, []aluCase{
+		{regs{A: 0x0F}, 0xC0, regs{A: 0x0F, P: FLAG_ZERO | FLAG_NEGATIVE | FLAG_OVERFLOW}, 0},
+		{regs{A: 0xFF}, 0x40, regs{A: 0xFF, P: FLAG_OVERFLOW}, 0},
+		{regs{A: 0x01, P: FLAG_NEGATIVE | FLAG_OVERFLOW | FLAG_CARRY}, 0x01, regs{A: 0x01, P: FLAG_CARRY}, 0},
+	}},
+
+	// Stores don't touch the flags.
+	{"STA", storeOp, []aluCase{
+		{regs{A: 0x42}, 0xEE, regs{A: 0x42}, 0x42},
+		{regs{A: 0x00, P: FLAG_NEGATIVE}, 0xEE, regs{A: 0x00, P: FLAG_NEGATIVE}, 0x00},
+	}},
+	{"STX", storeOp, []aluCase{
+		{regs{X: 0x42}, 0xEE, regs{X: 0x42}, 0x42},
+		{regs{X: 0x00, P: FLAG_NEGATIVE}, 0xEE, regs{X: 0x00, P: FLAG_NEGATIVE}, 0x00},
+	}},
+	{"STY", storeOp, []aluCase{
+		{regs{Y: 0x42}, 0xEE, regs{Y: 0x42}, 0x42},
+		{regs{Y: 0x00, P: FLAG_NEGATIVE}, 0xEE, regs{Y: 0x00, P: FLAG_NEGATIVE}, 0x00},
+	}},
+
+	{"ASL", modifyOp, []aluCase{
+		{regs{}, 0x81, regs{P: FLAG_CARRY}, 0x02},
+		{regs{}, 0x40, regs{P: FLAG_NEGATIVE}, 0x80},
+		{regs{}, 0x80, regs{P: FLAG_CARRY | FLAG_ZERO}, 0x00},
+		{regs{P: FLAG_CARRY}, 0x01, regs{}, 0x02},
+	}},
+	{"LSR", modifyOp, []aluCase{
+		{regs{}, 0x01, regs{P: FLAG_CARRY | FLAG_ZERO}, 0x00},
+		{regs{}, 0x82, regs{}, 0x41},
+		{regs{P: FLAG_CARRY | FLAG_NEGATIVE}, 0x80, regs{}, 0x40},
+		{regs{P: FLAG_NEGATIVE}, 0xFF, regs{P: FLAG_CARRY}, 0x7F},
+	}},
+	{"ROL", modifyOp, []aluCase{
+		{regs{}, 0x80, regs{P: FLAG_CARRY | FLAG_ZERO}, 0x00},
+		{regs{P: FLAG_CARRY}, 0x40, regs{P: FLAG_NEGATIVE}, 0x81},
+		{regs{P: FLAG_CARRY}, 0x01, regs{}, 0x03},
+		{regs{P: FLAG_CARRY}, 0xFF, regs{P: FLAG_CARRY | FLAG_NEGATIVE}, 0xFF},
+	}},
+	{"ROR", modifyOp, []aluCase{
+		{regs{}, 0x01, regs{P: FLAG_CARRY | FLAG_ZERO}, 0x00},
+		{regs{P: FLAG_CARRY}, 0x02, regs{P: FLAG_NEGATIVE}, 0x81},
+		{regs{P: FLAG_CARRY}, 0x81, regs{P: FLAG_CARRY | FLAG_NEGATIVE}, 0xC0},
+		{regs{}, 0xFE, regs{}, 0x7F},
+	}},
+	{"INC", modifyOp, []aluCase{
+		{regs{}, 0xFF, regs{P: FLAG_ZERO}, 0x00},
+		{regs{}, 0x7F, regs{P: FLAG_NEGATIVE}, 0x80},
+		{regs{P: FLAG_CARRY | FLAG_ZERO}, 0x10, regs{P: FLAG_CARRY}, 0x11},
+	}},
+	{"DEC", modifyOp, []aluCase{
+		{regs{}, 0x01, regs{P: FLAG_ZERO}, 0x00},
+		{regs{}, 0x00, regs{P: FLAG_NEGATIVE}, 0xFF},
+		{regs{P: FLAG_CARRY}, 0x81, regs{P: FLAG_CARRY | FLAG_NEGATIVE}, 0x80},
+	}},
+}
 
-			if t.Failed() {
-				core.dumpPage(0x00, t)
-				core.dumpPage(0x03, t)
-				core.dumpPage(0x80, t)
-				core.dumpReg(t)
-			}
-		})
+// placeOperand writes the instruction to testOrigin and puts value where the
+// addressing mode will find it.  Index registers and pointers are set so the
+// effective address is testZp for the zero page modes and testAddress for
+// the rest.
+func placeOperand(c *Core, bus *testBus, op byte, mode disasm.Mode, value uint8) uint16 {
+	ea := testAddress
+	code := []byte{op}
+
+	switch mode {
+	case disasm.Accumulator:
+		c.A = value
+		bus.poke(testOrigin, code...)
+		return 0
+
+	case disasm.Immediate:
+		ea = testOrigin + 1
+		code = append(code, value)
+
+	case disasm.ZeroPage:
+		ea = testZp
+		code = append(code, uint8(testZp))
+	case disasm.ZeroPageX:
+		ea = testZp
+		c.X = 4
+		code = append(code, uint8(testZp)-4)
+	case disasm.ZeroPageY:
+		ea = testZp
+		c.Y = 4
+		code = append(code, uint8(testZp)-4)
+
+	case disasm.Absolute:
+		code = append(code, word(testAddress)...)
+	case disasm.AbsoluteX:
+		c.X = 4
+		code = append(code, word(testAddress-4)...)
+	case disasm.AbsoluteY:
+		c.Y = 4
+		code = append(code, word(testAddress-4)...)
+
+	case disasm.IndirectX:
+		c.X = 4
+		code = append(code, 0x20)
+		bus.pokeWord(0x0024, testAddress)
+	case disasm.IndirectY:
+		c.Y = 4
+		code = append(code, 0x30)
+		bus.pokeWord(0x0030, testAddress-4)
+
+	default:
+		panic("placeOperand: unexpected mode " + mode.String())
+	}
+
+	bus.poke(testOrigin, code...)
+	if mode != disasm.Immediate {
+		bus.poke(ea, value)
 	}
+	return ea
 }
 
-func TestEnd(t *testing.T) {
-	t.Logf("Tests run: %d", testsRun)
+// aluOpcodes returns the official opcodes for an instruction.
+func aluOpcodes(name string) []byte {
+	ops := []byte{}
+	for op := 0; op < 256; op++ {
+		if def, ok := dasmVariant.Lookup(byte(op)); ok && def.Name == name {
+			ops = append(ops, byte(op))
+		}
+	}
+	return ops
 }
 
-// Core helper functions
-func (core *Core) checkRegisters(t *testing.T, name string, e regState) {
-	t.Helper()
-	if core.A != e.a {
-		t.Errorf("%s: Incorrect A: Exp:$%02X Got:$%02X", name, e.a, core.A)
+func TestInstructions(t *testing.T) {
+	for _, at := range aluTests {
+		for _, op := range aluOpcodes(at.name) {
+			def, _ := dasmVariant.Lookup(op)
+			for i, tc := range at.cases {
+				t.Run(fmt.Sprintf("%s_%s_%d", at.name, def.Mode, i), func(t *testing.T) {
+					runAluCase(t, at.kind, op, def.Mode, tc)
+				})
+			}
+		}
 	}
+}
 
-	if core.X != e.x {
-		t.Errorf("%s: Incorrect X: Exp:$%02X Got:$%02X", name, e.x, core.X)
+func runAluCase(t *testing.T, kind opKind, op byte, mode disasm.Mode, tc aluCase) {
+	c, bus := newBusCore()
+	c.setRegs(tc.in)
+	ea := placeOperand(c, bus, op, mode, tc.m)
+
+	expect := tc.out
+	switch mode {
+	case disasm.ZeroPageX, disasm.AbsoluteX, disasm.IndirectX:
+		expect.X = c.X
+	case disasm.ZeroPageY, disasm.AbsoluteY, disasm.IndirectY:
+		expect.Y = c.Y
+	case disasm.Accumulator:
+		expect.A = tc.mem
 	}
 
-	if core.Y != e.y {
-		t.Errorf("%s: Incorrect Y: Exp:$%02X Got:$%02X", name, e.y, core.Y)
+	step(t, c, bus)
+
+	if got := c.regs(); got != expect {
+		t.Errorf("$%02X %s: expected %s, got %s", op, mode, expect, got)
 	}
 
-	if core.PC != e.pc {
-		t.Errorf("%s: Incorrect PC: Exp:$%04X Got:$%04X", name, e.pc, core.PC)
+	if pc := testOrigin + uint16(mode.Size()); c.PC != pc {
+		t.Errorf("expected PC $%04X, got $%04X", pc, c.PC)
 	}
 
-	if core.Phlags != e.phlags {
-		t.Errorf("%s: Incorrect Phlags: Exp:$%02X Got:$%02X", name, e.phlags, core.Phlags)
+	switch {
+	case mode == disasm.Accumulator:
+		checkWrites(t, bus, nil)
+	case kind == readOp:
+		checkWrites(t, bus, nil)
+		if n := bus.reads(ea); n != 1 {
+			t.Errorf("expected one read of $%04X, got %d: %v", ea, n, bus.log)
+		}
+	case kind == storeOp:
+		checkWrites(t, bus, []busAccess{wr(ea, tc.mem)})
+	case kind == modifyOp:
+		checkWrites(t, bus, []busAccess{wr(ea, tc.mem)})
+		if n := bus.reads(ea); n != 1 {
+			t.Errorf("expected one read of $%04X, got %d: %v", ea, n, bus.log)
+		}
 	}
+}
+
+// stepTest runs a single instruction from code at org (testOrigin if
+// zero).
+type stepTest struct {
+	name   string
+	org    uint16
+	code   []byte
+	mem    map[uint16]uint8
+	in     regs
+	out    regs
+	pc     uint16      // PC afterwards
+	writes []busAccess // every write, in order
+}
+
+var stepTests = []stepTest{
+	// Transfers
+	{name: "TAX", code: []byte{OP_TAX}, in: regs{A: 0x80}, out: regs{A: 0x80, X: 0x80, P: FLAG_NEGATIVE}, pc: 0x0201},
+	{name: "TAX zero", code: []byte{OP_TAX}, in: regs{X: 0x12}, out: regs{P: FLAG_ZERO}, pc: 0x0201},
+	{name: "TAY", code: []byte{OP_TAY}, in: regs{A: 0x04, P: FLAG_ZERO}, out: regs{A: 0x04, Y: 0x04}, pc: 0x0201},
+	{name: "TXA", code: []byte{OP_TXA}, in: regs{X: 0x80}, out: regs{A: 0x80, X: 0x80, P: FLAG_NEGATIVE}, pc: 0x0201},
+	{name: "TYA", code: []byte{OP_TYA}, in: regs{A: 0x12}, out: regs{P: FLAG_ZERO}, pc: 0x0201},
+	{name: "TSX", code: []byte{OP_TSX}, in: regs{SP: 0xF0}, out: regs{X: 0xF0, SP: 0xF0, P: FLAG_NEGATIVE}, pc: 0x0201},
+	{name: "TXS no flags", code: []byte{OP_TXS}, in: regs{X: 0x00, SP: 0xFF}, out: regs{}, pc: 0x0201},
+	{name: "TXS", code: []byte{OP_TXS}, in: regs{X: 0x80, P: FLAG_ZERO}, out: regs{X: 0x80, SP: 0x80, P: FLAG_ZERO}, pc: 0x0201},
+
+	// Increments and decrements wrap
+	{name: "INX", code: []byte{OP_INX}, in: regs{X: 0x7F}, out: regs{X: 0x80, P: FLAG_NEGATIVE}, pc: 0x0201},
+	{name: "INX wrap", code: []byte{OP_INX}, in: regs{X: 0xFF}, out: regs{P: FLAG_ZERO}, pc: 0x0201},
+	{name: "INY", code: []byte{OP_INY}, in: regs{Y: 0x01, P: FLAG_CARRY}, out: regs{Y: 0x02, P: FLAG_CARRY}, pc: 0x0201},
+	{name: "INY wrap", code: []byte{OP_INY}, in: regs{Y: 0xFF}, out: regs{P: FLAG_ZERO}, pc: 0x0201},
+	{name: "DEX", code: []byte{OP_DEX}, in: regs{X: 0x01}, out: regs{P: FLAG_ZERO}, pc: 0x0201},
+	{name: "DEX wrap", code: []byte{OP_DEX}, in: regs{}, out: regs{X: 0xFF, P: FLAG_NEGATIVE}, pc: 0x0201},
+	{name: "DEY", code: []byte{OP_DEY}, in: regs{Y: 0x81}, out: regs{Y: 0x80, P: FLAG_NEGATIVE}, pc: 0x0201},
+	{name: "DEY wrap", code: []byte{OP_DEY}, in: regs{}, out: regs{Y: 0xFF, P: FLAG_NEGATIVE}, pc: 0x0201},
+
+	// Flags
+	{name: "CLC", code: []byte{OP_CLC}, in: regs{P: 0xFF &^ FLAG_BREAK}, out: regs{P: 0xFF &^ FLAG_BREAK &^ FLAG_CARRY}, pc: 0x0201},
+	{name: "SEC", code: []byte{OP_SEC}, in: regs{}, out: regs{P: FLAG_CARRY}, pc: 0x0201},
+	{name: "CLI", code: []byte{OP_CLI}, in: regs{P: FLAG_INTERRUPT | FLAG_CARRY}, out: regs{P: FLAG_CARRY}, pc: 0x0201},
+	{name: "SEI", code: []byte{OP_SEI}, in: regs{}, out: regs{P: FLAG_INTERRUPT}, pc: 0x0201},
+	{name: "CLD", code: []byte{OP_CLD}, in: regs{P: FLAG_DECIMAL | FLAG_ZERO}, out: regs{P: FLAG_ZERO}, pc: 0x0201},
+	{name: "SED", code: []byte{OP_SED}, in: regs{}, out: regs{P: FLAG_DECIMAL}, pc: 0x0201},
+	{name: "CLV", code: []byte{OP_CLV}, in: regs{P: FLAG_OVERFLOW | FLAG_NEGATIVE}, out: regs{P: FLAG_NEGATIVE}, pc: 0x0201},
+	{name: "NOP", code: []byte{OP_NOP}, in: regs{A: 1, X: 2, Y: 3, SP: 4, P: FLAG_CARRY}, out: regs{A: 1, X: 2, Y: 3, SP: 4, P: FLAG_CARRY}, pc: 0x0201},
+
+	// Stack.  Bits 4 and 5 are always set when P is pushed and never kept
+	// when it's pulled.
+	{name: "PHA", code: []byte{OP_PHA}, in: regs{A: 0x42, SP: 0xFF}, out: regs{A: 0x42, SP: 0xFE}, pc: 0x0201,
+		writes: []busAccess{wr(0x01FF, 0x42)}},
+	{name: "PHA wrap", code: []byte{OP_PHA}, in: regs{A: 0x42, SP: 0x00}, out: regs{A: 0x42, SP: 0xFF}, pc: 0x0201,
+		writes: []busAccess{wr(0x0100, 0x42)}},
+	{name: "PHP", code: []byte{OP_PHP}, in: regs{SP: 0xFF, P: FLAG_CARRY | FLAG_NEGATIVE}, out: regs{SP: 0xFE, P: FLAG_CARRY | FLAG_NEGATIVE}, pc: 0x0201,
+		writes: []busAccess{wr(0x01FF, 0xB1)}},
+	{name: "PLA", code: []byte{OP_PLA}, mem: map[uint16]uint8{0x01FF: 0x80}, in: regs{SP: 0xFE, P: FLAG_ZERO}, out: regs{A: 0x80, SP: 0xFF, P: FLAG_NEGATIVE}, pc: 0x0201},
+	{name: "PLA zero", code: []byte{OP_PLA}, mem: map[uint16]uint8{0x01FF: 0x00}, in: regs{A: 0x12, SP: 0xFE}, out: regs{SP: 0xFF, P: FLAG_ZERO}, pc: 0x0201},
+	{name: "PLA wrap", code: []byte{OP_PLA}, mem: map[uint16]uint8{0x0100: 0x42}, in: regs{SP: 0xFF}, out: regs{A: 0x42, SP: 0x00}, pc: 0x0201},
+	{name: "PLP", code: []byte{OP_PLP}, mem: map[uint16]uint8{0x01FF: 0xFF}, in: regs{SP: 0xFE}, out: regs{SP: 0xFF, P: 0xCF}, pc: 0x0201},
+
+	// Jumps
+	{name: "JMP", code: []byte{OP_JMP_AB, 0x34, 0x12}, pc: 0x1234},
+	{name: "JMP indirect", code: []byte{OP_JMP_ID, 0x00, 0x30}, mem: map[uint16]uint8{0x3000: 0x34, 0x3001: 0x12}, pc: 0x1234},
+	{name: "JMP indirect page bug", code: []byte{OP_JMP_ID, 0xFF, 0x30},
+		mem: map[uint16]uint8{0x30FF: 0x34, 0x3000: 0x12, 0x3100: 0x56}, pc: 0x1234},
+	{name: "JSR", code: []byte{OP_JSR, 0x34, 0x12}, in: regs{SP: 0xFF}, out: regs{SP: 0xFD}, pc: 0x1234,
+		writes: []busAccess{wr(0x01FF, 0x02), wr(0x01FE, 0x02)}},
+	{name: "JSR wrap", code: []byte{OP_JSR, 0x34, 0x12}, in: regs{SP: 0x00}, out: regs{SP: 0xFE}, pc: 0x1234,
+		writes: []busAccess{wr(0x0100, 0x02), wr(0x01FF, 0x02)}},
+	{name: "RTS", code: []byte{OP_RTS}, mem: map[uint16]uint8{0x01FE: 0x33, 0x01FF: 0x12}, in: regs{SP: 0xFD}, out: regs{SP: 0xFF}, pc: 0x1234},
+	{name: "RTI", code: []byte{OP_RTI}, mem: map[uint16]uint8{0x01FD: 0xF3, 0x01FE: 0x34, 0x01FF: 0x12},
+		in: regs{SP: 0xFC}, out: regs{SP: 0xFF, P: 0xC3}, pc: 0x1234},
+	{name: "BRK", code: []byte{OP_BRK, 0x00}, mem: map[uint16]uint8{0xFFFE: 0x34, 0xFFFF: 0x12},
+		in: regs{SP: 0xFF, P: FLAG_CARRY}, out: regs{SP: 0xFC, P: FLAG_CARRY | FLAG_INTERRUPT}, pc: 0x1234,
+		writes: []busAccess{wr(0x01FF, 0x02), wr(0x01FE, 0x02), wr(0x01FD, 0x31)}},
+
+	// Branches are relative to the next instruction.
+	{name: "BCC taken", code: []byte{OP_BCC, 0x10}, pc: 0x0212},
+	{name: "BCC not taken", code: []byte{OP_BCC, 0x10}, in: regs{P: FLAG_CARRY}, out: regs{P: FLAG_CARRY}, pc: 0x0202},
+	{name: "BCS taken", code: []byte{OP_BCS, 0xFE}, in: regs{P: FLAG_CARRY}, out: regs{P: FLAG_CARRY}, pc: 0x0200},
+	{name: "BCS not taken", code: []byte{OP_BCS, 0xFE}, pc: 0x0202},
+	{name: "BEQ taken", code: []byte{OP_BEQ, 0x7F}, in: regs{P: FLAG_ZERO}, out: regs{P: FLAG_ZERO}, pc: 0x0281},
+	{name: "BEQ not taken", code: []byte{OP_BEQ, 0x7F}, pc: 0x0202},
+	{name: "BNE taken", code: []byte{OP_BNE, 0x80}, pc: 0x0182},
+	{name: "BNE not taken", code: []byte{OP_BNE, 0x80}, in: regs{P: FLAG_ZERO}, out: regs{P: FLAG_ZERO}, pc: 0x0202},
+	{name: "BMI taken", code: []byte{OP_BMI, 0x04}, in: regs{P: FLAG_NEGATIVE}, out: regs{P: FLAG_NEGATIVE}, pc: 0x0206},
+	{name: "BMI not taken", code: []byte{OP_BMI, 0x04}, pc: 0x0202},
+	{name: "BPL taken", code: []byte{OP_BPL, 0xF0}, pc: 0x01F2},
+	{name: "BPL not taken", code: []byte{OP_BPL, 0xF0}, in: regs{P: FLAG_NEGATIVE}, out: regs{P: FLAG_NEGATIVE}, pc: 0x0202},
+	{name: "BVC taken", code: []byte{OP_BVC, 0x00}, pc: 0x0202},
+	{name: "BVC not taken", code: []byte{OP_BVC, 0x20}, in: regs{P: FLAG_OVERFLOW}, out: regs{P: FLAG_OVERFLOW}, pc: 0x0202},
+	{name: "BVS taken", code: []byte{OP_BVS, 0x20}, in: regs{P: FLAG_OVERFLOW}, out: regs{P: FLAG_OVERFLOW}, pc: 0x0222},
+	{name: "BVS not taken", code: []byte{OP_BVS, 0x20}, pc: 0x0202},
+	{name: "branch forward across a page", org: 0x02F0, code: []byte{OP_BNE, 0x20}, pc: 0x0312},
+	{name: "branch back across a page", org: 0x0300, code: []byte{OP_BEQ, 0xF0}, in: regs{P: FLAG_ZERO}, out: regs{P: FLAG_ZERO}, pc: 0x02F2},
+
+	// Zero page indexing stays in the zero page.
+	{name: "LDA zp,X wrap", code: []byte{OP_LDA_ZX, 0xF0}, mem: map[uint16]uint8{0x0010: 0x42, 0x0110: 0x99},
+		in: regs{X: 0x20}, out: regs{A: 0x42, X: 0x20}, pc: 0x0202},
+	{name: "LDX zp,Y wrap", code: []byte{OP_LDX_ZY, 0xF0}, mem: map[uint16]uint8{0x0010: 0x42, 0x0110: 0x99},
+		in: regs{Y: 0x20}, out: regs{X: 0x42, Y: 0x20}, pc: 0x0202},
+	{name: "STA zp,X wrap", code: []byte{OP_STA_ZX, 0xFF}, in: regs{A: 0x42, X: 0x02}, out: regs{A: 0x42, X: 0x02}, pc: 0x0202,
+		writes: []busAccess{wr(0x0001, 0x42)}},
+	{name: "STY zp,X wrap", code: []byte{OP_STY_ZX, 0x80}, in: regs{X: 0x80, Y: 0x42}, out: regs{X: 0x80, Y: 0x42}, pc: 0x0202,
+		writes: []busAccess{wr(0x0000, 0x42)}},
+	{name: "INC zp,X wrap", code: []byte{OP_INC_ZX, 0xFF}, mem: map[uint16]uint8{0x0000: 0x41}, in: regs{X: 0x01}, out: regs{X: 0x01}, pc: 0x0202,
+		writes: []busAccess{wr(0x0000, 0x42)}},
+
+	// So do the pointers for the indirect modes.
+	{name: "LDA (zp,X) wrap", code: []byte{OP_LDA_IX, 0xF0}, in: regs{X: 0x14}, out: regs{A: 0x42, X: 0x14}, pc: 0x0202,
+		mem: map[uint16]uint8{0x0004: 0x34, 0x0005: 0x12, 0x0104: 0x78, 0x0105: 0x56, 0x1234: 0x42}},
+	{name: "LDA (zp,X) pointer at $FF", code: []byte{OP_LDA_IX, 0xFF}, out: regs{A: 0x42}, pc: 0x0202,
+		mem: map[uint16]uint8{0x00FF: 0x34, 0x0000: 0x12, 0x0100: 0x56, 0x1234: 0x42, 0x5634: 0x99}},
+	{name: "LDA (zp),Y pointer at $FF", code: []byte{OP_LDA_IY, 0xFF}, in: regs{Y: 0x01}, out: regs{A: 0x42, Y: 0x01}, pc: 0x0202,
+		mem: map[uint16]uint8{0x00FF: 0x33, 0x0000: 0x12, 0x0100: 0x56, 0x1234: 0x42, 0x5634: 0x99}},
+	{name: "STA (zp),Y pointer at $FF", code: []byte{OP_STA_IY, 0xFF}, in: regs{A: 0x42, Y: 0x01}, out: regs{A: 0x42, Y: 0x01}, pc: 0x0202,
+		mem: map[uint16]uint8{0x00FF: 0x33, 0x0000: 0x12, 0x0100: 0x56}, writes: []busAccess{wr(0x1234, 0x42)}},
+
+	// Indexing crosses pages and wraps at the top of memory.
+	{name: "LDA (zp),Y across a page", code: []byte{OP_LDA_IY, 0x30}, in: regs{Y: 0x20}, out: regs{A: 0x42, Y: 0x20}, pc: 0x0202,
+		mem: map[uint16]uint8{0x0030: 0xF0, 0x0031: 0x12, 0x1310: 0x42, 0x1210: 0x99}},
+	{name: "LDA (zp),Y wrap", code: []byte{OP_LDA_IY, 0x30}, in: regs{Y: 0x20}, out: regs{A: 0x42, Y: 0x20}, pc: 0x0202,
+		mem: map[uint16]uint8{0x0030: 0xF0, 0x0031: 0xFF, 0x0010: 0x42}},
+	{name: "LDA abs,Y across a page", code: []byte{OP_LDA_AY, 0xF0, 0x12}, in: regs{Y: 0x20}, out: regs{A: 0x42, Y: 0x20}, pc: 0x0203,
+		mem: map[uint16]uint8{0x1310: 0x42, 0x1210: 0x99}},
+	{name: "LDA abs,X wrap", code: []byte{OP_LDA_AX, 0xF0, 0xFF}, in: regs{X: 0x20}, out: regs{A: 0x42, X: 0x20}, pc: 0x0203,
+		mem: map[uint16]uint8{0x0010: 0x42}},
+	{name: "STA abs,X wrap", code: []byte{OP_STA_AX, 0xFF, 0xFF}, in: regs{A: 0x42, X: 0x01}, out: regs{A: 0x42, X: 0x01}, pc: 0x0203,
+		writes: []busAccess{wr(0x0000, 0x42)}},
+	{name: "ASL abs,X across a page", code: []byte{OP_ASL_AX, 0xFF, 0x12}, mem: map[uint16]uint8{0x1300: 0x21, 0x1200: 0x99},
+		in: regs{X: 0x01}, out: regs{X: 0x01}, pc: 0x0203, writes: []busAccess{wr(0x1300, 0x42)}},
+}
 
-	if core.SP != e.stack {
-		t.Errorf("%s: Incorrect Stack Pointer: Exp:$%02X Got:$%02X", name, e.stack, core.SP)
+func TestStep(t *testing.T) {
+	for _, st := range stepTests {
+		t.Run(st.name, func(t *testing.T) {
+			c, bus := newBusCore()
+			org := st.org
+			if org == 0 {
+				org = testOrigin
+			}
+
+			for addr, val := range st.mem {
+				bus.poke(addr, val)
+			}
+			bus.poke(org, st.code...)
+			c.PC = org
+			c.setRegs(st.in)
+
+			step(t, c, bus)
+
+			if got := c.regs(); got != st.out {
+				t.Errorf("expected %s, got %s", st.out, got)
+			}
+			if c.PC != st.pc {
+				t.Errorf("expected PC $%04X, got $%04X", st.pc, c.PC)
+			}
+			checkWrites(t, bus, st.writes)
+		})
 	}
 }
 
-func (core *Core) setRegisters(t *testing.T, r regState) {
-	t.Helper()
-	core.A = r.a
-	core.X = r.x
-	core.Y = r.y
-	//core.PC = r.pc
-	core.Phlags = r.phlags
-	core.SP = r.stack
+// Trace lines wrap pointers the same way the CPU does.
+func TestEffectiveAddress(t *testing.T) {
+	c, bus := newBusCore()
+	bus.poke(0x00FF, 0x34)
+	bus.poke(0x0000, 0x12)
+	bus.poke(0x0100, 0x99)
+	bus.poke(0x10FF, 0x78)
+	bus.poke(0x1000, 0x56)
+	bus.poke(0x1100, 0x99)
+	c.X = 0x01
+	c.Y = 0x02
+
+	tests := []struct {
+		code   []byte
+		expect string
+	}{
+		{[]byte{0x6C, 0xFF, 0x10}, "@ $5678"}, // JMP ($10FF)
+		{[]byte{0xA1, 0xFE}, "@ $1234"},       // LDA ($FE,X)
+		{[]byte{0xB1, 0xFF}, "@ $1236"},       // LDA ($FF),Y
+	}
+	for _, tc := range tests {
+		instr := disasm.Decode(tc.code, testOrigin, dasmVariant)
+		if got := c.effective(instr); got != tc.expect {
+			t.Errorf("%s: expected %q, got %q", instr, tc.expect, got)
+		}
+	}
 }
 
-func (c *Core) resetTest(t *testing.T, rom, ram []byte) error {
-	t.Helper()
-	rom = PadWithVectors(rom, 0x8000, 0x8000, 0x8000)
-	if len(rom)%256 != 0 {
-		return fmt.Errorf("ROM is not divisible by 256: %d", len(rom))
+// Every official opcode needs to be covered by aluTests or stepTests.
+func TestOpcodeCoverage(t *testing.T) {
+	covered := map[byte]bool{}
+	for _, at := range aluTests {
+		if len(at.cases) == 0 {
+			continue
+		}
+		for _, op := range aluOpcodes(at.name) {
+			covered[op] = true
+		}
+	}
+	for _, st := range stepTests {
+		covered[st.code[0]] = true
+	}
+
+	count := 0
+	for op := 0; op < 256; op++ {
+		if _, ok := dasmVariant.Lookup(byte(op)); !ok || byte(op) == OP_DEBUG {
+			continue
+		}
+		count++
+		if !covered[byte(op)] {
+			t.Errorf("$%02X isn't tested", op)
+		}
 	}
 
-	c.rom = rom
-	c.PC = c.ReadWord(VECTOR_RESET)
+	if count != 151 {
+		t.Errorf("expected 151 official opcodes, found %d", count)
+	}
+}
 
-	if ram != nil {
-		c.memory = ram
-	} else {
-		c.memory = make([]byte, 0x1000)
+// Hardware interrupts push P without the break bit and set I.
+func TestInterrupts(t *testing.T) {
+	tests := []struct {
+		name   string
+		vector uint16
+		run    func(c *Core)
+	}{
+		{"NMI", VECTOR_NMI, (*Core).NMI},
+		{"IRQ", VECTOR_IRQ, (*Core).IRQ},
 	}
 
-	c.testDone = false
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, bus := newBusCore()
+			bus.pokeWord(VECTOR_NMI, 0x1111)
+			bus.pokeWord(VECTOR_IRQ, 0x2222)
+			c.setRegs(regs{SP: 0xFF, P: FLAG_CARRY | FLAG_NEGATIVE})
+			c.PC = 0x0456
+
+			tc.run(c)
+
+			if pc := uint16(bus.ram[tc.vector]) | uint16(bus.ram[tc.vector+1])<<8; c.PC != pc {
+				t.Errorf("expected PC $%04X, got $%04X", pc, c.PC)
+			}
 
-	// fill zero page with some data
-	for i := 0; i < 256; i++ {
-		c.memory[i] = uint8(i)
+			expect := regs{SP: 0xFC, P: FLAG_CARRY | FLAG_NEGATIVE | FLAG_INTERRUPT}
+			if got := c.regs(); got != expect {
+				t.Errorf("expected %s, got %s", expect, got)
+			}
+			checkWrites(t, bus, []busAccess{wr(0x01FF, 0x04), wr(0x01FE, 0x56), wr(0x01FD, 0xA1)})
+		})
 	}
 
-	return nil
+	t.Run("IRQ disabled", func(t *testing.T) {
+		c, bus := newBusCore()
+		bus.pokeWord(VECTOR_IRQ, 0x2222)
+		c.setRegs(regs{SP: 0xFF, P: FLAG_INTERRUPT})
+
+		c.IRQ()
+
+		if c.PC != testOrigin || c.SP != 0xFF {
+			t.Errorf("IRQ ran with interrupts disabled: PC $%04X SP $%02X", c.PC, c.SP)
+		}
+		checkWrites(t, bus, nil)
+	})
+
+	// RTI returns to the interrupted instruction, unlike RTS.
+	t.Run("NMI and RTI", func(t *testing.T) {
+		c, bus := newBusCore()
+		bus.pokeWord(VECTOR_NMI, 0x1000)
+		bus.poke(0x1000, OP_RTI)
+		c.setRegs(regs{SP: 0xFF, P: FLAG_CARRY})
+
+		c.NMI()
+		step(t, c, bus)
+
+		expect := regs{SP: 0xFF, P: FLAG_CARRY}
+		if got := c.regs(); got != expect || c.PC != testOrigin {
+			t.Errorf("expected %s PC:$%04X, got %s PC:$%04X", expect, testOrigin, got, c.PC)
+		}
+	})
 }
 
-func (c *Core) dumpReg(t *testing.T) {
-	t.Logf("A: %02X (%d) X: %02X (%d) Y: %02X (%d) PC: %04X Phlags: %08b",
-		c.A,
-		c.A,
-		c.X,
-		c.X,
-		c.Y,
-		c.Y,
-		c.PC,
-		c.Phlags,
+// A short program to make sure instructions work together.
+func TestProgram(t *testing.T) {
+	c, bus := newBusCore()
+
+	// Copy four bytes from $1234 to $0300, then add them up.
+	bus.poke(0x1234, 0x01, 0x02, 0x03, 0x04)
+	bus.poke(testOrigin,
+		OP_LDX_IM, 0x03,
+		OP_LDA_AX, 0x34, 0x12, // loop
+		OP_STA_AX, 0x00, 0x03,
+		OP_DEX,
+		OP_BPL, 0xF7, // loop
+		OP_CLC,
+		OP_LDA_IM, 0x00,
+		OP_LDY_IM, 0x04,
+		OP_ADC_AY, 0xFF, 0x02, // sum
+		OP_DEY,
+		OP_BNE, 0xFA, // sum
+		OP_STA_ZP, 0x10,
 	)
-}
+	c.SP = 0xFF
+
+	for c.PC != testOrigin+0x18 {
+		step(t, c, bus)
+		if c.Ticks() > 100 {
+			t.Fatalf("program didn't finish, stuck at $%04X", c.PC)
+		}
+	}
 
-func (c *Core) dumpPage(page uint8, t *testing.T) {
-	vals := []string{}
-	base := uint16(page) << 8
-	for i := uint16(0); i < 256; i++ {
-		vals = append(vals, fmt.Sprintf("%02X", c.ReadByte(base+i)))
+	for i, v := range []uint8{0x01, 0x02, 0x03, 0x04} {
+		if got := bus.ram[0x0300+i]; got != v {
+			t.Errorf("$%04X: expected $%02X, got $%02X", 0x0300+i, v, got)
+		}
 	}
 
-	for i := 0; i < 256; i += 16 {
-		t.Logf("%04X: %s", int(base)+i, strings.Join(vals[i:i+16], " "))
+	if bus.ram[0x0010] != 0x0A || c.X != 0xFF || c.Y != 0x00 {
+		t.Errorf("expected sum $0A X:FF Y:00, got $%02X X:%02X Y:%02X", bus.ram[0x0010], c.X, c.Y)
 	}
 }
 
-func newTestCore(t *testing.T) *Core {
-	t.Helper()
-	return &Core{
-		A:      0,
-		X:      0,
-		Y:      0,
-		PC:     0,
-		Phlags: 0,
-		SP:     0,
-
-		memory: make([]byte, 0x1000), // no registers, no WRAM, no ROM
-		rom:    nil,
-
-		InstructionLimit: 0,
-		testing:          true,
-		t:                t,
+// Running out of instructions isn't a Halt().
+func TestInstructionLimit(t *testing.T) {
+	c, bus := newBusCore()
+	bus.poke(testOrigin, append([]byte{OP_JMP_AB}, word(testOrigin)...)...)
+	c.InstructionLimit = 10
+
+	err := c.RunRoutine(testOrigin)
+	if !errors.Is(err, ErrInstructionLimit) || c.Ticks() != 10 {
+		t.Errorf("expected ErrInstructionLimit after 10 instructions, got %v after %d", err, c.Ticks())
+	}
+
+	c, bus = newBusCore()
+	bus.poke(testOrigin, append([]byte{OP_JMP_AB}, word(testOrigin)...)...)
+	c.Breakpoints.Register(EXECUTE, "halt", testOrigin, func(c *Core, _ uint8, _ uint8) {
+		if c.Ticks() == 5 {
+			c.Halt()
+		}
+	})
+
+	if err := c.RunRoutine(testOrigin); err != nil || c.Ticks() != 6 {
+		t.Errorf("expected a Halt() to end the routine without an error, got %v after %d", err, c.Ticks())
 	}
 }
@@ -0,0 +1,85 @@
+package emu
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// AddressRange is an inclusive range of CPU addresses.
+type AddressRange struct {
+	Start uint16
+	End   uint16
+}
+
+func (r AddressRange) Contains(address uint16) bool {
+	return address >= r.Start && address <= r.End
+}
+
+func (r AddressRange) String() string {
+	return fmt.Sprintf("$%04X-$%04X", r.Start, r.End)
+}
+
+func inRanges(ranges []AddressRange, address uint16) bool {
+	for _, r := range ranges {
+		if r.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// Diagnostic is a single problem found while running code.
+type Diagnostic struct {
+	Kind string
+
+	// Address of the instruction that caused the diagnostic
+	PC uint16
+
+	// Memory address involved, if any
+	Address uint16
+	Label   string
+
+	Message   string
+	Backtrace []StackFrame
+}
+
+func (d Diagnostic) String() string {
+	str := fmt.Sprintf("[$%04X] %s", d.PC, d.Kind)
+	if d.Label != "" {
+		str += fmt.Sprintf(": $%04X", d.Address)
+		if !strings.HasPrefix(d.Label, "$") {
+			str += " " + d.Label
+		}
+	}
+
+	if d.Message != "" {
+		str += ": " + d.Message
+	}
+	return str
+}
+
+// newDiagnostic fills in the PC, label and backtrace for the current
+// instruction.
+func (c *Core) newDiagnostic(kind string, address uint16, message string) Diagnostic {
+	return Diagnostic{
+		Kind:      kind,
+		PC:        c.opPC,
+		Address:   address,
+		Label:     c.memory.GetLabel(address),
+		Message:   message,
+		Backtrace: c.Backtrace(),
+	}
+}
+
+// writeDiagnostic writes a diagnostic and its backtrace to w, if w isn't nil.
+func (c *Core) writeDiagnostic(w io.Writer, d Diagnostic) {
+	if w == nil {
+		return
+	}
+
+	fmt.Fprintln(w, d.String())
+	if len(d.Backtrace) > 0 {
+		fmt.Fprintln(w, c.BacktraceString(d.Backtrace))
+	}
+}
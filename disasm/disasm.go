@@ -0,0 +1,164 @@
+// Package disasm decodes 6502 machine code without needing a running CPU.
+package disasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Resolver turns addresses into symbol names.
+type Resolver interface {
+	// Symbol returns the name to print for address, or an empty string to
+	// print it as a number.  zeroPage is true for single byte operands.
+	Symbol(address uint16, zeroPage bool) string
+}
+
+// ResolverFunc lets a plain function be used as a Resolver.
+type ResolverFunc func(address uint16, zeroPage bool) string
+
+func (f ResolverFunc) Symbol(address uint16, zeroPage bool) string {
+	return f(address, zeroPage)
+}
+
+// Instruction is a single decoded instruction.
+type Instruction struct {
+	Address uint16
+	Bytes   []byte
+
+	// Empty if the opcode isn't valid for the variant, or the data ran out
+	// before the end of the instruction.  Invalid instructions are always a
+	// single byte.
+	Name string
+	Mode Mode
+
+	// Operand value as it is stored.  For relative branches this is the
+	// offset byte; the destination is in Target.  For BBR and BBS this is
+	// the zero page address.
+	Operand uint16
+
+	// Destination of branches, JSR, and absolute JMP.
+	Target    uint16
+	HasTarget bool
+}
+
+func (i Instruction) Valid() bool {
+	return i.Name != ""
+}
+
+func (i Instruction) OpCode() byte {
+	if len(i.Bytes) == 0 {
+		return 0
+	}
+	return i.Bytes[0]
+}
+
+func (i Instruction) Size() int {
+	return len(i.Bytes)
+}
+
+// String returns the instruction without any symbols.
+func (i Instruction) String() string {
+	return i.Format(nil)
+}
+
+// Format returns the instruction using names from r.  r may be nil.
+func (i Instruction) Format(r Resolver) string {
+	if !i.Valid() {
+		return fmt.Sprintf(".byte $%02X", i.OpCode())
+	}
+	return strings.TrimSpace(i.Name + " " + i.OperandString(r))
+}
+
+// OperandString returns just the operand using names from r.  r may be nil.
+func (i Instruction) OperandString(r Resolver) string {
+	if !i.Valid() {
+		return ""
+	}
+
+	symbol := func(address uint16, zeroPage bool) string {
+		if r != nil {
+			if name := r.Symbol(address, zeroPage); name != "" {
+				return name
+			}
+		}
+		if zeroPage {
+			return fmt.Sprintf("$%02X", address)
+		}
+		return fmt.Sprintf("$%04X", address)
+	}
+
+	switch i.Mode {
+	case Implied:
+		return ""
+	case Accumulator:
+		return "A"
+	case Immediate:
+		return fmt.Sprintf("#$%02X", i.Operand)
+	case Relative:
+		return symbol(i.Target, false)
+	case ZeroPageRelative:
+		return fmt.Sprintf(i.Mode.Format(), symbol(i.Operand, true), symbol(i.Target, false))
+	}
+
+	return fmt.Sprintf(i.Mode.Format(), symbol(i.Operand, i.Mode.ZeroPageOperand()))
+}
+
+// Decode decodes the instruction at the start of data.  address is the CPU
+// address of data[0].
+func Decode(data []byte, address uint16, v *Variant) Instruction {
+	instr := Instruction{Address: address}
+	if len(data) == 0 {
+		return instr
+	}
+
+	op, ok := v.Lookup(data[0])
+	if !ok || len(data) < op.Mode.Size() {
+		instr.Bytes = data[:1]
+		return instr
+	}
+
+	instr.Name = op.Name
+	instr.Mode = op.Mode
+	instr.Bytes = data[:op.Mode.Size()]
+
+	switch len(instr.Bytes) {
+	case 2:
+		instr.Operand = uint16(data[1])
+	case 3:
+		instr.Operand = uint16(data[1]) | uint16(data[2])<<8
+	}
+
+	switch {
+	case op.Mode == Relative:
+		instr.Target = relative(address, 2, data[1])
+		instr.HasTarget = true
+
+	case op.Mode == ZeroPageRelative:
+		instr.Operand = uint16(data[1])
+		instr.Target = relative(address, 3, data[2])
+		instr.HasTarget = true
+
+	case op.Mode == Absolute && (op.Name == "JSR" || op.Name == "JMP"):
+		instr.Target = instr.Operand
+		instr.HasTarget = true
+	}
+
+	return instr
+}
+
+func relative(address uint16, size int, offset byte) uint16 {
+	return uint16(int(address) + size + int(int8(offset)))
+}
+
+// Disassemble decodes all of data in a straight line.  origin is the CPU
+// address of data[0].  Bytes that aren't valid instructions are returned as
+// single byte invalid instructions.
+func Disassemble(data []byte, origin uint16, v *Variant) []Instruction {
+	lst := []Instruction{}
+	for i := 0; i < len(data); {
+		instr := Decode(data[i:], origin+uint16(i), v)
+		lst = append(lst, instr)
+		i += instr.Size()
+	}
+	return lst
+}
@@ -0,0 +1,47 @@
+package disasm_test
+
+import (
+	"testing"
+
+	emu "github.com/zorchenhimer/emu-6502"
+	"github.com/zorchenhimer/emu-6502/disasm"
+)
+
+func TestDisassemble(t *testing.T) {
+	labels := disasm.ResolverFunc(func(address uint16, zeroPage bool) string {
+		switch {
+		case address == 0x10 && zeroPage:
+			return "tmp"
+		case address == 0xC000:
+			return "Start"
+		}
+		return ""
+	})
+
+	tests := []struct {
+		variant *disasm.Variant
+		data    []byte
+		expect  []string
+	}{
+		{emu.Variant(), []byte{0xA5, 0x10, 0xD0, 0xFC, 0x4C, 0x00, 0xC0, 0x6C, 0x10, 0x00},
+			[]string{"LDA tmp", "BNE Start", "JMP Start", "JMP ($0010)"}},
+		{emu.Variant(), []byte{0x0A, 0xB1, 0x10, 0x02, 0xAD, 0x34},
+			[]string{"ASL A", "LDA (tmp), Y", ".byte $02", ".byte $AD", ".byte $34"}},
+		{disasm.CMOS(emu.Variant()), []byte{0x1A, 0x0F, 0x10, 0xFC, 0x7C, 0x00, 0x02, 0xB2, 0x20},
+			[]string{"INC A", "BBR0 tmp, Start", "JMP ($0200, X)", "LDA ($20)"}},
+	}
+
+	for _, tc := range tests {
+		lst := disasm.Disassemble(tc.data, 0xC000, tc.variant)
+		if len(lst) != len(tc.expect) {
+			t.Errorf("%s: expected %d instructions, got %d: %v", tc.variant.Name, len(tc.expect), len(lst), lst)
+			continue
+		}
+
+		for i, instr := range lst {
+			if s := instr.Format(labels); s != tc.expect[i] {
+				t.Errorf("%s $%04X: expected %q got %q", tc.variant.Name, instr.Address, tc.expect[i], s)
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package disasm
+
+// Mode is an addressing mode.
+type Mode int
+
+const (
+	Implied Mode = iota
+	Accumulator
+	Immediate
+	ZeroPage
+	ZeroPageX
+	ZeroPageY
+	Absolute
+	AbsoluteX
+	AbsoluteY
+	Indirect
+	IndirectX
+	IndirectY
+	Relative
+
+	// 65C02 only
+	ZeroPageIndirect  // (zp)
+	AbsoluteIndirectX // JMP (abs, X)
+	ZeroPageRelative  // BBR and BBS: zp, rel
+)
+
+var modeInfo = map[Mode]struct {
+	name   string
+	format string
+	size   int
+}{
+	Implied:           {"Implied", "", 1},
+	Accumulator:       {"Accumulator", "A", 1},
+	Immediate:         {"#Immediate", "#%s", 2},
+	ZeroPage:          {"ZeroPage", "%s", 2},
+	ZeroPageX:         {"ZeroPage, X", "%s, X", 2},
+	ZeroPageY:         {"ZeroPage, Y", "%s, Y", 2},
+	Absolute:          {"Absolute", "%s", 3},
+	AbsoluteX:         {"Absolute, X", "%s, X", 3},
+	AbsoluteY:         {"Absolute, Y", "%s, Y", 3},
+	Indirect:          {"(Indirect)", "(%s)", 3},
+	IndirectX:         {"(Indirect, X)", "(%s, X)", 2},
+	IndirectY:         {"(Indirect), Y", "(%s), Y", 2},
+	Relative:          {"Relative", "%s", 2},
+	ZeroPageIndirect:  {"(ZeroPage)", "(%s)", 2},
+	AbsoluteIndirectX: {"(Absolute, X)", "(%s, X)", 3},
+	ZeroPageRelative:  {"ZeroPage, Relative", "%s, %s", 3},
+}
+
+func (m Mode) String() string {
+	return modeInfo[m].name
+}
+
+// Format is the operand syntax with %s in place of the value.
+// ZeroPageRelative has two values.
+func (m Mode) Format() string {
+	return modeInfo[m].format
+}
+
+// Size is the length of the whole instruction, including the opcode.
+func (m Mode) Size() int {
+	return modeInfo[m].size
+}
+
+// ZeroPageOperand returns true if the operand is a single byte address.
+func (m Mode) ZeroPageOperand() bool {
+	switch m {
+	case ZeroPage, ZeroPageX, ZeroPageY, IndirectX, IndirectY, ZeroPageIndirect, ZeroPageRelative:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package disasm
+
+// Opcode is a single entry in a Variant's opcode table.
+type Opcode struct {
+	Name string
+	Mode Mode
+}
+
+// Variant is the instruction set of a specific CPU.
+type Variant struct {
+	Name    string
+	opcodes [256]*Opcode
+}
+
+// Lookup returns the opcode's definition, or false if it isn't valid for this
+// variant.
+func (v *Variant) Lookup(op byte) (Opcode, bool) {
+	if v.opcodes[op] == nil {
+		return Opcode{}, false
+	}
+	return *v.opcodes[op], true
+}
+
+// With returns a copy of the variant with an added (or replaced) opcode.
+func (v *Variant) With(op byte, name string, mode Mode) *Variant {
+	nv := &Variant{Name: v.Name, opcodes: v.opcodes}
+	nv.opcodes[op] = &Opcode{Name: name, Mode: mode}
+	return nv
+}
+
+func newVariant(name string, base *Variant, ops map[byte]Opcode) *Variant {
+	v := &Variant{Name: name}
+	if base != nil {
+		v.opcodes = base.opcodes
+	}
+
+	for op, def := range ops {
+		d := def
+		v.opcodes[op] = &d
+	}
+	return v
+}
+
+// CMOS returns base with the WDC 65C02 opcodes added, including the Rockwell
+// bit instructions and WAI/STP.  The NMOS table lives with the emulator; see
+// emu.Variant().
+func CMOS(base *Variant) *Variant {
+	return newVariant("65C02", base, cmosOpcodes())
+}
+
+func cmosOpcodes() map[byte]Opcode {
+	ops := map[byte]Opcode{
+		0x04: {"TSB", ZeroPage},
+		0x0C: {"TSB", Absolute},
+		0x12: {"ORA", ZeroPageIndirect},
+		0x14: {"TRB", ZeroPage},
+		0x1A: {"INC", Accumulator},
+		0x1C: {"TRB", Absolute},
+		0x32: {"AND", ZeroPageIndirect},
+		0x34: {"BIT", ZeroPageX},
+		0x3A: {"DEC", Accumulator},
+		0x3C: {"BIT", AbsoluteX},
+		0x52: {"EOR", ZeroPageIndirect},
+		0x5A: {"PHY", Implied},
+		0x64: {"STZ", ZeroPage},
+		0x72: {"ADC", ZeroPageIndirect},
+		0x74: {"STZ", ZeroPageX},
+		0x7A: {"PLY", Implied},
+		0x7C: {"JMP", AbsoluteIndirectX},
+		0x80: {"BRA", Relative},
+		0x89: {"BIT", Immediate},
+		0x92: {"STA", ZeroPageIndirect},
+		0x9C: {"STZ", Absolute},
+		0x9E: {"STZ", AbsoluteX},
+		0xB2: {"LDA", ZeroPageIndirect},
+		0xCB: {"WAI", Implied},
+		0xD2: {"CMP", ZeroPageIndirect},
+		0xDA: {"PHX", Implied},
+		0xDB: {"STP", Implied},
+		0xF2: {"SBC", ZeroPageIndirect},
+		0xFA: {"PLX", Implied},
+	}
+
+	// RMBn, SMBn, BBRn and BBSn
+	for bit := byte(0); bit < 8; bit++ {
+		n := string('0' + bit)
+		ops[bit<<4|0x07] = Opcode{"RMB" + n, ZeroPage}
+		ops[bit<<4|0x87] = Opcode{"SMB" + n, ZeroPage}
+		ops[bit<<4|0x0F] = Opcode{"BBR" + n, ZeroPageRelative}
+		ops[bit<<4|0x8F] = Opcode{"BBS" + n, ZeroPageRelative}
+	}
+	return ops
+}
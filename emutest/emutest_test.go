@@ -0,0 +1,162 @@
+package emutest
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/zorchenhimer/emu-6502"
+)
+
+func loadMath(t *testing.T) *File {
+	f, err := LoadFile("testdata/math.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestFluent(t *testing.T) {
+	f := loadMath(t)
+	c, err := f.NewCore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Call("AddAX").
+		SetA(0x80).
+		SetX(0x01).
+		SetFlag(emu.FLAG_CARRY, true).
+		ExpectA(0x81).
+		ExpectFlag(emu.FLAG_CARRY|emu.FLAG_ZERO, false).
+		ExpectFlag(emu.FLAG_NEGATIVE, true).
+		ExpectMem("total", 0x81).
+		Check(t, c)
+
+	res := Call("Fill").
+		SetA(0x22).
+		Poke("buffer+3", 0xFF).
+		ExpectMemAt(0x0300, 0x22, 0x22, 0x22, 0x23).
+		ExpectY(0x10).
+		Run(c)
+
+	if res.Passed() || len(res.Failures) != 2 {
+		t.Fatalf("expected two failures, got %v", res.Failures)
+	}
+
+	expect := "memory at $0300 ($0300): 1 of 4 bytes differ\n" +
+		"  expected: 22 22 22 23\n" +
+		"  actual:   22 22 22 22\n" +
+		"                     ^^"
+	if res.Failures[1] != expect {
+		t.Errorf("unexpected diff:\n%s\nexpected:\n%s", res.Failures[1], expect)
+	}
+
+	if len(res.History) == 0 || !strings.Contains(res.History[len(res.History)-1], "RTS") {
+		t.Errorf("expected history ending in RTS, got %v", res.History)
+	}
+
+	// The caller's settings are put back afterwards.
+	c.Debug = false
+	c.InstructionLimit = 5000
+	Call("AddAX").Run(c)
+	if c.Debug || c.InstructionLimit != 5000 {
+		t.Errorf("expected Debug off and a limit of 5000, got %t and %d", c.Debug, c.InstructionLimit)
+	}
+}
+
+func TestSpecFile(t *testing.T) {
+	suite, err := loadMath(t).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passed := map[string]bool{
+		"add without carry": true,
+		"add with carry":    true,
+		"fill":              true,
+		"wrong fill":        false,
+		"runs forever":      false,
+	}
+
+	if len(suite.Results) != len(passed) {
+		t.Fatalf("expected %d results, got %d", len(passed), len(suite.Results))
+	}
+
+	for _, r := range suite.Results {
+		if r.Passed() != passed[r.Name] {
+			t.Errorf("%s: expected passed=%v, got %s", r.Name, passed[r.Name], r)
+		}
+	}
+
+	forever := suite.Results[4]
+	if forever.Err == nil || !strings.Contains(forever.Err.Error(), "limit of 50") || forever.Instructions != 50 {
+		t.Errorf("expected the instruction limit to stop the test, got %v after %d", forever.Err, forever.Instructions)
+	}
+}
+
+func TestReports(t *testing.T) {
+	suite, err := loadMath(t).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	suites := []Suite{suite}
+
+	buf := &bytes.Buffer{}
+	if err := WriteTAP(buf, suites); err != nil {
+		t.Fatal(err)
+	}
+
+	tap := buf.String()
+	for _, e := range []string{
+		"TAP version 13\n1..5\n",
+		"ok 1 - testdata/math.json: add without carry\n",
+		"not ok 4 - testdata/math.json: wrong fill\n  ---\n",
+		"    memory at buffer+2 ($0302): 1 of 2 bytes differ\n",
+		"not ok 5 - testdata/math.json: runs forever\n",
+	} {
+		if !strings.Contains(tap, e) {
+			t.Errorf("missing %q in TAP output:\n%s", e, tap)
+		}
+	}
+
+	buf.Reset()
+	if err := WriteJUnit(buf, suites); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed := junitSuites{}
+	if err := xml.Unmarshal(buf.Bytes(), &parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Tests != 5 || parsed.Failures != 1 || parsed.Errors != 1 {
+		t.Errorf("expected 5 tests, 1 failure and 1 error; got %d, %d and %d", parsed.Tests, parsed.Failures, parsed.Errors)
+	}
+
+	fail := parsed.Suites[0].Cases[3].Failure
+	if fail == nil || !strings.Contains(fail.Body, "last ") {
+		t.Errorf("expected failure with history, got %v", fail)
+	}
+}
+
+func TestBytesJSON(t *testing.T) {
+	st := State{}
+	err := json.Unmarshal([]byte(`{"memory": {"a": 5, "b": [1, 2], "c": "$0A ff"}}`), &st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]string{"a": "05", "b": "01 02", "c": "0A FF"}
+	for loc, e := range expect {
+		if st.Memory[loc].String() != e {
+			t.Errorf("%s: expected %q, got %q", loc, e, st.Memory[loc])
+		}
+	}
+
+	if err := json.Unmarshal([]byte(`{"memory": {"a": [256]}}`), &st); err == nil {
+		t.Errorf("expected an error for a value over $FF")
+	}
+}
@@ -0,0 +1,236 @@
+package emutest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/zorchenhimer/emu-6502"
+	"github.com/zorchenhimer/emu-6502/asm"
+	"github.com/zorchenhimer/emu-6502/labels"
+	"github.com/zorchenhimer/emu-6502/mappers"
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+// File is a JSON spec file.  It names the program to test and has a list
+// of tests to run against it:
+//
+//	{
+//		"rom": "breakout.nes",
+//		"symbols": "breakout.dbg",
+//		"tests": [
+//			{
+//				"name": "ball hits a brick",
+//				"call": "CheckBrickCollide",
+//				"given": {"memory": {"BallX": 32, "BallY": "40 00"}},
+//				"expect": {"a": 1, "flags": {"C": true}, "memory": {"TmpX": [5]}}
+//			}
+//		]
+//	}
+//
+// Exactly one of rom, binary or source is needed.  Paths are relative to
+// the spec file.  Every test gets a fresh copy of the program.
+type File struct {
+	Rom    string `json:"rom,omitempty"`    // iNES ROM
+	Binary string `json:"binary,omitempty"` // raw image loaded into 64k of RAM at Origin
+	Origin string `json:"origin,omitempty"` // eg "$0400"
+	Source string `json:"source,omitempty"` // assembled with package asm into 64k of RAM
+
+	// ld65 debug file
+	Symbols string `json:"symbols,omitempty"`
+
+	// Label files in any format labels.Load() knows
+	Labels []string `json:"labels,omitempty"`
+
+	// Defaults for tests that don't set them
+	Limit   int64 `json:"limit,omitempty"`
+	History int   `json:"history,omitempty"`
+
+	Tests []*Spec `json:"tests"`
+
+	filename string
+	image    []byte
+	origin   uint16
+	provider labels.Providers
+	symbols  *emu.Symbols
+}
+
+// Suite is the results for a spec file.
+type Suite struct {
+	Name    string
+	Results []*Result
+}
+
+func (s Suite) Failed() int {
+	count := 0
+	for _, r := range s.Results {
+		if !r.Passed() {
+			count++
+		}
+	}
+	return count
+}
+
+// LoadFile reads a spec file and everything it refers to.
+func LoadFile(filename string) (*File, error) {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{filename: filename}
+	if err = json.Unmarshal(raw, f); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	if err = f.load(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return f, nil
+}
+
+func (f *File) path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(filepath.Dir(f.filename), name)
+}
+
+func (f *File) load() error {
+	count := 0
+	for _, name := range []string{f.Rom, f.Binary, f.Source} {
+		if name != "" {
+			count++
+		}
+	}
+	if count != 1 {
+		return fmt.Errorf("need exactly one of rom, binary or source")
+	}
+
+	if f.Symbols != "" {
+		sym, err := emu.NewSymbols(f.path(f.Symbols))
+		if err != nil {
+			return err
+		}
+		f.symbols = sym
+		f.provider = append(f.provider, sym)
+	}
+
+	for _, name := range f.Labels {
+		lbls, err := labels.Load(f.path(name))
+		if err != nil {
+			return err
+		}
+		f.provider = append(f.provider, labels.NewIndex(lbls))
+	}
+
+	var err error
+	switch {
+	case f.Rom != "":
+		f.image, err = ioutil.ReadFile(f.path(f.Rom))
+
+	case f.Binary != "":
+		if f.Origin != "" {
+			origin, ok := emu.ParseAddress(f.Origin)
+			if !ok {
+				return fmt.Errorf("invalid origin %q", f.Origin)
+			}
+			f.origin = origin
+		}
+
+		f.image, err = ioutil.ReadFile(f.path(f.Binary))
+		if err == nil && int(f.origin)+len(f.image) > 0x10000 {
+			err = fmt.Errorf("%s doesn't fit at $%04X", f.Binary, f.origin)
+		}
+
+	case f.Source != "":
+		var src []byte
+		src, err = ioutil.ReadFile(f.path(f.Source))
+		if err != nil {
+			return err
+		}
+
+		var prog *asm.Program
+		prog, err = asm.Assemble(string(src))
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Source, err)
+		}
+
+		f.image = prog.Image()
+		f.provider = append(f.provider, labels.NewIndex(map[labels.MemoryType]labels.LabelMap{
+			labels.NesMemory: prog.LabelMap(),
+		}))
+	}
+	return err
+}
+
+// NewCore returns a core with a fresh copy of the program loaded.
+func (f *File) NewCore() (*emu.Core, error) {
+	var mem mmu.Manager
+	if f.Rom != "" {
+		mapper, err := mappers.LoadFromBytes(f.image)
+		if err != nil {
+			return nil, err
+		}
+		mem = mmu.NewNES(mapper)
+	} else {
+		img := make([]byte, 0x10000)
+		copy(img[f.origin:], f.image)
+
+		fr, err := mmu.NewFullRam(img)
+		if err != nil {
+			return nil, err
+		}
+		mem = fr
+	}
+
+	mem.SetLabels(f.provider)
+	c := emu.NewCore(mem)
+	c.Symbols = f.symbols
+	return c, nil
+}
+
+// Run all the tests, each on a new core.
+func (f *File) Run() (Suite, error) {
+	suite := Suite{Name: f.filename}
+	for i, spec := range f.Tests {
+		if spec.Name == "" {
+			spec.Name = fmt.Sprintf("test %d (%s)", i+1, spec.Call)
+		}
+
+		c, err := f.NewCore()
+		if err != nil {
+			return suite, err
+		}
+
+		suite.Results = append(suite.Results, spec.RunWith(c, Options{
+			Limit:   f.Limit,
+			History: f.History,
+		}))
+	}
+	return suite, nil
+}
+
+// RunFiles loads and runs each spec file.  Files that can't be loaded are
+// reported as a suite with a single error.
+func RunFiles(filenames ...string) []Suite {
+	suites := []Suite{}
+	for _, name := range filenames {
+		f, err := LoadFile(name)
+		if err == nil {
+			var suite Suite
+			suite, err = f.Run()
+			if err == nil {
+				suites = append(suites, suite)
+				continue
+			}
+		}
+
+		suites = append(suites, Suite{
+			Name:    name,
+			Results: []*Result{{Name: filepath.Base(name), Err: err}},
+		})
+	}
+	return suites
+}
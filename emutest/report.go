@@ -0,0 +1,136 @@
+package emutest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the results as JUnit XML.  Tests that couldn't run to
+// the end are errors and the rest of the failed tests are failures.
+func WriteJUnit(w io.Writer, suites []Suite) error {
+	out := junitSuites{}
+	for _, s := range suites {
+		js := junitSuite{Name: s.Name}
+		total := 0.0
+
+		for _, r := range s.Results {
+			jc := junitCase{
+				Name:      r.Name,
+				Classname: s.Name,
+				Time:      fmt.Sprintf("%.6f", r.Duration.Seconds()),
+			}
+			total += r.Duration.Seconds()
+
+			problem := &junitProblem{Message: r.Message(), Body: r.Details()}
+			if r.Err != nil {
+				jc.Error = problem
+				js.Errors++
+			} else if !r.Passed() {
+				jc.Failure = problem
+				js.Failures++
+			}
+
+			js.Cases = append(js.Cases, jc)
+		}
+
+		js.Tests = len(s.Results)
+		js.Time = fmt.Sprintf("%.6f", total)
+
+		out.Tests += js.Tests
+		out.Failures += js.Failures
+		out.Errors += js.Errors
+		out.Suites = append(out.Suites, js)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// WriteTAP writes the results in the Test Anything Protocol, version 13.
+// Failure details are in a YAML block after each failed test.
+func WriteTAP(w io.Writer, suites []Suite) error {
+	total := 0
+	for _, s := range suites {
+		total += len(s.Results)
+	}
+
+	lines := []string{"TAP version 13", fmt.Sprintf("1..%d", total)}
+	num := 0
+	for _, s := range suites {
+		for _, r := range s.Results {
+			num++
+			name := strings.Replace(s.Name+": "+r.Name, "#", `\#`, -1)
+			if r.Passed() {
+				lines = append(lines, fmt.Sprintf("ok %d - %s", num, name))
+				continue
+			}
+
+			lines = append(lines,
+				fmt.Sprintf("not ok %d - %s", num, name),
+				"  ---",
+				fmt.Sprintf("  message: %q", r.Message()),
+				"  details: |",
+			)
+			for _, line := range strings.Split(r.Details(), "\n") {
+				lines = append(lines, "    "+line)
+			}
+			lines = append(lines, "  ...")
+		}
+	}
+
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+// WriteText writes a line for each test and the details of the failures.
+func WriteText(w io.Writer, suites []Suite) error {
+	for _, s := range suites {
+		for _, r := range s.Results {
+			if _, err := fmt.Fprintf(w, "%s: %s\n", s.Name, r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package emutest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zorchenhimer/emu-6502"
+)
+
+// History lines included with failures when Options doesn't say.
+const DefaultHistory int = 20
+
+// Options for running a Spec.  Zero values use the defaults.
+type Options struct {
+	// Instruction limit for specs that don't have one
+	Limit int64
+
+	// Number of history lines to keep for failures.  -1 for none.
+	History int
+}
+
+// Result of a single test.
+type Result struct {
+	Name string
+
+	// Expectations that didn't match.  Memory failures include a diff.
+	Failures []string
+
+	// Set if the test couldn't run to the end, eg an unknown label, a bad
+	// opcode or the instruction limit was hit.
+	Err error
+
+	Instructions uint64
+	Duration     time.Duration
+
+	// The last instructions run.  Only kept for failed tests.
+	History []string
+}
+
+func (r *Result) Passed() bool {
+	return r.Err == nil && len(r.Failures) == 0
+}
+
+// Message is a one line summary of why the test failed.
+func (r *Result) Message() string {
+	if r.Err != nil {
+		return r.Err.Error()
+	}
+	if len(r.Failures) > 0 {
+		return strings.SplitN(r.Failures[0], "\n", 2)[0]
+	}
+	return ""
+}
+
+// Details is everything about the failure, with the history at the end.
+func (r *Result) Details() string {
+	lines := []string{}
+	if r.Err != nil {
+		lines = append(lines, r.Err.Error())
+	}
+	lines = append(lines, r.Failures...)
+
+	if len(r.History) > 0 {
+		lines = append(lines, fmt.Sprintf("last %d instructions:", len(r.History)))
+		lines = append(lines, r.History...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (r *Result) String() string {
+	if r.Passed() {
+		return fmt.Sprintf("PASS %s (%d instructions)", r.Name, r.Instructions)
+	}
+	return fmt.Sprintf("FAIL %s\n%s", r.Name, r.Details())
+}
+
+// TB is the part of testing.TB used by Check.
+type TB interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Check runs the spec and reports a failure to t.
+func (s *Spec) Check(t TB, c *emu.Core) *Result {
+	t.Helper()
+	res := s.Run(c)
+	if !res.Passed() {
+		t.Errorf("%s", res)
+	}
+	return res
+}
+
+// Run the spec with the default options.
+func (s *Spec) Run(c *emu.Core) *Result {
+	return s.RunWith(c, Options{})
+}
+
+func (s *Spec) RunWith(c *emu.Core, opts Options) *Result {
+	res := &Result{Name: s.Name}
+
+	limit := s.Limit
+	if limit <= 0 {
+		limit = opts.Limit
+	}
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	history := opts.History
+	if history == 0 {
+		history = DefaultHistory
+	}
+
+	// History is only recorded with Debug on.
+	debug, oldLimit := c.Debug, c.InstructionLimit
+	c.Debug = history > 0
+	c.ClearHistory()
+	defer func() {
+		c.Debug = debug
+		c.InstructionLimit = oldLimit
+	}()
+
+	if err := s.setup(c); err != nil {
+		res.Err = err
+		return res
+	}
+
+	address, err := c.Location(s.Call)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+
+	start := time.Now()
+	ticks := c.Ticks()
+	c.InstructionLimit = limit
+	err = c.RunRoutine(address)
+	res.Duration = time.Since(start)
+	res.Instructions = c.Ticks() - ticks
+
+	if err != nil {
+		if errors.Is(err, emu.ErrInstructionLimit) {
+			err = fmt.Errorf("Instruction limit of %d reached at $%04X", limit, c.PC)
+		}
+		res.Err = err
+	} else {
+		res.Failures = s.check(c)
+	}
+
+	if !res.Passed() && history > 0 {
+		res.History = c.History(history)
+	}
+	return res
+}
+
+func (s *Spec) setup(c *emu.Core) error {
+	g := s.Given
+	if g.A != nil {
+		c.A = *g.A
+	}
+	if g.X != nil {
+		c.X = *g.X
+	}
+	if g.Y != nil {
+		c.Y = *g.Y
+	}
+
+	// An empty stack keeps the history lines short.
+	c.SP = 0xFF
+	if g.SP != nil {
+		c.SP = *g.SP
+	}
+
+	for letter, set := range g.Flags {
+		flag, err := flagBit(letter)
+		if err != nil {
+			return err
+		}
+
+		if set {
+			c.Phlags |= flag
+		} else {
+			c.Phlags &^= flag
+		}
+	}
+
+	for _, loc := range g.sortedLocations() {
+		address, err := c.Location(loc)
+		if err != nil {
+			return err
+		}
+
+		for i, v := range g.Memory[loc] {
+			c.WriteByte(address+uint16(i), v)
+		}
+	}
+	return nil
+}
+
+// check returns a message for each expectation that doesn't match.
+func (s *Spec) check(c *emu.Core) []string {
+	failed := []string{}
+	e := s.Expect
+
+	regs := []struct {
+		name   string
+		expect *uint8
+		actual uint8
+	}{
+		{"A", e.A, c.A},
+		{"X", e.X, c.X},
+		{"Y", e.Y, c.Y},
+		{"SP", e.SP, c.SP},
+	}
+
+	for _, r := range regs {
+		if r.expect != nil && *r.expect != r.actual {
+			failed = append(failed, fmt.Sprintf("%s: expected $%02X, got $%02X", r.name, *r.expect, r.actual))
+		}
+	}
+
+	for _, f := range flagLetters {
+		set, ok := e.Flags[f.letter]
+		if !ok {
+			// Letters are case insensitive in spec files.
+			set, ok = e.Flags[strings.ToLower(f.letter)]
+		}
+		if !ok {
+			continue
+		}
+
+		if actual := c.Phlags&f.flag != 0; actual != set {
+			failed = append(failed, fmt.Sprintf("flag %s: expected %s, got %s", f.letter, setClear(set), setClear(actual)))
+		}
+	}
+
+	for letter := range e.Flags {
+		if _, err := flagBit(letter); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	for _, loc := range e.sortedLocations() {
+		address, err := c.Location(loc)
+		if err != nil {
+			failed = append(failed, err.Error())
+			continue
+		}
+
+		expect := e.Memory[loc]
+		actual := Bytes{}
+		for i := range expect {
+			actual = append(actual, c.ReadByte(address+uint16(i)))
+		}
+
+		if diff := memoryDiff(loc, address, expect, actual); diff != "" {
+			failed = append(failed, diff)
+		}
+	}
+
+	return failed
+}
+
+func setClear(set bool) string {
+	if set {
+		return "set"
+	}
+	return "clear"
+}
+
+// memoryDiff shows the expected and actual bytes with the differences
+// marked.  Returns an empty string if they match.
+func memoryDiff(loc string, address uint16, expect, actual Bytes) string {
+	marks := []string{}
+	count := 0
+	for i := range expect {
+		if expect[i] != actual[i] {
+			marks = append(marks, "^^")
+			count++
+		} else {
+			marks = append(marks, "  ")
+		}
+	}
+
+	if count == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("memory at %s ($%04X): %d of %d bytes differ\n  expected: %s\n  actual:   %s\n            %s",
+		loc, address, count, len(expect), expect, actual, strings.TrimRight(strings.Join(marks, " "), " "))
+}
@@ -0,0 +1,231 @@
+// Package emutest runs unit tests against 6502 routines.
+//
+// A test sets up registers and memory, calls a routine by label or address
+// and checks the registers, flags and memory afterwards.  Tests can be built
+// in Go:
+//
+//	res := emutest.Call("CheckBrickCollide").
+//		SetA(0).
+//		Poke("BallX", 0x20).
+//		Poke("BallY", 0x40).
+//		ExpectMem("TmpX", 0x05).
+//		ExpectFlag(emu.FLAG_CARRY, true).
+//		Run(core)
+//
+// or loaded from JSON spec files.  See File.
+package emutest
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zorchenhimer/emu-6502"
+)
+
+// Used when a test doesn't set a limit.
+const DefaultLimit int64 = 100000
+
+// Spec is a single test.  The methods return the Spec so they can be
+// chained.
+type Spec struct {
+	Name string `json:"name"`
+
+	// Label or address of the routine, eg "UpdateBall" or "$C123".
+	Call string `json:"call"`
+
+	// Most instructions to run before failing.  Zero uses the file's limit
+	// or DefaultLimit.
+	Limit int64 `json:"limit,omitempty"`
+
+	Given  State `json:"given"`
+	Expect State `json:"expect"`
+}
+
+// State is the registers and memory before or after a call.  Nil and
+// missing values aren't set or checked, except SP which is set to $FF if
+// it isn't given.
+type State struct {
+	A  *uint8 `json:"a,omitempty"`
+	X  *uint8 `json:"x,omitempty"`
+	Y  *uint8 `json:"y,omitempty"`
+	SP *uint8 `json:"sp,omitempty"`
+
+	// Keyed by flag letter: N, V, D, I, Z or C
+	Flags map[string]bool `json:"flags,omitempty"`
+
+	// Keyed by location, eg "BallX", "buffer+4" or "$0300"
+	Memory map[string]Bytes `json:"memory,omitempty"`
+}
+
+// Call starts a test for the routine at the given label or address.
+func Call(routine string) *Spec {
+	return &Spec{Name: routine, Call: routine}
+}
+
+// CallAddress starts a test for the routine at the given address.
+func CallAddress(address uint16) *Spec {
+	return Call(fmt.Sprintf("$%04X", address))
+}
+
+func (s *Spec) Named(name string) *Spec {
+	s.Name = name
+	return s
+}
+
+// WithLimit sets the most instructions the routine can run.
+func (s *Spec) WithLimit(count int64) *Spec {
+	s.Limit = count
+	return s
+}
+
+func (s *Spec) SetA(v uint8) *Spec  { s.Given.A = &v; return s }
+func (s *Spec) SetX(v uint8) *Spec  { s.Given.X = &v; return s }
+func (s *Spec) SetY(v uint8) *Spec  { s.Given.Y = &v; return s }
+func (s *Spec) SetSP(v uint8) *Spec { s.Given.SP = &v; return s }
+
+// SetFlag sets or clears flags before the call.  flag is one or more of
+// the emu.FLAG_* values.
+func (s *Spec) SetFlag(flag uint8, set bool) *Spec {
+	s.Given.setFlag(flag, set)
+	return s
+}
+
+// Poke writes bytes starting at a location before the call.
+func (s *Spec) Poke(location string, values ...uint8) *Spec {
+	s.Given.setMemory(location, values)
+	return s
+}
+
+func (s *Spec) PokeAt(address uint16, values ...uint8) *Spec {
+	return s.Poke(fmt.Sprintf("$%04X", address), values...)
+}
+
+func (s *Spec) ExpectA(v uint8) *Spec  { s.Expect.A = &v; return s }
+func (s *Spec) ExpectX(v uint8) *Spec  { s.Expect.X = &v; return s }
+func (s *Spec) ExpectY(v uint8) *Spec  { s.Expect.Y = &v; return s }
+func (s *Spec) ExpectSP(v uint8) *Spec { s.Expect.SP = &v; return s }
+
+// ExpectFlag checks that flags are set or clear after the call.
+func (s *Spec) ExpectFlag(flag uint8, set bool) *Spec {
+	s.Expect.setFlag(flag, set)
+	return s
+}
+
+// ExpectMem checks the bytes starting at a location after the call.
+func (s *Spec) ExpectMem(location string, values ...uint8) *Spec {
+	s.Expect.setMemory(location, values)
+	return s
+}
+
+func (s *Spec) ExpectMemAt(address uint16, values ...uint8) *Spec {
+	return s.ExpectMem(fmt.Sprintf("$%04X", address), values...)
+}
+
+func (st *State) setFlag(flag uint8, set bool) {
+	if st.Flags == nil {
+		st.Flags = map[string]bool{}
+	}
+
+	for _, f := range flagLetters {
+		if flag&f.flag != 0 {
+			st.Flags[f.letter] = set
+		}
+	}
+}
+
+func (st *State) setMemory(location string, values []uint8) {
+	if st.Memory == nil {
+		st.Memory = map[string]Bytes{}
+	}
+	st.Memory[location] = Bytes(values)
+}
+
+var flagLetters = []struct {
+	letter string
+	flag   uint8
+}{
+	{"N", emu.FLAG_NEGATIVE},
+	{"V", emu.FLAG_OVERFLOW},
+	{"D", emu.FLAG_DECIMAL},
+	{"I", emu.FLAG_INTERRUPT},
+	{"Z", emu.FLAG_ZERO},
+	{"C", emu.FLAG_CARRY},
+}
+
+func flagBit(letter string) (uint8, error) {
+	for _, f := range flagLetters {
+		if strings.EqualFold(f.letter, letter) {
+			return f.flag, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown flag %q", letter)
+}
+
+// sortedLocations returns the memory locations in a stable order for
+// output.
+func (st *State) sortedLocations() []string {
+	locs := []string{}
+	for loc := range st.Memory {
+		locs = append(locs, loc)
+	}
+	sort.Strings(locs)
+	return locs
+}
+
+// Bytes is a list of byte values.  In JSON it can be a number, an array of
+// numbers, or a string of hex bytes separated by spaces (eg, "01 02 FF").
+type Bytes []uint8
+
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var num uint8
+	if err := json.Unmarshal(data, &num); err == nil {
+		*b = Bytes{num}
+		return nil
+	}
+
+	// Not []uint8, which would be read as base64.
+	var list []int
+	if err := json.Unmarshal(data, &list); err == nil {
+		vals := Bytes{}
+		for _, v := range list {
+			if v < 0 || v > 0xFF {
+				return fmt.Errorf("byte value out of range: %d", v)
+			}
+			vals = append(vals, uint8(v))
+		}
+		*b = vals
+		return nil
+	}
+
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return fmt.Errorf("bytes must be a number, an array or a hex string: %s", data)
+	}
+
+	vals := Bytes{}
+	for _, field := range strings.Fields(str) {
+		v, err := strconv.ParseUint(strings.TrimPrefix(field, "$"), 16, 8)
+		if err != nil {
+			return fmt.Errorf("invalid hex byte %q", field)
+		}
+		vals = append(vals, uint8(v))
+	}
+	*b = vals
+	return nil
+}
+
+// MarshalJSON writes the hex string format.
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.String())
+}
+
+func (b Bytes) String() string {
+	strs := []string{}
+	for _, v := range b {
+		strs = append(strs, fmt.Sprintf("%02X", v))
+	}
+	return strings.Join(strs, " ")
+}
@@ -0,0 +1,30 @@
+; Routines for the emutest tests
+
+.org $0010
+total:  .res 1
+
+.org $0300
+buffer: .res 4
+
+.org $8000
+
+; total = A + X.  Carry is set on overflow.
+AddAX:
+    stx total
+    clc
+    adc total
+    sta total
+    rts
+
+; Fill buffer with A
+Fill:
+    ldx #0
+@loop:
+    sta buffer,x
+    inx
+    cpx #4
+    bne @loop
+    rts
+
+Forever:
+    jmp Forever
@@ -0,0 +1,106 @@
+package emu
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// buildFixtures runs testdata/Makefile into a temporary directory and returns
+// it.  The test is skipped if the cc65 tools aren't installed.
+func buildFixtures(t *testing.T) string {
+	t.Helper()
+	for _, tool := range []string{"make", "cc65", "ca65", "ld65"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command("make", "-C", "testdata", "OUT="+dir).CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("make failed: %v\n%s", err, out)
+	}
+	return dir
+}
+
+// dbgSummary lists what the tests rely on in a debug file, ignoring IDs,
+// object names and timestamps.  cc65 output is limited to the C parts, as
+// the runtime library changes between versions.
+func dbgSummary(sym *Symbols, cOnly bool) []string {
+	list := []string{}
+
+	for _, lr := range sym.Lines {
+		if cOnly && lr.Type != LineC {
+			continue
+		}
+		for _, sp := range lr.Spans {
+			list = append(list, fmt.Sprintf("line %s type %d $%04X+%d", lr, lr.Type, sp.Address(), sp.Size))
+		}
+	}
+
+	for _, cs := range sym.CSymbols {
+		list = append(list, fmt.Sprintf("csym %s %s %s type %s offs %d", cs.Scope.Name, cs.Name, cs.Storage, cs.Type.Value, cs.Offset))
+	}
+
+	if cOnly {
+		sort.Strings(list)
+		return list
+	}
+
+	for _, sf := range sym.Files {
+		list = append(list, fmt.Sprintf("file %s size %d", sf.Name, sf.Size))
+	}
+	for _, seg := range sym.Segments {
+		list = append(list, fmt.Sprintf("seg %s $%04X+%d %q %d", seg.Name, seg.Start, seg.Size, filepath.Base(seg.OutputName), seg.OutputOffset))
+	}
+	for _, sr := range sym.symIds {
+		list = append(list, fmt.Sprintf("sym %s %s $%04X size %d", sr.Name, sr.Type, sr.Value, sr.Size))
+	}
+
+	sort.Strings(list)
+	return list
+}
+
+// The committed debug files need to match what the Makefile builds from the
+// sources.  Run make in testdata to update them.
+func TestFixtures(t *testing.T) {
+	dir := buildFixtures(t)
+	defer os.RemoveAll(dir)
+
+	for name, cOnly := range map[string]bool{"game.dbg": false, "banked.dbg": false, "cgame.dbg": true} {
+		committed, err := NewSymbols(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		built, err := NewSymbols(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		want, got := map[string]bool{}, map[string]bool{}
+		for _, s := range dbgSummary(committed, cOnly) {
+			want[s] = true
+		}
+		for _, s := range dbgSummary(built, cOnly) {
+			got[s] = true
+			if !want[s] {
+				t.Errorf("%s: only in the new build: %s", name, s)
+			}
+		}
+		for s := range want {
+			if !got[s] {
+				t.Errorf("%s: missing from the new build: %s", name, s)
+			}
+		}
+	}
+}
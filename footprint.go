@@ -0,0 +1,365 @@
+package emu
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zorchenhimer/emu-6502/disasm"
+	"github.com/zorchenhimer/emu-6502/labels"
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+// Registers is a set of registers and flags.  The flags use the same bits as
+// in the status register.
+type Registers uint16
+
+const (
+	REG_A Registers = 0x0100
+	REG_X Registers = 0x0200
+	REG_Y Registers = 0x0400
+
+	// Every real flag.  Break and the unused bit only exist on the stack.
+	regFlags = Registers(FLAG_CARRY | FLAG_ZERO | FLAG_INTERRUPT | FLAG_DECIMAL | FLAG_OVERFLOW | FLAG_NEGATIVE)
+)
+
+var registerNames = []struct {
+	reg  Registers
+	name string
+}{
+	{REG_A, "A"},
+	{REG_X, "X"},
+	{REG_Y, "Y"},
+	{Registers(FLAG_NEGATIVE), "N"},
+	{Registers(FLAG_OVERFLOW), "V"},
+	{Registers(FLAG_DECIMAL), "D"},
+	{Registers(FLAG_INTERRUPT), "I"},
+	{Registers(FLAG_ZERO), "Z"},
+	{Registers(FLAG_CARRY), "C"},
+}
+
+// Names returns the registers, then the flags in NV-BDIZC order.
+func (r Registers) Names() []string {
+	names := []string{}
+	for _, n := range registerNames {
+		if r&n.reg != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+func (r Registers) String() string {
+	if r == 0 {
+		return "none"
+	}
+	return strings.Join(r.Names(), " ")
+}
+
+// Registers and flags read and written by each instruction.  Indexed
+// addressing modes and accumulator shifts are added in registerUse.
+var registerEffects = map[string]struct{ read, write Registers }{
+	"ADC": {REG_A | Registers(FLAG_CARRY|FLAG_DECIMAL), REG_A | Registers(FLAG_NEGATIVE|FLAG_OVERFLOW|FLAG_ZERO|FLAG_CARRY)},
+	"SBC": {REG_A | Registers(FLAG_CARRY|FLAG_DECIMAL), REG_A | Registers(FLAG_NEGATIVE|FLAG_OVERFLOW|FLAG_ZERO|FLAG_CARRY)},
+	"AND": {REG_A, REG_A | Registers(FLAG_NEGATIVE|FLAG_ZERO)},
+	"EOR": {REG_A, REG_A | Registers(FLAG_NEGATIVE|FLAG_ZERO)},
+	"ORA": {REG_A, REG_A | Registers(FLAG_NEGATIVE|FLAG_ZERO)},
+	"ASL": {0, Registers(FLAG_NEGATIVE | FLAG_ZERO | FLAG_CARRY)},
+	"LSR": {0, Registers(FLAG_NEGATIVE | FLAG_ZERO | FLAG_CARRY)},
+	"ROL": {Registers(FLAG_CARRY), Registers(FLAG_NEGATIVE | FLAG_ZERO | FLAG_CARRY)},
+	"ROR": {Registers(FLAG_CARRY), Registers(FLAG_NEGATIVE | FLAG_ZERO | FLAG_CARRY)},
+	"BIT": {REG_A, Registers(FLAG_NEGATIVE | FLAG_OVERFLOW | FLAG_ZERO)},
+	"CMP": {REG_A, Registers(FLAG_NEGATIVE | FLAG_ZERO | FLAG_CARRY)},
+	"CPX": {REG_X, Registers(FLAG_NEGATIVE | FLAG_ZERO | FLAG_CARRY)},
+	"CPY": {REG_Y, Registers(FLAG_NEGATIVE | FLAG_ZERO | FLAG_CARRY)},
+	"DEC": {0, Registers(FLAG_NEGATIVE | FLAG_ZERO)},
+	"INC": {0, Registers(FLAG_NEGATIVE | FLAG_ZERO)},
+	"DEX": {REG_X, REG_X | Registers(FLAG_NEGATIVE|FLAG_ZERO)},
+	"INX": {REG_X, REG_X | Registers(FLAG_NEGATIVE|FLAG_ZERO)},
+	"DEY": {REG_Y, REG_Y | Registers(FLAG_NEGATIVE|FLAG_ZERO)},
+	"INY": {REG_Y, REG_Y | Registers(FLAG_NEGATIVE|FLAG_ZERO)},
+	"LDA": {0, REG_A | Registers(FLAG_NEGATIVE|FLAG_ZERO)},
+	"LDX": {0, REG_X | Registers(FLAG_NEGATIVE|FLAG_ZERO)},
+	"LDY": {0, REG_Y | Registers(FLAG_NEGATIVE|FLAG_ZERO)},
+	"STA": {REG_A, 0},
+	"STX": {REG_X, 0},
+	"STY": {REG_Y, 0},
+	"TAX": {REG_A, REG_X | Registers(FLAG_NEGATIVE|FLAG_ZERO)},
+	"TAY": {REG_A, REG_Y | Registers(FLAG_NEGATIVE|FLAG_ZERO)},
+	"TXA": {REG_X, REG_A | Registers(FLAG_NEGATIVE|FLAG_ZERO)},
+	"TYA": {REG_Y, REG_A | Registers(FLAG_NEGATIVE|FLAG_ZERO)},
+	"TSX": {0, REG_X | Registers(FLAG_NEGATIVE|FLAG_ZERO)},
+	"TXS": {REG_X, 0},
+	"PHA": {REG_A, 0},
+	"PLA": {0, REG_A | Registers(FLAG_NEGATIVE|FLAG_ZERO)},
+	"PHP": {regFlags, 0},
+	"PLP": {0, regFlags},
+	"RTI": {0, regFlags},
+	"BRK": {regFlags, Registers(FLAG_INTERRUPT)},
+	"BCC": {Registers(FLAG_CARRY), 0},
+	"BCS": {Registers(FLAG_CARRY), 0},
+	"BEQ": {Registers(FLAG_ZERO), 0},
+	"BNE": {Registers(FLAG_ZERO), 0},
+	"BMI": {Registers(FLAG_NEGATIVE), 0},
+	"BPL": {Registers(FLAG_NEGATIVE), 0},
+	"BVC": {Registers(FLAG_OVERFLOW), 0},
+	"BVS": {Registers(FLAG_OVERFLOW), 0},
+	"CLC": {0, Registers(FLAG_CARRY)},
+	"SEC": {0, Registers(FLAG_CARRY)},
+	"CLD": {0, Registers(FLAG_DECIMAL)},
+	"SED": {0, Registers(FLAG_DECIMAL)},
+	"CLI": {0, Registers(FLAG_INTERRUPT)},
+	"SEI": {0, Registers(FLAG_INTERRUPT)},
+	"CLV": {0, Registers(FLAG_OVERFLOW)},
+}
+
+// registerUse returns the registers and flags an instruction reads and
+// writes.
+func registerUse(instr Instruction) (read, write Registers) {
+	effect := registerEffects[instr.Name()]
+	read, write = effect.read, effect.write
+
+	switch instr.AddressMeta().Mode {
+	case disasm.Accumulator:
+		read |= REG_A
+		write |= REG_A
+	case disasm.ZeroPageX, disasm.AbsoluteX, disasm.IndirectX:
+		read |= REG_X
+	case disasm.ZeroPageY, disasm.AbsoluteY, disasm.IndirectY:
+		read |= REG_Y
+	}
+	return read, write
+}
+
+// FootprintLocation is a single byte of memory used by a routine.
+type FootprintLocation struct {
+	Address uint16            `json:"address"`
+	Label   string            `json:"label,omitempty"`
+	Type    labels.MemoryType `json:"type,omitempty"`
+}
+
+func (l FootprintLocation) String() string {
+	if l.Label == "" {
+		return fmt.Sprintf("$%04X", l.Address)
+	}
+	return fmt.Sprintf("$%04X %s", l.Address, l.Label)
+}
+
+// Footprint records what routines need and what they change.  While
+// Core.Footprint is set, every RunRoutine() and CallRoutine() is added to it.
+// Stack pushes and pulls count toward MaxStack rather than Inputs and
+// Outputs, and instruction fetches aren't counted at all.
+type Footprint struct {
+	Calls int `json:"calls"`
+
+	// Memory read before the routine wrote to it, and memory it wrote to.
+	// Both in address order.
+	Inputs  []FootprintLocation `json:"inputs"`
+	Outputs []FootprintLocation `json:"outputs"`
+
+	// Registers and flags read before the routine set them, and the ones
+	// it set.
+	Consumed  Registers `json:"consumed"`
+	Clobbered Registers `json:"clobbered"`
+
+	// Deepest the stack got below SP at the call, in bytes.  This includes
+	// the return address.
+	MaxStack int `json:"max_stack"`
+
+	// The call in progress.  state is allocated by the first call so
+	// unused footprints (eg, in clones) stay small.
+	state    *[0x10000]uint8
+	touched  []uint16
+	read     Registers
+	written  Registers
+	base     int
+	depth    int
+	size     uint16
+	stackOp  bool
+	tracking bool
+}
+
+const (
+	fpInput uint8 = 1 << iota
+	fpOutput
+)
+
+// start is called at the beginning of RunRoutine() and CallRoutine().  base
+// is the stack pointer with nothing from the routine on it.
+func (fp *Footprint) start(base int) {
+	if fp.state == nil {
+		fp.state = &[0x10000]uint8{}
+	}
+	for _, addr := range fp.touched {
+		fp.state[addr] = 0
+	}
+	fp.touched = fp.touched[:0]
+	fp.read, fp.written = 0, 0
+	fp.base = base
+	fp.depth = 0
+	fp.size = 1
+	fp.tracking = true
+}
+
+// footprintInstruction is called with each instruction before it is
+// executed.
+func (c *Core) footprintInstruction(instr Instruction) {
+	fp := c.Footprint
+	fp.size = uint16(instr.InstrLength())
+
+	read, write := registerUse(instr)
+	fp.read |= read &^ fp.written
+	fp.written |= write
+}
+
+// footprintAccess is called for every read and write by the CPU.
+func (c *Core) footprintAccess(address uint16, write bool) {
+	fp := c.Footprint
+	if !fp.tracking || fp.stackOp || address-c.opPC < fp.size {
+		return
+	}
+
+	st := fp.state[address]
+	if st == 0 {
+		fp.touched = append(fp.touched, address)
+	}
+
+	if write {
+		fp.state[address] = st | fpOutput
+	} else if st&fpOutput == 0 {
+		fp.state[address] = st | fpInput
+	}
+}
+
+// pushed is called after each push.  The depth isn't checked after pulls
+// because the final RTS of RunRoutine() can wrap SP.
+func (fp *Footprint) pushed(sp uint8) {
+	fp.stackOp = false
+	if d := fp.base - int(sp); d > fp.depth {
+		fp.depth = d
+	}
+}
+
+// footprintDone adds the call in progress to the totals.
+func (c *Core) footprintDone() {
+	fp := c.Footprint
+	if !fp.tracking {
+		return
+	}
+	fp.tracking = false
+
+	sort.Slice(fp.touched, func(i, j int) bool { return fp.touched[i] < fp.touched[j] })
+
+	inputs := []FootprintLocation{}
+	outputs := []FootprintLocation{}
+	for _, addr := range fp.touched {
+		st := fp.state[addr]
+		if st&fpInput != 0 {
+			inputs = append(inputs, c.footprintLocation(addr))
+		}
+		if st&fpOutput != 0 {
+			outputs = append(outputs, c.footprintLocation(addr))
+		}
+	}
+
+	fp.Merge(&Footprint{
+		Calls:     1,
+		Inputs:    inputs,
+		Outputs:   outputs,
+		Consumed:  fp.read,
+		Clobbered: fp.written,
+		MaxStack:  fp.depth,
+	})
+}
+
+// footprintLocation labels an address for the current bank configuration.
+func (c *Core) footprintLocation(address uint16) FootprintLocation {
+	loc := FootprintLocation{Address: address}
+	if lbl := c.memory.GetLabel(address); !strings.HasPrefix(lbl, "$") {
+		loc.Label = lbl
+	}
+	if typer, ok := c.memory.(mmu.MemoryTyper); ok {
+		loc.Type = typer.MemoryType(address)
+	}
+	return loc
+}
+
+// Merge adds other to fp.  Use this to combine footprints from clones, eg
+// from a Sweep.
+func (fp *Footprint) Merge(other *Footprint) {
+	fp.Calls += other.Calls
+	fp.Inputs = mergeLocations(fp.Inputs, other.Inputs)
+	fp.Outputs = mergeLocations(fp.Outputs, other.Outputs)
+	fp.Consumed |= other.Consumed
+	fp.Clobbered |= other.Clobbered
+	if other.MaxStack > fp.MaxStack {
+		fp.MaxStack = other.MaxStack
+	}
+}
+
+// mergeLocations combines two sorted lists.  Labels already in a are kept.
+func mergeLocations(a, b []FootprintLocation) []FootprintLocation {
+	merged := make([]FootprintLocation, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i].Address < b[j].Address:
+			merged = append(merged, a[i])
+			i++
+		case a[i].Address > b[j].Address:
+			merged = append(merged, b[j])
+			j++
+		default:
+			merged = append(merged, a[i])
+			i++
+			j++
+		}
+	}
+	merged = append(merged, a[i:]...)
+	return append(merged, b[j:]...)
+}
+
+// InputLabels returns the names of the inputs, with each label only listed
+// once.  Unlabeled addresses are given as "$xxxx".  OutputLabels is the
+// same for outputs.
+func (fp *Footprint) InputLabels() []string {
+	return locationNames(fp.Inputs)
+}
+
+func (fp *Footprint) OutputLabels() []string {
+	return locationNames(fp.Outputs)
+}
+
+// locationNames drops the "+N" from labels so multi-byte variables are
+// listed once.
+func locationNames(locs []FootprintLocation) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, loc := range locs {
+		name := loc.Label
+		if idx := strings.LastIndex(name, "+"); idx > 0 {
+			name = name[:idx]
+		}
+		if name == "" {
+			name = fmt.Sprintf("$%04X", loc.Address)
+		}
+
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (fp *Footprint) String() string {
+	lines := []string{
+		fmt.Sprintf("calls:     %d", fp.Calls),
+		fmt.Sprintf("consumed:  %s", fp.Consumed),
+		fmt.Sprintf("clobbered: %s", fp.Clobbered),
+		fmt.Sprintf("stack:     %d bytes", fp.MaxStack),
+		fmt.Sprintf("inputs:    %s", strings.Join(fp.InputLabels(), " ")),
+		fmt.Sprintf("outputs:   %s", strings.Join(fp.OutputLabels(), " ")),
+	}
+	return strings.Join(lines, "\n")
+}
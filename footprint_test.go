@@ -0,0 +1,99 @@
+package emu
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zorchenhimer/emu-6502/labels"
+	"github.com/zorchenhimer/emu-6502/mappers"
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+func TestFootprint(t *testing.T) {
+	rom := make([]byte, 0x8000)
+	copy(rom, []byte{
+		0xA5, 0x81, // LDA BallX
+		0x18,       // CLC
+		0x65, 0x84, // ADC BallSpeed
+		0x85, 0x81, // STA BallX
+		0xA0, 0x00, // LDY #$00
+		0xB1, 0x10, // LDA (Ptr), Y
+		0x85, 0x90, // STA TmpY
+		0xA5, 0x90, // LDA TmpY
+		0x48, // PHA
+		0x68, // PLA
+		0x60, // RTS
+	})
+	copy(rom[0x20:], []byte{
+		0x8E, 0x00, 0x03, // STX Board
+		0x60, // RTS
+	})
+
+	mapper, err := mappers.NewNROM(rom, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lbls := labels.Set{}
+	lbls.Add(labels.NesInternalRam, 0x10, &labels.Label{Name: "Ptr", Size: 2})
+	lbls.Add(labels.NesInternalRam, 0x81, &labels.Label{Name: "BallX"})
+	lbls.Add(labels.NesInternalRam, 0x84, &labels.Label{Name: "BallSpeed"})
+	lbls.Add(labels.NesInternalRam, 0x90, &labels.Label{Name: "TmpY"})
+	lbls.Add(labels.NesInternalRam, 0x300, &labels.Label{Name: "Board"})
+	nes := mmu.NewNES(mapper)
+	nes.SetLabels(lbls)
+
+	c := NewCore(nes)
+	c.SP = 0xFF
+	c.WriteByte(0x0011, 0x03)
+	c.Footprint = &Footprint{}
+
+	if err := c.RunRoutine(0x8000); err != nil {
+		t.Fatal(err)
+	}
+
+	fp := c.Footprint
+	if names := fp.InputLabels(); !reflect.DeepEqual(names, []string{"Ptr", "BallX", "BallSpeed", "Board"}) {
+		t.Errorf("unexpected inputs: %v", fp.Inputs)
+	}
+	if len(fp.Inputs) != 5 || fp.Inputs[1].Label != "Ptr+1" || fp.Inputs[1].Type != labels.NesInternalRam {
+		t.Errorf("unexpected input locations: %v", fp.Inputs)
+	}
+	if names := fp.OutputLabels(); !reflect.DeepEqual(names, []string{"BallX", "TmpY"}) {
+		t.Errorf("unexpected outputs: %v", fp.Outputs)
+	}
+	if fp.Consumed.String() != "D" || fp.Clobbered.String() != "A Y N V Z C" {
+		t.Errorf("expected D consumed and A Y N V Z C clobbered, got %s and %s", fp.Consumed, fp.Clobbered)
+	}
+	if fp.MaxStack != 3 {
+		t.Errorf("expected 3 bytes of stack, got %d", fp.MaxStack)
+	}
+
+	// A second routine is merged in.
+	if _, err := c.CallRoutine("$8020", Args{}); err != nil {
+		t.Fatal(err)
+	}
+	if fp.Calls != 2 || fp.Consumed.String() != "X D" || fp.MaxStack != 3 {
+		t.Errorf("unexpected merged footprint:\n%s", fp)
+	}
+	if names := fp.OutputLabels(); !reflect.DeepEqual(names, []string{"BallX", "TmpY", "Board"}) {
+		t.Errorf("unexpected merged outputs: %v", fp.Outputs)
+	}
+
+	// Footprints from clones, eg in a Sweep, are merged by hand.  Clones
+	// start empty, without the per-call state until they're used.
+	clone := c.Clone()
+	if clone.Footprint.state != nil {
+		t.Errorf("expected the clone's footprint state to be allocated on first use")
+	}
+	if _, err := clone.CallRoutine("$8000", Args{Values: map[string]interface{}{"X": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if clone.Footprint.Calls != 1 || len(clone.Footprint.Outputs) != 2 {
+		t.Errorf("expected a fresh footprint in the clone:\n%s", clone.Footprint)
+	}
+	fp.Merge(clone.Footprint)
+	if fp.Calls != 3 || len(fp.Inputs) != 5 {
+		t.Errorf("unexpected footprint after merging a clone:\n%s", fp)
+	}
+}
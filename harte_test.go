@@ -0,0 +1,292 @@
+package emu
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zorchenhimer/emu-6502/disasm"
+)
+
+// Runner for the SingleStepTests ProcessorTests (formerly Tom Harte's).  The
+// JSON files aren't in the repo, they're a few gigabytes.  Copy or link the
+// variant directories into testdata/ProcessorTests so it looks like this:
+//
+//	testdata/ProcessorTests/nes6502/v1/00.json
+//	testdata/ProcessorTests/6502/v1/00.json
+//	testdata/ProcessorTests/wdc65c02/v1/00.json
+//
+// Variants that aren't there are skipped, as are 65C02 opcodes the core
+// doesn't implement.  Run a single opcode with
+//
+//	go test -run 'TestProcessorTests/nes6502/a9'
+var (
+	harteDir   = flag.String("harte", "testdata/ProcessorTests", "directory with the ProcessorTests variants")
+	harteBus   = flag.String("harte.bus", "", "also compare bus activity: \"writes\" or \"all\" cycles")
+	harteCases = flag.Int("harte.cases", 0, "cases to run per opcode, 0 for all (100 with -short)")
+)
+
+// Failures printed per opcode.  The rest are only counted.
+const harteDetails = 3
+
+var harteVariants = []struct {
+	dir     string
+	variant *disasm.Variant
+	decimal bool // ADC and SBC have decimal mode, which isn't emulated
+}{
+	{"nes6502", Variant(), false},
+	{"6502", Variant(), true},
+	{"wdc65c02", disasm.CMOS(Variant()), true},
+	{"rockwell65c02", disasm.CMOS(Variant()), true},
+	{"synertek65c02", disasm.CMOS(Variant()), true},
+}
+
+type harteState struct {
+	PC  uint16      `json:"pc"`
+	S   uint8       `json:"s"`
+	A   uint8       `json:"a"`
+	X   uint8       `json:"x"`
+	Y   uint8       `json:"y"`
+	P   uint8       `json:"p"`
+	RAM [][2]uint16 `json:"ram"`
+}
+
+// Bits 4 and 5 aren't kept in Phlags.
+func (s harteState) regs() regs {
+	return regs{A: s.A, X: s.X, Y: s.Y, SP: s.S, P: s.P & 0xCF}
+}
+
+type harteCase struct {
+	Name    string      `json:"name"`
+	Initial harteState  `json:"initial"`
+	Final   harteState  `json:"final"`
+	Cycles  []busAccess `json:"cycles"`
+}
+
+// Cycles are [address, value, "read" or "write"].
+func (a *busAccess) UnmarshalJSON(data []byte) error {
+	var raw []interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw) != 3 {
+		return fmt.Errorf("invalid cycle: %s", data)
+	}
+
+	addr, ok1 := raw[0].(float64)
+	val, ok2 := raw[1].(float64)
+	kind, ok3 := raw[2].(string)
+	if !ok1 || !ok2 || !ok3 {
+		return fmt.Errorf("invalid cycle: %s", data)
+	}
+
+	*a = busAccess{Address: uint16(addr), Value: uint8(val), Write: kind == "write"}
+	return nil
+}
+
+type harteResult struct {
+	op               byte
+	name             string
+	pass, fail, skip int
+	reason           string // why everything was skipped
+}
+
+func TestProcessorTests(t *testing.T) {
+	switch *harteBus {
+	case "", "writes", "all":
+	default:
+		t.Fatalf("-harte.bus must be \"writes\" or \"all\", not %q", *harteBus)
+	}
+
+	found := false
+	for _, hv := range harteVariants {
+		files := harteFiles(filepath.Join(*harteDir, hv.dir))
+		if len(files) == 0 {
+			continue
+		}
+		found = true
+
+		hv := hv
+		t.Run(hv.dir, func(t *testing.T) {
+			results := []*harteResult{}
+			for _, file := range files {
+				op, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(file), ".json"), 16, 8)
+				if err != nil {
+					continue
+				}
+
+				res := &harteResult{op: byte(op)}
+				results = append(results, res)
+				t.Run(fmt.Sprintf("%02x", op), func(t *testing.T) {
+					runHarteFile(t, file, hv.variant, hv.decimal, res)
+				})
+			}
+
+			// The summary is printed even without -v, and failing opcodes
+			// are listed again so they show up in one place.
+			summary := harteSummary(results)
+			fmt.Printf("ProcessorTests %s:\n%s\n", hv.dir, summary)
+			if failed := harteFailed(results); len(failed) > 0 {
+				t.Errorf("%d opcodes failed: %s", len(failed), strings.Join(failed, " "))
+			}
+		})
+	}
+
+	if !found {
+		t.Skipf("no ProcessorTests in %s", *harteDir)
+	}
+}
+
+// harteFiles returns the JSON files for a variant, in v1/ or the variant's
+// directory itself.
+func harteFiles(dir string) []string {
+	for _, d := range []string{filepath.Join(dir, "v1"), dir} {
+		files, _ := filepath.Glob(filepath.Join(d, "*.json"))
+		if len(files) > 0 {
+			sort.Strings(files)
+			return files
+		}
+	}
+	return nil
+}
+
+func runHarteFile(t *testing.T, filename string, variant *disasm.Variant, decimal bool, res *harteResult) {
+	def, ok := variant.Lookup(res.op)
+	if !ok || res.op == OP_DEBUG {
+		res.reason = "undocumented"
+		t.Skipf("$%02X isn't a documented %s opcode", res.op, variant.Name)
+	}
+	res.name = def.Name
+
+	instr, ok := instructionList[res.op]
+	if !ok || instr.Name() != def.Name || instr.AddressMeta().Mode != def.Mode {
+		res.reason = "not implemented"
+		t.Skipf("$%02X %s %s isn't implemented", res.op, def.Name, def.Mode)
+	}
+
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []harteCase{}
+	if err = json.Unmarshal(raw, &cases); err != nil {
+		t.Fatalf("%s: %v", filename, err)
+	}
+
+	limit := *harteCases
+	if limit == 0 && testing.Short() {
+		limit = 100
+	}
+	if limit > 0 && limit < len(cases) {
+		cases = cases[:limit]
+	}
+
+	noDecimal := decimal && (def.Name == "ADC" || def.Name == "SBC")
+	bus := &testBus{}
+	for _, hc := range cases {
+		if noDecimal && hc.Initial.P&FLAG_DECIMAL != 0 {
+			res.skip++
+			continue
+		}
+
+		problems := runHarteCase(bus, hc)
+		if len(problems) == 0 {
+			res.pass++
+			continue
+		}
+
+		res.fail++
+		if res.fail <= harteDetails {
+			t.Errorf("%s: %s", hc.Name, strings.Join(problems, "; "))
+		}
+	}
+
+	if res.fail > harteDetails {
+		t.Errorf("%d more failures", res.fail-harteDetails)
+	}
+}
+
+// runHarteCase returns what didn't match.
+func runHarteCase(bus *testBus, hc harteCase) []string {
+	bus.ram = [0x10000]uint8{}
+	for _, m := range hc.Initial.RAM {
+		bus.ram[m[0]] = uint8(m[1])
+	}
+
+	c := NewCore(bus)
+	c.PC = hc.Initial.PC
+	c.setRegs(hc.Initial.regs())
+	bus.log = nil
+
+	if err := c.tick(); err != nil {
+		return []string{err.Error()}
+	}
+
+	problems := []string{}
+	if expect, got := hc.Final.regs(), c.regs(); expect != got {
+		problems = append(problems, fmt.Sprintf("expected %s, got %s", expect, got))
+	}
+	if c.PC != hc.Final.PC {
+		problems = append(problems, fmt.Sprintf("expected PC $%04X, got $%04X", hc.Final.PC, c.PC))
+	}
+
+	for _, m := range hc.Final.RAM {
+		if got := bus.ram[m[0]]; got != uint8(m[1]) {
+			problems = append(problems, fmt.Sprintf("$%04X: expected $%02X, got $%02X", m[0], m[1], got))
+		}
+	}
+
+	switch *harteBus {
+	case "writes":
+		expect := []busAccess{}
+		for _, cyc := range hc.Cycles {
+			if cyc.Write {
+				expect = append(expect, cyc)
+			}
+		}
+		if fmt.Sprint(expect) != fmt.Sprint(bus.writes()) {
+			problems = append(problems, fmt.Sprintf("expected writes %v, got %v", expect, bus.writes()))
+		}
+	case "all":
+		if fmt.Sprint(hc.Cycles) != fmt.Sprint(bus.log) {
+			problems = append(problems, fmt.Sprintf("expected cycles %v, got %v", hc.Cycles, bus.log))
+		}
+	}
+
+	return problems
+}
+
+// harteFailed returns the opcodes with failing cases.
+func harteFailed(results []*harteResult) []string {
+	failed := []string{}
+	for _, r := range results {
+		if r.fail > 0 {
+			failed = append(failed, fmt.Sprintf("%02x", r.op))
+		}
+	}
+	return failed
+}
+
+func harteSummary(results []*harteResult) string {
+	lines := []string{"op  name   pass   fail   skip"}
+	total := harteResult{}
+	for _, r := range results {
+		if r.reason != "" {
+			lines = append(lines, fmt.Sprintf("%02x  %-4s   %s", r.op, r.name, r.reason))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%02x  %-4s %6d %6d %6d", r.op, r.name, r.pass, r.fail, r.skip))
+		total.pass += r.pass
+		total.fail += r.fail
+		total.skip += r.skip
+	}
+	lines = append(lines, fmt.Sprintf("total    %6d %6d %6d", total.pass, total.fail, total.skip))
+	return strings.Join(lines, "\n")
+}
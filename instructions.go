@@ -2,6 +2,8 @@ package emu
 
 import (
 	"fmt"
+
+	"github.com/zorchenhimer/emu-6502/disasm"
 )
 
 type ExecFunc func(c *Core, address uint16)
@@ -14,6 +16,25 @@ type Instruction interface {
 	Decode(c *Core) string
 }
 
+// InstructionSet returns a copy of every implemented opcode.
+func InstructionSet() map[byte]Instruction {
+	set := make(map[byte]Instruction, len(instructionList))
+	for op, instr := range instructionList {
+		set[op] = instr
+	}
+	return set
+}
+
+// Variant returns the instruction set implemented by the emulator, for use
+// with the disasm and asm packages.
+func Variant() *disasm.Variant {
+	v := &disasm.Variant{Name: "emu-6502"}
+	for op, instr := range instructionList {
+		v = v.With(op, instr.Name(), instr.AddressMeta().Mode)
+	}
+	return v
+}
+
 var instructionList = map[byte]Instruction{
 
 	OP_DEBUG: DebugInstruction{
@@ -833,7 +854,7 @@ func (di DebugInstruction) Decode(c *Core) string {
 }
 
 func instr_DBG(c *Core, i Instruction) {
-	fmt.Println(c.HistoryString(c.PC, i))
+	fmt.Println(c.HistoryString(c.PC))
 }
 
 type StandardInstruction struct {
@@ -1044,6 +1065,9 @@ func instr_TXA(c *Core, address uint16) {
 
 func instr_TXS(c *Core, address uint16) {
 	c.SP = c.X
+	if c.StackCheck != nil {
+		c.StackCheck.moved(c.SP)
+	}
 }
 
 func instr_TYA(c *Core, address uint16) {
@@ -1163,15 +1187,18 @@ func (b Branch) Execute(c *Core) {
 		v = b.Flag
 	}
 
+	next := c.PC + 2
+	target := c.addrRelative(c.PC, c.ReadByte(c.PC+1))
+
 	if (c.Phlags & b.Flag) == v {
-		c.PC = c.addrRelative(c.PC, c.ReadByte(c.PC+1))
+		c.PC = target
 		if c.Disassemble {
-			c.dasmTrees = append(c.dasmTrees, c.PC+2)
+			c.dasmTrees = append(c.dasmTrees, next)
 		}
 	} else {
-		c.PC += 2
+		c.PC = next
 		if c.Disassemble {
-			c.dasmTrees = append(c.dasmTrees, c.addrRelative(c.PC, c.ReadByte(c.PC+1)))
+			c.dasmTrees = append(c.dasmTrees, target)
 		}
 	}
 }
@@ -1219,26 +1246,31 @@ func instr_JMP(c *Core, address uint16) uint16 {
 
 func instr_JSR(c *Core, address uint16) uint16 {
 	c.routineDepth += 1
+	c.pushFrame(address, c.PC, "")
 	c.pushAddress(c.PC + 2)
 	return address
 }
 
 func instr_RTS(c *Core, address uint16) uint16 {
 	c.routineDepth -= 1
+	c.popFrame()
 	return c.pullAddress() + 1
 }
 
 func instr_RTI(c *Core, address uint16) uint16 {
 	c.routineDepth -= 1
-	c.Phlags = c.pullByte()
+	c.popFrame()
+	c.Phlags = c.pullByte() & (0xCF) // same as PLP
 	return c.pullAddress()
 }
 
 func instr_BRK(c *Core, address uint16) uint16 {
 	c.routineDepth += 1
+	vector := c.ReadWord(0xFFFE)
+	c.pushFrame(vector, c.PC, "BRK")
 	c.pushAddress(c.PC + 2)
 	c.pushByte(c.Phlags | FLAG_BREAK)
 	c.Phlags = c.Phlags | FLAG_INTERRUPT
-	return c.ReadWord(0xFFFE)
+	return vector
 }
 
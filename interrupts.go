@@ -7,9 +7,12 @@ type Interrupt struct {
 }
 
 func (i Interrupt) Execute(c *Core) {
+	vector := c.ReadWord(i.vector)
+	c.pushFrame(vector, c.PC, i.Name)
 	c.pushAddress(c.PC)
 	c.pushByte(i.phlags | c.Phlags)
-	c.PC = c.ReadWord(i.vector)
+	c.Phlags |= FLAG_INTERRUPT
+	c.PC = vector
 }
 
 var interruptList = map[uint16]Interrupt{
@@ -0,0 +1,379 @@
+package emu
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+// Klaus Dormann's 6502 test suite, assembled with ca65 into 64k images.
+// Each test ends in a loop on its own address: either a trap for a failed
+// check or the success trap, which is found in the listing.  Only the
+// functional test is in the repo.  The others are run if their .bin and
+// .lst are added to testdata/klaus.
+var klausDecimal = flag.Bool("klaus.decimal", false, "run the decimal test even though decimal mode isn't emulated")
+
+// The functional test needs about 43 million.
+const klausLimit int64 = 100000000
+
+var klausTests = []struct {
+	name       string
+	decimal    bool // needs decimal mode
+	interrupts bool // needs the feedback register
+}{
+	{"6502_functional_test", false, false},
+	{"6502_decimal_test", true, false},
+	{"6502_interrupt_test", false, true},
+}
+
+func TestKlaus(t *testing.T) {
+	for _, kt := range klausTests {
+		kt := kt
+		t.Run(kt.name, func(t *testing.T) {
+			base := filepath.Join("testdata", "klaus", kt.name)
+			if _, err := os.Stat(base + ".bin"); os.IsNotExist(err) {
+				t.Skipf("%s.bin not found", base)
+			}
+
+			if kt.decimal && !*klausDecimal {
+				t.Skip("decimal mode isn't emulated.  Use -klaus.decimal to run it anyway.")
+			}
+			if testing.Short() {
+				t.Skip("skipped with -short")
+			}
+
+			runKlaus(t, base, kt.interrupts)
+		})
+	}
+}
+
+// The interrupt test isn't in the repo, so this checks the feedback port
+// with a small program that raises its own IRQ and NMIs the same way.
+func TestKlausFeedbackPort(t *testing.T) {
+	program := []struct {
+		address uint16
+		bytes   string
+		source  string
+	}{
+		{0x0000, "", "I_port = $BFFC"},
+		{0x0000, "", "IRQ_bit = 0"},
+		{0x0000, "", "NMI_bit = 1"},
+		{0x0010, "", "irq_count = $10"},
+		{0x0011, "", "nmi_count = $11"},
+		{0x0400, "", "start:"},
+		{0x0400, "58", "cli"},
+		{0x0401, "A901", "lda #1<<IRQ_bit"},
+		{0x0403, "8DFCBF", "sta I_port"},
+		{0x0406, "A510", "wait_irq: lda irq_count"},
+		{0x0408, "F0FC", "beq wait_irq"},
+		{0x040A, "", "; NMI only fires on the rising edge"},
+		{0x040A, "A902", "lda #1<<NMI_bit"},
+		{0x040C, "8DFCBF", "sta I_port"},
+		{0x040F, "8DFCBF", "sta I_port"},
+		{0x0412, "A900", "lda #0"},
+		{0x0414, "8DFCBF", "sta I_port"},
+		{0x0417, "A902", "lda #1<<NMI_bit"},
+		{0x0419, "8DFCBF", "sta I_port"},
+		{0x041C, "A511", "lda nmi_count"},
+		{0x041E, "C902", "cmp #2"},
+		{0x0420, "D0FE", "trap_ne"},
+		{0x0422, "A510", "lda irq_count"},
+		{0x0424, "C901", "cmp #1"},
+		{0x0426, "D0FE", "trap_ne"},
+		{0x0428, "4C2804", "success"},
+		{0x0500, "", "; IRQ is level triggered, so the handler clears it"},
+		{0x0500, "E610", "irq: inc irq_count"},
+		{0x0502, "A900", "lda #0"},
+		{0x0504, "8DFCBF", "sta I_port"},
+		{0x0507, "40", "rti"},
+		{0x0510, "E611", "nmi: inc nmi_count"},
+		{0x0512, "40", "rti"},
+		{0xFFFA, "1005", ".word nmi"},
+		{0xFFFE, "0005", ".word irq"},
+	}
+
+	image := make([]byte, 0x10000)
+	src := &strings.Builder{}
+	for _, line := range program {
+		data, err := hex.DecodeString(line.bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		copy(image[line.address:], data)
+
+		// Formatted like a ca65 listing, with the source in column 24.
+		fmt.Fprintf(src, "%06X  1  %-12X %s\n", line.address, data, line.source)
+	}
+
+	lst, err := readListing(strings.NewReader(src.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runKlausImage(t, image, lst, true)
+}
+
+func runKlaus(t *testing.T, base string, interrupts bool) {
+	image, err := ioutil.ReadFile(base + ".bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lst, err := loadListing(base + ".lst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runKlausImage(t, image, lst, interrupts)
+}
+
+func runKlausImage(t *testing.T, image []byte, lst *listing, interrupts bool) {
+	success, ok := lst.successTrap()
+	if !ok {
+		t.Fatalf("no success trap in the listing")
+	}
+
+	// The reset vector points to a trap, not the start.
+	start, ok := lst.symbol("start")
+	if !ok {
+		start = 0x0400
+	}
+
+	ram, err := mmu.NewFullRam(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var port *feedbackPort
+	var mem mmu.Manager = ram
+	if interrupts {
+		port = newFeedbackPort(ram, lst)
+		mem = port
+	}
+
+	c := NewCore(mem)
+	c.PC = start
+
+	for i := int64(0); i < klausLimit; i++ {
+		pc := c.PC
+		if err := c.tick(); err != nil {
+			t.Fatalf("%v\n%s", err, lst.describe(pc, ram))
+		}
+
+		if c.PC == pc {
+			if pc == success {
+				return
+			}
+			t.Fatalf("trapped at $%04X after %d instructions\n%s", pc, c.Ticks(), lst.describe(pc, ram))
+		}
+
+		if port != nil {
+			port.poll(c)
+		}
+	}
+
+	t.Fatalf("no trap after %d instructions, at $%04X", klausLimit, c.PC)
+}
+
+// feedbackPort is the I/O port the interrupt test writes to trigger its
+// own interrupts.  NMI is edge triggered, IRQ is level triggered.
+type feedbackPort struct {
+	mmu.Manager
+	address uint16
+	irq     uint8
+	nmi     uint8
+	invert  bool // open collector, the lines are active low
+
+	value   uint8
+	nmiLine bool
+}
+
+// Defaults are the ones in 6502_interrupt_test.a65.
+func newFeedbackPort(m mmu.Manager, lst *listing) *feedbackPort {
+	fp := &feedbackPort{Manager: m, address: 0xBFFC, irq: 0x01, nmi: 0x02}
+	if addr, ok := lst.symbol("I_port"); ok {
+		fp.address = addr
+	}
+	if bit, ok := lst.symbol("IRQ_bit"); ok {
+		fp.irq = 1 << bit
+	}
+	if bit, ok := lst.symbol("NMI_bit"); ok {
+		fp.nmi = 1 << bit
+	}
+	if drive, ok := lst.symbol("I_drive"); ok && drive == 0 {
+		fp.invert = true
+		fp.value = 0xFF
+	}
+	return fp
+}
+
+func (fp *feedbackPort) ReadByte(address uint16) uint8 {
+	if address == fp.address {
+		return fp.value
+	}
+	return fp.Manager.ReadByte(address)
+}
+
+func (fp *feedbackPort) WriteByte(address uint16, value uint8) {
+	if address == fp.address {
+		fp.value = value
+		return
+	}
+	fp.Manager.WriteByte(address, value)
+}
+
+func (fp *feedbackPort) active(bit uint8) bool {
+	return (fp.value&bit != 0) != fp.invert
+}
+
+// poll is called after every instruction.
+func (fp *feedbackPort) poll(c *Core) {
+	nmi := fp.active(fp.nmi)
+	if nmi && !fp.nmiLine {
+		c.NMI()
+	}
+	fp.nmiLine = nmi
+
+	if fp.active(fp.irq) {
+		c.IRQ()
+	}
+}
+
+type listingLine struct {
+	address uint16
+	bytes   []byte
+	source  string
+}
+
+// listing is a ca65 listing file.
+type listing struct {
+	lines []listingLine
+}
+
+// Lines look like this, with the source starting in column 24:
+//
+//	0006CD  1  D0 FE                trap_ne
+func loadListing(filename string) (*listing, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readListing(file)
+}
+
+func readListing(r io.Reader) (*listing, error) {
+	lst := &listing{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) < 24 {
+			continue
+		}
+
+		addr, err := strconv.ParseUint(line[0:6], 16, 32)
+		if err != nil {
+			continue
+		}
+
+		data, err := hex.DecodeString(strings.Replace(strings.TrimSpace(line[11:23]), " ", "", -1))
+		if err != nil {
+			continue
+		}
+
+		lst.lines = append(lst.lines, listingLine{uint16(addr), data, line[24:]})
+	}
+	return lst, scanner.Err()
+}
+
+// successTrap is the address of the "success" macro.
+func (l *listing) successTrap() (uint16, bool) {
+	for _, line := range l.lines {
+		fields := strings.Fields(line.source)
+		if len(fields) > 0 && fields[0] == "success" && len(line.bytes) > 0 {
+			return line.address, true
+		}
+	}
+	return 0, false
+}
+
+// symbol finds a label ("name:") or an equate ("name = $1234").
+func (l *listing) symbol(name string) (uint16, bool) {
+	for _, line := range l.lines {
+		fields := strings.Fields(line.source)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == name+":" {
+			return line.address, true
+		}
+
+		if len(fields) >= 3 && fields[0] == name && fields[1] == "=" {
+			val := fields[2]
+			var v uint64
+			var err error
+			if strings.HasPrefix(val, "$") {
+				v, err = strconv.ParseUint(val[1:], 16, 16)
+			} else {
+				v, err = strconv.ParseUint(val, 10, 16)
+			}
+			if err == nil {
+				return uint16(v), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// describe names the test around a trap: the current test number, the
+// comment that starts the section and the last few lines of code.
+func (l *listing) describe(pc uint16, mem mmu.Manager) string {
+	idx := -1
+	for i, line := range l.lines {
+		if line.address == pc && len(line.bytes) > 0 {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return fmt.Sprintf("$%04X isn't in the listing", pc)
+	}
+
+	out := []string{}
+	if addr, ok := l.symbol("test_case"); ok {
+		out = append(out, fmt.Sprintf("test case: $%02X", mem.ReadByte(addr)))
+	}
+
+	for i := idx; i >= 0; i-- {
+		src := strings.TrimSpace(l.lines[i].source)
+		if strings.HasPrefix(src, ";") && strings.ContainsAny(src, "abcdefghijklmnopqrstuvwxyz") && len(l.lines[i].bytes) == 0 {
+			out = append(out, "section: "+strings.TrimSpace(strings.TrimLeft(src, ";")))
+			break
+		}
+	}
+
+	first := idx - 5
+	if first < 0 {
+		first = 0
+	}
+	for _, line := range l.lines[first : idx+1] {
+		if len(line.bytes) == 0 {
+			continue
+		}
+		marker := "  "
+		if line.address == pc {
+			marker = "> "
+		}
+		out = append(out, fmt.Sprintf("%s$%04X  %s", marker, line.address, strings.TrimSpace(line.source)))
+	}
+	return strings.Join(out, "\n")
+}
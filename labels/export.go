@@ -0,0 +1,323 @@
+package labels
+
+// Writers for label files used by other emulators and tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// entry is a single label with its memory type, for sorted output.
+type entry struct {
+	Type    MemoryType
+	Address uint
+	*Label
+}
+
+// size is never zero.  Some sources don't set it.
+func (e entry) size() uint {
+	if e.Size == 0 {
+		return 1
+	}
+	return e.Size
+}
+
+// sorted returns all labels sorted by memory type, then address.
+func sorted(lbls map[MemoryType]LabelMap) []entry {
+	entries := []entry{}
+	for t, lm := range lbls {
+		for addr, lbl := range lm {
+			entries = append(entries, entry{t, addr, lbl})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Type != entries[j].Type {
+			return entries[i].Type < entries[j].Type
+		}
+		return entries[i].Address < entries[j].Address
+	})
+	return entries
+}
+
+// cpuAddress returns the CPU address of labels that aren't in PRG ROM.
+func cpuAddress(t MemoryType, address uint) (uint, bool) {
+	switch t {
+	case NesInternalRam, NesMemory:
+		return address, true
+	case NesWorkRam, NesSaveRam:
+		return address + 0x6000, true
+	}
+	return 0, false
+}
+
+// PrgAddress returns the CPU address that an offset in PRG ROM is used at.
+// ok is false if it isn't mapped anywhere.
+type PrgAddress func(offset uint) (address uint, ok bool)
+
+// LastBankFixed is the PRG layout of NROM, UxROM and MMC1 at power on: the
+// last 16k bank is at $C000 and the others are switched in at $8000.  A 16k
+// NROM is at $C000 too.
+func LastBankFixed(prgSize uint) PrgAddress {
+	return func(offset uint) (uint, bool) {
+		if offset >= prgSize {
+			return 0, false
+		}
+		if offset/fceuxBankSize == (prgSize-1)/fceuxBankSize {
+			return 0xC000 + offset%fceuxBankSize, true
+		}
+		return 0x8000 + offset%fceuxBankSize, true
+	}
+}
+
+// defaultPrg is used when the PRG layout isn't given.  The ROM is assumed to
+// end with the last bank that has labels.
+func defaultPrg(prg PrgAddress, lbls map[MemoryType]LabelMap) PrgAddress {
+	if prg != nil {
+		return prg
+	}
+
+	size := uint(0)
+	for _, bank := range FceuxBanks(lbls) {
+		size = uint(bank+1) * fceuxBankSize
+	}
+	return LastBankFixed(size)
+}
+
+// Save writes labels to a file in the given format.  An empty format is
+// picked from the file extension.  prg places PRG ROM labels in CPU address
+// space; if it's nil, see defaultPrg().
+//
+// For FCEUX, a file name that doesn't end in .nl is taken as the ROM name
+// and a file is written for RAM and each PRG bank that has labels.
+func Save(filename string, lbls map[MemoryType]LabelMap, format Format, prg PrgAddress) error {
+	if format == "" {
+		format = FormatFromName(filename)
+	}
+
+	if format == FormatFceux && strings.ToLower(filepath.Ext(filename)) != ".nl" {
+		return SaveFceux(filename, lbls, prg)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case FormatMesen2:
+		err = WriteMesen2(file, lbls)
+	case FormatMesen:
+		err = WriteMesen(file, lbls)
+	case FormatFceux:
+		err = WriteFceux(file, lbls, fceuxBank(filename), prg)
+	case FormatCa65:
+		err = WriteCa65(file, lbls, prg)
+	default:
+		err = fmt.Errorf("unable to write %q labels", format)
+	}
+
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	return file.Close()
+}
+
+type mesen2Label struct {
+	Address    uint
+	MemoryType MemoryType
+	Label      string
+	Comment    string
+	Length     uint
+}
+
+// WriteMesen2 writes a Mesen2 workspace with just the labels.
+func WriteMesen2(w io.Writer, lbls map[MemoryType]LabelMap) error {
+	ws := struct {
+		Labels []mesen2Label
+	}{[]mesen2Label{}}
+
+	for _, e := range sorted(lbls) {
+		ws.Labels = append(ws.Labels, mesen2Label{
+			Address:    e.Address,
+			MemoryType: e.Type,
+			Label:      e.Name,
+			Comment:    e.Comment,
+			Length:     e.size(),
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ws)
+}
+
+// WriteMesen writes a Mesen 1 .mlb file.  Memory types that Mesen 1 doesn't
+// have are skipped.
+func WriteMesen(w io.Writer, lbls map[MemoryType]LabelMap) error {
+	prefixes := map[MemoryType]string{}
+	for p, t := range mlbTypes {
+		prefixes[t] = p
+	}
+
+	for _, e := range sorted(lbls) {
+		prefix, ok := prefixes[e.Type]
+		if !ok {
+			continue
+		}
+
+		addr := fmt.Sprintf("%04X", e.Address)
+		if e.size() > 1 {
+			addr = fmt.Sprintf("%04X-%04X", e.Address, e.Address+e.size()-1)
+		}
+
+		line := fmt.Sprintf("%s:%s:%s", prefix, addr, e.Name)
+		if e.Comment != "" {
+			line += ":" + strings.Replace(e.Comment, "\n", `\n`, -1)
+		}
+
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FceuxBanks returns the 16k PRG banks that have labels.
+func FceuxBanks(lbls map[MemoryType]LabelMap) []int {
+	found := map[int]bool{}
+	banks := []int{}
+	for addr := range lbls[NesPrgRom] {
+		bank := int(addr / fceuxBankSize)
+		if !found[bank] {
+			found[bank] = true
+			banks = append(banks, bank)
+		}
+	}
+
+	sort.Ints(banks)
+	return banks
+}
+
+// SaveFceux writes rom.nes.ram.nl and a rom.nes.X.nl file for each bank.
+func SaveFceux(romname string, lbls map[MemoryType]LabelMap, prg PrgAddress) error {
+	files := map[string]int{romname + ".ram.nl": -1}
+	for _, bank := range FceuxBanks(lbls) {
+		files[fmt.Sprintf("%s.%X.nl", romname, bank)] = bank
+	}
+
+	for filename, bank := range files {
+		file, err := os.Create(filename)
+		if err != nil {
+			return err
+		}
+
+		err = WriteFceux(file, lbls, bank, prg)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+
+		if err = file.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteFceux writes an FCEUX .nl file for the given 16k PRG bank, or the
+// RAM file if bank is -1.  PRG addresses are taken from prg.
+func WriteFceux(w io.Writer, lbls map[MemoryType]LabelMap, bank int, prg PrgAddress) error {
+	prg = defaultPrg(prg, lbls)
+	for _, e := range sorted(lbls) {
+		var address uint
+		if bank < 0 {
+			addr, ok := cpuAddress(e.Type, e.Address)
+			if !ok || addr >= 0x8000 {
+				continue
+			}
+			address = addr
+		} else {
+			if e.Type != NesPrgRom || int(e.Address/fceuxBankSize) != bank {
+				continue
+			}
+			addr, ok := prg(e.Address)
+			if !ok {
+				continue
+			}
+			address = addr
+		}
+
+		addr := fmt.Sprintf("$%04X", address)
+		if e.size() > 1 {
+			addr += fmt.Sprintf("/%X", e.size())
+		}
+
+		if _, err := fmt.Fprintf(w, "%s#%s#%s\n", addr, e.Name, e.Comment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCa65 writes an include file of "name = $addr" definitions for
+// labels with a CPU address.  PRG ROM addresses are taken from prg.
+// Comments and sizes are written as comments.
+func WriteCa65(w io.Writer, lbls map[MemoryType]LabelMap, prg PrgAddress) error {
+	prg = defaultPrg(prg, lbls)
+	written := map[string]bool{}
+	for _, e := range sorted(lbls) {
+		address, ok := cpuAddress(e.Type, e.Address)
+		if e.Type == NesPrgRom {
+			address, ok = prg(e.Address)
+		}
+
+		if !ok || !isIdentifier(e.Name) || written[e.Name] {
+			continue
+		}
+		written[e.Name] = true
+
+		for _, line := range strings.Split(e.Comment, "\n") {
+			if line == "" {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "; %s\n", line); err != nil {
+				return err
+			}
+		}
+
+		def := fmt.Sprintf("%s = $%04X", e.Name, address)
+		if e.size() > 1 {
+			def = fmt.Sprintf("%-32s ; %d bytes", def, e.size())
+		}
+
+		if _, err := fmt.Fprintln(w, def); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isIdentifier returns true if name is usable as a ca65 symbol.  Cheap
+// locals (@name) aren't.
+func isIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && r >= '0' && r <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
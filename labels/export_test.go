@@ -0,0 +1,193 @@
+package labels
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testLabels() map[MemoryType]LabelMap {
+	return map[MemoryType]LabelMap{
+		NesPrgRom: {
+			0x0000: {Name: "Reset"},
+			0x401D: {Name: "palette", Size: 8, Comment: "BG colors\nsecond line"},
+		},
+		NesInternalRam: {
+			0x0000: {Name: "frame", Size: 1, Comment: "Frame counter"},
+			0x0300: {Name: "buffer", Size: 0x100},
+		},
+		NesWorkRam: {
+			0x0000: {Name: "save", Size: 0x10},
+		},
+		NesMemory: {
+			0x2002: {Name: "PPUSTATUS", Size: 1},
+		},
+	}
+}
+
+// Sizes of zero are written as one.
+func normalize(lbls map[MemoryType]LabelMap) map[MemoryType]LabelMap {
+	for _, lm := range lbls {
+		for _, lbl := range lm {
+			if lbl.Size == 0 {
+				lbl.Size = 1
+			}
+		}
+	}
+	return lbls
+}
+
+func TestMesenRoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteMesen(buf, testLabels()); err != nil {
+		t.Fatal(err)
+	}
+
+	lbls, err := ReadMesen(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := normalize(testLabels()); !reflect.DeepEqual(lbls, expect) {
+		t.Errorf("round trip mismatch\nexpected %v\n     got %v", expect, lbls)
+	}
+}
+
+func TestFceuxRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "labels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rom := filepath.Join(dir, "game.nes")
+	if err := Save(rom, testLabels(), FormatFceux, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bank 1 is the last one with labels, so it's taken to be at $C000.
+	for name, expect := range map[string]string{"game.nes.0.nl": "$8000#Reset#", "game.nes.1.nl": "$C01D/8#palette#BG colors"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		} else if !strings.HasPrefix(string(data), expect+"\n") {
+			t.Errorf("%s: expected %q, got %q", name, expect, data)
+		}
+	}
+
+	merged := map[MemoryType]LabelMap{}
+	for _, name := range []string{"game.nes.ram.nl", "game.nes.0.nl", "game.nes.1.nl"} {
+		lbls, err := Load(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for typ, lm := range lbls {
+			for addr, lbl := range lm {
+				add(merged, typ, addr, lbl)
+			}
+		}
+	}
+
+	if expect := normalize(testLabels()); !reflect.DeepEqual(merged, expect) {
+		t.Errorf("round trip mismatch\nexpected %v\n     got %v", expect, merged)
+	}
+}
+
+func TestWriteMesen2(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteMesen2(buf, testLabels()); err != nil {
+		t.Fatal(err)
+	}
+
+	ws := struct{ Labels []mesen2Label }{}
+	if err := json.Unmarshal(buf.Bytes(), &ws); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ws.Labels) != 6 {
+		t.Fatalf("expected 6 labels, got %d", len(ws.Labels))
+	}
+
+	found := false
+	for _, lbl := range ws.Labels {
+		if lbl.Label == "palette" {
+			found = true
+			if lbl.MemoryType != NesPrgRom || lbl.Address != 0x401D || lbl.Length != 8 || lbl.Comment != "BG colors\nsecond line" {
+				t.Errorf("unexpected palette label: %v", lbl)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("palette label missing")
+	}
+}
+
+func TestWriteCa65(t *testing.T) {
+	lbls := testLabels()
+	lbls[NesInternalRam][0x0010] = &Label{Name: "@local"}
+	lbls[NesPrgRom][0x8000] = &Label{Name: "Bank2"}
+
+	buf := &bytes.Buffer{}
+	if err := WriteCa65(buf, lbls, LastBankFixed(0x8000)); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{
+		"frame = $0000",
+		"buffer = $0300                   ; 256 bytes",
+		"PPUSTATUS = $2002",
+		"; BG colors",
+		"; second line",
+		"palette = $C01D                  ; 8 bytes",
+		"Reset = $8000",
+		"save = $6000                     ; 16 bytes",
+	}
+
+	out := buf.String()
+	for _, e := range expect {
+		if !strings.Contains(out, e+"\n") {
+			t.Errorf("missing %q in output:\n%s", e, out)
+		}
+	}
+
+	if strings.Contains(out, "@local") || strings.Contains(out, "Bank2") {
+		t.Errorf("unexpected labels in output:\n%s", out)
+	}
+}
+
+func TestLastBankFixed(t *testing.T) {
+	tests := []struct {
+		size    uint
+		offset  uint
+		address uint
+		ok      bool
+	}{
+		{0x4000, 0x0010, 0xC010, true},
+		{0x4000, 0x4000, 0, false},
+		{0x8000, 0x0010, 0x8010, true},
+		{0x8000, 0x7FFA, 0xFFFA, true},
+		{0x20000, 0x4010, 0x8010, true},
+		{0x20000, 0x1C010, 0xC010, true},
+	}
+
+	for _, tc := range tests {
+		address, ok := LastBankFixed(tc.size)(tc.offset)
+		if address != tc.address || ok != tc.ok {
+			t.Errorf("$%X in $%X: expected $%04X %t, got $%04X %t", tc.offset, tc.size, tc.address, tc.ok, address, ok)
+		}
+	}
+
+	// Bank 1 of a 128k ROM is switched in at $8000.
+	buf := &bytes.Buffer{}
+	if err := WriteFceux(buf, testLabels(), 1, LastBankFixed(0x20000)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "$801D/8#palette#BG colors\nsecond line\n" {
+		t.Errorf("unexpected bank 1 file: %q", buf)
+	}
+}
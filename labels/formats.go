@@ -0,0 +1,425 @@
+package labels
+
+// Loaders for label files from other emulators and tools
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FCEUX bank files are always in 16k banks
+const fceuxBankSize uint = 0x4000
+
+// add a label, creating the map for the memory type if needed.
+func add(ret map[MemoryType]LabelMap, t MemoryType, address uint, lbl *Label) {
+	if _, ok := ret[t]; !ok {
+		ret[t] = make(LabelMap)
+	}
+	ret[t][address] = lbl
+}
+
+// cpuType returns the memory type and address for a CPU address outside of
+// PRG ROM.  The work RAM address is relative to $6000, like Mesen's.
+func cpuType(address uint) (MemoryType, uint) {
+	switch {
+	case address < 0x2000:
+		return NesInternalRam, address % 0x0800
+	case address >= 0x6000 && address < 0x8000:
+		return NesWorkRam, address - 0x6000
+	}
+	return NesMemory, address
+}
+
+// Load reads labels from any of the supported formats.  The format is picked
+// by the file extension, or by looking at the contents if the extension
+// isn't known.
+func Load(filename string) (map[MemoryType]LabelMap, error) {
+	return LoadFormat(filename, "")
+}
+
+// LoadFormat reads labels in the given format.  An empty format is the same
+// as Load().
+func LoadFormat(filename string, format Format) (map[MemoryType]LabelMap, error) {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", filename, err)
+	}
+
+	if format == "" {
+		format = FormatFromName(filename)
+	}
+	if format == "" {
+		format = DetectFormat(raw)
+	}
+
+	var ret map[MemoryType]LabelMap
+	r := bytes.NewReader(raw)
+
+	switch format {
+	case FormatMesen2:
+		return LoadMesen2(filename)
+	case FormatMesen:
+		ret, err = ReadMesen(r)
+	case FormatFceux:
+		ret, err = ReadFceux(r, fceuxBank(filename))
+	case FormatVice:
+		ret, err = ReadVice(r)
+	case FormatLd65Map:
+		ret, err = ReadLd65Map(r)
+	default:
+		return nil, fmt.Errorf("unknown label format for %s", filename)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return ret, nil
+}
+
+type Format string
+
+const (
+	FormatMesen2  Format = "mesen2" // Mesen2 workspace JSON
+	FormatMesen   Format = "mlb"    // Mesen 1 .mlb
+	FormatFceux   Format = "nl"     // FCEUX .nl
+	FormatVice    Format = "vice"   // VICE label file, written by ld65 -Ln
+	FormatLd65Map Format = "map"    // ld65 -m map file
+	FormatCa65    Format = "ca65"   // ca65 include file.  Export only.
+)
+
+// FormatFromName guesses the format from the file extension.  Returns an
+// empty string if it's not known.
+func FormatFromName(filename string) Format {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return FormatMesen2
+	case ".mlb":
+		return FormatMesen
+	case ".nl":
+		return FormatFceux
+	case ".lbl", ".vice", ".labels":
+		return FormatVice
+	case ".map":
+		return FormatLd65Map
+	case ".inc", ".s", ".asm":
+		return FormatCa65
+	}
+	return ""
+}
+
+var (
+	reMlb   = regexp.MustCompile(`^[PRSWG]:[0-9A-Fa-f]+(-[0-9A-Fa-f]+)?:`)
+	reFceux = regexp.MustCompile(`^\$[0-9A-Fa-f]+(/[0-9A-Fa-f]+)?#`)
+	reVice  = regexp.MustCompile(`^al\s+(C:)?[0-9A-Fa-f]+\s+\S+`)
+)
+
+// DetectFormat guesses the format from the file contents.  Returns an empty
+// string if it's not known.
+func DetectFormat(data []byte) Format {
+	text := string(data)
+	if strings.Contains(text, "Exports list by name:") {
+		return FormatLd65Map
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "{"):
+			return FormatMesen2
+		case reMlb.MatchString(line):
+			return FormatMesen
+		case reFceux.MatchString(line):
+			return FormatFceux
+		case reVice.MatchString(line):
+			return FormatVice
+		}
+		break
+	}
+	return ""
+}
+
+// fceuxBank returns the bank number from a file name like "game.nes.1.nl".
+// Returns -1 for RAM files ("game.nes.ram.nl") or if there's no bank.
+func fceuxBank(filename string) int {
+	parts := strings.Split(filepath.Base(filename), ".")
+	if len(parts) < 3 {
+		return -1
+	}
+
+	bank, err := strconv.ParseUint(parts[len(parts)-2], 16, 8)
+	if err != nil {
+		return -1
+	}
+	return int(bank)
+}
+
+// Mesen 1 memory type prefixes
+var mlbTypes = map[string]MemoryType{
+	"P": NesPrgRom,
+	"R": NesInternalRam,
+	"S": NesSaveRam,
+	"W": NesWorkRam,
+	"G": NesMemory,
+}
+
+// LoadMesen reads a Mesen 1 .mlb file.
+func LoadMesen(filename string) (map[MemoryType]LabelMap, error) {
+	return loadWith(filename, ReadMesen)
+}
+
+// ReadMesen reads a Mesen 1 .mlb file.  Lines look like this:
+//
+//	P:1F00:label:comment
+//	R:0300-030F:buffer
+func ReadMesen(r io.Reader) (map[MemoryType]LabelMap, error) {
+	ret := make(map[MemoryType]LabelMap)
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 4)
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("line %d: invalid label %q", lineNum, line)
+		}
+
+		t, ok := mlbTypes[parts[0]]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown memory type %q", lineNum, parts[0])
+		}
+
+		start, end, err := parseRange(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+
+		lbl := &Label{Name: parts[2], Size: end - start + 1}
+		if len(parts) == 4 {
+			lbl.Comment = strings.Replace(parts[3], `\n`, "\n", -1)
+		}
+		add(ret, t, start, lbl)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// parseRange parses "1234" or "1234-1237" (inclusive).
+func parseRange(val string) (uint, uint, error) {
+	parts := strings.SplitN(val, "-", 2)
+	start, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid address %q", parts[0])
+	}
+
+	end := start
+	if len(parts) == 2 {
+		end, err = strconv.ParseUint(parts[1], 16, 32)
+		if err != nil || end < start {
+			return 0, 0, fmt.Errorf("invalid address range %q", val)
+		}
+	}
+	return uint(start), uint(end), nil
+}
+
+// LoadFceux reads an FCEUX .nl file.  The bank is taken from the file name,
+// eg "game.nes.1.nl" for bank 1 or "game.nes.ram.nl" for RAM.
+func LoadFceux(filename string) (map[MemoryType]LabelMap, error) {
+	return loadWith(filename, func(r io.Reader) (map[MemoryType]LabelMap, error) {
+		return ReadFceux(r, fceuxBank(filename))
+	})
+}
+
+// ReadFceux reads an FCEUX .nl file.  Bank is the 16k PRG bank the file is
+// for, or -1 for the RAM file.  Lines look like this:
+//
+//	$C000#Reset#comment
+//	$0300/10#buffer#
+func ReadFceux(r io.Reader, bank int) (map[MemoryType]LabelMap, error) {
+	ret := make(map[MemoryType]LabelMap)
+	var last *Label
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		// Comments can continue on the next line.
+		if !strings.HasPrefix(line, "$") {
+			if last == nil {
+				return nil, fmt.Errorf("line %d: invalid label %q", lineNum, line)
+			}
+			last.Comment += "\n" + line
+			continue
+		}
+
+		parts := strings.SplitN(line[1:], "#", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("line %d: invalid label %q", lineNum, line)
+		}
+
+		addrParts := strings.SplitN(parts[0], "/", 2)
+		address, err := strconv.ParseUint(addrParts[0], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid address %q", lineNum, addrParts[0])
+		}
+
+		size := uint64(1)
+		if len(addrParts) == 2 {
+			size, err = strconv.ParseUint(addrParts[1], 16, 16)
+			if err != nil || size == 0 {
+				return nil, fmt.Errorf("line %d: invalid size %q", lineNum, addrParts[1])
+			}
+		}
+
+		last = &Label{Name: parts[1], Size: uint(size)}
+		if len(parts) == 3 {
+			last.Comment = parts[2]
+		}
+
+		if bank >= 0 && address >= 0x8000 {
+			add(ret, NesPrgRom, uint(bank)*fceuxBankSize+uint(address)%fceuxBankSize, last)
+		} else {
+			t, addr := cpuType(uint(address))
+			add(ret, t, addr, last)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// LoadVice reads a VICE label file.
+func LoadVice(filename string) (map[MemoryType]LabelMap, error) {
+	return loadWith(filename, ReadVice)
+}
+
+// ReadVice reads a VICE label file, like the ones written by ld65's -Ln
+// option.  Addresses are CPU addresses, so everything is NesMemory.  Lines
+// look like this:
+//
+//	al 00C000 .Reset
+func ReadVice(r io.Reader) (map[MemoryType]LabelMap, error) {
+	ret := make(map[MemoryType]LabelMap)
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] != "al" || len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: invalid label %q", lineNum, scanner.Text())
+		}
+
+		address, err := strconv.ParseUint(strings.TrimPrefix(fields[1], "C:"), 16, 32)
+		if err != nil || address > 0xFFFF {
+			return nil, fmt.Errorf("line %d: invalid address %q", lineNum, fields[1])
+		}
+
+		// Keep the first label at an address.  ld65 writes them sorted by
+		// name, not by how they were defined.
+		if _, ok := ret[NesMemory][uint(address)]; ok {
+			continue
+		}
+		add(ret, NesMemory, uint(address), &Label{Name: strings.TrimPrefix(fields[2], "."), Size: 1})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// LoadLd65Map reads the exports from an ld65 map file.
+func LoadLd65Map(filename string) (map[MemoryType]LabelMap, error) {
+	return loadWith(filename, ReadLd65Map)
+}
+
+// ReadLd65Map reads the "Exports list by name" section of an ld65 map file.
+// Addresses are CPU addresses, so everything is NesMemory.  Only labels are
+// loaded.  Equates (eg, __STACKSIZE__) are skipped.
+func ReadLd65Map(r io.Reader) (map[MemoryType]LabelMap, error) {
+	ret := make(map[MemoryType]LabelMap)
+	scanner := bufio.NewScanner(r)
+
+	found := false
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "Exports list by name:" {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no exports list found")
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "---") {
+			continue
+		}
+		if line == "" {
+			break
+		}
+
+		// Two exports per line: name, value, flags
+		fields := strings.Fields(line)
+		if len(fields)%3 != 0 {
+			return nil, fmt.Errorf("invalid export line %q", line)
+		}
+
+		for i := 0; i < len(fields); i += 3 {
+			address, err := strconv.ParseUint(fields[i+1], 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid export value %q", fields[i+1])
+			}
+
+			if !strings.Contains(fields[i+2], "L") || address > 0xFFFF {
+				continue
+			}
+			add(ret, NesMemory, uint(address), &Label{Name: fields[i], Size: 1})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func loadWith(filename string, read func(io.Reader) (map[MemoryType]LabelMap, error)) (map[MemoryType]LabelMap, error) {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", filename, err)
+	}
+
+	ret, err := read(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return ret, nil
+}
@@ -0,0 +1,108 @@
+package labels
+
+import (
+	"testing"
+)
+
+type expectLabel struct {
+	t       MemoryType
+	address uint
+	name    string
+	size    uint
+	comment string
+}
+
+func checkLabels(t *testing.T, filename string, expect []expectLabel) {
+	t.Helper()
+
+	lbls, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for _, lm := range lbls {
+		count += len(lm)
+	}
+	if count != len(expect) {
+		t.Errorf("%s: expected %d labels, got %d", filename, len(expect), count)
+	}
+
+	for _, e := range expect {
+		lbl, ok := lbls[e.t][e.address]
+		if !ok {
+			t.Errorf("%s: missing %s $%04X", filename, e.t, e.address)
+			continue
+		}
+
+		if lbl.Name != e.name || lbl.Size != e.size || lbl.Comment != e.comment {
+			t.Errorf("%s: %s $%04X: expected %q size %d %q; got %q size %d %q", filename, e.t, e.address,
+				e.name, e.size, e.comment, lbl.Name, lbl.Size, lbl.Comment)
+		}
+	}
+}
+
+func TestLoadFceux(t *testing.T) {
+	checkLabels(t, "testdata/game.nes.ram.nl", []expectLabel{
+		{NesInternalRam, 0x0000, "frame", 1, "Frame counter"},
+		{NesInternalRam, 0x0001, "ptr", 2, ""},
+		{NesMemory, 0x2002, "PPUSTATUS", 1, ""},
+		{NesWorkRam, 0x0000, "save", 0x10, "Save data\ncontinued here"},
+	})
+
+	checkLabels(t, "testdata/game.nes.1.nl", []expectLabel{
+		{NesPrgRom, 0x4000, "Reset", 1, ""},
+		{NesPrgRom, 0x4010, "Bank1Start", 1, "first routine"},
+	})
+}
+
+func TestLoadMesen(t *testing.T) {
+	checkLabels(t, "testdata/game.mlb", []expectLabel{
+		{NesPrgRom, 0x4000, "Reset", 1, ""},
+		{NesPrgRom, 0x401D, "palette", 8, "BG colors\nsecond line"},
+		{NesInternalRam, 0x0000, "frame", 1, ""},
+		{NesInternalRam, 0x0300, "buffer", 0x100, ""},
+		{NesMemory, 0x2002, "PPUSTATUS", 1, ""},
+		{NesSaveRam, 0x0010, "saveFlag", 1, ""},
+	})
+}
+
+func TestLoadVice(t *testing.T) {
+	expect := []expectLabel{
+		{NesMemory, 0xC000, "Reset", 1, ""},
+		{NesMemory, 0xC016, "NMI", 1, ""},
+		{NesMemory, 0xC017, "Wait", 1, ""},
+		{NesMemory, 0x0000, "frame", 1, ""},
+		{NesMemory, 0xC01D, "palette", 1, ""},
+	}
+
+	checkLabels(t, "testdata/game.lbl", expect)
+
+	// Unknown extension
+	checkLabels(t, "testdata/labels.txt", expect)
+}
+
+func TestLoadLd65Map(t *testing.T) {
+	checkLabels(t, "testdata/game.map", []expectLabel{
+		{NesMemory, 0xC000, "Reset", 1, ""},
+		{NesMemory, 0xC016, "NMI", 1, ""},
+		{NesMemory, 0xC017, "Wait", 1, ""},
+	})
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := map[string]Format{
+		"\n\nal 00C000 .Reset\n":              FormatVice,
+		"P:1234:label\n":                      FormatMesen,
+		"$C000#Reset#\n":                      FormatFceux,
+		`{"Labels": []}`:                      FormatMesen2,
+		"Exports list by name:\n----------\n": FormatLd65Map,
+		"Reset = $C000\n":                     "",
+	}
+
+	for data, expect := range tests {
+		if f := DetectFormat([]byte(data)); f != expect {
+			t.Errorf("%q: expected %q, got %q", data, expect, f)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package labels
+
+import (
+	"sort"
+)
+
+// Highest possible address.  Used for the end of labels that would wrap.
+const maxAddress = ^uint(0)
+
+// Index is a read only Provider that's fast with lots of labels.  Address
+// lookups are a binary search and name lookups are a map.
+//
+// Sized labels can overlap.  An address inside more than one of them gets
+// the one that starts closest to it, the same as a label inside a .proc.
+type Index struct {
+	types map[MemoryType]*typeIndex
+	names map[string]nameRef
+}
+
+type typeIndex struct {
+	labels LabelMap
+
+	// Sorted, non-overlapping ranges that each resolve to a single label.
+	// Gaps between ranges don't have a label.
+	ranges []labelRange
+}
+
+type labelRange struct {
+	start, end uint // end is exclusive
+	base       uint // address of the label
+	label      *Label
+}
+
+type nameRef struct {
+	t       MemoryType
+	address uint
+}
+
+// NewIndex builds an Index from the given labels.  The maps are copied, so
+// changing them afterwards doesn't change the index.
+func NewIndex(lbls map[MemoryType]LabelMap) *Index {
+	idx := &Index{
+		types: map[MemoryType]*typeIndex{},
+		names: map[string]nameRef{},
+	}
+
+	types := []string{}
+	for t := range lbls {
+		types = append(types, string(t))
+	}
+	sort.Strings(types)
+
+	for _, name := range types {
+		t := MemoryType(name)
+		ti := newTypeIndex(lbls[t])
+		idx.types[t] = ti
+
+		// Same order as Set.Find(): memory types in order, then the
+		// lowest address.
+		for _, r := range ti.ranges {
+			if r.start != r.base {
+				continue
+			}
+			if _, ok := idx.names[r.label.Name]; !ok {
+				idx.names[r.label.Name] = nameRef{t, r.base}
+			}
+		}
+	}
+
+	return idx
+}
+
+func newTypeIndex(lm LabelMap) *typeIndex {
+	ti := &typeIndex{labels: LabelMap{}}
+
+	type entry struct {
+		address, end uint
+		label        *Label
+	}
+
+	entries := []entry{}
+	for addr, lbl := range lm {
+		ti.labels[addr] = lbl
+
+		size := lbl.Size
+		if size == 0 {
+			size = 1
+		}
+
+		end := addr + size
+		if end < addr {
+			end = maxAddress
+		}
+		entries = append(entries, entry{addr, end, lbl})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].address < entries[j].address
+	})
+
+	// Sweep through the labels keeping a stack of the ones that are still
+	// open.  The top of the stack started last, so it's the one to use
+	// until it ends.  Anything under it that has already ended is dropped
+	// when it comes back to the top.
+	stack := []entry{}
+	pos := uint(0)
+
+	closeUntil := func(limit uint) {
+		for len(stack) > 0 && pos < limit {
+			top := stack[len(stack)-1]
+			if top.end <= pos {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+
+			end := top.end
+			if end > limit {
+				end = limit
+			}
+
+			ti.ranges = append(ti.ranges, labelRange{pos, end, top.address, top.label})
+			pos = end
+		}
+	}
+
+	for _, e := range entries {
+		closeUntil(e.address)
+		stack = append(stack, e)
+		pos = e.address
+	}
+	closeUntil(maxAddress)
+
+	return ti
+}
+
+// Label implements Provider.
+func (idx *Index) Label(t MemoryType, address uint) (*Label, uint) {
+	ti, ok := idx.types[t]
+	if !ok {
+		return nil, 0
+	}
+
+	i := sort.Search(len(ti.ranges), func(i int) bool {
+		return ti.ranges[i].end > address
+	})
+
+	if i == len(ti.ranges) || ti.ranges[i].start > address {
+		return nil, 0
+	}
+
+	r := ti.ranges[i]
+	return r.label, address - r.base
+}
+
+// Find implements Provider.
+func (idx *Index) Find(name string) (MemoryType, uint, bool) {
+	ref, ok := idx.names[name]
+	if !ok {
+		return "", 0, false
+	}
+	return ref.t, ref.address, true
+}
+
+// Labels implements Provider.  Don't modify the returned map.
+func (idx *Index) Labels(t MemoryType) LabelMap {
+	if ti, ok := idx.types[t]; ok {
+		return ti.labels
+	}
+	return nil
+}
+
+// Len returns the number of labels in the index.
+func (idx *Index) Len() int {
+	count := 0
+	for _, ti := range idx.types {
+		count += len(ti.labels)
+	}
+	return count
+}
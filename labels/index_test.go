@@ -0,0 +1,151 @@
+package labels
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	idx := NewIndex(map[MemoryType]LabelMap{
+		NesInternalRam: {
+			0x0000: {Name: "frame", Size: 1},
+			0x0300: {Name: "buffer", Size: 0x100},
+			0x0310: {Name: "inner", Size: 4},
+			0x0320: {Name: "unsized"},
+		},
+		NesPrgRom: {
+			0x0000: {Name: "Reset"},
+			0x0010: {Name: "buffer"},
+		},
+	})
+
+	tests := []struct {
+		address uint
+		name    string
+	}{
+		{0x0000, "frame"},
+		{0x0001, ""},
+		{0x02FF, ""},
+		{0x0300, "buffer"},
+		{0x030F, "buffer+15"},
+		{0x0310, "inner"},
+		{0x0313, "inner+3"},
+		{0x0314, "buffer+20"},
+		{0x0320, "unsized"},
+		{0x0321, "buffer+33"},
+		{0x03FF, "buffer+255"},
+		{0x0400, ""},
+	}
+
+	for _, tc := range tests {
+		if name := Name(idx.Label(NesInternalRam, tc.address)); name != tc.name {
+			t.Errorf("$%04X: expected %q, got %q", tc.address, tc.name, name)
+		}
+	}
+
+	if lbl, _ := idx.Label(NesWorkRam, 0); lbl != nil {
+		t.Errorf("expected no label in NesWorkRam, got %q", lbl.Name)
+	}
+
+	if typ, addr, ok := idx.Find("buffer"); !ok || typ != NesInternalRam || addr != 0x0300 {
+		t.Errorf("expected buffer at NesInternalRam $0300, got %v %s $%04X", ok, typ, addr)
+	}
+
+	if _, _, ok := idx.Find("missing"); ok {
+		t.Errorf("found a label that doesn't exist")
+	}
+
+	if idx.Len() != 6 {
+		t.Errorf("expected 6 labels, got %d", idx.Len())
+	}
+}
+
+// The index should give the same answers as a Set when labels don't
+// overlap.
+func TestIndexMatchesSet(t *testing.T) {
+	lbls := randomLabels(rand.New(rand.NewSource(1)), 2000)
+	set := Set(lbls)
+	idx := NewIndex(lbls)
+
+	for addr := uint(0); addr < 0x10000; addr += 3 {
+		lbl, offset := set.Label(NesMemory, addr)
+		ilbl, ioffset := idx.Label(NesMemory, addr)
+		if lbl != ilbl || offset != ioffset {
+			t.Fatalf("$%04X: expected %q, got %q", addr, Name(lbl, offset), Name(ilbl, ioffset))
+		}
+	}
+
+	for addr, lbl := range lbls[NesMemory] {
+		if _, found, ok := idx.Find(lbl.Name); !ok || found != addr {
+			t.Fatalf("%s: expected $%04X, got $%04X", lbl.Name, addr, found)
+		}
+	}
+}
+
+// randomLabels makes count non-overlapping labels in a 64k address space.
+// About a quarter of them are sized.
+func randomLabels(r *rand.Rand, count int) map[MemoryType]LabelMap {
+	lm := LabelMap{}
+	step := uint(0x10000 / count)
+	for i := 0; i < count; i++ {
+		addr := uint(i)*step + uint(r.Intn(int(step)/2))
+		lbl := &Label{Name: fmt.Sprintf("label_%d", i), Size: 1}
+		if r.Intn(4) == 0 {
+			lbl.Size = uint(r.Intn(int(step)/2)) + 1
+		}
+		lm[addr] = lbl
+	}
+	return map[MemoryType]LabelMap{NesMemory: lm}
+}
+
+// A ca65 project of a decent size has a few thousand symbols.
+const benchLabels = 5000
+
+func BenchmarkIndexLabel(b *testing.B) {
+	idx := NewIndex(randomLabels(rand.New(rand.NewSource(1)), benchLabels))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.Label(NesMemory, uint(i&0xFFFF))
+	}
+}
+
+func BenchmarkSetLabel(b *testing.B) {
+	set := Set(randomLabels(rand.New(rand.NewSource(1)), benchLabels))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set.Label(NesMemory, uint(i&0xFFFF))
+	}
+}
+
+func BenchmarkLabelMapGetLabel(b *testing.B) {
+	lm := randomLabels(rand.New(rand.NewSource(1)), benchLabels)[NesMemory]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lm.GetLabel(uint(i & 0xFFFF))
+	}
+}
+
+func BenchmarkIndexFind(b *testing.B) {
+	idx := NewIndex(randomLabels(rand.New(rand.NewSource(1)), benchLabels))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.Find(fmt.Sprintf("label_%d", i%benchLabels))
+	}
+}
+
+func BenchmarkLabelMapFindLabel(b *testing.B) {
+	lm := randomLabels(rand.New(rand.NewSource(1)), benchLabels)[NesMemory]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lm.FindLabel(fmt.Sprintf("label_%d", i%benchLabels))
+	}
+}
+
+func BenchmarkNewIndex(b *testing.B) {
+	lbls := randomLabels(rand.New(rand.NewSource(1)), benchLabels)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewIndex(lbls)
+	}
+}
@@ -0,0 +1,114 @@
+package labels
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Provider is a source of labels.  Memory managers use one to name
+// addresses, and anything that knows about symbols (label files, ca65 debug
+// info, etc) can be one.
+//
+// Addresses are relative to the memory type, the same as Mesen's.  CPU
+// addresses use NesMemory.
+type Provider interface {
+	// Label returns the label at the given address, or a sized label that
+	// covers it, along with how far into the label the address is.  Returns
+	// nil if there isn't one.
+	Label(t MemoryType, address uint) (*Label, uint)
+
+	// Find looks up a label by name.
+	Find(name string) (MemoryType, uint, bool)
+
+	// Labels returns all the labels for a memory type.
+	Labels(t MemoryType) LabelMap
+}
+
+// Name formats a label returned by Provider.Label(), eg "buffer+3".
+func Name(lbl *Label, offset uint) string {
+	if lbl == nil {
+		return ""
+	}
+
+	if offset > 0 {
+		return fmt.Sprintf("%s+%d", lbl.Name, offset)
+	}
+	return lbl.Name
+}
+
+// Set is a Provider for labels loaded from files.  Sized label lookups are
+// a linear search, so use an Index instead for anything large.
+type Set map[MemoryType]LabelMap
+
+func (s Set) Label(t MemoryType, address uint) (*Label, uint) {
+	lm, ok := s[t]
+	if !ok {
+		return nil, 0
+	}
+
+	if lbl, ok := lm[address]; ok {
+		return lbl, 0
+	}
+
+	for addr, lbl := range lm {
+		if addr < address && addr+lbl.Size > address {
+			return lbl, address - addr
+		}
+	}
+	return nil, 0
+}
+
+func (s Set) Find(name string) (MemoryType, uint, bool) {
+	types := []string{}
+	for t := range s {
+		types = append(types, string(t))
+	}
+	sort.Strings(types)
+
+	for _, t := range types {
+		if addr, ok := s[MemoryType(t)].FindLabel(name); ok {
+			return MemoryType(t), addr, true
+		}
+	}
+	return "", 0, false
+}
+
+func (s Set) Labels(t MemoryType) LabelMap {
+	return s[t]
+}
+
+// Add adds a label, replacing any that's already at the address.
+func (s Set) Add(t MemoryType, address uint, lbl *Label) {
+	add(s, t, address, lbl)
+}
+
+// Providers combines several providers.  Earlier ones take priority.
+type Providers []Provider
+
+func (p Providers) Label(t MemoryType, address uint) (*Label, uint) {
+	for _, provider := range p {
+		if lbl, offset := provider.Label(t, address); lbl != nil {
+			return lbl, offset
+		}
+	}
+	return nil, 0
+}
+
+func (p Providers) Find(name string) (MemoryType, uint, bool) {
+	for _, provider := range p {
+		if t, addr, ok := provider.Find(name); ok {
+			return t, addr, true
+		}
+	}
+	return "", 0, false
+}
+
+func (p Providers) Labels(t MemoryType) LabelMap {
+	ret := LabelMap{}
+	for i := len(p) - 1; i >= 0; i-- {
+		for addr, lbl := range p[i].Labels(t) {
+			ret[addr] = lbl
+		}
+	}
+	return ret
+}
@@ -0,0 +1,2 @@
+$C000#Reset#
+$8010#Bank1Start#first routine
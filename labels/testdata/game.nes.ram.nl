@@ -0,0 +1,5 @@
+$0000#frame#Frame counter
+$0001/2#ptr#
+$2002#PPUSTATUS#
+$6000/10#save#Save data
+continued here
@@ -52,3 +52,74 @@ var mmc1_banks = BankConfiguration{
 		},
 	},
 }
+
+// PrgWindow is a range of CPU address space that PRG ROM banks are mapped
+// into.  Bank numbers are in units of the window's size.
+type PrgWindow struct {
+	Start uint16
+	Size  uint
+
+	// Bank that is always mapped here, or -1 if it can be switched.
+	FixedBank int
+}
+
+func (w PrgWindow) Contains(address uint16) bool {
+	return uint(address) >= uint(w.Start) && uint(address) < uint(w.Start)+w.Size
+}
+
+// PrgLayouter is implemented by mappers that can describe how PRG ROM is
+// mapped in their current mode.
+type PrgLayouter interface {
+	PrgLayout() []PrgWindow
+}
+
+// PrgLayout returns the PRG window layout of the given mapper.  Mappers that
+// don't implement PrgLayouter get one window per PrgBankSize, each fixed to
+// the bank that is mapped there right now.
+func PrgLayout(m Mapper) []PrgWindow {
+	if l, ok := m.(PrgLayouter); ok {
+		return l.PrgLayout()
+	}
+
+	info := m.Info()
+	if info.PrgBankSize == 0 {
+		return nil
+	}
+
+	windows := []PrgWindow{}
+	for start := uint(info.PrgStartAddress); start < 0x10000; start += info.PrgBankSize {
+		windows = append(windows, PrgWindow{
+			Start:     uint16(start),
+			Size:      info.PrgBankSize,
+			FixedBank: int(m.Offset(uint16(start)) / uint32(info.PrgBankSize)),
+		})
+	}
+	return windows
+}
+
+// PrgAddress returns the CPU address that an offset in PRG ROM is used at
+// with the mapper's current layout.  Banks that aren't fixed anywhere go in
+// the first switchable window.  ok is false for offsets past the end of PRG
+// ROM or if there's nowhere to put them.
+func PrgAddress(m Mapper, offset uint) (uint16, bool) {
+	if offset >= m.Info().PrgSize {
+		return 0, false
+	}
+
+	// Later windows win, so a mirrored NROM-128 bank is at $C000 with its
+	// vectors.
+	layout := PrgLayout(m)
+	for i := len(layout) - 1; i >= 0; i-- {
+		w := layout[i]
+		if w.FixedBank >= 0 && offset/w.Size == uint(w.FixedBank) {
+			return w.Start + uint16(offset%w.Size), true
+		}
+	}
+
+	for _, w := range layout {
+		if w.FixedBank < 0 {
+			return w.Start + uint16(offset%w.Size), true
+		}
+	}
+	return 0, false
+}
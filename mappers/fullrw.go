@@ -28,6 +28,13 @@ func (rw *FullRW) SetState(interface{}) error {
 	return fmt.Errorf("\"Mapper\" FullRW does not support GetState()")
 }
 
+// Everything is writable, so nothing is shared.
+func (rw *FullRW) Clone() Mapper {
+	rom := make([]byte, len(rw.rom))
+	copy(rom, rw.rom)
+	return &FullRW{rom: rom}
+}
+
 func (rw *FullRW) Info() Info {
 	return Info{
 		PrgSize: 0,
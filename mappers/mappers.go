@@ -43,6 +43,10 @@ type Mapper interface {
 	// SetState clobbers all current mapper settings with the provided state.
 	SetState(data interface{}) error
 
+	// Clone returns a copy with its own RAM and registers.  ROM data is
+	// shared.
+	Clone() Mapper
+
 	Info() Info
 
 	// Debugging/Info
@@ -102,7 +106,7 @@ func LoadFromBytes(raw []byte) (Mapper, error) {
 	// TODO: NES2 submapper IDs
 	init, exists := availableMappers[int(header.Mapper)]
 	if !exists {
-		return nil, fmt.Errorf("Mapper with ID %d not implemented")
+		return nil, fmt.Errorf("Mapper with ID %d not implemented", header.Mapper)
 	}
 
 	// Assume all mappers have PRGRAM until parsing this info from a
@@ -69,7 +69,7 @@ func (m *MMC1) GetState() interface{} {
 }
 
 func (m *MMC1) SetState(data interface{}) error {
-	state, ok := data.(MMC1)
+	state, ok := data.(*MMC1)
 	if !ok {
 		return fmt.Errorf("Invalid state given")
 	}
@@ -93,6 +93,11 @@ func (m *MMC1) SetState(data interface{}) error {
 	return nil
 }
 
+func (m *MMC1) Clone() Mapper {
+	clone := *m
+	return &clone
+}
+
 func NewMMC1(data []byte, hasRam bool) (Mapper, error) {
 	// FIXME: data doesn't account for CHR
 	mmc1 := &MMC1{
@@ -178,8 +183,8 @@ func (m *MMC1) Offset(address uint16) uint32 {
 	case 0, 1:
 		romAddr = ((uint32(m.PrgBank) & 0xFE) * 0x4000) + romAddr
 	case 2:
-		if romAddr > 0x4000 {
-			romAddr = (uint32(m.PrgBank) * 0x4000) + romAddr
+		if romAddr >= 0x4000 {
+			romAddr = (uint32(m.PrgBank) * 0x4000) + (romAddr - 0x4000)
 		}
 	case 3:
 		if romAddr < 0x4000 {
@@ -190,7 +195,7 @@ func (m *MMC1) Offset(address uint16) uint32 {
 		}
 		//fmt.Printf("[3] %04X -> %08X\n", address, romAddr)
 	default:
-		panic(fmt.Sprintf("Invalid PrgBankMode: %02X"))
+		panic(fmt.Sprintf("Invalid PrgBankMode: %02X", m.PrgBankMode))
 	}
 
 	if int(romAddr) > len(m.rom) {
@@ -201,6 +206,25 @@ func (m *MMC1) Offset(address uint16) uint32 {
 	return romAddr
 }
 
+func (m *MMC1) PrgLayout() []PrgWindow {
+	lastBank := (len(m.rom) / 0x4000) - 1
+
+	switch m.PrgBankMode {
+	case 0, 1:
+		return []PrgWindow{{Start: 0x8000, Size: 0x8000, FixedBank: -1}}
+	case 2:
+		return []PrgWindow{
+			{Start: 0x8000, Size: 0x4000, FixedBank: 0},
+			{Start: 0xC000, Size: 0x4000, FixedBank: -1},
+		}
+	}
+
+	return []PrgWindow{
+		{Start: 0x8000, Size: 0x4000, FixedBank: -1},
+		{Start: 0xC000, Size: 0x4000, FixedBank: lastBank},
+	}
+}
+
 func (m *MMC1) ReadByte(address uint16) uint8 {
 	// RAM
 	if address < 0x2000 {
@@ -35,7 +35,7 @@ func (nr *NROM) GetState() interface{} {
 }
 
 func (nr *NROM) SetState(data interface{}) error {
-	state, ok := data.(NROM)
+	state, ok := data.(*NROM)
 	if !ok {
 		return fmt.Errorf("Invalid state given")
 	}
@@ -129,6 +129,22 @@ func (nr *NROM) Offset(address uint16) uint32 {
 	return uint32(address) - 0x8000
 }
 
+func (nr *NROM) Clone() Mapper {
+	clone := *nr
+	return &clone
+}
+
+func (nr *NROM) PrgLayout() []PrgWindow {
+	if nr.isHalf {
+		// Mirrored at $8000 and $C000
+		return []PrgWindow{
+			{Start: 0x8000, Size: 0x4000, FixedBank: 0},
+			{Start: 0xC000, Size: 0x4000, FixedBank: 0},
+		}
+	}
+	return []PrgWindow{{Start: 0x8000, Size: 0x8000, FixedBank: 0}}
+}
+
 func (nr *NROM) MemoryType(address uint16) string {
 	if address >= 0x8000 {
 		return "NesPrgRom"
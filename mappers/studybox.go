@@ -57,17 +57,29 @@ func NewStudyBox(raw []byte, hasRam bool) (Mapper, error) {
 		//mainRam: [0x0800]byte{},
 		//ramA: [0x8000]byte{},
 		//ramB: [0x8000]byte{},
-		writeRegisters: map[uint16]sbWriteRegisterFunction{},
-		readRegisters: map[uint16]sbReadRegisterFunction{},
 	}
 
+	sb.initRegisters()
+	return sb, nil
+}
+
+// The register functions are bound to sb, so clones need their own.
+func (sb *StudyBox) initRegisters() {
+	sb.writeRegisters = map[uint16]sbWriteRegisterFunction{}
+	sb.readRegisters = map[uint16]sbReadRegisterFunction{}
+
 	sb.writeRegisters[0x4200] = sb.write4200
 	sb.writeRegisters[0x4201] = sb.write4201
 
 	sb.readRegisters[0x4200] = sb.read4200
 	sb.readRegisters[0x4201] = sb.read4201
+}
 
-	return sb, nil
+// The tape is read-only and shared with the clone.
+func (sb *StudyBox) Clone() Mapper {
+	clone := *sb
+	clone.initRegisters()
+	return &clone
 }
 
 // Read a byte from tape
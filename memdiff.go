@@ -0,0 +1,202 @@
+package emu
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/zorchenhimer/emu-6502/labels"
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+// MemoryCapture is a copy of some or all of memory.
+type MemoryCapture struct {
+	Ranges []AddressRange
+	data   [][]byte
+}
+
+// CaptureMemory copies the given ranges, or all of RAM if there aren't
+// any.  Memory is read directly, without breakpoints.
+func (c *Core) CaptureMemory(ranges ...AddressRange) *MemoryCapture {
+	if len(ranges) == 0 {
+		ranges = c.allMemory()
+	}
+
+	mc := &MemoryCapture{Ranges: ranges}
+	for _, r := range ranges {
+		data := []byte{}
+		for addr := int(r.Start); addr <= int(r.End); addr++ {
+			data = append(data, c.memory.ReadByte(uint16(addr)))
+		}
+		mc.data = append(mc.data, data)
+	}
+	return mc
+}
+
+// allMemory is all of RAM, without the mirrors of NES internal RAM.  Reading
+// registers can have side effects, and reading WRAM that isn't there can
+// panic, so only addresses that mmu.IsRam() are captured.  Managers without
+// memory types get all 64k.
+func (c *Core) allMemory() []AddressRange {
+	typer, ok := c.memory.(mmu.MemoryTyper)
+	if !ok {
+		return []AddressRange{{0x0000, 0xFFFF}}
+	}
+
+	ranges := []AddressRange{}
+	for addr := 0; addr <= 0xFFFF; addr++ {
+		// RAM mirrors, PPU and APU registers
+		if addr >= 0x0800 && addr < 0x4020 {
+			continue
+		}
+		if !mmu.IsRam(typer.MemoryType(uint16(addr))) {
+			continue
+		}
+
+		last := len(ranges) - 1
+		if last >= 0 && int(ranges[last].End)+1 == addr {
+			ranges[last].End = uint16(addr)
+		} else {
+			ranges = append(ranges, AddressRange{uint16(addr), uint16(addr)})
+		}
+	}
+	return ranges
+}
+
+// Value returns the captured byte at address.  ok is false if the address
+// wasn't captured.
+func (mc *MemoryCapture) Value(address uint16) (value uint8, ok bool) {
+	for i, r := range mc.Ranges {
+		if r.Contains(address) {
+			return mc.data[i][address-r.Start], true
+		}
+	}
+	return 0, false
+}
+
+// MemoryChange is a single byte that changed between two captures.
+type MemoryChange struct {
+	Address uint16            `json:"address"`
+	Old     uint8             `json:"old"`
+	New     uint8             `json:"new"`
+	Label   string            `json:"label,omitempty"`
+	Type    labels.MemoryType `json:"type,omitempty"`
+}
+
+// MemoryDiff is every byte that changed, in address order.
+type MemoryDiff struct {
+	Changes []MemoryChange `json:"changes"`
+}
+
+// DiffMemory compares the ranges in before against after.  Addresses that
+// aren't in both are skipped.  Labels and memory types are for the current
+// bank configuration.
+func (c *Core) DiffMemory(before, after *MemoryCapture) *MemoryDiff {
+	typer, _ := c.memory.(mmu.MemoryTyper)
+
+	diff := &MemoryDiff{Changes: []MemoryChange{}}
+	seen := make([]bool, 0x10000)
+	for i, r := range before.Ranges {
+		for addr := int(r.Start); addr <= int(r.End); addr++ {
+			address := uint16(addr)
+			if seen[address] {
+				continue
+			}
+			seen[address] = true
+
+			old := before.data[i][addr-int(r.Start)]
+			val, ok := after.Value(address)
+			if !ok || val == old {
+				continue
+			}
+
+			change := MemoryChange{Address: address, Old: old, New: val}
+			if lbl := c.memory.GetLabel(address); !strings.HasPrefix(lbl, "$") {
+				change.Label = lbl
+			}
+			if typer != nil {
+				change.Type = typer.MemoryType(address)
+			}
+			diff.Changes = append(diff.Changes, change)
+		}
+	}
+
+	// Ranges can be out of order.
+	sort.Slice(diff.Changes, func(i, j int) bool { return diff.Changes[i].Address < diff.Changes[j].Address })
+	return diff
+}
+
+// RunRoutineDiff runs a routine and returns what it changed in the given
+// ranges (or all of RAM).  The diff is returned even if the routine
+// fails.
+func (c *Core) RunRoutineDiff(address uint16, ranges ...AddressRange) (*MemoryDiff, error) {
+	before := c.CaptureMemory(ranges...)
+	err := c.RunRoutine(address)
+	return c.DiffMemory(before, c.CaptureMemory(before.Ranges...)), err
+}
+
+// RunDiff is RunRoutineDiff for Run.
+func (c *Core) RunDiff(ranges ...AddressRange) (*MemoryDiff, error) {
+	before := c.CaptureMemory(ranges...)
+	err := c.Run()
+	return c.DiffMemory(before, c.CaptureMemory(before.Ranges...)), err
+}
+
+// WriteHex writes a line of old values and a line of new values for each
+// 16 byte row with changes.  Unchanged bytes are "..".  Labels for the
+// changed bytes follow the old values.
+//
+//	$0080 - 00 .. 03 .. .. .. .. .. .. .. .. .. .. .. .. ..  BallDirection BallX
+//	      + 01 .. 07 .. .. .. .. .. .. .. .. .. .. .. .. ..
+func (d *MemoryDiff) WriteHex(w io.Writer) error {
+	if len(d.Changes) == 0 {
+		_, err := fmt.Fprintln(w, "no changes")
+		return err
+	}
+
+	for i := 0; i < len(d.Changes); {
+		row := d.Changes[i].Address &^ 0x000F
+		old := make([]string, 16)
+		val := make([]string, 16)
+		for j := range old {
+			old[j], val[j] = "..", ".."
+		}
+
+		names := []string{}
+		for ; i < len(d.Changes) && d.Changes[i].Address&^0x000F == row; i++ {
+			ch := d.Changes[i]
+			col := ch.Address & 0x000F
+			old[col] = fmt.Sprintf("%02X", ch.Old)
+			val[col] = fmt.Sprintf("%02X", ch.New)
+
+			if ch.Label != "" && (len(names) == 0 || names[len(names)-1] != ch.Label) {
+				names = append(names, ch.Label)
+			}
+		}
+
+		line := fmt.Sprintf("$%04X - %s", row, strings.Join(old, " "))
+		if len(names) > 0 {
+			line += "  " + strings.Join(names, " ")
+		}
+
+		_, err := fmt.Fprintf(w, "%s\n      + %s\n", line, strings.Join(val, " "))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *MemoryDiff) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+func (d *MemoryDiff) String() string {
+	sb := &strings.Builder{}
+	d.WriteHex(sb)
+	return sb.String()
+}
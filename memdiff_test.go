@@ -0,0 +1,121 @@
+package emu
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/zorchenhimer/emu-6502/labels"
+	"github.com/zorchenhimer/emu-6502/mappers"
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+func TestMemoryDiff(t *testing.T) {
+	rom := make([]byte, 0x8000)
+	copy(rom, []byte{
+		0xA9, 0x05, // LDA #$05
+		0x85, 0x81, // STA BallX
+		0xE6, 0x83, // INC BallY
+		0x8D, 0x0F, 0x60, // STA $600F
+		0x8D, 0x10, 0x60, // STA $6010
+		0x60, // RTS
+	})
+
+	mapper, err := mappers.NewNROM(rom, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lbls := labels.Set{}
+	lbls.Add(labels.NesInternalRam, 0x81, &labels.Label{Name: "BallX"})
+	lbls.Add(labels.NesInternalRam, 0x83, &labels.Label{Name: "BallY"})
+	nes := mmu.NewNES(mapper)
+	nes.SetLabels(lbls)
+
+	c := NewCore(nes)
+	c.SP = 0xFF
+	c.WriteByte(0x0083, 0x41)
+	c.WriteByte(0x6010, 0x05)
+
+	diff, err := c.RunRoutineDiff(0x8000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []MemoryChange{
+		{0x0081, 0x00, 0x05, "BallX", labels.NesInternalRam},
+		{0x0083, 0x41, 0x42, "BallY", labels.NesInternalRam},
+		{0x600F, 0x00, 0x05, "", labels.NesWorkRam},
+	}
+	if len(diff.Changes) != len(expect) {
+		t.Fatalf("expected %d changes, got %v", len(expect), diff.Changes)
+	}
+	for i, ch := range diff.Changes {
+		if ch != expect[i] {
+			t.Errorf("change %d: expected %+v, got %+v", i, expect[i], ch)
+		}
+	}
+
+	hex := "$0080 - .. 00 .. 41 .. .. .. .. .. .. .. .. .. .. .. ..  BallX BallY\n" +
+		"      + .. 05 .. 42 .. .. .. .. .. .. .. .. .. .. .. ..\n" +
+		"$6000 - .. .. .. .. .. .. .. .. .. .. .. .. .. .. .. 00\n" +
+		"      + .. .. .. .. .. .. .. .. .. .. .. .. .. .. .. 05\n"
+	if diff.String() != hex {
+		t.Errorf("unexpected hex diff:\n%s\nexpected:\n%s", diff, hex)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := diff.WriteJSON(buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded := &MemoryDiff{}
+	if err := json.Unmarshal(buf.Bytes(), decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Changes) != 3 || decoded.Changes[0] != expect[0] {
+		t.Errorf("JSON didn't round trip: %s", buf)
+	}
+
+	// Only the chosen range
+	c.WriteByte(0x0083, 0x41)
+	diff, err = c.RunRoutineDiff(0x8000, AddressRange{0x6000, 0x7FFF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Changes) != 0 {
+		t.Errorf("expected no changes in WRAM the second time, got %v", diff.Changes)
+	}
+}
+
+func TestCaptureAllMemory(t *testing.T) {
+	wram, err := mappers.NewNROM(make([]byte, 0x8000), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noWram, err := mappers.NewMMC1(make([]byte, 0x20000), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr, err := mmu.NewFullRam(make([]byte, 0x10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		memory mmu.Manager
+		expect []AddressRange
+	}{
+		{"NROM", mmu.NewNES(wram), []AddressRange{{0x0000, 0x07FF}, {0x6000, 0x7FFF}}},
+		{"MMC1 without WRAM", mmu.NewNES(noWram), []AddressRange{{0x0000, 0x07FF}}},
+		{"FullRam", fr, []AddressRange{{0x0000, 0xFFFF}}},
+	}
+
+	for _, tc := range tests {
+		mc := NewCore(tc.memory).CaptureMemory()
+		if !reflect.DeepEqual(mc.Ranges, tc.expect) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expect, mc.Ranges)
+		}
+	}
+}
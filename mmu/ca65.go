@@ -0,0 +1,343 @@
+package mmu
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/zorchenhimer/emu-6502/labels"
+)
+
+// DasmMode selects the output of WriteDasmMode().
+type DasmMode int
+
+const (
+	// Instructions with their offsets in comments.  This is what WriteDasm()
+	// writes.  It can't be assembled.
+	DasmListing DasmMode = iota
+
+	// ca65 source that assembles to the exact same bytes when linked with the
+	// config from WriteLinkerConfig().  Every branch and jump target gets a
+	// label, and anything that wasn't disassembled is written as data.
+	DasmCa65
+)
+
+// ca65Bank is a chunk of the image that gets its own segment.
+type ca65Bank struct {
+	Segment string
+	Memory  string
+	Start   uint16 // CPU address of the first byte
+	Size    uint
+
+	// Generated label names are this followed by the address.
+	Prefix string
+
+	read  func(idx uint) byte
+	dasm  func(idx uint) *Disassembly // instruction starting at idx, or nil
+	label func(idx uint) *labels.Label
+
+	instr []*Disassembly
+	names map[uint]string
+}
+
+type ca65Image struct {
+	banks []*ca65Bank
+
+	// Bank that holds the given address when seen from code in bank from.
+	// Returns -1 if that can't be known.
+	resolve func(from int, address uint16) int
+
+	// Name of the RAM label covering address, and that label's address.
+	ram func(address uint16) (string, uint16)
+
+	// Memory area options for the linker config.
+	fill string
+}
+
+// prepare decodes each bank's instructions and names everything that gets
+// referenced.
+func (img *ca65Image) prepare() {
+	for _, bank := range img.banks {
+		bank.instr = make([]*Disassembly, bank.Size)
+		bank.names = make(map[uint]string)
+
+		for i := uint(0); i < bank.Size; {
+			d := bank.dasm(i)
+			if d == nil || !bank.reassembles(i, d) {
+				i++
+				continue
+			}
+			bank.instr[i] = d
+			i += d.Size
+		}
+
+		for i := uint(0); i < bank.Size; i++ {
+			if lbl := bank.label(i); lbl != nil && lbl.Name != "" && !bank.inside(i) {
+				bank.names[i] = lbl.Name
+			}
+		}
+	}
+
+	for b, bank := range img.banks {
+		for i := uint(0); i < bank.Size; i++ {
+			d := bank.instr[i]
+			if d != nil && d.Target {
+				img.name(b, d.Operand)
+			}
+		}
+
+		if idx, ok := bank.vectors(); ok {
+			for v := uint(0); v < 6; v += 2 {
+				img.name(b, uint16(bank.read(idx+v))|uint16(bank.read(idx+v+1))<<8)
+			}
+		}
+	}
+}
+
+// name generates a label for the target if it doesn't already have one.
+func (img *ca65Image) name(from int, target uint16) {
+	b := img.resolve(from, target)
+	if b < 0 {
+		return
+	}
+
+	bank := img.banks[b]
+	idx := uint(target - bank.Start)
+	if bank.inside(idx) {
+		return
+	}
+
+	if _, ok := bank.names[idx]; !ok {
+		bank.names[idx] = fmt.Sprintf("%s%04X", bank.Prefix, target)
+	}
+}
+
+// lookup returns the label name for address if it has one.
+func (img *ca65Image) lookup(from int, address uint16) string {
+	b := img.resolve(from, address)
+	if b < 0 {
+		return ""
+	}
+	bank := img.banks[b]
+	return bank.names[uint(address-bank.Start)]
+}
+
+// inside returns true if idx is in the middle of an instruction.
+func (bank *ca65Bank) inside(idx uint) bool {
+	for i := uint(1); i < 3 && i <= idx; i++ {
+		if d := bank.instr[idx-i]; d != nil {
+			return i < d.Size
+		}
+	}
+	return false
+}
+
+// reassembles checks that the instruction still matches the bytes in the
+// image and can be written as source.
+func (bank *ca65Bank) reassembles(idx uint, d *Disassembly) bool {
+	if d.Name == "" || d.Size == 0 || idx+d.Size > bank.Size || bank.read(idx) != d.OpCode {
+		return false
+	}
+
+	var operand uint16
+	switch d.Size {
+	case 2:
+		operand = uint16(bank.read(idx + 1))
+	case 3:
+		operand = uint16(bank.read(idx+1)) | uint16(bank.read(idx+2))<<8
+	}
+
+	if d.Target && d.Size == 2 {
+		// Branches store the destination, not the offset.
+		return uint16(int(bank.Start)+int(idx)+2+int(int8(operand))) == d.Operand
+	}
+	return operand == d.Operand
+}
+
+// vectors returns the index of the NMI, RESET and IRQ vectors if they are in
+// this bank and weren't disassembled as code.
+func (bank *ca65Bank) vectors() (uint, bool) {
+	if uint(bank.Start)+bank.Size != 0x10000 || bank.Size < 6 {
+		return 0, false
+	}
+
+	idx := bank.Size - 6
+	for i := idx; i < bank.Size; i++ {
+		if bank.instr[i] != nil || bank.inside(i) {
+			return 0, false
+		}
+		if _, ok := bank.names[i]; ok && i != idx {
+			return 0, false
+		}
+	}
+	return idx, true
+}
+
+func (img *ca65Image) operand(from int, d *Disassembly, equates map[string]uint16) string {
+	if !strings.Contains(d.Format, "%s") {
+		return ""
+	}
+
+	if strings.HasPrefix(d.Format, "#") {
+		return fmt.Sprintf("$%02X", d.Operand)
+	}
+
+	if d.Target {
+		if name := img.lookup(from, d.Operand); name != "" {
+			return name
+		}
+		return fmt.Sprintf("$%04X", d.Operand)
+	}
+
+	value := ""
+	if d.Size == 3 {
+		value = img.lookup(from, d.Operand)
+	}
+
+	if value == "" && img.ram != nil {
+		if name, base := img.ram(d.Operand); name != "" {
+			equates[name] = base
+			value = name
+			if d.Operand > base {
+				value = fmt.Sprintf("%s+%d", name, d.Operand-base)
+			}
+		}
+	}
+
+	if value == "" {
+		value = fmt.Sprintf("$%02X", d.Operand)
+		if d.Size == 3 {
+			value = fmt.Sprintf("$%04X", d.Operand)
+		}
+	}
+
+	// Keep absolute addressing for operands that would fit in zero page.
+	if d.Size == 3 && d.Operand < 0x100 && d.Format != "(%s)" {
+		value = "a:" + value
+	}
+	return value
+}
+
+func (img *ca65Image) writeSource(w io.Writer) error {
+	img.prepare()
+
+	body := &strings.Builder{}
+	equates := map[string]uint16{}
+
+	for b, bank := range img.banks {
+		fmt.Fprintf(body, "\n.segment \"%s\"\n", bank.Segment)
+
+		vec, hasVectors := bank.vectors()
+		data := []string{}
+		flush := func() {
+			if len(data) > 0 {
+				fmt.Fprintf(body, "    .byte %s\n", strings.Join(data, ", "))
+				data = []string{}
+			}
+		}
+
+		for i := uint(0); i < bank.Size; {
+			lbl := bank.label(i)
+			name, named := bank.names[i]
+			d := bank.instr[i]
+
+			if named || d != nil || (lbl != nil && lbl.Comment != "") || (hasVectors && i == vec) || len(data) == 16 {
+				flush()
+			}
+
+			if lbl != nil && lbl.Comment != "" {
+				for _, line := range strings.Split(lbl.Comment, "\n") {
+					fmt.Fprintf(body, "; %s\n", strings.TrimRight(line, "\r"))
+				}
+			}
+
+			if named {
+				fmt.Fprintf(body, "%s:\n", name)
+			}
+
+			if hasVectors && i == vec {
+				words := []string{}
+				for v := uint(0); v < 6; v += 2 {
+					addr := uint16(bank.read(i+v)) | uint16(bank.read(i+v+1))<<8
+					if name := img.lookup(b, addr); name != "" {
+						words = append(words, name)
+					} else {
+						words = append(words, fmt.Sprintf("$%04X", addr))
+					}
+				}
+				fmt.Fprintf(body, "    .word %s\n", strings.Join(words, ", "))
+				i += 6
+				continue
+			}
+
+			if d == nil {
+				data = append(data, fmt.Sprintf("$%02X", bank.read(i)))
+				i++
+				continue
+			}
+
+			src := d.Name
+			if !strings.Contains(d.Format, "%s") {
+				src = strings.TrimSpace(d.Name + " " + d.Format)
+			} else {
+				src += " " + fmt.Sprintf(d.Format, img.operand(b, d, equates))
+			}
+			fmt.Fprintf(body, "    %-30s ; $%04X\n", src, uint(bank.Start)+i)
+			i += d.Size
+		}
+		flush()
+	}
+
+	_, err := fmt.Fprintln(w, ".setcpu \"6502\"")
+	if err != nil {
+		return err
+	}
+
+	if len(equates) > 0 {
+		names := []string{}
+		for name := range equates {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if equates[names[i]] == equates[names[j]] {
+				return names[i] < names[j]
+			}
+			return equates[names[i]] < equates[names[j]]
+		})
+
+		fmt.Fprintln(w)
+		for _, name := range names {
+			format := "%s = $%04X\n"
+			if equates[name] < 0x100 {
+				format = "%s = $%02X\n"
+			}
+			_, err = fmt.Fprintf(w, format, name, equates[name])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = io.WriteString(w, body.String())
+	return err
+}
+
+// writeConfig writes an ld65 config that places each bank's segment in its
+// own memory area, in order, in the output file.
+func (img *ca65Image) writeConfig(w io.Writer) error {
+	cfg := &strings.Builder{}
+	fmt.Fprintln(cfg, "MEMORY {")
+	for _, bank := range img.banks {
+		fmt.Fprintf(cfg, "    %s: start = $%04X, size = $%04X, file = %%O, fill = yes%s;\n",
+			bank.Memory, bank.Start, bank.Size, img.fill)
+	}
+	fmt.Fprintln(cfg, "}\n\nSEGMENTS {")
+	for _, bank := range img.banks {
+		fmt.Fprintf(cfg, "    %s: load = %s, type = ro;\n", bank.Segment, bank.Memory)
+	}
+	fmt.Fprintln(cfg, "}")
+
+	_, err := io.WriteString(w, cfg.String())
+	return err
+}
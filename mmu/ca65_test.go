@@ -0,0 +1,127 @@
+package mmu_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	emu "github.com/zorchenhimer/emu-6502"
+	"github.com/zorchenhimer/emu-6502/mappers"
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+// assemble runs ca65 and ld65 on the given source and config and returns the
+// output binary.  The test is skipped if either isn't installed.
+func assemble(t *testing.T, src, cfg []byte) []byte {
+	t.Helper()
+	for _, tool := range []string{"ca65", "ld65"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "ca65")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string][]byte{"out.s": src, "out.cfg": cfg}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"ca65", "-o", "out.o", "out.s"},
+		{"ld65", "-C", "out.cfg", "-o", "out.bin", "out.o"},
+	} {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%s failed: %v\n%s", args[0], err, out)
+		}
+	}
+
+	bin, err := ioutil.ReadFile(filepath.Join(dir, "out.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bin
+}
+
+func TestCa65RoundTrip(t *testing.T) {
+	mapper, err := mappers.LoadFromFile("../cmd/breakout.nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := mmu.NewNES(mapper)
+	emu.NewCore(n).StaticDisassembly()
+
+	src, cfg := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := n.WriteDasmMode(src, mmu.DasmCa65); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.WriteLinkerConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(src.String(), `.segment "BANK_0F"`) {
+		t.Errorf("missing segment for the fixed bank")
+	}
+
+	// Every branch in the fixed bank stays in the fixed bank.
+	if m := regexp.MustCompile(`(?m)^\s+B(CC|CS|EQ|NE|MI|PL|VC|VS) \$`).FindString(src.String()); m != "" {
+		t.Errorf("branch without a label: %q", strings.TrimSpace(m))
+	}
+
+	bin := assemble(t, src.Bytes(), cfg.Bytes())
+
+	prg := mapper.Info().PrgSize
+	if uint(len(bin)) != prg {
+		t.Fatalf("size mismatch: expected $%X got $%X", prg, len(bin))
+	}
+
+	for i := uint(0); i < prg; i++ {
+		if bin[i] != mapper.RomRead(i) {
+			t.Fatalf("mismatch at offset $%05X: expected $%02X got $%02X", i, mapper.RomRead(i), bin[i])
+		}
+	}
+}
+
+func TestCa65AbsoluteZeroPage(t *testing.T) {
+	rom := []byte{
+		0xAD, 0x10, 0x00, // LDA a:$0010
+		0xA5, 0x10, // LDA $10
+		0xD0, 0xF9, // BNE $0000
+		0x1A, // DBG
+	}
+
+	fr, err := mmu.NewFullRam(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fr.AddDasm(0x0000, &mmu.Disassembly{OpCode: 0xAD, Size: 3, Name: "LDA", Format: "%s", Operand: 0x0010})
+	fr.AddDasm(0x0003, &mmu.Disassembly{OpCode: 0xA5, Size: 2, Name: "LDA", Format: "%s", Operand: 0x10})
+	fr.AddDasm(0x0005, &mmu.Disassembly{OpCode: 0xD0, Size: 2, Name: "BNE", Format: "%s", Operand: 0x0000, Target: true})
+	fr.AddDasm(0x0007, &mmu.Disassembly{OpCode: 0x1A, Size: 1, Format: ""})
+
+	src := &bytes.Buffer{}
+	if err := fr.WriteDasmMode(src, mmu.DasmCa65); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"L0000:", "LDA a:$0010", "LDA $10", "BNE L0000", ".byte $1A"} {
+		if !strings.Contains(src.String(), line) {
+			t.Errorf("expected %q in output:\n%s", line, src)
+		}
+	}
+}
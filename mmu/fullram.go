@@ -11,8 +11,20 @@ import (
 
 type FullRam struct {
 	ram [0x10000]byte
-	lbmap labels.LabelMap
-	dasm map[uint16]string
+	init [0x10000]bool
+	labels labels.Provider
+	dasm map[uint16]*Disassembly
+
+	// Length of the loaded image
+	size uint
+
+	// dasm is shared with a clone and must be copied before it's changed.
+	dasmShared bool
+}
+
+type fullRamState struct {
+	ram [0x10000]byte
+	init [0x10000]bool
 }
 
 func NewFullRam(rombytes []byte) (*FullRam, error) {
@@ -20,41 +32,133 @@ func NewFullRam(rombytes []byte) (*FullRam, error) {
 		return nil, fmt.Errorf("rom too large")
 	}
 
-	fr := &FullRam{lbmap: make(labels.LabelMap), dasm: make(map[uint16]string)}
+	fr := &FullRam{labels: labels.Set{}, dasm: make(map[uint16]*Disassembly), size: uint(len(rombytes))}
 	for i, b := range rombytes {
 	//for i := 0; i < len(rombytes); i++ {
 		fr.ram[i] = b
+		fr.init[i] = true
 	}
 
 	return fr, nil
 }
 
+func (fr *FullRam) Clone() Manager {
+	clone := *fr
+	fr.dasmShared = true
+	clone.dasmShared = true
+	return &clone
+}
+
+func (fr *FullRam) GetState() interface{} {
+	return &fullRamState{ram: fr.ram, init: fr.init}
+}
+
+func (fr *FullRam) SetState(data interface{}) error {
+	state, ok := data.(*fullRamState)
+	if !ok {
+		return fmt.Errorf("Invalid state given")
+	}
+
+	fr.ram = state.ram
+	fr.init = state.init
+	return nil
+}
+
+func (fr *FullRam) ownDasm() {
+	if !fr.dasmShared {
+		return
+	}
+
+	dasm := make(map[uint16]*Disassembly, len(fr.dasm))
+	for k, v := range fr.dasm {
+		dasm[k] = v
+	}
+	fr.dasm = dasm
+	fr.dasmShared = false
+}
+
 func (fr *FullRam) ReadByte(address uint16) uint8 {
 	return fr.ram[address]
 }
 
 func (fr *FullRam) WriteByte(address uint16, value uint8) {
 	fr.ram[address] = value
+	fr.init[address] = true
+	if len(fr.dasm) > 0 {
+		fr.ownDasm()
+		invalidateDasm(fr.dasm, address)
+	}
+}
+
+// Everything is RAM.  Bytes loaded from the rom image count as initialized.
+func (fr *FullRam) IsInitialized(address uint16) (bool, bool) {
+	return fr.init[address], true
+}
+
+func (fr *FullRam) SetInitialized(value bool) {
+	for i := 0; i < len(fr.init); i++ {
+		fr.init[i] = value
+	}
 }
 
+// The program lives in RAM, so nothing is cleared.  Only the loaded image
+// counts as initialized afterwards.
 func (fr *FullRam) ClearRam() {
-	// do nothing
+	for i := 0; i < len(fr.init); i++ {
+		fr.init[i] = uint(i) < fr.size
+	}
 }
 
+// Everything is a CPU address, so labels are all NesMemory.
 func (fr *FullRam) GetZpLabel(address uint8) string {
+	if lbl := labels.Name(fr.labels.Label(labels.NesMemory, uint(address))); lbl != "" {
+		return lbl
+	}
 	return fmt.Sprintf("$%02X", address)
 }
 
 func (fr *FullRam) GetLabel(address uint16) string {
+	if lbl := labels.Name(fr.labels.Label(labels.NesMemory, uint(address))); lbl != "" {
+		return lbl
+	}
 	return fmt.Sprintf("$%04X", address)
 }
 
-func (fr *FullRam) AddDasm(address uint16, src string, size uint) {
+func (fr *FullRam) FindLabel(name string) (uint, labels.MemoryType) {
+	if t, addr, ok := fr.labels.Find(name); ok {
+		return addr, t
+	}
+	return 0, labels.NesOpenBus
+}
+
+func (fr *FullRam) Labels(t labels.MemoryType) labels.LabelMap {
+	return fr.labels.Labels(t)
+}
+
+func (fr *FullRam) SetLabels(p labels.Provider) {
+	fr.labels = p
+}
+
+func (fr *FullRam) AddDasm(address uint16, instr *Disassembly) {
 	//panic("AddDasm() not implemented for FullRam")
-	fr.dasm[address] = src
+	fr.ownDasm()
+	instr.Address = uint(address)
+	fr.dasm[address] = instr
 }
 
 func (fr *FullRam) WriteDasm(writer io.Writer) error {
+	return fr.WriteDasmMode(writer, DasmListing)
+}
+
+func (fr *FullRam) WriteDasmMode(writer io.Writer, mode DasmMode) error {
+	switch mode {
+	case DasmListing:
+	case DasmCa65:
+		return fr.ca65Image().writeSource(writer)
+	default:
+		return fmt.Errorf("unknown disassembly mode: %d", mode)
+	}
+
 	addrs := []uint16{}
 
 	for addr, _ := range fr.dasm {
@@ -64,7 +168,7 @@ func (fr *FullRam) WriteDasm(writer io.Writer) error {
 	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
 
 	for _, addr := range addrs {
-		_, err := fmt.Fprintln(writer, fr.dasm[addr])
+		_, err := fmt.Fprintln(writer, fr.dasm[addr].Value)
 		if err != nil {
 			return err
 		}
@@ -72,3 +176,40 @@ func (fr *FullRam) WriteDasm(writer io.Writer) error {
 
 	return nil
 }
+
+func (fr *FullRam) WriteLinkerConfig(writer io.Writer) error {
+	return fr.ca65Image().writeConfig(writer)
+}
+
+// The whole loaded image is a single bank starting at $0000.
+func (fr *FullRam) ca65Image() *ca65Image {
+	size := fr.size
+	if size == 0 {
+		size = 0x10000
+	}
+
+	bank := &ca65Bank{
+		Segment: "CODE",
+		Memory: "RAM",
+		Size: size,
+		Prefix: "L",
+		read: func(idx uint) byte { return fr.ram[idx] },
+		dasm: func(idx uint) *Disassembly { return fr.dasm[uint16(idx)] },
+		label: func(idx uint) *labels.Label {
+			if lbl, offset := fr.labels.Label(labels.NesMemory, idx); offset == 0 {
+				return lbl
+			}
+			return nil
+		},
+	}
+
+	return &ca65Image{
+		banks: []*ca65Bank{bank},
+		resolve: func(from int, address uint16) int {
+			if uint(address) < size {
+				return 0
+			}
+			return -1
+		},
+	}
+}
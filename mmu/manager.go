@@ -20,10 +20,76 @@ type Manager interface {
 	// Return all known labels for the given memory type
 	Labels(t labels.MemoryType) labels.LabelMap
 
-	AddDasm(address uint16, src string, size uint)
+	// Use the given labels for everything above
+	SetLabels(p labels.Provider)
+
+	AddDasm(address uint16, instr *Disassembly)
 	//UpdateDasm(address uint16, instr 
 	WriteDasm(writer io.Writer) error
+	WriteDasmMode(writer io.Writer, mode DasmMode) error
+
+	// Config for ld65 that goes with the DasmCa65 output
+	WriteLinkerConfig(writer io.Writer) error
 
 	ClearRam()
+
+	// Clone returns a copy with its own RAM and mapper state.  ROM data and
+	// labels are shared.
+	Clone() Manager
+}
+
+// InitTracker is implemented by managers that keep track of which RAM bytes
+// have been written since the last reset.
+type InitTracker interface {
+	// IsInitialized returns whether the byte at the given address has been
+	// written.  tracked is false if the address isn't RAM.
+	IsInitialized(address uint16) (initialized bool, tracked bool)
+
+	// SetInitialized marks all tracked RAM as initialized (or not).
+	SetInitialized(value bool)
+}
+
+// StateSaver is implemented by managers that can save and restore all of
+// their RAM and mapper state.  States only go back into the manager they
+// came from, or a clone of it.
+type StateSaver interface {
+	GetState() interface{}
+
+	// SetState doesn't change the state, so it can be used again.
+	SetState(state interface{}) error
 }
 
+// MemoryTyper is implemented by managers that know what kind of memory is
+// mapped at a given address.
+type MemoryTyper interface {
+	MemoryType(address uint16) labels.MemoryType
+}
+
+// PrgMapper is implemented by managers with bank switched PRG ROM.
+type PrgMapper interface {
+	// PrgOffset returns the offset in PRG ROM that is currently mapped at
+	// the given CPU address.  ok is false if the address isn't PRG ROM.
+	PrgOffset(address uint16) (offset uint32, ok bool)
+
+	// PrgBankSize is the size of a switchable PRG bank.
+	PrgBankSize() uint32
+}
+
+// IsRam returns true for memory types that can be written to.
+func IsRam(t labels.MemoryType) bool {
+	switch t {
+	case labels.NesInternalRam, labels.NesWorkRam, labels.NesSaveRam:
+		return true
+	}
+	return false
+}
+
+// invalidateDasm removes any cached instruction that covers the given
+// address.  Instructions are at most three bytes long.
+func invalidateDasm(dasm map[uint16]*Disassembly, address uint16) {
+	for i := uint16(0); i < 3 && i <= address; i++ {
+		if d, ok := dasm[address-i]; ok && uint(i) < d.Size {
+			delete(dasm, address-i)
+		}
+	}
+}
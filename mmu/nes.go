@@ -12,32 +12,125 @@ import (
 )
 
 type Disassembly struct {
-	//OpCode byte
+	OpCode byte
 	Value string
 	Size uint
 	Address uint // address in bank space, not CPU space
+
+	// Used for reassemblable output.  An empty Name means the instruction
+	// can't be reassembled (eg, DBG) and it is written as data instead.
+	Name string
+	Format string // operand syntax with %s in place of the value
+	Operand uint16
+
+	// Operand is the address of code (branches, JMP and JSR).  For branches
+	// this is the destination, not the relative offset.
+	Target bool
 }
 
 type NES struct {
 	mapper mappers.Mapper
 	ram [0x0800]byte
-	labels map[labels.MemoryType]labels.LabelMap
+	labels labels.Provider
+
+	// Written since reset
+	ramInit [0x0800]bool
+	wramInit []bool
+	wramStart uint16
 
 	dasmRom map[uint]string
-	dasmRam map[uint]string
+
+	// Code executed from RAM, keyed by CPU address.  Internal RAM is keyed
+	// by its address without mirroring.
+	dasmRam map[uint16]*Disassembly
 
 	dasm []*Disassembly
+
+	// dasm and dasmRam are shared with a clone and must be copied before
+	// they're changed.
+	dasmShared bool
+}
+
+type nesState struct {
+	ram [0x0800]byte
+	ramInit [0x0800]bool
+	wramInit []bool
+	mapper interface{}
 }
 
 func NewNES(mapper mappers.Mapper) *NES {
+	info := mapper.Info()
 	return &NES{
 		mapper: mapper,
 		ram: [0x0800]byte{},
+		labels: labels.Set{},
+
+		wramInit: make([]bool, info.PrgRamSize),
+		wramStart: info.PrgRamStartAddress,
 
 		dasmRom: make(map[uint]string),
-		dasmRam: make(map[uint]string),
-		dasm: make([]*Disassembly, mapper.Info().PrgSize),
+		dasmRam: make(map[uint16]*Disassembly),
+		dasm: make([]*Disassembly, info.PrgSize),
+	}
+}
+
+func (n *NES) Clone() Manager {
+	clone := *n
+	clone.mapper = n.mapper.Clone()
+	clone.wramInit = make([]bool, len(n.wramInit))
+	copy(clone.wramInit, n.wramInit)
+
+	// Disassembly can be big, so it's only copied if one of them changes it.
+	n.dasmShared = true
+	clone.dasmShared = true
+	return &clone
+}
+
+// GetState returns a copy of RAM and the mapper's state.  Disassembly isn't
+// included.
+func (n *NES) GetState() interface{} {
+	state := &nesState{
+		ram: n.ram,
+		ramInit: n.ramInit,
+		wramInit: make([]bool, len(n.wramInit)),
+		mapper: n.mapper.GetState(),
 	}
+	copy(state.wramInit, n.wramInit)
+	return state
+}
+
+func (n *NES) SetState(data interface{}) error {
+	state, ok := data.(*nesState)
+	if !ok || len(state.wramInit) != len(n.wramInit) {
+		return fmt.Errorf("Invalid state given")
+	}
+
+	if err := n.mapper.SetState(state.mapper); err != nil {
+		return err
+	}
+
+	n.ram = state.ram
+	n.ramInit = state.ramInit
+	copy(n.wramInit, state.wramInit)
+	return nil
+}
+
+// ownDasm makes a private copy of the disassembly if it's shared.
+func (n *NES) ownDasm() {
+	if !n.dasmShared {
+		return
+	}
+
+	dasm := make([]*Disassembly, len(n.dasm))
+	copy(dasm, n.dasm)
+	n.dasm = dasm
+
+	dasmRam := make(map[uint16]*Disassembly, len(n.dasmRam))
+	for k, v := range n.dasmRam {
+		dasmRam[k] = v
+	}
+	n.dasmRam = dasmRam
+	n.dasmShared = false
 }
 
 func (n *NES) ReadByte(address uint16) uint8 {
@@ -53,17 +146,66 @@ func (n *NES) ReadByte(address uint16) uint8 {
 func (n *NES) WriteByte(address uint16, value uint8) {
 	if address < 0x2000 {
 		n.ram[address % 0x0800] = value
+		n.ramInit[address % 0x0800] = true
+		if len(n.dasmRam) > 0 {
+			n.ownDasm()
+			invalidateDasm(n.dasmRam, address % 0x0800)
+		}
 	} else if address >= 0x4020 { // $4020 is the start of cart space
 		n.mapper.WriteByte(address, value)
+		if idx, ok := n.wramIndex(address); ok {
+			n.wramInit[idx] = true
+			if len(n.dasmRam) > 0 {
+				n.ownDasm()
+				invalidateDasm(n.dasmRam, address)
+			}
+		}
+	}
+}
+
+// Index into wramInit for the given address
+func (n *NES) wramIndex(address uint16) (int, bool) {
+	if address < n.wramStart || n.MemoryType(address) != labels.NesWorkRam {
+		return 0, false
+	}
+
+	idx := int(address - n.wramStart)
+	if idx >= len(n.wramInit) {
+		return 0, false
+	}
+	return idx, true
+}
+
+// Internal RAM and mapper WRAM are tracked.  Everything else is not.
+func (n *NES) IsInitialized(address uint16) (bool, bool) {
+	if address < 0x2000 {
+		return n.ramInit[address % 0x0800], true
+	}
+
+	if idx, ok := n.wramIndex(address); ok {
+		return n.wramInit[idx], true
+	}
+	return false, false
+}
+
+func (n *NES) SetInitialized(value bool) {
+	for i := 0; i < len(n.ramInit); i++ {
+		n.ramInit[i] = value
+	}
+
+	for i := 0; i < len(n.wramInit); i++ {
+		n.wramInit[i] = value
 	}
 }
 
+// ClearRam zeroes RAM and marks all of it as uninitialized, same as power-on.
 func (n *NES) ClearRam() {
 	for i := 0; i < len(n.ram); i++ {
 		n.ram[i] = 0
 	}
 
 	n.mapper.ClearRam()
+	n.SetInitialized(false)
 }
 
 func (n *NES) GetZpLabel(address uint8) string {
@@ -83,52 +225,78 @@ func (n *NES) GetLabel(address uint16) string {
 }
 
 func (n *NES) lookupLabel(address uint16) string {
-	switch n.MemoryType(address) {
+	var lbl *labels.Label
+	var offset uint
+
+	switch t := n.MemoryType(address); t {
 	case labels.NesInternalRam:
-		if lbl, ok := n.labels[labels.NesInternalRam][uint(address%0x800)]; ok {
-			return lbl.Name
-		}
+		lbl, offset = n.labels.Label(t, uint(address%0x800))
 	case labels.NesPrgRom, labels.NesWorkRam, labels.NesSaveRam:
-		if lbl, ok := n.labels[labels.MemoryType(n.MemoryType(address))][uint(n.mapper.Offset(address))]; ok {
-			return lbl.Name
-		}
+		lbl, offset = n.labels.Label(t, uint(n.mapper.Offset(address)))
+	}
+
+	// Labels by CPU address, eg from a ca65 debug file
+	if lbl == nil {
+		lbl, offset = n.labels.Label(labels.NesMemory, uint(address))
 	}
-	return ""
+	return labels.Name(lbl, offset)
+}
+
+// exactLabel returns the label that starts at address, or nil.
+func (n *NES) exactLabel(t labels.MemoryType, address uint) *labels.Label {
+	if lbl, offset := n.labels.Label(t, address); offset == 0 {
+		return lbl
+	}
+	return nil
 }
 
 func (n *NES) FindLabel(name string) (uint, labels.MemoryType) {
-	for t, list := range n.labels {
-		addr, found := list.FindLabel(name)
-		if found {
-			return addr, t
-		}
+	if t, addr, ok := n.labels.Find(name); ok {
+		return addr, t
 	}
 
 	return 0, labels.NesOpenBus
 }
 
-func (n *NES) LoadLabelsMesen2(filename string) error {
-	var err error
-	n.labels, err = labels.LoadMesen2(filename)
-	return err
+func (n *NES) SetLabels(p labels.Provider) {
+	n.labels = p
 }
 
-func (n *NES) AddDasm(address uint16, src string, size uint) {
-	offset := uint(n.mapper.Offset(address))
-	if n.MemoryType(address) != labels.NesPrgRom {
-		return
+func (n *NES) LoadLabelsMesen2(filename string) error {
+	lbls, err := labels.LoadMesen2(filename)
+	if err != nil {
+		return err
 	}
+	n.labels = labels.NewIndex(lbls)
+	return nil
+}
 
-	instr := &Disassembly{
-		Value: src,
-		Address: offset,
-		Size: size,
+// LoadLabels loads labels from any format labels.Load() knows about.
+func (n *NES) LoadLabels(filename string) error {
+	lbls, err := labels.Load(filename)
+	if err != nil {
+		return err
 	}
+	n.labels = labels.NewIndex(lbls)
+	return nil
+}
 
-	for i := uint(0); i < size; i++ {
-		n.dasm[i+offset] = instr
+func (n *NES) AddDasm(address uint16, instr *Disassembly) {
+	n.ownDasm()
+	switch n.MemoryType(address) {
+	case labels.NesInternalRam:
+		address = address % 0x0800
+		fallthrough
+	case labels.NesWorkRam, labels.NesSaveRam:
+		instr.Address = uint(address)
+		n.dasmRam[address] = instr
+		return
+	case labels.NesPrgRom:
+	default:
+		return
 	}
 
+	n.AddRomDasm(uint(n.mapper.Offset(address)), instr)
 	//n.dasm.Add(&dis.Instruction{
 	//	Address: offset,
 	//	Value: src,
@@ -145,6 +313,24 @@ func (n *NES) AddDasm(address uint16, src string, size uint) {
 	//}
 }
 
+// AddRomDasm adds an instruction by its PRG ROM offset instead of its CPU
+// address.  Used for banks that aren't currently mapped.
+func (n *NES) AddRomDasm(offset uint, instr *Disassembly) {
+	if offset+instr.Size > uint(len(n.dasm)) {
+		return
+	}
+
+	n.ownDasm()
+	instr.Address = offset
+	for i := uint(0); i < instr.Size; i++ {
+		n.dasm[i+offset] = instr
+	}
+}
+
+func (n *NES) Mapper() mappers.Mapper {
+	return n.mapper
+}
+
 func (n *NES) MemoryType(address uint16) labels.MemoryType {
 	if address >= 0x4020 {
 		return labels.MemoryType(n.mapper.MemoryType(address))
@@ -154,7 +340,39 @@ func (n *NES) MemoryType(address uint16) labels.MemoryType {
 	return labels.NesMemory
 }
 
+func (n *NES) PrgOffset(address uint16) (uint32, bool) {
+	if n.MemoryType(address) != labels.NesPrgRom {
+		return 0, false
+	}
+	return n.mapper.Offset(address), true
+}
+
+func (n *NES) PrgBankSize() uint32 {
+	return uint32(n.mapper.Info().PrgBankSize)
+}
+
 func (n *NES) WriteDasm(writer io.Writer) error {
+	return n.WriteDasmMode(writer, DasmListing)
+}
+
+func (n *NES) WriteDasmMode(writer io.Writer, mode DasmMode) error {
+	switch mode {
+	case DasmListing:
+	case DasmCa65:
+		img, err := n.ca65Image()
+		if err != nil {
+			return err
+		}
+
+		err = img.writeSource(writer)
+		if err != nil {
+			return err
+		}
+		return n.writeRamDasm(writer, "; ")
+	default:
+		return fmt.Errorf("unknown disassembly mode: %d", mode)
+	}
+
 	nothing := 0
 	start := uint(0)
 	for i := uint(0); i < uint(len(n.dasm)); i++ {
@@ -184,7 +402,7 @@ func (n *NES) WriteDasm(writer io.Writer) error {
 			nothing = 0
 		}
 
-		if lbl, ok := n.labels[labels.NesPrgRom][i]; ok {
+		if lbl := n.exactLabel(labels.NesPrgRom, i); lbl != nil {
 			if lbl.Comment != "" {
 				err := n.writeline(writer, "", fmt.Sprintf("$%06X: %s", i, lbl.Comment))
 				if err != nil {
@@ -211,10 +429,38 @@ func (n *NES) WriteDasm(writer io.Writer) error {
 		}
 	}
 
-	return nil
+	return n.writeRamDasm(writer, "    ")
 	//return n.dasm.Write(writer)
 }
 
+// Code that was executed from RAM, as it was the last time it ran.  Each line
+// starts with prefix.
+func (n *NES) writeRamDasm(writer io.Writer, prefix string) error {
+	if len(n.dasmRam) == 0 {
+		return nil
+	}
+
+	addrs := []uint16{}
+	for addr, _ := range n.dasmRam {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+
+	_, err := fmt.Fprintln(writer, "\n; Code executed from RAM")
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range addrs {
+		err = n.writeline(writer, prefix+n.dasmRam[addr].Value, fmt.Sprintf("$%04X", addr))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (n *NES) writeline(w io.Writer, src, comment string) error {
 	_, err := fmt.Fprintf(w, "%-30s ; %s\n", src, comment)
 	return err
@@ -230,7 +476,7 @@ func (n *NES) WriteDasmWhat(writer io.Writer) error {
 	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
 
 	for _, addr := range addrs {
-		if lbl, ok := n.labels[labels.NesPrgRom][addr]; ok {
+		if lbl := n.exactLabel(labels.NesPrgRom, addr); lbl != nil {
 			if lbl.Comment != "" {
 				_, err := fmt.Fprintln(writer, ";"+lbl.Comment)
 				if err != nil {
@@ -254,9 +500,117 @@ func (n *NES) WriteDasmWhat(writer io.Writer) error {
 }
 
 func (n *NES) Labels(t labels.MemoryType) labels.LabelMap {
-	if l, ok := n.labels[t]; ok {
-		return l
+	return n.labels.Labels(t)
+}
+
+func (n *NES) WriteLinkerConfig(writer io.Writer) error {
+	img, err := n.ca65Image()
+	if err != nil {
+		return err
+	}
+	return img.writeConfig(writer)
+}
+
+// ca65Image splits PRG ROM into banks using the mapper's window layout.  Each
+// bank is placed at the window it's fixed to, or the first switchable window
+// otherwise.
+func (n *NES) ca65Image() (*ca65Image, error) {
+	layout := mappers.PrgLayout(n.mapper)
+	if len(layout) == 0 {
+		return nil, fmt.Errorf("unknown PRG layout for mapper")
+	}
+
+	size := layout[0].Size
+	for _, w := range layout {
+		if w.Size < size {
+			size = w.Size
+		}
+	}
+
+	prgSize := uint(len(n.dasm))
+	if size == 0 || prgSize%size != 0 {
+		return nil, fmt.Errorf("PRG size $%X isn't a multiple of the bank size $%X", prgSize, size)
+	}
+
+	img := &ca65Image{fill: ", fillval = $FF"}
+	for b := 0; b < int(prgSize/size); b++ {
+		start := layout[0].Start
+		fixed := false
+		for _, w := range layout {
+			if w.FixedBank == b {
+				start = w.Start
+				fixed = true
+			}
+		}
+
+		if !fixed {
+			for _, w := range layout {
+				if w.FixedBank < 0 {
+					start = w.Start
+					break
+				}
+			}
+		}
+
+		offset := uint(b)*size
+		bank := &ca65Bank{
+			Segment: fmt.Sprintf("BANK_%02X", b),
+			Memory: fmt.Sprintf("PRG_%02X", b),
+			Start: start,
+			Size: size,
+			Prefix: "L",
+
+			read: func(idx uint) byte { return n.mapper.RomRead(offset+idx) },
+			dasm: func(idx uint) *Disassembly {
+				if d := n.dasm[offset+idx]; d != nil && d.Address == offset+idx {
+					return d
+				}
+				return nil
+			},
+			label: func(idx uint) *labels.Label { return n.exactLabel(labels.NesPrgRom, offset+idx) },
+		}
+
+		if !fixed {
+			bank.Prefix = fmt.Sprintf("B%02X_", b)
+		}
+		img.banks = append(img.banks, bank)
+	}
+
+	img.resolve = func(from int, address uint16) int {
+		for _, w := range layout {
+			if !w.Contains(address) {
+				continue
+			}
+
+			b := w.FixedBank
+			if b < 0 {
+				b = from
+			}
+
+			bank := img.banks[b]
+			if address >= bank.Start && uint(address-bank.Start) < bank.Size {
+				return b
+			}
+			return -1
+		}
+		return -1
+	}
+
+	img.ram = func(address uint16) (string, uint16) {
+		// Mirrors would reassemble to a different address.
+		if address >= 0x0800 {
+			return "", 0
+		}
+
+		lbl, offset := n.labels.Label(labels.NesInternalRam, uint(address))
+		if lbl == nil {
+			lbl, offset = n.labels.Label(labels.NesMemory, uint(address))
+		}
+		if lbl == nil || lbl.Name == "" {
+			return "", 0
+		}
+		return lbl.Name, address - uint16(offset)
 	}
-	return nil
 
+	return img, nil
 }
@@ -0,0 +1,131 @@
+package mmu
+
+import (
+	"testing"
+
+	"github.com/zorchenhimer/emu-6502/labels"
+	"github.com/zorchenhimer/emu-6502/mappers"
+)
+
+func newTestNES(t *testing.T) *NES {
+	t.Helper()
+	mapper, err := mappers.NewNROM(make([]byte, 0x8000), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewNES(mapper)
+}
+
+func TestInitTracking(t *testing.T) {
+	n := newTestNES(t)
+
+	for _, addr := range []uint16{0x0010, 0x0810, 0x6000, 0x7FFF} {
+		init, tracked := n.IsInitialized(addr)
+		if !tracked || init {
+			t.Errorf("$%04X: expected tracked and uninitialized; got tracked:%t init:%t", addr, tracked, init)
+		}
+	}
+
+	if _, tracked := n.IsInitialized(0x8000); tracked {
+		t.Errorf("$8000: PRG ROM should not be tracked")
+	}
+
+	n.WriteByte(0x0810, 0x12) // mirror of $0010
+	n.WriteByte(0x7FFF, 0x34)
+
+	for _, addr := range []uint16{0x0010, 0x1010, 0x7FFF} {
+		if init, _ := n.IsInitialized(addr); !init {
+			t.Errorf("$%04X: expected initialized after write", addr)
+		}
+	}
+
+	if init, _ := n.IsInitialized(0x6000); init {
+		t.Errorf("$6000: expected uninitialized")
+	}
+
+	n.ClearRam()
+	if init, _ := n.IsInitialized(0x0010); init {
+		t.Errorf("$0010: expected uninitialized after ClearRam()")
+	}
+
+	n.SetInitialized(true)
+	if init, _ := n.IsInitialized(0x6000); !init {
+		t.Errorf("$6000: expected initialized after SetInitialized(true)")
+	}
+}
+
+func TestRamDasmInvalidate(t *testing.T) {
+	n := newTestNES(t)
+
+	n.AddDasm(0x0300, &Disassembly{Value: "LDA #$00", Size: 2})
+	n.AddDasm(0x0302, &Disassembly{Value: "RTS", Size: 1})
+
+	n.WriteByte(0x0B01, 0x01) // mirror of $0301; operand of the LDA
+	if _, ok := n.dasmRam[0x0300]; ok {
+		t.Errorf("LDA at $0300 should have been invalidated")
+	}
+
+	if _, ok := n.dasmRam[0x0302]; !ok {
+		t.Errorf("RTS at $0302 should not have been invalidated")
+	}
+}
+
+func TestLabels(t *testing.T) {
+	n := newTestNES(t)
+	n.SetLabels(labels.Set{
+		labels.NesInternalRam: {
+			0x0000: {Name: "frame", Size: 1},
+			0x0300: {Name: "buffer", Size: 16},
+		},
+		labels.NesPrgRom: {
+			0x0010: {Name: "Start", Size: 1},
+		},
+		labels.NesMemory: {
+			0x2002: {Name: "PPUSTATUS", Size: 1},
+			0xC000: {Name: "Reset", Size: 1},
+		},
+	})
+
+	expect := map[uint16]string{
+		0x0000: "frame",
+		0x0800: "frame",
+		0x0305: "buffer+5",
+		0x0310: "$0310",
+		0x2002: "PPUSTATUS",
+		0x8010: "Start",
+		0xC000: "Reset",
+		0xC001: "$C001",
+	}
+
+	for addr, name := range expect {
+		if lbl := n.GetLabel(addr); lbl != name {
+			t.Errorf("$%04X: expected %q, got %q", addr, name, lbl)
+		}
+	}
+
+	if addr, typ := n.FindLabel("buffer"); addr != 0x0300 || typ != labels.NesInternalRam {
+		t.Errorf("expected buffer at NesInternalRam $0300, got %s $%04X", typ, addr)
+	}
+}
+
+func TestFullRamLabels(t *testing.T) {
+	var fr Manager
+	fr, err := NewFullRam([]byte{0xEA})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fr.SetLabels(labels.Set{
+		labels.NesMemory: {0x0010: {Name: "ptr", Size: 2}},
+	})
+
+	if lbl := fr.GetZpLabel(0x11); lbl != "ptr+1" {
+		t.Errorf("expected ptr+1, got %q", lbl)
+	}
+	if lbl := fr.GetLabel(0x0012); lbl != "$0012" {
+		t.Errorf("expected $0012, got %q", lbl)
+	}
+	if addr, _ := fr.FindLabel("ptr"); addr != 0x0010 {
+		t.Errorf("expected ptr at $0010, got $%04X", addr)
+	}
+}
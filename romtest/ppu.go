@@ -0,0 +1,62 @@
+package romtest
+
+import (
+	"github.com/zorchenhimer/emu-6502"
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+// Roughly one NTSC frame: 29781 CPU cycles at about three cycles an
+// instruction.
+const FrameInstructions uint64 = 9927
+
+// ppu fakes just enough of the PPU for test ROMs to get through their
+// startup: the vblank flag in PPUSTATUS and the NMI at the start of vblank.
+// Everything else is passed on to the NES memory map, which ignores it.
+type ppu struct {
+	mmu.Manager
+
+	ctrl      uint8
+	vblank    bool
+	nextFrame uint64
+}
+
+func newPpu(m mmu.Manager) *ppu {
+	return &ppu{Manager: m, nextFrame: FrameInstructions}
+}
+
+func isPpuRegister(address uint16, reg uint16) bool {
+	return address >= 0x2000 && address < 0x4000 && address&0x0007 == reg
+}
+
+// Reading PPUSTATUS clears the vblank flag.
+func (p *ppu) ReadByte(address uint16) uint8 {
+	if isPpuRegister(address, 2) {
+		var status uint8
+		if p.vblank {
+			status = 0x80
+		}
+		p.vblank = false
+		return status
+	}
+	return p.Manager.ReadByte(address)
+}
+
+func (p *ppu) WriteByte(address uint16, value uint8) {
+	if isPpuRegister(address, 0) {
+		p.ctrl = value
+	}
+	p.Manager.WriteByte(address, value)
+}
+
+// tick is called after every instruction.
+func (p *ppu) tick(c *emu.Core) {
+	if c.Ticks() < p.nextFrame {
+		return
+	}
+
+	p.nextFrame += FrameInstructions
+	p.vblank = true
+	if p.ctrl&0x80 != 0 {
+		c.NMI()
+	}
+}
@@ -0,0 +1,34 @@
+package romtest
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// WriteSummary writes a table with a line for each ROM and the totals.
+func WriteSummary(w io.Writer, results []*Result) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROM\tResult\tCode\tInstructions\tTime\tMessage")
+
+	counts := map[Status]int{}
+	for _, r := range results {
+		counts[r.Status]++
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\n",
+			r.File, r.Status, r.Code, r.Instructions,
+			r.Duration.Round(1000000), firstLine(r.Message))
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, "\n%d passed, %d failed, %d errors, %d timeouts\n",
+		counts[Passed], counts[Failed], counts[Error], counts[Timeout])
+	return err
+}
+
+func firstLine(s string) string {
+	return strings.SplitN(s, "\n", 2)[0]
+}
@@ -0,0 +1,306 @@
+// Package romtest runs NES test ROMs headless and reads their results.
+//
+// Most of blargg's CPU and mapper tests (and plenty written since) report
+// through WRAM: a status byte at $6000, the signature DE B0 61 at $6001 and
+// a NUL terminated message at $6004.  The status is $80 while the test is
+// running, $81 when it wants the reset button pressed and the result code
+// when it's done, zero for a pass.
+//
+// nestest is run in its automated mode from $C000.  Its result codes are
+// at $02 (official opcodes) and $03 (unofficial opcodes).
+package romtest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zorchenhimer/emu-6502"
+	"github.com/zorchenhimer/emu-6502/mappers"
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+// Used when Options doesn't have a limit.  About a minute and a half of
+// NES time.
+const DefaultLimit int64 = 50000000
+
+// Wait this long before pressing reset when a ROM asks for it.  The
+// protocol wants at least 100ms.
+const resetDelay = FrameInstructions * 6
+
+type Mode int
+
+const (
+	// Blargg, unless the file is named nestest.nes
+	Auto Mode = iota
+	Blargg
+	Nestest
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Blargg:
+		return "blargg"
+	case Nestest:
+		return "nestest"
+	}
+	return "auto"
+}
+
+type Status int
+
+const (
+	Passed  Status = iota
+	Failed         // finished with a non-zero code
+	Error          // couldn't be loaded or hit an opcode that isn't implemented
+	Timeout        // didn't finish before the instruction limit
+)
+
+func (s Status) String() string {
+	switch s {
+	case Passed:
+		return "passed"
+	case Failed:
+		return "failed"
+	case Error:
+		return "error"
+	}
+	return "timeout"
+}
+
+type Options struct {
+	Mode Mode
+
+	// Most instructions to run.  DefaultLimit if zero.
+	Limit int64
+}
+
+type Result struct {
+	File string
+	Mode Mode
+
+	Status Status
+
+	// Result code from the ROM.  For nestest this is the official opcode
+	// error code.
+	Code int
+
+	// Text from the ROM, or the error.
+	Message string
+
+	Instructions uint64
+	Duration     time.Duration
+}
+
+func (r *Result) Passed() bool {
+	return r.Status == Passed
+}
+
+// Run a single ROM.
+func Run(filename string, opts Options) *Result {
+	res := &Result{File: filename, Mode: opts.Mode}
+	if res.Mode == Auto {
+		res.Mode = Blargg
+		if strings.EqualFold(filepath.Base(filename), "nestest.nes") {
+			res.Mode = Nestest
+		}
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	mapper, err := mappers.LoadFromFile(filename)
+	if err != nil {
+		res.Status = Error
+		res.Message = err.Error()
+		return res
+	}
+
+	p := newPpu(mmu.NewNES(mapper))
+	c := emu.NewCore(p)
+
+	start := time.Now()
+	switch res.Mode {
+	case Nestest:
+		runNestest(c, p, res, uint64(limit))
+	default:
+		runBlargg(c, p, res, uint64(limit))
+	}
+	res.Duration = time.Since(start)
+	res.Instructions = c.Ticks()
+	return res
+}
+
+func runBlargg(c *emu.Core, p *ppu, res *Result, limit uint64) {
+	c.Reset()
+
+	var resetAt uint64
+	for c.Ticks() < limit {
+		if err := c.Step(); err != nil {
+			res.Status = Error
+			res.Message = err.Error()
+			return
+		}
+		p.tick(c)
+
+		// No need to look every instruction.
+		if c.Ticks()%256 != 0 || !hasSignature(p) {
+			continue
+		}
+
+		status := p.Manager.ReadByte(0x6000)
+		switch {
+		case status == 0x80:
+			// still running
+
+		case status == 0x81:
+			if resetAt == 0 {
+				resetAt = c.Ticks() + resetDelay
+			} else if c.Ticks() >= resetAt {
+				resetAt = 0
+				c.Reset()
+			}
+
+		case status < 0x80:
+			res.Code = int(status)
+			res.Message = blarggText(p)
+			res.Status = Passed
+			if status != 0 {
+				res.Status = Failed
+			}
+			return
+		}
+	}
+
+	res.Status = Timeout
+	res.Message = fmt.Sprintf("no result after %d instructions", limit)
+	if hasSignature(p) {
+		res.Message += "\n" + blarggText(p)
+	}
+}
+
+func hasSignature(m mmu.Manager) bool {
+	return m.ReadByte(0x6001) == 0xDE && m.ReadByte(0x6002) == 0xB0 && m.ReadByte(0x6003) == 0x61
+}
+
+func blarggText(m mmu.Manager) string {
+	text := []byte{}
+	for addr := uint16(0x6004); addr < 0x8000; addr++ {
+		b := m.ReadByte(addr)
+		if b == 0 {
+			break
+		}
+		text = append(text, b)
+	}
+	return strings.TrimSpace(string(text))
+}
+
+// The automated mode returns from $C000 with an RTS when it's done.  The
+// unofficial opcode tests run last, so an opcode that isn't implemented
+// doesn't hide the official result.
+func runNestest(c *emu.Core, p *ppu, res *Result, limit uint64) {
+	c.PC = 0xC000
+	c.SP = 0xFD
+	c.Phlags = emu.FLAG_INTERRUPT
+
+	var stepErr error
+	for c.Ticks() < limit && c.PC >= 0x8000 {
+		if stepErr = c.Step(); stepErr != nil {
+			break
+		}
+		p.tick(c)
+	}
+
+	official := p.Manager.ReadByte(0x0002)
+	unofficial := p.Manager.ReadByte(0x0003)
+	res.Code = int(official)
+
+	switch {
+	case official != 0:
+		res.Status = Failed
+		res.Message = fmt.Sprintf("official opcodes failed with error $%02X", official)
+	case stepErr != nil:
+		res.Status = Passed
+		res.Message = fmt.Sprintf("official opcodes passed.  Unofficial opcodes not tested: %v", stepErr)
+	case c.PC >= 0x8000:
+		res.Status = Timeout
+		res.Message = fmt.Sprintf("no result after %d instructions", limit)
+	case unofficial != 0:
+		res.Status = Failed
+		res.Code = int(unofficial)
+		res.Message = fmt.Sprintf("official opcodes passed.  Unofficial opcodes failed with error $%02X", unofficial)
+	default:
+		res.Status = Passed
+		res.Message = "all opcodes passed"
+	}
+}
+
+// Find returns the .nes files in the given paths.  Directories are
+// searched recursively.
+func Find(paths ...string) ([]string, error) {
+	files := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		found := []string{}
+		err = filepath.Walk(path, func(name string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !fi.IsDir() && strings.EqualFold(filepath.Ext(name), ".nes") {
+				found = append(found, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(found)
+		files = append(files, found...)
+	}
+	return files, nil
+}
+
+// RunAll runs the ROMs with the given number of workers.  Results are in
+// the same order as the files.
+func RunAll(files []string, opts Options, workers int) []*Result {
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]*Result, len(files))
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				results[idx] = Run(files[idx], opts)
+			}
+		}()
+	}
+
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,131 @@
+package romtest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeRom writes a 16k NROM image with the program at $C000 and all the
+// vectors pointing to it.
+func writeRom(t *testing.T, dir, name string, program []byte) string {
+	prg := make([]byte, 0x4000)
+	copy(prg, program)
+	for i := 0x3FFA; i < 0x4000; i += 2 {
+		prg[i], prg[i+1] = 0x00, 0xC0
+	}
+
+	header := []byte{'N', 'E', 'S', 0x1A, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	filename := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, append(header, prg...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// blarggProgram writes the signature and a message, then the code.  With
+// reset it asks for a reset first and counts them at $6010.
+func blarggProgram(code uint8, message string, reset bool) []byte {
+	p := []byte{
+		0xA9, 0x80, 0x8D, 0x00, 0x60, // LDA #$80, STA $6000
+		0xA9, 0xDE, 0x8D, 0x01, 0x60, // LDA #$DE, STA $6001
+		0xA9, 0xB0, 0x8D, 0x02, 0x60, // LDA #$B0, STA $6002
+		0xA9, 0x61, 0x8D, 0x03, 0x60, // LDA #$61, STA $6003
+	}
+
+	if reset {
+		p = append(p,
+			0xAD, 0x10, 0x60, // LDA $6010
+			0xD0, 0x0B, // BNE +11
+			0xEE, 0x10, 0x60, // INC $6010
+			0xA9, 0x81, 0x8D, 0x00, 0x60, // LDA #$81, STA $6000
+			0x4C, 0x00, 0x00, // JMP *
+		)
+		here := 0xC000 + len(p) - 3
+		p[len(p)-2], p[len(p)-1] = uint8(here), uint8(here>>8)
+	}
+
+	for i, b := range append([]byte(message), 0) {
+		addr := 0x6004 + i
+		p = append(p, 0xA9, b, 0x8D, uint8(addr), uint8(addr>>8))
+	}
+
+	here := 0xC000 + len(p) + 5
+	return append(p,
+		0xA9, code, 0x8D, 0x00, 0x60, // LDA #code, STA $6000
+		0x4C, uint8(here), uint8(here>>8), // JMP *
+	)
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "romtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		file    string
+		program []byte
+		mode    Mode
+		status  Status
+		code    int
+		message string
+	}{
+		{"pass.nes", blarggProgram(0, "\nPassed\n", false), Blargg, Passed, 0, "Passed"},
+		{"fail.nes", blarggProgram(3, "Failed #3", false), Blargg, Failed, 3, "Failed #3"},
+		{"reset.nes", blarggProgram(0, "Passed", true), Blargg, Passed, 0, "Passed"},
+		{"loop.nes", []byte{0x4C, 0x00, 0xC0}, Blargg, Timeout, 0, "no result"},
+		{"broken.nes", []byte{0x02}, Blargg, Error, 0, ""},
+
+		// LDA #code, STA $02, LDA #$00, STA $03, RTS
+		{"nestest.nes", []byte{0xA9, 0x00, 0x85, 0x02, 0xA9, 0x00, 0x85, 0x03, 0x60}, Nestest, Passed, 0, "all opcodes passed"},
+		{"bad/nestest.nes", []byte{0xA9, 0x05, 0x85, 0x02, 0xA9, 0x00, 0x85, 0x03, 0x60}, Nestest, Failed, 5, "$05"},
+	}
+
+	files := []string{}
+	for _, tt := range tests {
+		files = append(files, writeRom(t, dir, tt.file, tt.program))
+	}
+
+	results := RunAll(files, Options{Limit: 200000}, 4)
+
+	for i, tt := range tests {
+		res := results[i]
+		if res.File != files[i] {
+			t.Errorf("%s: result is for %s", tt.file, res.File)
+			continue
+		}
+
+		if res.Mode != tt.mode {
+			t.Errorf("%s: expected mode %s, got %s", tt.file, tt.mode, res.Mode)
+		}
+		if res.Status != tt.status || res.Code != tt.code {
+			t.Errorf("%s: expected %s (%d), got %s (%d): %s", tt.file, tt.status, tt.code, res.Status, res.Code, res.Message)
+		}
+		if !strings.Contains(res.Message, tt.message) {
+			t.Errorf("%s: expected message with %q, got %q", tt.file, tt.message, res.Message)
+		}
+	}
+
+	found, err := Find(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != len(tests) {
+		t.Errorf("expected %d ROMs, found %v", len(tests), found)
+	}
+
+	out := &strings.Builder{}
+	if err := WriteSummary(out, results); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "3 passed, 2 failed, 1 errors, 1 timeouts") {
+		t.Errorf("unexpected summary:\n%s", out)
+	}
+}
@@ -0,0 +1,283 @@
+package emu
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zorchenhimer/emu-6502/labels"
+)
+
+// Used when Args doesn't have a limit.
+const DefaultCallLimit int64 = 1000000
+
+// CallRoutine pushes this minus one as the return address.  The call is done
+// when the routine's RTS lands here with SP back where it started.
+const callSentinel uint16 = 0xFFFF
+
+// Args for CallRoutine.
+type Args struct {
+	// Registers and memory to set before the call.  Keys are a register
+	// (A, X, Y, SP or P) or a location (see Location).  Registers take a
+	// uint8 or an int.  Memory takes a uint8, an int, a uint16 (two bytes,
+	// little endian) or a []byte.
+	Values map[string]interface{}
+
+	// Locations to read after the call.  Labels with a size are read
+	// whole, everything else is a single byte.
+	Outputs []string
+
+	// Most instructions to run.  DefaultCallLimit if zero.
+	Limit int64
+}
+
+// CallResult is the state after CallRoutine.
+type CallResult struct {
+	A, X, Y uint8
+	SP      uint8
+	Phlags  uint8
+	PC      uint16
+
+	// Keyed by the names in Args.Outputs
+	Outputs map[string][]byte
+
+	Instructions uint64
+	Duration     time.Duration
+
+	// Deepest the stack got below SP at the call, in bytes.  This includes
+	// the return address.
+	StackDepth int
+}
+
+// Byte returns the first byte of an output.
+func (r *CallResult) Byte(name string) uint8 {
+	if out := r.Outputs[name]; len(out) > 0 {
+		return out[0]
+	}
+	return 0
+}
+
+// Word returns the first two bytes of an output, little endian.
+func (r *CallResult) Word(name string) uint16 {
+	out := r.Outputs[name]
+	switch len(out) {
+	case 0:
+		return 0
+	case 1:
+		return uint16(out[0])
+	}
+	return uint16(out[0]) | uint16(out[1])<<8
+}
+
+// CallRoutine sets up the arguments and runs the routine at the given
+// location until it returns.  Unlike RunRoutine the return is found with a
+// sentinel return address, so routines that play with the stack (or are
+// entered with things already on it) end cleanly.  The result is returned
+// along with any error.
+func (c *Core) CallRoutine(name string, args Args) (*CallResult, error) {
+	address, err := c.Location(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.setArgs(args.Values); err != nil {
+		return nil, err
+	}
+
+	limit := args.Limit
+	if limit <= 0 {
+		limit = DefaultCallLimit
+	}
+
+	if c.DebugFile != nil {
+		c.Debug = true
+	}
+
+	sp := c.SP
+	if c.StackCheck != nil {
+		c.StackCheck.start(int(sp))
+	}
+	if c.Footprint != nil {
+		c.Footprint.start(int(sp))
+		defer c.footprintDone()
+	}
+
+	c.stop = false
+	c.callStack = []StackFrame{}
+	c.pushFrame(address, 0, "")
+	c.pushAddress(callSentinel - 1)
+	c.PC = address
+
+	res := &CallResult{Outputs: map[string][]byte{}, StackDepth: 2}
+	start := time.Now()
+	ticks := c.ticks
+
+	for !(c.PC == callSentinel && c.SP == sp) {
+		if int64(c.ticks-ticks) >= limit {
+			err = fmt.Errorf("Instruction limit of %d reached at $%04X", limit, c.PC)
+			break
+		}
+
+		if err = c.tick(); err != nil {
+			break
+		}
+
+		if c.stop {
+			err = fmt.Errorf("Halt received")
+			break
+		}
+
+		if depth := int(sp) - int(c.SP); depth > res.StackDepth {
+			res.StackDepth = depth
+		}
+	}
+
+	res.Duration = time.Since(start)
+	res.Instructions = c.ticks - ticks
+	res.A, res.X, res.Y = c.A, c.X, c.Y
+	res.SP, res.Phlags, res.PC = c.SP, c.Phlags, c.PC
+
+	for _, out := range args.Outputs {
+		addr, lerr := c.Location(out)
+		if lerr != nil {
+			if err == nil {
+				err = lerr
+			}
+			continue
+		}
+
+		data := []byte{}
+		for i := 0; i < c.labelSize(out); i++ {
+			data = append(data, c.memory.ReadByte(addr+uint16(i)))
+		}
+		res.Outputs[out] = data
+	}
+
+	return res, err
+}
+
+func (c *Core) setArgs(values map[string]interface{}) error {
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var reg *uint8
+		// Case matters.  cc65's "sp" is a label.
+		switch name {
+		case "A":
+			reg = &c.A
+		case "X":
+			reg = &c.X
+		case "Y":
+			reg = &c.Y
+		case "SP":
+			reg = &c.SP
+		case "P":
+			reg = &c.Phlags
+		}
+
+		data, err := argBytes(values[name])
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+
+		if reg != nil {
+			if len(data) != 1 {
+				return fmt.Errorf("%s: registers take a single byte", name)
+			}
+			*reg = data[0]
+			continue
+		}
+
+		addr, err := c.Location(name)
+		if err != nil {
+			return err
+		}
+		for i, b := range data {
+			c.WriteByte(addr+uint16(i), b)
+		}
+	}
+	return nil
+}
+
+func argBytes(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case uint8:
+		return []byte{v}, nil
+	case int:
+		if v < 0 || v > 0xFF {
+			return nil, fmt.Errorf("%d doesn't fit in a byte", v)
+		}
+		return []byte{uint8(v)}, nil
+	case uint16:
+		return []byte{uint8(v), uint8(v >> 8)}, nil
+	case []byte:
+		return v, nil
+	}
+	return nil, fmt.Errorf("unsupported type %T", value)
+}
+
+// labelSize is the size of the data at a label, or 1 if it isn't known.
+func (c *Core) labelSize(name string) int {
+	if c.Symbols != nil {
+		if sym, err := c.Symbols.GetSymbol(name); err == nil && sym.Size > 0 {
+			return int(sym.Size)
+		}
+	}
+
+	addr, t := c.memory.FindLabel(name)
+	if t != labels.NesOpenBus {
+		if lbl := c.memory.Labels(t)[addr]; lbl != nil && lbl.Name == name && lbl.Size > 0 {
+			return int(lbl.Size)
+		}
+	}
+	return 1
+}
+
+// Location parses "$1234", "0x1234", "label" or "label+offset".
+func (c *Core) Location(loc string) (uint16, error) {
+	loc = strings.TrimSpace(loc)
+	if loc == "" {
+		return 0, fmt.Errorf("Empty location")
+	}
+
+	if addr, ok := ParseAddress(loc); ok {
+		return addr, nil
+	}
+
+	name, offset := loc, uint16(0)
+	if idx := strings.LastIndex(loc, "+"); idx > 0 {
+		off, ok := ParseAddress(strings.TrimSpace(loc[idx+1:]))
+		if !ok {
+			return 0, fmt.Errorf("Invalid offset in %q", loc)
+		}
+		name, offset = strings.TrimSpace(loc[:idx]), off
+	}
+
+	addr, err := c.LabelAddress(name)
+	if err != nil {
+		return 0, err
+	}
+	return addr + offset, nil
+}
+
+// ParseAddress parses a 16-bit number written as "$1234", "0x1234" or
+// decimal.
+func ParseAddress(s string) (uint16, bool) {
+	var v uint64
+	var err error
+	switch {
+	case strings.HasPrefix(s, "$"):
+		v, err = strconv.ParseUint(s[1:], 16, 16)
+	case strings.HasPrefix(s, "0x"), strings.HasPrefix(s, "0X"):
+		v, err = strconv.ParseUint(s[2:], 16, 16)
+	default:
+		v, err = strconv.ParseUint(s, 10, 16)
+	}
+	return uint16(v), err == nil
+}
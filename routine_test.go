@@ -0,0 +1,121 @@
+package emu
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zorchenhimer/emu-6502/labels"
+	"github.com/zorchenhimer/emu-6502/mappers"
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+func newRoutineCore(t *testing.T) *Core {
+	rom := make([]byte, 0x8000)
+	code := map[uint16][]byte{
+		// Sum: Result = A + X, INY in Helper
+		0x8000: {0x86, 0x10, 0x18, 0x65, 0x10, 0x85, 0x20, 0xA9, 0x00, 0x2A, 0x85, 0x21, 0x20, 0x20, 0x80, 0x60},
+		0x8020: {0xC8, 0x60},
+
+		// Tricky: jumps to Target with an RTS
+		0x8030: {0xA9, 0x80, 0x48, 0xA9, 0x3F, 0x48, 0x60},
+		0x8040: {0xA2, 0x42, 0x60},
+
+		// Loop: JMP Loop
+		0x8050: {0x4C, 0x50, 0x80},
+	}
+	for addr, data := range code {
+		copy(rom[addr-0x8000:], data)
+	}
+
+	mapper, err := mappers.NewNROM(rom, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lbls := labels.Set{}
+	for addr, name := range map[uint]string{0x8000: "Sum", 0x8020: "Helper", 0x8030: "Tricky", 0x8040: "Target", 0x8050: "Loop", 0x10: "Tmp"} {
+		lbls.Add(labels.NesMemory, addr, &labels.Label{Name: name})
+	}
+	lbls.Add(labels.NesMemory, 0x20, &labels.Label{Name: "Result", Size: 2})
+
+	nes := mmu.NewNES(mapper)
+	nes.SetLabels(lbls)
+	c := NewCore(nes)
+	c.SP = 0xFF
+	return c
+}
+
+func TestCallRoutine(t *testing.T) {
+	c := newRoutineCore(t)
+
+	res, err := c.CallRoutine("Sum", Args{
+		Values:  map[string]interface{}{"A": 0xF0, "X": uint8(0x20), "Y": 7, "Result": uint16(0xFFFF)},
+		Outputs: []string{"Result", "Tmp", "Result+1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Word("Result") != 0x0110 || len(res.Outputs["Result"]) != 2 {
+		t.Errorf("expected Result $0110, got %v", res.Outputs["Result"])
+	}
+	if res.Byte("Tmp") != 0x20 || res.Byte("Result+1") != 0x01 {
+		t.Errorf("unexpected outputs: %v", res.Outputs)
+	}
+	if res.Y != 8 || res.SP != 0xFF || res.PC != callSentinel {
+		t.Errorf("unexpected registers: Y:%02X SP:%02X PC:%04X", res.Y, res.SP, res.PC)
+	}
+	if res.Instructions != 11 || res.StackDepth != 4 {
+		t.Errorf("expected 11 instructions and 4 bytes of stack, got %d and %d", res.Instructions, res.StackDepth)
+	}
+
+	// RunRoutine would stop at the first RTS.  Entered with the stack
+	// partly used.
+	res, err = c.CallRoutine("Tricky", Args{Values: map[string]interface{}{"SP": 0xE0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.X != 0x42 || res.SP != 0xE0 {
+		t.Errorf("expected X $42 and SP $E0, got X:%02X SP:%02X", res.X, res.SP)
+	}
+
+	res, err = c.CallRoutine("Loop", Args{Limit: 100})
+	if err == nil || !strings.Contains(err.Error(), "limit") || res.Instructions != 100 {
+		t.Errorf("expected the instruction limit after 100 instructions, got %v", err)
+	}
+
+	for _, args := range []Args{
+		{Values: map[string]interface{}{"A": 0x100}},
+		{Values: map[string]interface{}{"X": uint16(1)}},
+		{Values: map[string]interface{}{"Nowhere": 1}},
+		{Values: map[string]interface{}{"Tmp": "text"}},
+	} {
+		if _, err := c.CallRoutine("Sum", args); err == nil {
+			t.Errorf("expected an error for %v", args.Values)
+		}
+	}
+
+	if _, err := c.CallRoutine("Missing", Args{}); err == nil {
+		t.Errorf("expected an error for a missing routine")
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := map[string]uint16{
+		"$C000":  0xC000,
+		"0x0300": 0x0300,
+		"0X10":   0x0010,
+		"512":    0x0200,
+	}
+	for s, expect := range tests {
+		if addr, ok := ParseAddress(s); !ok || addr != expect {
+			t.Errorf("%q: expected $%04X, got $%04X %t", s, expect, addr, ok)
+		}
+	}
+
+	for _, s := range []string{"", "$", "$10000", "65536", "Reset"} {
+		if _, ok := ParseAddress(s); ok {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package emu
+
+import (
+	"fmt"
+
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+// Snapshot is the state of the CPU, RAM, WRAM and mapper at some point.  It
+// can only be restored to the core it came from, or a clone of that core.
+type Snapshot struct {
+	A, X, Y uint8
+	PC      uint16
+	Phlags  uint8
+	SP      uint8
+
+	ticks        uint64
+	callStack    []StackFrame
+	runRoutine   bool
+	routineDepth int
+	memory       interface{}
+}
+
+// Snapshot saves the machine state in memory.  Breakpoints, history,
+// diagnostics and disassembly aren't part of it.  The memory manager must
+// implement mmu.StateSaver.
+func (c *Core) Snapshot() (*Snapshot, error) {
+	saver, ok := c.memory.(mmu.StateSaver)
+	if !ok {
+		return nil, fmt.Errorf("Memory manager doesn't support snapshots")
+	}
+
+	return &Snapshot{
+		A:      c.A,
+		X:      c.X,
+		Y:      c.Y,
+		PC:     c.PC,
+		Phlags: c.Phlags,
+		SP:     c.SP,
+
+		ticks:        c.ticks,
+		callStack:    append([]StackFrame{}, c.callStack...),
+		runRoutine:   c.runRoutine,
+		routineDepth: c.routineDepth,
+		memory:       saver.GetState(),
+	}, nil
+}
+
+// Restore puts the machine back the way it was when the snapshot was taken.
+// The snapshot isn't changed, so it can be restored any number of times.
+func (c *Core) Restore(s *Snapshot) error {
+	saver, ok := c.memory.(mmu.StateSaver)
+	if !ok {
+		return fmt.Errorf("Memory manager doesn't support snapshots")
+	}
+
+	if err := saver.SetState(s.memory); err != nil {
+		return err
+	}
+
+	c.A = s.A
+	c.X = s.X
+	c.Y = s.Y
+	c.PC = s.PC
+	c.Phlags = s.Phlags
+	c.SP = s.SP
+
+	c.ticks = s.ticks
+	c.callStack = append(c.callStack[:0], s.callStack...)
+	c.runRoutine = s.runRoutine
+	c.routineDepth = s.routineDepth
+	c.stop = false
+	c.lastSame = 0
+	return nil
+}
@@ -0,0 +1,151 @@
+package emu
+
+import (
+	"testing"
+
+	"github.com/zorchenhimer/emu-6502/mappers"
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+func newSnapshotCore(t testing.TB) (*Core, *mappers.MMC1) {
+	mapper, err := mappers.NewMMC1(make([]byte, 0x20000), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewCore(mmu.NewNES(mapper)), mapper.(*mappers.MMC1)
+}
+
+func TestSnapshot(t *testing.T) {
+	c, mmc1 := newSnapshotCore(t)
+	c.A, c.X, c.Y, c.SP, c.PC = 0x01, 0x02, 0x03, 0xF0, 0xC123
+	c.WriteByte(0x0010, 0xAA)
+	c.WriteByte(0x6010, 0xBB)
+	mmc1.PrgBank = 2
+
+	// As if it was taken two JSRs into RunRoutine()
+	c.runRoutine, c.routineDepth = true, 2
+	c.callStack = []StackFrame{{Routine: 0xC000}, {Routine: 0xC100}, {Routine: 0xC123}}
+
+	snap, err := c.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		c.A, c.X, c.Y, c.SP, c.PC = 0xFF, 0xFF, 0xFF, 0x00, 0x0000
+		c.WriteByte(0x0010, 0x11)
+		c.WriteByte(0x6010, 0x22)
+		c.WriteByte(0x0020, 0x33)
+		mmc1.PrgBank = 5
+		c.runRoutine, c.routineDepth = false, -1
+		c.callStack = c.callStack[:1]
+
+		if err := c.Restore(snap); err != nil {
+			t.Fatal(err)
+		}
+
+		if r := c.regs(); r != (regs{0x01, 0x02, 0x03, 0xF0, 0x00}) || c.PC != 0xC123 {
+			t.Errorf("restore %d: wrong registers: %s PC:%04X", i, r, c.PC)
+		}
+
+		for addr, val := range map[uint16]uint8{0x0010: 0xAA, 0x6010: 0xBB, 0x0020: 0x00} {
+			if got := c.ReadByte(addr); got != val {
+				t.Errorf("restore %d: $%04X: expected $%02X, got $%02X", i, addr, val, got)
+			}
+		}
+
+		if mmc1.PrgBank != 2 {
+			t.Errorf("restore %d: expected PRG bank 2, got %d", i, mmc1.PrgBank)
+		}
+
+		if !c.runRoutine || c.routineDepth != 2 || len(c.callStack) != 3 {
+			t.Errorf("restore %d: expected to be two calls into a routine, got %t %d %v", i, c.runRoutine, c.routineDepth, c.callStack)
+		}
+	}
+
+	// Clones share the snapshot's origin.
+	clone := c.Clone()
+	clone.WriteByte(0x0010, 0x44)
+	if err := clone.Restore(snap); err != nil {
+		t.Fatal(err)
+	}
+	if got := clone.ReadByte(0x0010); got != 0xAA {
+		t.Errorf("clone: expected $AA after restore, got $%02X", got)
+	}
+
+	other, _ := newBusCore()
+	if _, err := other.Snapshot(); err == nil {
+		t.Errorf("expected an error from a manager without snapshots")
+	}
+
+	// Mapper states go back in as the pointers they come out as.
+	nrom, err := mappers.NewNROM(make([]byte, 0x8000), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nrom.SetState(nrom.GetState()); err != nil {
+		t.Errorf("NROM: %v", err)
+	}
+}
+
+// A reset after switching banks, with or without a restore in between, looks
+// the same as a new core.
+func TestHardResetMapper(t *testing.T) {
+	fresh, _ := newSnapshotCore(t)
+	c, mmc1 := newSnapshotCore(t)
+
+	// Write $0F to the control register, then bank 5.  MMC1 registers are
+	// written one bit at a time.
+	for _, write := range []struct {
+		address uint16
+		value   uint8
+	}{{0x8000, 0x0F}, {0xE000, 0x05}} {
+		for i := uint(0); i < 5; i++ {
+			c.WriteByte(write.address, (write.value>>i)&1)
+		}
+	}
+	if mmc1.PrgBankMode != 3 || mmc1.PrgBank != 5 || mmc1.Mirroring != 3 {
+		t.Fatalf("bank switch didn't happen: %s", mmc1.State())
+	}
+
+	snap, err := c.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		c.HardReset()
+		if mmc1.State() != fresh.memory.(mapperOwner).Mapper().State() || mmc1.Mirroring != 0 {
+			t.Errorf("reset %d: expected the power on mapper state, got %s", i, mmc1.State())
+		}
+		if offset, _ := c.memory.(mmu.PrgMapper).PrgOffset(0x8000); offset != 0 {
+			t.Errorf("reset %d: expected bank 0 at $8000, got PRG $%05X", i, offset)
+		}
+
+		if err := c.Restore(snap); err != nil {
+			t.Fatal(err)
+		}
+		if mmc1.PrgBank != 5 {
+			t.Errorf("restore %d: expected bank 5, got %d", i, mmc1.PrgBank)
+		}
+	}
+}
+
+func BenchmarkRestore(b *testing.B) {
+	c, _ := newSnapshotCore(b)
+	snap, err := c.Snapshot()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Restore(snap)
+	}
+}
+
+func BenchmarkNewCore(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		newSnapshotCore(b)
+	}
+}
@@ -0,0 +1,158 @@
+package emu
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+// Most instructions to run while looking for the next source line before
+// giving up.  Catches code that never reaches another line (eg, JMP *).
+const maxLineSteps int = 1000000
+
+// UseSymbols sets the debug info used for source level debugging and
+// replaces the memory manager's labels with it.
+func (c *Core) UseSymbols(sym *Symbols) {
+	c.Symbols = sym
+	c.memory.SetLabels(sym)
+}
+
+// Step runs a single instruction.
+func (c *Core) Step() error {
+	if c.DebugFile != nil {
+		c.Debug = true
+	}
+	return c.tick()
+}
+
+// CurrentLine returns the source line for the current PC, or nil if there
+// isn't one.
+func (c *Core) CurrentLine() *LineRecord {
+	if c.Symbols == nil {
+		return nil
+	}
+	return c.lineAt(c.PC)
+}
+
+// lineAt finds the source line for a CPU address.  ROM addresses are looked
+// up by PRG offset so banked code gets the line from the bank that's mapped
+// in.
+func (c *Core) lineAt(address uint16) *LineRecord {
+	if pm, ok := c.memory.(mmu.PrgMapper); ok {
+		if offset, ok := pm.PrgOffset(address); ok {
+			if line := c.Symbols.LineAtPrg(offset); line != nil {
+				return line
+			}
+		}
+	}
+	return c.Symbols.LineAt(address)
+}
+
+// StepLine runs until the PC is on a different source line, stepping into
+// subroutines.  Code without line info (eg, library code without debug info)
+// is run through.
+func (c *Core) StepLine() error {
+	return c.stepLine(-1)
+}
+
+// NextLine is like StepLine, but JSRs and interrupts are run until they
+// return.
+func (c *Core) NextLine() error {
+	return c.stepLine(len(c.callStack))
+}
+
+func (c *Core) stepLine(depth int) error {
+	if c.Symbols == nil {
+		return fmt.Errorf("No debug symbols loaded")
+	}
+
+	start := c.CurrentLine()
+	return c.runUntil(func() bool {
+		if depth > -1 && len(c.callStack) > depth {
+			return false
+		}
+
+		line := c.CurrentLine()
+		return line != nil && !sameLine(line, start)
+	})
+}
+
+// RunToLine runs until the PC is at the start of code generated by the
+// given source line.  File names are matched the same way as
+// Symbols.FindFile().
+func (c *Core) RunToLine(file string, line int) error {
+	if c.Symbols == nil {
+		return fmt.Errorf("No debug symbols loaded")
+	}
+
+	spans, err := c.Symbols.SpansForLine(file, line)
+	if err != nil {
+		return err
+	}
+
+	if len(spans) == 0 {
+		return fmt.Errorf("No code at %s:%d", file, line)
+	}
+
+	targets := map[uint16]bool{}
+	for _, span := range spans {
+		targets[span.Address()] = true
+	}
+
+	// Already being on the line doesn't count.
+	first := true
+	return c.runUntil(func() bool {
+		if first {
+			first = false
+			return false
+		}
+		return targets[c.PC]
+	})
+}
+
+// runUntil steps until done returns true.  done is called before each
+// instruction.
+func (c *Core) runUntil(done func() bool) error {
+	// Let a Halt() from an earlier run (eg, a breakpoint) be stepped past.
+	c.stop = false
+
+	for i := 0; i < maxLineSteps; i++ {
+		if done() {
+			return nil
+		}
+
+		if err := c.Step(); err != nil {
+			return err
+		}
+
+		if c.stop {
+			return fmt.Errorf("Halt received")
+		}
+	}
+
+	return fmt.Errorf("No new source line after %d instructions ($%04X)", maxLineSteps, c.PC)
+}
+
+// sameLine returns true if both records are the same line in the same file.
+// Different records can point to the same line (eg, a line in a macro that
+// is used more than once).
+func sameLine(a, b *LineRecord) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.File == b.File && a.Line == b.Line
+}
+
+// sourcePrefix is the "file.s:123  source" prefix for trace lines.
+func (c *Core) sourcePrefix(address uint16) string {
+	loc, text := "", ""
+	if line := c.lineAt(address); line != nil {
+		loc = fmt.Sprintf("%s:%d", filepath.Base(line.File.Name), line.Line)
+		text, _ = c.Symbols.SourceText(line)
+		text = strings.TrimSpace(strings.Replace(text, "\t", " ", -1))
+	}
+
+	return fmt.Sprintf("%-16s %-24s | ", loc, text)
+}
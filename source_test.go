@@ -0,0 +1,144 @@
+package emu
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/zorchenhimer/emu-6502/mappers"
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+// Assembled testdata/src, matching testdata/game.dbg
+var gameCode = []byte{
+	0x78, 0xD8, 0xA2, 0xFF, 0x9A, // Reset
+	0x2C, 0x02, 0x20, 0x10, 0xFB, // @wait
+	0xA9, 0x05, 0x85, 0x00,
+	0x20, 0x17, 0xC0, 0xE6, 0x00, 0x4C, 0x0E, 0xC0, // loop
+	0x40,                               // NMI
+	0x2C, 0x02, 0x20, 0x10, 0xFB, 0x60, // Wait
+}
+
+func newSourceCore(t *testing.T) *Core {
+	rom := make([]byte, 0x4000)
+	copy(rom, gameCode)
+	copy(rom[0x3FFA:], []byte{0x16, 0xC0, 0x00, 0xC0, 0x00, 0x00})
+
+	mapper, err := mappers.NewNROM(rom, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCore(mmu.NewNES(mapper))
+	c.UseSymbols(loadTestSymbols(t))
+
+	// There's no PPU, so pretend vblank is always set.
+	vblank := func(c *Core, event uint8, value uint8) { c.Phlags |= FLAG_NEGATIVE }
+	c.Breakpoints.Register(EXECUTE, "vblank", 0xC008, vblank)
+	c.Breakpoints.Register(EXECUTE, "vblank", 0xC01A, vblank)
+	return c
+}
+
+func TestSourceStepping(t *testing.T) {
+	c := newSourceCore(t)
+
+	steps := []struct {
+		name   string
+		step   func() error
+		expect string
+		pc     uint16
+	}{
+		{"step", c.StepLine, "src/main.s:12", 0xC001},
+		{"run to", func() error { return c.RunToLine("main.s", 25) }, "src/main.s:25", 0xC00E},
+		{"step into", c.StepLine, "src/util.s:6", 0xC017},
+		{"step out", func() error { return c.RunToLine("main.s", 26) }, "src/main.s:26", 0xC011},
+		{"step macro", c.StepLine, "src/main.s:27", 0xC013},
+		{"step jmp", c.StepLine, "src/main.s:25", 0xC00E},
+		{"next", c.NextLine, "src/main.s:26", 0xC011},
+	}
+
+	for _, st := range steps {
+		if err := st.step(); err != nil {
+			t.Fatalf("%s: %v", st.name, err)
+		}
+
+		line := c.CurrentLine()
+		if line == nil || line.String() != st.expect || c.PC != st.pc {
+			t.Fatalf("%s: expected %s ($%04X), got %v ($%04X)", st.name, st.expect, st.pc, line, c.PC)
+		}
+	}
+
+	if len(c.Backtrace()) != 0 {
+		t.Errorf("NextLine() should return from Wait: %v", c.Backtrace())
+	}
+
+	if err := c.RunToLine("main.s", 19); err == nil {
+		t.Errorf("expected an error running to a line without code")
+	}
+}
+
+func TestSourceTrace(t *testing.T) {
+	c := newSourceCore(t)
+
+	buf := &bytes.Buffer{}
+	c.DebugFile = buf
+	c.TraceSource = true
+
+	if err := c.RunToLine("util.s", 6); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 9 {
+		t.Fatalf("expected 9 trace lines, got %d:\n%s", len(lines), buf.String())
+	}
+
+	expect := []string{
+		"main.s:11        sei",
+		"main.s:16        bit PPUSTATUS",
+		"main.s:25        jsr Wait",
+		"JSR Wait",
+		"BPL Reset@wait",
+	}
+	for _, e := range expect {
+		if !strings.Contains(buf.String(), e) {
+			t.Errorf("expected %q in trace:\n%s", e, buf.String())
+		}
+	}
+}
+
+// Both banks have code at $8000, so lines have to come from the bank that's
+// mapped in.
+func TestBankedSourceLines(t *testing.T) {
+	c, mmc1 := newBankedCore(t)
+
+	tests := []struct {
+		bank    uint8
+		address uint16
+		expect  string
+	}{
+		{0, 0x8000, "banked/bank0.s:5"},
+		{0, 0x8002, "banked/bank0.s:6"},
+		{1, 0x8000, "banked/bank1.s:5"},
+		{1, 0x8002, "banked/bank1.s:10"},
+		{2, 0x8000, "<nil>"},
+		{1, 0xC003, "banked/fixed.s:11"},
+	}
+
+	for _, tc := range tests {
+		mmc1.PrgBank = tc.bank
+		c.PC = tc.address
+		if line := c.CurrentLine(); fmt.Sprint(line) != tc.expect {
+			t.Errorf("bank %d $%04X: expected %s, got %v", tc.bank, tc.address, tc.expect, line)
+		}
+	}
+
+	// Without a mapper there's no telling which bank it is.
+	if line := c.Symbols.LineAt(0x8000); line != nil {
+		t.Errorf("expected no line for banked code by CPU address, got %s", line)
+	}
+	if line := c.Symbols.LineAtPrg(0x4000); fmt.Sprint(line) != "banked/bank1.s:5" {
+		t.Errorf("expected bank1.s:5 at PRG $04000, got %v", line)
+	}
+}
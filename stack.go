@@ -0,0 +1,248 @@
+package emu
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// StackCheck watches the hardware stack for wrapping, growing into a
+// reserved part of page 1, and pulling more bytes than were pushed.  It also
+// keeps track of the deepest the stack got in each routine and interrupt
+// handler.
+type StackCheck struct {
+	// Parts of page 1 the stack must never grow into (eg, $0100-$013F for
+	// buffers).
+	Reserved []AddressRange
+
+	// If not nil, each diagnostic is written here as it happens.
+	Output io.Writer
+
+	Diagnostics []Diagnostic
+
+	// High-water marks, keyed by routine address and by interrupt name.
+	// These include the usage of any routines called from them.
+	Routines   map[uint16]*StackUsage
+	Interrupts map[string]*StackUsage
+
+	// Deepest the stack got overall, in bytes.
+	MaxDepth int
+
+	// Stack pointer value that is considered an empty stack.  This is an
+	// int because RunRoutine() places it above $FF when SP is near the top.
+	base int
+
+	// The two bytes below base are RunRoutine()'s pretend return address.
+	// Only the routine's final RTS may pull them.
+	returnAddr bool
+}
+
+type StackUsage struct {
+	Calls    int
+	MaxDepth int // in bytes below the empty stack
+}
+
+func (sc *StackCheck) depth(sp uint8) int {
+	return sc.base - int(sp)
+}
+
+// start is called at the beginning of Run() and CallRoutine().
+func (sc *StackCheck) start(base int) {
+	sc.base = base
+	sc.returnAddr = false
+	if sc.Routines == nil {
+		sc.Routines = make(map[uint16]*StackUsage)
+	}
+	if sc.Interrupts == nil {
+		sc.Interrupts = make(map[string]*StackUsage)
+	}
+}
+
+// startRoutine is called at the beginning of RunRoutine().  The final RTS
+// pulls a return address that was never pushed, so the base goes above it.
+func (sc *StackCheck) startRoutine(sp uint8) {
+	sc.start(int(sp) + 2)
+	sc.returnAddr = true
+}
+
+// moved is called on TXS.  Moving SP above the current base starts a new
+// stack; eg, the LDX #$FF TXS at the start of a reset handler.
+func (sc *StackCheck) moved(sp uint8) {
+	if int(sp) > sc.base {
+		sc.base = int(sp)
+		sc.returnAddr = false
+	}
+}
+
+func (sc *StackCheck) usage(frame StackFrame) *StackUsage {
+	var u *StackUsage
+	var ok bool
+	if frame.Interrupt != "" {
+		if u, ok = sc.Interrupts[frame.Interrupt]; !ok {
+			u = &StackUsage{}
+			sc.Interrupts[frame.Interrupt] = u
+		}
+	} else {
+		if u, ok = sc.Routines[frame.Routine]; !ok {
+			u = &StackUsage{}
+			sc.Routines[frame.Routine] = u
+		}
+	}
+	return u
+}
+
+// frameDone records the high-water mark of a frame that has returned.
+func (sc *StackCheck) frameDone(frame StackFrame) {
+	u := sc.usage(frame)
+	u.Calls++
+	if frame.maxDepth > u.MaxDepth {
+		u.MaxDepth = frame.maxDepth
+	}
+}
+
+func (c *Core) stackDiagnostic(kind, message string) {
+	d := c.newDiagnostic(kind, uint16(c.SP)|0x0100, message)
+	c.StackCheck.Diagnostics = append(c.StackCheck.Diagnostics, d)
+	c.writeDiagnostic(c.StackCheck.Output, d)
+}
+
+// checkPush is called before a byte is pushed.
+func (c *Core) checkPush() {
+	sc := c.StackCheck
+	if c.SP == 0x00 {
+		c.stackDiagnostic("stack overflow", "SP wrapped from $00 to $FF")
+	}
+
+	if inRanges(sc.Reserved, uint16(c.SP)|0x0100) {
+		c.stackDiagnostic("stack collision", "push into reserved region")
+	}
+}
+
+// checkPull is called before a byte is pulled.
+func (c *Core) checkPull() {
+	sc := c.StackCheck
+	limit := sc.base
+	if sc.returnAddr && !(c.runRoutine && c.routineDepth < 0) {
+		limit -= 2
+	}
+
+	if int(c.SP) < limit {
+		// Only RunRoutine()'s pretend return address puts the base above
+		// $FF.  Pulling it wraps SP, so the base wraps with it.
+		if c.SP == 0xFF {
+			sc.base -= 0x100
+		}
+		return
+	}
+
+	if c.SP == 0xFF {
+		c.stackDiagnostic("stack underflow", "SP wrapped from $FF to $00")
+	} else {
+		c.stackDiagnostic("stack underflow", "pulled more bytes than were pushed")
+	}
+
+	// Only report each missing byte once.  Otherwise the final RTS gets
+	// blamed for it again.
+	sc.base++
+}
+
+// pushed is called after a byte is pushed to update the high-water marks.
+func (c *Core) pushed() {
+	sc := c.StackCheck
+	depth := sc.depth(c.SP)
+	if depth > sc.MaxDepth {
+		sc.MaxDepth = depth
+	}
+
+	if len(c.callStack) > 0 {
+		top := &c.callStack[len(c.callStack)-1]
+		if depth > top.maxDepth {
+			top.maxDepth = depth
+		}
+	}
+}
+
+// StackReport writes the high-water marks for every routine and interrupt
+// seen so far, deepest first.  Routines that haven't returned yet are
+// included.
+func (c *Core) StackReport(w io.Writer) error {
+	sc := c.StackCheck
+	if sc == nil {
+		return fmt.Errorf("StackCheck is not enabled")
+	}
+
+	// Copy the finished routines and fold in the ones still running.
+	routines := map[uint16]StackUsage{}
+	for addr, u := range sc.Routines {
+		routines[addr] = *u
+	}
+	interrupts := map[string]StackUsage{}
+	for name, u := range sc.Interrupts {
+		interrupts[name] = *u
+	}
+
+	for _, frame := range c.callStack {
+		var u StackUsage
+		if frame.Interrupt != "" {
+			u = interrupts[frame.Interrupt]
+		} else {
+			u = routines[frame.Routine]
+		}
+
+		if frame.maxDepth > u.MaxDepth {
+			u.MaxDepth = frame.maxDepth
+		}
+
+		if frame.Interrupt != "" {
+			interrupts[frame.Interrupt] = u
+		} else {
+			routines[frame.Routine] = u
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "Max stack depth: %d bytes\n", sc.MaxDepth)
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for name := range interrupts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if interrupts[names[i]].MaxDepth == interrupts[names[j]].MaxDepth {
+			return names[i] < names[j]
+		}
+		return interrupts[names[i]].MaxDepth > interrupts[names[j]].MaxDepth
+	})
+
+	for _, name := range names {
+		u := interrupts[name]
+		_, err = fmt.Fprintf(w, "%5d bytes  %-30s calls: %d\n", u.MaxDepth, "["+name+"]", u.Calls)
+		if err != nil {
+			return err
+		}
+	}
+
+	addrs := []uint16{}
+	for addr := range routines {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		if routines[addrs[i]].MaxDepth == routines[addrs[j]].MaxDepth {
+			return addrs[i] < addrs[j]
+		}
+		return routines[addrs[i]].MaxDepth > routines[addrs[j]].MaxDepth
+	})
+
+	for _, addr := range addrs {
+		u := routines[addr]
+		_, err = fmt.Fprintf(w, "%5d bytes  %-30s calls: %d\n", u.MaxDepth,
+			fmt.Sprintf("%s ($%04X)", c.memory.GetLabel(addr), addr), u.Calls)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package emu
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zorchenhimer/emu-6502/labels"
+	"github.com/zorchenhimer/emu-6502/mappers"
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+// newStackCore returns a core with the given code in an NROM image and
+// StackCheck enabled.
+func newStackCore(t *testing.T, sp uint8, code map[uint16][]byte) *Core {
+	t.Helper()
+	rom := make([]byte, 0x8000)
+	for addr, data := range code {
+		copy(rom[addr-0x8000:], data)
+	}
+
+	mapper, err := mappers.NewNROM(rom, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCore(mmu.NewNES(mapper))
+	c.SP = sp
+	c.StackCheck = &StackCheck{}
+	return c
+}
+
+func diagnosticKinds(list []Diagnostic) []string {
+	kinds := []string{}
+	for _, d := range list {
+		kinds = append(kinds, d.Kind)
+	}
+	return kinds
+}
+
+func TestStackCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		sp       uint8
+		reserved []AddressRange
+		code     []byte
+		expect   []string
+	}{
+		// The return address RunRoutine() pretends to push wraps when
+		// SP starts near the top.
+		{"empty $FF", 0xFF, nil, []byte{0x60}, []string{}},
+		{"empty $FE", 0xFE, nil, []byte{0x60}, []string{}},
+
+		// PHA x3, PLA x3, RTS.  The pulls after the overflow wrap back.
+		{"overflow", 0x02, nil, []byte{0x48, 0x48, 0x48, 0x68, 0x68, 0x68, 0x60},
+			[]string{"stack overflow", "stack underflow"}},
+
+		// PLA, RTS
+		{"extra pull", 0xFF, nil, []byte{0x68, 0x60}, []string{"stack underflow"}},
+		{"extra pull low", 0x80, nil, []byte{0x68, 0x60}, []string{"stack underflow"}},
+
+		// PHA x2, PLA x2, RTS
+		{"reserved", 0xF0, []AddressRange{{0x0100, 0x01EF}}, []byte{0x48, 0x48, 0x68, 0x68, 0x60},
+			[]string{"stack collision"}},
+
+		// LDX #$F0, TXS, PHA, PLA, LDX #$80, TXS, RTS.  Moving SP up
+		// starts a new stack, so the pulls after it aren't underflows.
+		{"txs", 0x80, nil, []byte{0xA2, 0xF0, 0x9A, 0x48, 0x68, 0xA2, 0x80, 0x9A, 0x60}, []string{}},
+	}
+
+	for _, tc := range tests {
+		c := newStackCore(t, tc.sp, map[uint16][]byte{0x8000: tc.code})
+		c.StackCheck.Reserved = tc.reserved
+
+		if err := c.RunRoutine(0x8000); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+
+		kinds := diagnosticKinds(c.StackCheck.Diagnostics)
+		if len(kinds) != len(tc.expect) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expect, c.StackCheck.Diagnostics)
+			continue
+		}
+		for i := range kinds {
+			if kinds[i] != tc.expect[i] {
+				t.Errorf("%s: expected %v, got %v", tc.name, tc.expect, c.StackCheck.Diagnostics)
+				break
+			}
+		}
+	}
+
+	// The diagnostic points at the push.
+	c := newStackCore(t, 0x02, map[uint16][]byte{0x8000: {0x48, 0x48, 0x48, 0x68, 0x68, 0x68, 0x60}})
+	c.RunRoutine(0x8000)
+	if d := c.StackCheck.Diagnostics[0]; d.PC != 0x8002 || d.Address != 0x0100 {
+		t.Errorf("unexpected overflow diagnostic: %+v", d)
+	}
+
+	// An extra pull is reported at the pull, not at the final RTS.  NOP,
+	// PLA, RTS
+	for _, sp := range []uint8{0xFF, 0xF0} {
+		c = newStackCore(t, sp, map[uint16][]byte{0x8000: {0xEA, 0x68, 0x60}})
+		c.RunRoutine(0x8000)
+		if len(c.StackCheck.Diagnostics) != 1 || c.StackCheck.Diagnostics[0].PC != 0x8001 {
+			t.Errorf("SP $%02X: expected one underflow at $8001, got %+v", sp, c.StackCheck.Diagnostics)
+		}
+	}
+}
+
+func TestStackReport(t *testing.T) {
+	c := newStackCore(t, 0xFF, map[uint16][]byte{
+		// Main: JSR Sub, BRK, RTS
+		0x8000: {0x20, 0x10, 0x80, 0x00, 0xEA, 0x60},
+		// Sub: PHA x3, PLA x3, RTS
+		0x8010: {0x48, 0x48, 0x48, 0x68, 0x68, 0x68, 0x60},
+		// IRQ: PHA, PLA, RTI
+		0x8020: {0x48, 0x68, 0x40},
+		0xFFFE: {0x20, 0x80},
+	})
+
+	lbls := labels.Set{}
+	lbls.Add(labels.NesMemory, 0x8000, &labels.Label{Name: "Main"})
+	lbls.Add(labels.NesMemory, 0x8010, &labels.Label{Name: "Sub"})
+	c.memory.SetLabels(lbls)
+
+	if err := c.RunRoutine(0x8000); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.StackCheck.Diagnostics) != 0 {
+		t.Errorf("unexpected diagnostics: %v", c.StackCheck.Diagnostics)
+	}
+
+	// Main's return address, then Sub's and three bytes.  The BRK handler
+	// gets three bytes from BRK and one of its own.
+	buf := &bytes.Buffer{}
+	if err := c.StackReport(buf); err != nil {
+		t.Fatal(err)
+	}
+	expect := "Max stack depth: 7 bytes\n" +
+		"    6 bytes  [BRK]                          calls: 1\n" +
+		"    7 bytes  Main ($8000)                   calls: 1\n" +
+		"    7 bytes  Sub ($8010)                    calls: 1\n"
+	if buf.String() != expect {
+		t.Errorf("unexpected report:\n%s\nexpected:\n%s", buf, expect)
+	}
+
+	// Ties are sorted by name so the report doesn't change between runs.
+	c.StackCheck.Interrupts = map[string]*StackUsage{}
+	for _, name := range []string{"NMI", "IRQ", "BRK", "RESET"} {
+		c.StackCheck.Interrupts[name] = &StackUsage{Calls: 1, MaxDepth: 3}
+	}
+	buf.Reset()
+	if err := c.StackReport(buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(buf.String(), "\n")
+	order := []string{}
+	for _, line := range lines[1:5] {
+		order = append(order, strings.Fields(line)[2])
+	}
+	if strings.Join(order, " ") != "[BRK] [IRQ] [NMI] [RESET]" {
+		t.Errorf("expected interrupts sorted by name, got %v", order)
+	}
+}
@@ -0,0 +1,366 @@
+package emu
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zorchenhimer/emu-6502/mappers"
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+// StaticEntry is a starting point for the static disassembler.
+type StaticEntry struct {
+	Name    string
+	Address uint16
+
+	// PRG bank to start in.  -1 uses the bank that is fixed at Address, or
+	// the one that is currently mapped there.
+	Bank int
+}
+
+// StaticInstruction is a single instruction found by the static
+// disassembler.
+type StaticInstruction struct {
+	Address uint16 // CPU address
+	Bank    int
+	Offset  uint // offset in PRG ROM
+
+	OpCode  byte
+	Name    string
+	Mode    AddressModeMeta
+	Size    uint8
+	Operand uint16
+
+	// Destination of branches, JMP and JSR
+	Target    uint16
+	HasTarget bool
+}
+
+func (si *StaticInstruction) String() string {
+	if !strings.Contains(si.Mode.Format, "%s") {
+		return strings.TrimSpace(si.Name + " " + si.Mode.Format)
+	}
+
+	var value string
+	switch {
+	case si.HasTarget, si.Size == 3:
+		value = fmt.Sprintf("$%04X", si.Operand)
+		if si.HasTarget {
+			value = fmt.Sprintf("$%04X", si.Target)
+		}
+	default:
+		value = fmt.Sprintf("$%02X", si.Operand)
+	}
+
+	return si.Name + " " + fmt.Sprintf(si.Mode.Format, value)
+}
+
+// Disassembly converts the instruction for the memory manager.
+func (si *StaticInstruction) Disassembly() *mmu.Disassembly {
+	d := &mmu.Disassembly{
+		OpCode:  si.OpCode,
+		Value:   si.String(),
+		Size:    uint(si.Size),
+		Name:    si.Name,
+		Format:  si.Mode.Format,
+		Operand: si.Operand,
+		Target:  si.HasTarget,
+	}
+
+	if si.HasTarget {
+		d.Operand = si.Target
+	}
+	if si.OpCode == OP_DEBUG {
+		d.Name = ""
+	}
+	return d
+}
+
+// StaticBank holds all the instructions found in a single PRG bank.
+type StaticBank struct {
+	Number int
+	Window mappers.PrgWindow
+
+	// Keyed by PRG ROM offset
+	Instructions map[uint]*StaticInstruction
+}
+
+// Sorted returns the bank's instructions ordered by address.
+func (sb *StaticBank) Sorted() []*StaticInstruction {
+	lst := []*StaticInstruction{}
+	for _, instr := range sb.Instructions {
+		lst = append(lst, instr)
+	}
+	sort.Slice(lst, func(i, j int) bool { return lst[i].Offset < lst[j].Offset })
+	return lst
+}
+
+// StaticReference is a branch, jump or call that couldn't be followed.
+type StaticReference struct {
+	From   *StaticInstruction
+	Target uint16
+	Reason string
+}
+
+func (sr StaticReference) String() string {
+	return fmt.Sprintf("$%04X [bank %d] %s: %s", sr.From.Address, sr.From.Bank, sr.From, sr.Reason)
+}
+
+type StaticResult struct {
+	Banks      map[int]*StaticBank
+	Unresolved []StaticReference
+}
+
+// StaticDisassembler follows code from the interrupt vectors and any given
+// entry points without executing anything.  Branches, JSR and JMP targets
+// are followed until an RTS, RTI, BRK or JMP.  Jumps into a switchable bank
+// window from a different window can't be followed statically and are
+// returned as unresolved references.
+type StaticDisassembler struct {
+	rom    []byte
+	layout []mappers.PrgWindow
+
+	// Returns the bank that is currently mapped at the given address.  May
+	// be nil.
+	current func(address uint16) int
+}
+
+// NewStaticDisassembler disassembles the given PRG data using the given
+// window layout.
+func NewStaticDisassembler(rom []byte, layout []mappers.PrgWindow) *StaticDisassembler {
+	return &StaticDisassembler{rom: rom, layout: layout}
+}
+
+// NewStaticDisassemblerFromMapper uses the PRG ROM and bank layout of the
+// given mapper.  The mapper's state is only read, never changed.
+func NewStaticDisassemblerFromMapper(m mappers.Mapper) *StaticDisassembler {
+	info := m.Info()
+	rom := make([]byte, info.PrgSize)
+	for i := uint(0); i < info.PrgSize; i++ {
+		rom[i] = m.RomRead(i)
+	}
+
+	sd := NewStaticDisassembler(rom, mappers.PrgLayout(m))
+	sd.current = func(address uint16) int {
+		w := sd.window(address)
+		if w == nil {
+			return -1
+		}
+		return int(uint(m.Offset(address)) / w.Size)
+	}
+	return sd
+}
+
+func (sd *StaticDisassembler) window(address uint16) *mappers.PrgWindow {
+	for i := 0; i < len(sd.layout); i++ {
+		if sd.layout[i].Contains(address) {
+			return &sd.layout[i]
+		}
+	}
+	return nil
+}
+
+// bankFor finds the bank that holds target.  from is the instruction that
+// references it, or nil for entry points.
+func (sd *StaticDisassembler) bankFor(from *StaticInstruction, target uint16) (int, error) {
+	w := sd.window(target)
+	if w == nil {
+		return -1, fmt.Errorf("not in PRG ROM")
+	}
+
+	if w.FixedBank >= 0 {
+		return w.FixedBank, nil
+	}
+
+	if from != nil {
+		if w.Contains(from.Address) {
+			return from.Bank, nil
+		}
+		return -1, fmt.Errorf("target is in a switchable bank")
+	}
+
+	if sd.current != nil {
+		return sd.current(target), nil
+	}
+
+	// Assume the last bank, like most mappers at power-on.
+	return (len(sd.rom) / int(w.Size)) - 1, nil
+}
+
+func (sd *StaticDisassembler) offset(bank int, address uint16) (uint, bool) {
+	w := sd.window(address)
+	if w == nil || bank < 0 {
+		return 0, false
+	}
+
+	offset := uint(bank)*w.Size + uint(address-w.Start)
+	return offset, offset < uint(len(sd.rom))
+}
+
+// Vectors returns the NMI, RESET and IRQ entry points.
+func (sd *StaticDisassembler) Vectors() []StaticEntry {
+	entries := []StaticEntry{}
+	for _, vec := range []uint16{VECTOR_NMI, VECTOR_RESET, VECTOR_IRQ} {
+		bank, err := sd.bankFor(nil, vec)
+		if err != nil {
+			continue
+		}
+
+		offset, ok := sd.offset(bank, vec)
+		if !ok || offset+1 >= uint(len(sd.rom)) {
+			continue
+		}
+
+		entries = append(entries, StaticEntry{
+			Name:    interruptList[vec].Name,
+			Address: uint16(sd.rom[offset]) | uint16(sd.rom[offset+1])<<8,
+			Bank:    -1,
+		})
+	}
+	return entries
+}
+
+type staticTarget struct {
+	address uint16
+	bank    int
+}
+
+// Disassemble follows code from the vectors and the given entry points.
+func (sd *StaticDisassembler) Disassemble(entries ...StaticEntry) *StaticResult {
+	result := &StaticResult{Banks: make(map[int]*StaticBank)}
+
+	// Offset of each byte that is part of a decoded instruction, mapped to
+	// the offset of the start of that instruction.
+	covered := map[uint]uint{}
+
+	queue := []staticTarget{}
+	for _, entry := range append(sd.Vectors(), entries...) {
+		bank := entry.Bank
+		if bank < 0 {
+			var err error
+			bank, err = sd.bankFor(nil, entry.Address)
+			if err != nil {
+				continue
+			}
+		}
+		queue = append(queue, staticTarget{address: entry.Address, bank: bank})
+	}
+
+	follow := func(from *StaticInstruction, target uint16) {
+		bank, err := sd.bankFor(from, target)
+		if err != nil {
+			result.Unresolved = append(result.Unresolved, StaticReference{From: from, Target: target, Reason: err.Error()})
+			return
+		}
+		queue = append(queue, staticTarget{address: target, bank: bank})
+	}
+
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+
+		address := next.address
+		bank := next.bank
+		start := sd.window(address)
+
+		for {
+			// Don't fall through into a different window.
+			w := sd.window(address)
+			if w != start {
+				break
+			}
+
+			offset, ok := sd.offset(bank, address)
+			if !ok {
+				break
+			}
+
+			// Already decoded, or in the middle of another instruction.
+			if _, ok := covered[offset]; ok {
+				break
+			}
+
+			opcode := sd.rom[offset]
+			instr, ok := instructionList[opcode]
+			if !ok || instr == nil {
+				break
+			}
+
+			size := instr.InstrLength()
+			if uint(address-w.Start)+uint(size) > w.Size || offset+uint(size) > uint(len(sd.rom)) {
+				break
+			}
+
+			si := &StaticInstruction{
+				Address: address,
+				Bank:    bank,
+				Offset:  offset,
+				OpCode:  opcode,
+				Name:    instr.Name(),
+				Mode:    instr.AddressMeta(),
+				Size:    size,
+			}
+
+			switch size {
+			case 2:
+				si.Operand = uint16(sd.rom[offset+1])
+			case 3:
+				si.Operand = uint16(sd.rom[offset+1]) | uint16(sd.rom[offset+2])<<8
+			}
+
+			if _, ok := result.Banks[bank]; !ok {
+				result.Banks[bank] = &StaticBank{
+					Number:       bank,
+					Window:       *w,
+					Instructions: make(map[uint]*StaticInstruction),
+				}
+			}
+			result.Banks[bank].Instructions[offset] = si
+			for i := uint(0); i < uint(size); i++ {
+				covered[offset+i] = offset
+			}
+
+			stop := false
+			switch instr.(type) {
+			case Branch:
+				val, negative := TwosCompInv(uint8(si.Operand))
+				si.Target = address + 2 + uint16(val)
+				if negative {
+					si.Target = address + 2 - uint16(val)
+				}
+				si.HasTarget = true
+				follow(si, si.Target)
+
+			case Jump:
+				switch {
+				case opcode == OP_JSR:
+					si.Target = si.Operand
+					si.HasTarget = true
+					follow(si, si.Target)
+
+				case opcode == OP_JMP_AB:
+					si.Target = si.Operand
+					si.HasTarget = true
+					follow(si, si.Target)
+					stop = true
+
+				case opcode == OP_JMP_ID:
+					result.Unresolved = append(result.Unresolved, StaticReference{From: si, Target: si.Operand, Reason: "indirect jump"})
+					stop = true
+
+				default:
+					// RTS, RTI, BRK
+					stop = true
+				}
+			}
+
+			if stop {
+				break
+			}
+			address += uint16(size)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,126 @@
+package emu
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/zorchenhimer/emu-6502/mappers"
+)
+
+// staticAddresses returns the address of every instruction found in a bank.
+func staticAddresses(sb *StaticBank) []uint16 {
+	addrs := []uint16{}
+	if sb == nil {
+		return addrs
+	}
+	for _, instr := range sb.Instructions {
+		addrs = append(addrs, instr.Address)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+func TestStaticDisassembler(t *testing.T) {
+	rom := make([]byte, 0x8000)
+	code := map[uint16][]byte{
+		// Reset: LDX #$00, INX, BNE *-1, JSR Sub, JMP Tail, then data
+		0x8000: {0xA2, 0x00, 0xE8, 0xD0, 0xFD, 0x20, 0x10, 0x80, 0x4C, 0x20, 0x80, 0xFF, 0xFF},
+		// Sub: LDA #$01, RTS, then data
+		0x8010: {0xA9, 0x01, 0x60, 0xFF},
+		// Tail: JMP ($0300), then data
+		0x8020: {0x6C, 0x00, 0x03, 0xFF},
+		// NMI: RTI
+		0x8030: {0x40, 0xFF},
+		// IRQ: BRK
+		0x8040: {0x00, 0xFF},
+		// Extra entry: NOP, RTS
+		0x8050: {0xEA, 0x60, 0xFF},
+		0xFFFA: {0x30, 0x80, 0x00, 0x80, 0x40, 0x80},
+	}
+	for addr, data := range code {
+		copy(rom[addr-0x8000:], data)
+	}
+
+	sd := NewStaticDisassembler(rom, []mappers.PrgWindow{{Start: 0x8000, Size: 0x8000, FixedBank: 0}})
+	res := sd.Disassemble(StaticEntry{Name: "Extra", Address: 0x8050, Bank: -1})
+
+	expect := []uint16{0x8000, 0x8002, 0x8003, 0x8005, 0x8008, 0x8010, 0x8012, 0x8020, 0x8030, 0x8040, 0x8050, 0x8051}
+	if addrs := staticAddresses(res.Banks[0]); !reflect.DeepEqual(addrs, expect) {
+		t.Errorf("expected instructions at %04X, got %04X", expect, addrs)
+	}
+
+	if bne := res.Banks[0].Instructions[0x0003]; bne == nil || !bne.HasTarget || bne.Target != 0x8002 || bne.String() != "BNE $8002" {
+		t.Errorf("unexpected branch: %v", bne)
+	}
+
+	if len(res.Unresolved) != 1 || res.Unresolved[0].From.Address != 0x8020 || res.Unresolved[0].Reason != "indirect jump" {
+		t.Errorf("expected the indirect jump to be unresolved, got %v", res.Unresolved)
+	}
+}
+
+// A 64k MMC1 image with code laid out for PRG modes 2 and 3.
+func newStaticMMC1(t *testing.T) *mappers.MMC1 {
+	rom := make([]byte, 0x10000)
+	code := map[uint][]byte{
+		// Bank 0: RTS
+		0x0000: {0x60},
+		// Bank 1: LDA #$00, BEQ *-2 (taken), RTS
+		0x4000: {0xA9, 0x00, 0xF0, 0xFC, 0x60},
+		// Bank 2 at $C000 in mode 2: JSR $8000, RTS
+		0x8000: {0x20, 0x00, 0x80, 0x60},
+		0xBFFA: {0x03, 0xC0, 0x00, 0xC0, 0x03, 0xC0},
+		// Bank 3 at $C000 in mode 3: JSR $8000, JMP $C010
+		0xC000: {0x20, 0x00, 0x80, 0x4C, 0x10, 0xC0},
+		0xC010: {0x60},
+		0xFFFA: {0x10, 0xC0, 0x00, 0xC0, 0x10, 0xC0},
+	}
+	for offset, data := range code {
+		copy(rom[offset:], data)
+	}
+
+	mapper, err := mappers.NewMMC1(rom, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mapper.(*mappers.MMC1)
+}
+
+func TestStaticDisassemblerBanked(t *testing.T) {
+	mmc1 := newStaticMMC1(t)
+
+	// Mode 3: the last bank is fixed at $C000.  Its JSR into the switchable
+	// bank can't be followed.
+	res := NewStaticDisassemblerFromMapper(mmc1).Disassemble(StaticEntry{Address: 0x8000, Bank: 1})
+
+	if addrs := staticAddresses(res.Banks[3]); !reflect.DeepEqual(addrs, []uint16{0xC000, 0xC003, 0xC010}) {
+		t.Errorf("mode 3: unexpected fixed bank: %04X", addrs)
+	}
+	if addrs := staticAddresses(res.Banks[1]); !reflect.DeepEqual(addrs, []uint16{0x8000, 0x8002, 0x8004}) {
+		t.Errorf("mode 3: unexpected bank 1: %04X", addrs)
+	}
+	if instr := res.Banks[1].Instructions[0x4004]; instr == nil || instr.Address != 0x8004 {
+		t.Errorf("mode 3: expected RTS at PRG $04004, got %v", instr)
+	}
+	if len(res.Unresolved) != 1 || res.Unresolved[0].From.Address != 0xC000 || res.Unresolved[0].Target != 0x8000 {
+		t.Errorf("mode 3: expected the JSR to be unresolved, got %v", res.Unresolved)
+	}
+
+	// Mode 2: bank 0 is fixed at $8000 and bank 2 is mapped at $C000.
+	mmc1.PrgBankMode = 2
+	mmc1.PrgBank = 2
+	res = NewStaticDisassemblerFromMapper(mmc1).Disassemble()
+
+	if addrs := staticAddresses(res.Banks[2]); !reflect.DeepEqual(addrs, []uint16{0xC000, 0xC003}) {
+		t.Errorf("mode 2: unexpected bank 2: %04X", addrs)
+	}
+	if instr := res.Banks[2].Instructions[0x8000]; instr == nil || instr.Address != 0xC000 {
+		t.Errorf("mode 2: expected JSR at PRG $08000, got %v", instr)
+	}
+	if addrs := staticAddresses(res.Banks[0]); !reflect.DeepEqual(addrs, []uint16{0x8000}) {
+		t.Errorf("mode 2: unexpected fixed bank: %04X", addrs)
+	}
+	if len(res.Unresolved) != 0 || len(res.Banks) != 2 {
+		t.Errorf("mode 2: unexpected banks %d or unresolved %v", len(res.Banks), res.Unresolved)
+	}
+}
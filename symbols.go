@@ -6,37 +6,217 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/zorchenhimer/emu-6502/labels"
+)
+
+// Line types in line records
+const (
+	LineAsm   int = 0 // assembly source
+	LineC     int = 1 // C source from cc65
+	LineMacro int = 2 // inside a macro expansion
 )
 
 type SymbolRecord struct {
-	Name string
-	Size uint16 // size of the data (the '.res #' value)
-	AddrSize int // size of the address itself.  either zero page or absolute
-	Value uint16
+	Id   int
+	Name string // full name, eg "Scope::Label" or "Parent@local"
+
+	Size     uint16 // size of the data (the '.res #' value)
+	AddrSize int    // size of the address itself.  either zero page or absolute
+	Value    uint16
+
+	// "lab" for labels, "equ" for constants, "imp" for imports
 	Type string
 
-	Defined *lineRecord
+	Scope   *Scope
+	Segment *Segment
+
+	// Set on cheap locals.  This is the label they belong to.
+	Parent *SymbolRecord
 
-	References []*lineRecord
+	// The exported symbol that an import refers to
+	Export *SymbolRecord
+
+	Defined    *LineRecord
+	References []*LineRecord
 }
 
-type fileRecord struct {
-	Id int
-	ModuleId int
+// IsLabel returns true for symbols that are addresses in a segment.
+func (sr *SymbolRecord) IsLabel() bool {
+	return sr.Type == "lab"
+}
 
+type SourceFile struct {
+	Id   int
 	Name string
 	Size int
-	Mtime int // what is the format of this?
+
+	// Modification time, as a unix timestamp
+	Mtime int64
+
+	Modules []*Module
+}
+
+type Module struct {
+	Id      int
+	Name    string
+	File    *SourceFile // main source file
+	Library *Library    // nil if it wasn't linked from a library
+}
+
+type Library struct {
+	Id   int
+	Name string
+}
+
+type Segment struct {
+	Id       int
+	Name     string
+	Start    int
+	Size     int
+	AddrSize string
+	Type     string // "ro" or "rw"
+
+	// Output file and the offset of the segment in it.  Empty for segments
+	// that aren't written anywhere (eg, BSS).
+	OutputName   string
+	OutputOffset int
+}
+
+func (seg *Segment) Contains(address uint16) bool {
+	return int(address) >= seg.Start && int(address) < seg.Start+seg.Size
+}
+
+// FileOffset returns the offset of the given address in the output file.
+func (seg *Segment) FileOffset(address uint16) (int, bool) {
+	if seg.OutputName == "" || !seg.Contains(address) {
+		return 0, false
+	}
+	return seg.OutputOffset + int(address) - seg.Start, true
+}
+
+// Span is a range of bytes in a segment generated by one or more lines.
+type Span struct {
+	Id      int
+	Segment *Segment
+	Offset  int // from the start of the segment
+	Size    int
+	Type    *TypeRecord // may be nil
+
+	Lines  []*LineRecord
+	Scopes []*Scope
+}
+
+// Address of the first byte of the span.
+func (sp *Span) Address() uint16 {
+	return uint16(sp.Segment.Start + sp.Offset)
+}
+
+func (sp *Span) Contains(address uint16) bool {
+	start := sp.Segment.Start + sp.Offset
+	return int(address) >= start && int(address) < start+sp.Size
+}
+
+type LineRecord struct {
+	Id   int
+	File *SourceFile
+	Line int
+	Type int // LineAsm, LineC or LineMacro
+
+	// Macro nesting level for LineMacro
+	Count int
+
+	Spans []*Span
+}
+
+func (lr *LineRecord) String() string {
+	return fmt.Sprintf("%s:%d", lr.File.Name, lr.Line)
+}
+
+// Scope is a .proc, .scope, .struct, etc.  Each module has a nameless root
+// scope.
+type Scope struct {
+	Id     int
+	Name   string
+	Module *Module
+	Type   string // "scope", "struct", "enum", or empty for the root
+	Size   int
+
+	Parent   *Scope
+	Children []*Scope
+
+	// Label with the same name as the scope for .proc
+	Label *SymbolRecord
+
+	Spans    []*Span
+	Symbols  []*SymbolRecord
+	CSymbols []*CSymbol
+}
+
+// FullName returns the name with all the parent scopes, eg "Outer::Inner".
+func (sc *Scope) FullName() string {
+	if sc.Parent == nil || sc.Parent.FullName() == "" {
+		return sc.Name
+	}
+	return sc.Parent.FullName() + "::" + sc.Name
+}
+
+func (sc *Scope) Contains(address uint16) bool {
+	for _, span := range sc.Spans {
+		if span.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// CSymbol is a C variable or function from cc65.
+type CSymbol struct {
+	Id    int
+	Name  string
+	Scope *Scope
+	Type  *TypeRecord
+
+	// Storage class: "auto", "reg", "static" or "ext"
+	Storage string
+
+	// Offset on the C stack for autos, or into the register bank for
+	// register variables.
+	Offset int
+
+	// Assembler symbol for statics and externals
+	Symbol *SymbolRecord
+}
+
+// TypeRecord is a type string from cc65 or ca65.
+type TypeRecord struct {
+	Id    int
+	Value string // hex encoded
 }
 
 type Symbols struct {
 	Version string
 
-	files map[int]*fileRecord
-	lines map[int]*lineRecord
+	// Directory the source file names are relative to.  NewSymbols() sets
+	// this to the directory of the debug file.
+	SourceDir string
+
+	Files     map[int]*SourceFile
+	Modules   map[int]*Module
+	Libraries map[int]*Library
+	Segments  map[int]*Segment
+	Spans     map[int]*Span
+	Lines     map[int]*LineRecord
+	Scopes    map[int]*Scope
+	CSymbols  map[int]*CSymbol
+	Types     map[int]*TypeRecord
 
 	// indexed by scope name.  "" is default
 	// "SomeLabel"
@@ -47,17 +227,29 @@ type Symbols struct {
 	// Each address may have more than one symbol attached to it
 	symAddr map[uint16][]*SymbolRecord
 
-	segments map[int]*segmentRecord
-}
+	// Sorted keys of symAddr
+	addrs []uint16
 
-type segmentRecord struct {
-	Id int
-	Name string
-	Start int
-	Size uint16
-	Type string
-	OutputName string
-	OutputOffset int
+	// Spans covering each address, smallest first.  Spans in bank switched
+	// segments are only in spanPrg, by PRG offset, like cpuLabels.
+	spanAddr map[uint16][]*Span
+	spanPrg  map[uint32][]*Span
+
+	// Full scope names
+	scopeNames map[string]*Scope
+
+	// All symbols by ID, including imports
+	symIds map[int]*SymbolRecord
+
+	// Source file contents, loaded as needed.  Files that couldn't be read
+	// are kept in sourceErrs so they aren't tried again for every line.
+	// Clones share Symbols, so these are behind sourceLock.
+	sources    map[*SourceFile][]string
+	sourceErrs map[*SourceFile]error
+	sourceLock sync.Mutex
+
+	// Labels by CPU address, for labels.Provider
+	cpuLabels *labels.Index
 }
 
 func (s *Symbols) AllLabels() []string {
@@ -71,7 +263,7 @@ func (s *Symbols) AllLabels() []string {
 
 // Returns a list of labels that occupy a given address.
 // Returns nil if none found.
-func (s *Symbols) LabelsAt(address uint16) ([]string) {
+func (s *Symbols) LabelsAt(address uint16) []string {
 	if list, ok := s.symAddr[address]; ok {
 		ret := []string{}
 		for _, rec := range list {
@@ -111,323 +303,1051 @@ func (s *Symbols) GetLastLabel(address uint16) string {
 		return fmt.Sprintf("%s ($%04X)", labelList[0], address)
 	}
 
-	var last *SymbolRecord
-	for addr, sym := range s.symAddr {
-		if addr > address {
+	// First address after the one we want
+	i := sort.Search(len(s.addrs), func(i int) bool {
+		return s.addrs[i] > address
+	})
+
+	if i > 0 {
+		last := s.symAddr[s.addrs[i-1]][0]
+		return fmt.Sprintf("%s ($%04X)", last.Name, last.Value)
+	}
+	return "<none>"
+}
+
+// Label implements labels.Provider.  Labels are in NesMemory by CPU
+// address and NesPrgRom by PRG offset.  Labels in bank switched segments
+// are only in NesPrgRom.
+func (s *Symbols) Label(t labels.MemoryType, address uint) (*labels.Label, uint) {
+	return s.cpuLabels.Label(t, address)
+}
+
+// Find implements labels.Provider.  This includes constants.
+func (s *Symbols) Find(name string) (labels.MemoryType, uint, bool) {
+	if sym, ok := s.sym[name]; ok {
+		return labels.NesMemory, uint(sym.Value), true
+	}
+	return "", 0, false
+}
+
+// Labels implements labels.Provider.
+func (s *Symbols) Labels(t labels.MemoryType) labels.LabelMap {
+	return s.cpuLabels.Labels(t)
+}
+
+// GetScope finds a scope by its full name, eg "Outer::Inner".
+func (s *Symbols) GetScope(name string) (*Scope, error) {
+	if sc, ok := s.scopeNames[name]; ok {
+		return sc, nil
+	}
+	return nil, fmt.Errorf("Scope %q does not exist", name)
+}
+
+// ScopeAt returns the innermost scope that has code or data at the given
+// address.
+func (s *Symbols) ScopeAt(address uint16) *Scope {
+	var found *Scope
+	depth := -1
+	for _, sc := range s.Scopes {
+		if !sc.Contains(address) {
 			continue
 		}
 
-		if last == nil || last.Value < addr {
-			last = sym[0]
+		d := 0
+		for p := sc.Parent; p != nil; p = p.Parent {
+			d++
+		}
+
+		if d > depth || d == depth && sc.Id < found.Id {
+			found = sc
+			depth = d
 		}
 	}
+	return found
+}
 
-	if last != nil {
-		return fmt.Sprintf("%s ($%04X)", last.Name, last.Value)
+// SpansAt returns every span that covers the given address, smallest first.
+// Spans in bank switched segments aren't found by CPU address, use
+// SpansAtPrg() for those.
+func (s *Symbols) SpansAt(address uint16) []*Span {
+	return append([]*Span{}, s.spanAddr[address]...)
+}
+
+// SpansAtPrg returns every span that covers the given PRG ROM offset,
+// smallest first.
+func (s *Symbols) SpansAtPrg(offset uint32) []*Span {
+	return append([]*Span{}, s.spanPrg[offset]...)
+}
+
+// indexSpans fills spanAddr and spanPrg.  Stepping looks up the line for
+// every instruction, so this can't be a search through all the spans.
+func (s *Symbols) indexSpans() {
+	s.spanAddr = map[uint16][]*Span{}
+	s.spanPrg = map[uint32][]*Span{}
+	banked := s.bankedSegments()
+
+	for _, span := range s.Spans {
+		start := span.Segment.Start + span.Offset
+		if !banked[span.Segment] {
+			for addr := start; addr < start+span.Size && addr <= 0xFFFF; addr++ {
+				s.spanAddr[uint16(addr)] = append(s.spanAddr[uint16(addr)], span)
+			}
+		}
+
+		offset, ok := span.Segment.FileOffset(span.Address())
+		if !ok || offset < inesHeaderSize {
+			continue
+		}
+		for i := 0; i < span.Size; i++ {
+			prg := uint32(offset - inesHeaderSize + i)
+			s.spanPrg[prg] = append(s.spanPrg[prg], span)
+		}
+	}
+
+	for _, spans := range s.spanAddr {
+		sortSpans(spans)
+	}
+	for _, spans := range s.spanPrg {
+		sortSpans(spans)
 	}
-	return "<none>"
 }
 
-type lineRecord struct {
-	Id int
-	File *fileRecord
-	Line int
-	Span *spanRecord
+func sortSpans(spans []*Span) {
+	sort.Slice(spans, func(i, j int) bool {
+		if spans[i].Size == spans[j].Size {
+			return spans[i].Id < spans[j].Id
+		}
+		return spans[i].Size < spans[j].Size
+	})
 }
 
-type spanRecord struct {
+// LinesAt returns all the source lines that generated the byte at the given
+// address.  This includes macro definitions and the lines that invoked them.
+func (s *Symbols) LinesAt(address uint16) []*LineRecord {
+	return spanLines(s.SpansAt(address))
 }
 
-func NewSymbols(filename string) (*Symbols, error) {
-	file, err := os.Open(filename)
+// LinesAtPrg is LinesAt() for a PRG ROM offset.
+func (s *Symbols) LinesAtPrg(offset uint32) []*LineRecord {
+	return spanLines(s.SpansAtPrg(offset))
+}
+
+func spanLines(spans []*Span) []*LineRecord {
+	lines := []*LineRecord{}
+	for _, span := range spans {
+		lines = append(lines, span.Lines...)
+	}
+	return lines
+}
+
+// LineAt returns the source line for the given address, or nil.  Lines that
+// were written by hand are preferred over the insides of macros.
+func (s *Symbols) LineAt(address uint16) *LineRecord {
+	return bestLine(s.LinesAt(address))
+}
+
+// LineAtPrg is LineAt() for a PRG ROM offset.
+func (s *Symbols) LineAtPrg(offset uint32) *LineRecord {
+	return bestLine(s.LinesAtPrg(offset))
+}
+
+func bestLine(lines []*LineRecord) *LineRecord {
+	var found *LineRecord
+	for _, line := range lines {
+		if found == nil || found.Type == LineMacro && line.Type != LineMacro {
+			found = line
+		}
+	}
+	return found
+}
+
+// FindFile finds a source file by name.  An exact match is preferred, then a
+// match on the end of the path (eg, "main.s" for "src/main.s").
+func (s *Symbols) FindFile(name string) (*SourceFile, error) {
+	name = filepath.ToSlash(name)
+	var found *SourceFile
+	for _, f := range s.Files {
+		fname := filepath.ToSlash(f.Name)
+		if fname == name {
+			return f, nil
+		}
+
+		if strings.HasSuffix(fname, "/"+name) || strings.HasSuffix(name, "/"+fname) {
+			if found != nil {
+				return nil, fmt.Errorf("%q matches both %q and %q", name, found.Name, f.Name)
+			}
+			found = f
+		}
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("Source file %q not found", name)
+	}
+	return found, nil
+}
+
+// SpansForLine returns the spans generated by the given source line.
+func (s *Symbols) SpansForLine(file string, line int) ([]*Span, error) {
+	f, err := s.FindFile(file)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	scopes := map[int]map[string]string{}
-	symbols := map[int]map[string]string{}
-	lines := map[int]map[string]string{}
+	spans := []*Span{}
+	for _, lr := range s.Lines {
+		if lr.File == f && lr.Line == line {
+			spans = append(spans, lr.Spans...)
+		}
+	}
 
-	sym := &Symbols{
-		sym: map[string]*SymbolRecord{},
-		symAddr: map[uint16][]*SymbolRecord{},
-		Version: "",
-		files: map[int]*fileRecord{},
-		lines: map[int]*lineRecord{},
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Address() < spans[j].Address() })
+	return spans, nil
+}
+
+// AddressForLine returns the first address of code or data generated by the
+// given source line.
+func (s *Symbols) AddressForLine(file string, line int) (uint16, error) {
+	spans, err := s.SpansForLine(file, line)
+	if err != nil {
+		return 0, err
 	}
 
-	// pass one
-	reader := bufio.NewReader(file)
-	var readerr error
-	for readerr == nil {
-		var line string
-		line, readerr = reader.ReadString('\n')
-		if readerr != nil && readerr != io.EOF {
-			return nil, readerr
+	if len(spans) == 0 {
+		return 0, fmt.Errorf("No code or data at %s:%d", file, line)
+	}
+	return spans[0].Address(), nil
+}
+
+// SourceText returns the text of the given source line.  Files are read
+// from SourceDir the first time they're needed.
+func (s *Symbols) SourceText(lr *LineRecord) (string, error) {
+	s.sourceLock.Lock()
+	defer s.sourceLock.Unlock()
+
+	if s.sources == nil {
+		s.sources = map[*SourceFile][]string{}
+		s.sourceErrs = map[*SourceFile]error{}
+	}
+
+	if err, ok := s.sourceErrs[lr.File]; ok {
+		return "", err
+	}
+
+	lines, ok := s.sources[lr.File]
+	if !ok {
+		name := filepath.FromSlash(lr.File.Name)
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(s.SourceDir, name)
 		}
 
-		line = strings.TrimSpace(line)
-		if line == "" {
+		raw, err := ioutil.ReadFile(name)
+		if err != nil {
+			s.sourceErrs[lr.File] = err
+			return "", err
+		}
+
+		lines = strings.Split(strings.ReplaceAll(string(raw), "\r\n", "\n"), "\n")
+		s.sources[lr.File] = lines
+	}
+
+	if lr.Line < 1 || lr.Line > len(lines) {
+		return "", fmt.Errorf("%s only has %d lines", lr.File.Name, len(lines))
+	}
+	return lines[lr.Line-1], nil
+}
+
+// SegmentsAt returns the segments that contain the given address.  There can
+// be more than one with bank switching.
+func (s *Symbols) SegmentsAt(address uint16) []*Segment {
+	segs := []*Segment{}
+	for _, seg := range s.Segments {
+		if seg.Contains(address) {
+			segs = append(segs, seg)
+		}
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].Id < segs[j].Id })
+	return segs
+}
+
+// AddressForOffset translates an offset in the named output file (eg, the
+// .nes file) back to a CPU address.
+func (s *Symbols) AddressForOffset(output string, offset int) (uint16, *Segment, error) {
+	for _, seg := range s.Segments {
+		if seg.OutputName != output {
 			continue
 		}
 
-		idx := strings.Index(line, "\t")
-		if idx == -1 {
-			return nil, fmt.Errorf("Invalid line: %q", line)
+		if offset >= seg.OutputOffset && offset < seg.OutputOffset+seg.Size {
+			return uint16(seg.Start + offset - seg.OutputOffset), seg, nil
 		}
+	}
+	return 0, nil, fmt.Errorf("Offset $%X in %q isn't in any segment", offset, output)
+}
+
+// Size of the iNES header at the start of the output file.  PRG ROM starts
+// right after it.
+const inesHeaderSize int = 16
+
+// PrgOffset returns the offset of a label in PRG ROM, not counting the
+// iNES header.  ok is false for labels that aren't in the ROM.
+func (s *Symbols) PrgOffset(sym *SymbolRecord) (uint32, bool) {
+	if !sym.IsLabel() || sym.Segment == nil {
+		return 0, false
+	}
 
-		t := line[:idx]
-		vals := strings.Split(line[idx+1:], ",")
+	offset, ok := sym.Segment.FileOffset(sym.Value)
+	if !ok || offset < inesHeaderSize {
+		return 0, false
+	}
+	return uint32(offset - inesHeaderSize), true
+}
 
-		m := map[string]string{}
-		for _, kv := range vals {
-			data := strings.Split(kv, "=")
-			if len(data) != 2 {
-				return nil, fmt.Errorf("Invalid key/value pair: %q", kv)
+// SymbolsNamed returns every symbol with the given full name.  There can be
+// more than one when modules have symbols that aren't exported, eg a
+// routine with the same name in different banks.  Imports are skipped.
+func (s *Symbols) SymbolsNamed(name string) []*SymbolRecord {
+	found := []*SymbolRecord{}
+	for _, sym := range s.symIds {
+		if sym.Name == name && sym.Type != "imp" {
+			found = append(found, sym)
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].Id < found[j].Id })
+	return found
+}
+
+// bankedSegments finds the ROM segments that share CPU addresses with other
+// ROM segments.
+func (s *Symbols) bankedSegments() map[*Segment]bool {
+	banked := map[*Segment]bool{}
+	for _, a := range s.Segments {
+		for _, b := range s.Segments {
+			if a == b || a.OutputName == "" || b.OutputName == "" || a.Size == 0 || b.Size == 0 {
+				continue
 			}
 
-			m[data[0]] = strings.Trim(data[1], `"`)
+			if a.Start < b.Start+b.Size && b.Start < a.Start+a.Size {
+				banked[a] = true
+			}
 		}
+	}
+	return banked
+}
 
-		idStr, ok := m["id"]
-		if !ok {
+// dbgRecord is the key/value pairs of a single line in the file.
+type dbgRecord map[string]string
+
+func (r dbgRecord) int(key string) (int, error) {
+	val, ok := r[key]
+	if !ok {
+		return 0, fmt.Errorf("missing %s", key)
+	}
+
+	i, err := strconv.ParseInt(val, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to parse %s value %q: %w", key, val, err)
+	}
+	return int(i), nil
+}
+
+// optInt is like int, but missing keys return def.
+func (r dbgRecord) optInt(key string, def int) (int, error) {
+	if _, ok := r[key]; !ok {
+		return def, nil
+	}
+	return r.int(key)
+}
+
+// ids parses a list of IDs separated by '+'.  Missing keys return nil.
+func (r dbgRecord) ids(key string) ([]int, error) {
+	val, ok := r[key]
+	if !ok || val == "" {
+		return nil, nil
+	}
+
+	ret := []int{}
+	for _, str := range strings.Split(val, "+") {
+		id, err := strconv.Atoi(str)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse %s id %q: %w", key, str, err)
+		}
+		ret = append(ret, id)
+	}
+	return ret, nil
+}
+
+// parseDbgLine splits a line into its type and key/value pairs.  Values may
+// be quoted, and quoted values may contain commas.
+func parseDbgLine(line string) (string, dbgRecord, error) {
+	idx := strings.IndexAny(line, "\t ")
+	if idx == -1 {
+		return "", nil, fmt.Errorf("Invalid line: %q", line)
+	}
+
+	t := line[:idx]
+	rest := strings.TrimSpace(line[idx+1:])
+	m := dbgRecord{}
+
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq < 1 {
+			return "", nil, fmt.Errorf("Invalid key/value pair: %q", rest)
+		}
+		key := rest[:eq]
+		rest = rest[eq+1:]
+
+		var val string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end == -1 {
+				return "", nil, fmt.Errorf("Unterminated string: %q", line)
+			}
+			val = rest[1 : end+1]
+			rest = rest[end+2:]
+		} else {
+			end := strings.Index(rest, ",")
+			if end == -1 {
+				end = len(rest)
+			}
+			val = rest[:end]
+			rest = rest[end:]
+		}
+
+		m[key] = val
+		rest = strings.TrimPrefix(rest, ",")
+	}
+
+	return t, m, nil
+}
+
+func NewSymbols(filename string) (*Symbols, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sym, err := LoadSymbols(file)
+	if err != nil {
+		return nil, err
+	}
+
+	sym.SourceDir = filepath.Dir(filename)
+	return sym, nil
+}
+
+// LoadSymbols reads a debug file written by ld65's --dbgfile option.
+func LoadSymbols(r io.Reader) (*Symbols, error) {
+	sym := &Symbols{
+		Files:      map[int]*SourceFile{},
+		Modules:    map[int]*Module{},
+		Libraries:  map[int]*Library{},
+		Segments:   map[int]*Segment{},
+		Spans:      map[int]*Span{},
+		Lines:      map[int]*LineRecord{},
+		Scopes:     map[int]*Scope{},
+		CSymbols:   map[int]*CSymbol{},
+		Types:      map[int]*TypeRecord{},
+		sym:        map[string]*SymbolRecord{},
+		symAddr:    map[uint16][]*SymbolRecord{},
+		scopeNames: map[string]*Scope{},
+	}
+
+	// Records by type, then ID.  Everything is linked up after the whole
+	// file is read because records can refer to ones that come later.
+	records := map[string]map[int]dbgRecord{}
+
+	// pass one
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
 			continue
 		}
 
-		id, err := strconv.ParseInt(idStr, 10, 32)
+		t, m, err := parseDbgLine(line)
 		if err != nil {
 			return nil, err
 		}
 
 		switch t {
-		case "scope":
-			scopes[int(id)] = m
+		case "version":
+			sym.Version = m["major"] + "." + m["minor"]
+			continue
+		case "info":
+			continue
+		}
 
-		case "sym":
-			symbols[int(id)] = m
+		id, err := m.int("id")
+		if err != nil {
+			return nil, fmt.Errorf("%s record: %w", t, err)
+		}
 
-		case "line":
-			lines[int(id)] = m
+		if _, ok := records[t]; !ok {
+			records[t] = map[int]dbgRecord{}
+		}
+		records[t][id] = m
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
 
-		case "version":
-			sym.Version = m["major"] + "." + m["minor"]
+	// Order matters here.  Each step only refers to records built by the
+	// ones before it, except for the back references fixed up at the end.
+	steps := []struct {
+		name  string
+		parse func(id int, m dbgRecord) error
+	}{
+		{"lib", sym.parseLib},
+		{"file", sym.parseFile},
+		{"mod", sym.parseMod},
+		{"seg", sym.parseSeg},
+		{"type", sym.parseType},
+		{"span", sym.parseSpan},
+		{"line", sym.parseLine},
+		{"scope", sym.parseScope},
+	}
 
-		case "seg":
-			id, err := strconv.Atoi(m["id"])
-			if err != nil {
-				return nil, fmt.Errorf("Unable to parse id value for segment %q: %w", m["id"], err)
+	for _, step := range steps {
+		for id, m := range records[step.name] {
+			if err := step.parse(id, m); err != nil {
+				return nil, fmt.Errorf("%s record %d: %w", step.name, id, err)
 			}
+		}
+	}
 
-			start, err := strconv.ParseInt(m["start"], 0, 32)
-			if err != nil {
-				return nil, fmt.Errorf("Unable to parse start value for segment %q: %w", m["start"], err)
-			}
+	// Map order is random, so put the back references in file order.
+	for _, span := range sym.Spans {
+		sort.Slice(span.Lines, func(i, j int) bool { return span.Lines[i].Id < span.Lines[j].Id })
+		sort.Slice(span.Scopes, func(i, j int) bool { return span.Scopes[i].Id < span.Scopes[j].Id })
+	}
+	sym.indexSpans()
 
-			size, err := strconv.ParseUint(m["size"], 0, 16)
-			if err != nil {
-				return nil, fmt.Errorf("Unable to parse size value for segment %q: %w", m["size"], err)
+	// File records list their modules, but modules come after files.
+	for id, m := range records["file"] {
+		mods, err := m.ids("mod")
+		if err != nil {
+			return nil, err
+		}
+		for _, modId := range mods {
+			if mod, ok := sym.Modules[modId]; ok {
+				sym.Files[id].Modules = append(sym.Files[id].Modules, mod)
 			}
+		}
+	}
 
-			seg := &segmentRecord{
-				Id: id,
-				Name: m["name"],
-				Start: int(start),
-				Size: uint16(size),
-				Type: m["type"],
-			}
+	if err := sym.linkScopes(records["scope"]); err != nil {
+		return nil, err
+	}
 
-			var oname string
-			if val, ok := m["oname"]; ok {
-				oname = val
-				offset, err := strconv.Atoi(m["ooffs"])
-				if err != nil {
-					return nil, fmt.Errorf("Unable to parse ooffs value for segment %q: %w", m["ooffs"], err)
-				}
+	if err := sym.parseSymbols(records["sym"], records["scope"]); err != nil {
+		return nil, err
+	}
 
-				seg.OutputName = oname
-				seg.OutputOffset = offset
-			}
+	for id, m := range records["csym"] {
+		if err := sym.parseCSym(id, m); err != nil {
+			return nil, fmt.Errorf("csym record %d: %w", id, err)
+		}
+	}
 
-		case "file":
-			mtime, err := strconv.ParseInt(m["mtime"], 0, 32)
-			if err != nil {
-				return nil, fmt.Errorf("Unable to parse mtime value of %q for file record: %w", m["mtime"], err)
-			}
+	for _, sc := range sym.Scopes {
+		sort.Slice(sc.CSymbols, func(i, j int) bool { return sc.CSymbols[i].Id < sc.CSymbols[j].Id })
+	}
 
-			size , err := strconv.ParseInt(m["size"], 10, 32)
-			if err != nil {
-				return nil, fmt.Errorf("Unable to parse size value of %q for file record: %w", m["size"], err)
-			}
+	return sym, nil
+}
 
-			sym.files[int(id)] =  &fileRecord{
-				Id: int(id),
-				Name: m["name"],
-				Mtime: int(mtime),
-				Size: int(size),
-			}
+func (s *Symbols) parseLib(id int, m dbgRecord) error {
+	s.Libraries[id] = &Library{Id: id, Name: m["name"]}
+	return nil
+}
 
+func (s *Symbols) parseFile(id int, m dbgRecord) error {
+	mtime, err := m.optInt("mtime", 0)
+	if err != nil {
+		return err
+	}
 
-		default:
-			// all others not implemented yet
-			continue
-		}
+	size, err := m.optInt("size", 0)
+	if err != nil {
+		return err
 	}
 
-	// second passes
-	for _, line := range lines {
-		id, err := strconv.Atoi(line["id"])
+	s.Files[id] = &SourceFile{
+		Id:    id,
+		Name:  m["name"],
+		Mtime: int64(mtime),
+		Size:  size,
+	}
+	return nil
+}
+
+func (s *Symbols) parseMod(id int, m dbgRecord) error {
+	mod := &Module{Id: id, Name: m["name"]}
+
+	fileId, err := m.int("file")
+	if err != nil {
+		return err
+	}
+	mod.File = s.Files[fileId]
+
+	if _, ok := m["lib"]; ok {
+		libId, err := m.int("lib")
 		if err != nil {
-			return nil, fmt.Errorf("Unable to parse line ID %q: %w", err)
+			return err
 		}
+		mod.Library = s.Libraries[libId]
+	}
+
+	s.Modules[id] = mod
+	return nil
+}
+
+func (s *Symbols) parseSeg(id int, m dbgRecord) error {
+	start, err := m.int("start")
+	if err != nil {
+		return err
+	}
+
+	size, err := m.int("size")
+	if err != nil {
+		return err
+	}
+
+	seg := &Segment{
+		Id:       id,
+		Name:     m["name"],
+		Start:    start,
+		Size:     size,
+		AddrSize: m["addrsize"],
+		Type:     m["type"],
+	}
 
-		fileId, err := strconv.Atoi(line["file"])
+	if oname, ok := m["oname"]; ok {
+		offset, err := m.int("ooffs")
 		if err != nil {
-			return nil, fmt.Errorf("Unable to parse line fileId %q: %w", err)
+			return err
 		}
 
-		lineNum, err := strconv.Atoi(line["line"])
+		seg.OutputName = oname
+		seg.OutputOffset = offset
+	}
+
+	s.Segments[id] = seg
+	return nil
+}
+
+func (s *Symbols) parseType(id int, m dbgRecord) error {
+	s.Types[id] = &TypeRecord{Id: id, Value: m["val"]}
+	return nil
+}
+
+func (s *Symbols) parseSpan(id int, m dbgRecord) error {
+	segId, err := m.int("seg")
+	if err != nil {
+		return err
+	}
+
+	seg, ok := s.Segments[segId]
+	if !ok {
+		return fmt.Errorf("Cannot find segment with ID %d", segId)
+	}
+
+	start, err := m.int("start")
+	if err != nil {
+		return err
+	}
+
+	size, err := m.int("size")
+	if err != nil {
+		return err
+	}
+
+	span := &Span{Id: id, Segment: seg, Offset: start, Size: size}
+	if _, ok := m["type"]; ok {
+		typeId, err := m.int("type")
 		if err != nil {
-			return nil, fmt.Errorf("Unable to parse line number %q: %w", err)
+			return err
 		}
+		span.Type = s.Types[typeId]
+	}
 
-		f, ok := sym.files[fileId]
-		if !ok {
-			return nil, fmt.Errorf("Cannot find file with ID %d", fileId)
-		}
+	s.Spans[id] = span
+	return nil
+}
+
+func (s *Symbols) parseLine(id int, m dbgRecord) error {
+	fileId, err := m.int("file")
+	if err != nil {
+		return err
+	}
+
+	f, ok := s.Files[fileId]
+	if !ok {
+		return fmt.Errorf("Cannot find file with ID %d", fileId)
+	}
+
+	lineNum, err := m.int("line")
+	if err != nil {
+		return err
+	}
+
+	lineType, err := m.optInt("type", LineAsm)
+	if err != nil {
+		return err
+	}
+
+	count, err := m.optInt("count", 0)
+	if err != nil {
+		return err
+	}
+
+	lr := &LineRecord{
+		Id:    id,
+		Line:  lineNum,
+		File:  f,
+		Type:  lineType,
+		Count: count,
+	}
 
-		sym.lines[id] = &lineRecord{
-			Id: id,
-			Line: lineNum,
-			File: f,
-			// TODO: span
+	spans, err := m.ids("span")
+	if err != nil {
+		return err
+	}
+	for _, spanId := range spans {
+		if span, ok := s.Spans[spanId]; ok {
+			lr.Spans = append(lr.Spans, span)
+			span.Lines = append(span.Lines, lr)
 		}
 	}
 
-	for _, symbol := range symbols {
-		prefix := ""
+	s.Lines[id] = lr
+	return nil
+}
 
-		var parent map[string]string
-		var parentOk bool = false
+func (s *Symbols) parseScope(id int, m dbgRecord) error {
+	size, err := m.optInt("size", 0)
+	if err != nil {
+		return err
+	}
 
-		parentIdStr, ok := symbol["parent"]
-		if ok {
-			parentId, err := strconv.ParseInt(parentIdStr, 10, 32)
-			if err != nil {
-				return nil, err
-			}
+	sc := &Scope{
+		Id:   id,
+		Name: m["name"],
+		Type: m["type"],
+		Size: size,
+	}
 
-			parent, parentOk = symbols[int(parentId)]
+	if _, ok := m["mod"]; ok {
+		modId, err := m.int("mod")
+		if err != nil {
+			return err
 		}
+		sc.Module = s.Modules[modId]
+	}
 
-		var scopeIdStr string
-		if parentOk {
-			scopeIdStr = parent["scope"]
-			prefix = parent["name"]
-		} else {
-			scopeIdStr = symbol["scope"]
+	spans, err := m.ids("span")
+	if err != nil {
+		return err
+	}
+	for _, spanId := range spans {
+		if span, ok := s.Spans[spanId]; ok {
+			sc.Spans = append(sc.Spans, span)
+			span.Scopes = append(span.Scopes, sc)
+		}
+	}
+
+	s.Scopes[id] = sc
+	return nil
+}
+
+// linkScopes builds the scope tree once every scope exists.
+func (s *Symbols) linkScopes(records map[int]dbgRecord) error {
+	ids := []int{}
+	for id := range records {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		m := records[id]
+		if _, ok := m["parent"]; !ok {
+			continue
 		}
 
-		scopeId, err := strconv.ParseInt(scopeIdStr, 10, 32)
+		parentId, err := m.int("parent")
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("scope record %d: %w", id, err)
 		}
 
-		scope, ok := scopes[int(scopeId)]
+		parent, ok := s.Scopes[parentId]
 		if !ok {
-			fmt.Printf("Scope with ID %d not found", scopeId)
-			continue
+			return fmt.Errorf("scope record %d: Cannot find parent scope %d", id, parentId)
 		}
 
-		if scope["name"] != "" {
-			prefix = scope["name"] + "::" + prefix
+		sc := s.Scopes[id]
+		sc.Parent = parent
+		parent.Children = append(parent.Children, sc)
+	}
+
+	for _, id := range ids {
+		sc := s.Scopes[id]
+		name := sc.FullName()
+		if _, ok := s.scopeNames[name]; !ok && name != "" {
+			s.scopeNames[name] = sc
 		}
+	}
+	return nil
+}
+
+func (s *Symbols) parseSymbols(records, scopes map[int]dbgRecord) error {
+	ids := []int{}
+	for id := range records {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	records2 := map[int]*SymbolRecord{}
+
+	// Create all the records first so cheap locals can find their parent
+	// and imports can find their export.
+	for _, id := range ids {
+		m := records[id]
 
 		var addrSize int = 0
-		switch symbol["addrsize"] {
+		switch m["addrsize"] {
 		case "absolute":
 			addrSize = 2
 		case "zeropage":
 			addrSize = 1
+		case "far":
+			addrSize = 3
 		default:
-			fmt.Println("unknown addrsize:", symbol["addrsize"])
-			continue
+			return fmt.Errorf("sym record %d: unknown addrsize %q", id, m["addrsize"])
+		}
+
+		size, err := m.optInt("size", 1)
+		if err != nil {
+			return fmt.Errorf("sym record %d: %w", id, err)
+		}
+
+		value, err := m.optInt("val", 0)
+		if err != nil {
+			return fmt.Errorf("sym record %d: %w", id, err)
+		}
+
+		record := &SymbolRecord{
+			Id:         id,
+			Name:       m["name"],
+			AddrSize:   addrSize,
+			Size:       uint16(size),
+			Value:      uint16(value),
+			Type:       m["type"],
+			References: []*LineRecord{},
 		}
 
-		size := uint16(1)
-		if val, ok := symbol["size"]; ok {
-			s, err := strconv.ParseUint(val, 0, 16)
+		if _, ok := m["seg"]; ok {
+			segId, err := m.int("seg")
 			if err != nil {
-				return nil, fmt.Errorf("Unable to parse size value %q: %w", val, err)
+				return fmt.Errorf("sym record %d: %w", id, err)
 			}
-			size = uint16(s)
+			record.Segment = s.Segments[segId]
 		}
 
-		addrValue, err := strconv.ParseUint(symbol["val"], 0, 16)
+		// Symbols can be defined more than once (eg, .set).  Use the first.
+		defs, err := m.ids("def")
 		if err != nil {
-			return nil, fmt.Errorf("Unable to parse address value %q: %w", symbol["val"], err)
+			return fmt.Errorf("sym record %d: %w", id, err)
+		}
+		if len(defs) > 0 {
+			record.Defined = s.Lines[defs[0]]
 		}
 
-		name := symbol["name"]
-		if prefix != "" {
-			name = prefix+name
+		refs, err := m.ids("ref")
+		if err != nil {
+			return fmt.Errorf("sym record %d: %w", id, err)
 		}
+		for _, lineId := range refs {
+			if line, ok := s.Lines[lineId]; ok {
+				record.References = append(record.References, line)
+			}
+		}
+
+		records2[id] = record
+	}
+
+	for _, id := range ids {
+		m := records[id]
+		record := records2[id]
 
-		def := symbol["def"]
-		plusIdx := strings.LastIndex(def, "+")
-		if plusIdx > -1 {
-			def = def[plusIdx:]
+		if _, ok := m["parent"]; ok {
+			parentId, err := m.int("parent")
+			if err != nil {
+				return fmt.Errorf("sym record %d: %w", id, err)
+			}
+			record.Parent = records2[parentId]
 		}
 
-		definedId, err := strconv.Atoi(def)
-		if err != nil {
-			return nil, fmt.Errorf("Unable to parse def value for symbol %q: %w", symbol["def"], err)
+		if _, ok := m["exp"]; ok {
+			expId, err := m.int("exp")
+			if err != nil {
+				return fmt.Errorf("sym record %d: %w", id, err)
+			}
+			record.Export = records2[expId]
 		}
 
-		defined, ok := sym.lines[definedId]
-		if !ok {
-			return nil, fmt.Errorf("No line with id %d", definedId)
+		// Cheap locals use the scope of their parent.
+		scopeRec := m
+		if record.Parent != nil {
+			scopeRec = records[record.Parent.Id]
+		}
+		if _, ok := scopeRec["scope"]; ok {
+			scopeId, err := scopeRec.int("scope")
+			if err != nil {
+				return fmt.Errorf("sym record %d: %w", id, err)
+			}
+			record.Scope = s.Scopes[scopeId]
 		}
+	}
 
-		record := &SymbolRecord{
-			Name: name,
-			AddrSize: addrSize,
-			Size: size,
-			Value: uint16(addrValue),
-			Type: scope["type"],
-			Defined: defined,
-			References: []*lineRecord{},
-		}
-		sym.sym[name] = record
-
-		if references, ok := symbol["ref"]; ok {
-			refList := strings.Split(references, "+")
-			for _, lineIdStr := range refList {
-				lineId, err := strconv.Atoi(lineIdStr)
-				if err != nil {
-					return nil, fmt.Errorf("Unable to parse reference id %q: %w", lineIdStr, err)
-				}
-
-				if line, lok := sym.lines[lineId]; lok {
-					record.References = append(record.References, line)
-				}
+	// Names are qualified after everything is linked so a cheap local's
+	// parent name is still the bare one.
+	bare := map[int]string{}
+	for id, record := range records2 {
+		bare[id] = record.Name
+	}
+
+	for _, id := range ids {
+		record := records2[id]
+
+		// Imports are just references to another module's export.
+		if record.Type == "imp" {
+			if record.Export != nil {
+				record.Value = record.Export.Value
+				record.Size = record.Export.Size
+			}
+			continue
+		}
+
+		name := bare[id]
+		if record.Parent != nil {
+			name = bare[record.Parent.Id] + name
+		}
+		if record.Scope != nil {
+			record.Scope.Symbols = append(record.Scope.Symbols, record)
+			if prefix := record.Scope.FullName(); prefix != "" {
+				name = prefix + "::" + name
 			}
 		}
+		record.Name = name
+
+		if _, ok := s.sym[record.Name]; !ok {
+			s.sym[record.Name] = record
+		}
 
 		// Add a reference for every address this label occupies
-		for i := uint16(0); i < size; i++ {
-			if _, ok := sym.symAddr[uint16(addrValue)+i]; !ok {
-				sym.symAddr[uint16(addrValue)+i] = []*SymbolRecord{}
+		for i := uint16(0); i < record.Size; i++ {
+			s.symAddr[record.Value+i] = append(s.symAddr[record.Value+i], record)
+		}
+	}
+
+	// A .proc's scope points at the label with the same name.
+	for id, m := range scopes {
+		if _, ok := m["sym"]; !ok {
+			continue
+		}
+
+		symId, err := m.int("sym")
+		if err != nil {
+			return fmt.Errorf("scope record %d: %w", id, err)
+		}
+		s.Scopes[id].Label = records2[symId]
+	}
+
+	s.symIds = records2
+
+	s.addrs = make([]uint16, 0, len(s.symAddr))
+	for addr := range s.symAddr {
+		s.addrs = append(s.addrs, addr)
+	}
+	sort.Slice(s.addrs, func(i, j int) bool { return s.addrs[i] < s.addrs[j] })
+
+	// Labels in banked segments are only found by their PRG offset.  Their
+	// CPU address could be any of the banks.
+	banked := s.bankedSegments()
+	cpu := labels.LabelMap{}
+	prg := labels.LabelMap{}
+	for _, id := range ids {
+		record := records2[id]
+		if !record.IsLabel() {
+			continue
+		}
+
+		// The first label at an address wins.
+		lbl := &labels.Label{Name: record.Name, Size: uint(record.Size)}
+		if offset, ok := s.PrgOffset(record); ok {
+			if _, ok := prg[uint(offset)]; !ok {
+				prg[uint(offset)] = lbl
 			}
+		}
 
-			sym.symAddr[uint16(addrValue)+i] = append(sym.symAddr[uint16(addrValue)+i], record)
+		if banked[record.Segment] {
+			continue
+		}
+		if _, ok := cpu[uint(record.Value)]; !ok {
+			cpu[uint(record.Value)] = lbl
 		}
+	}
+	s.cpuLabels = labels.NewIndex(map[labels.MemoryType]labels.LabelMap{
+		labels.NesMemory: cpu,
+		labels.NesPrgRom: prg,
+	})
 
+	return nil
+}
+
+func (s *Symbols) parseCSym(id int, m dbgRecord) error {
+	cs := &CSymbol{
+		Id:      id,
+		Name:    m["name"],
+		Storage: m["sc"],
 	}
 
-	//for name, _ := range sym.sym {
-	//	fmt.Println(name)
-	//}
+	if _, ok := m["scope"]; ok {
+		scopeId, err := m.int("scope")
+		if err != nil {
+			return err
+		}
+		cs.Scope = s.Scopes[scopeId]
+		if cs.Scope != nil {
+			cs.Scope.CSymbols = append(cs.Scope.CSymbols, cs)
+		}
+	}
 
-	return sym, nil
+	if _, ok := m["type"]; ok {
+		typeId, err := m.int("type")
+		if err != nil {
+			return err
+		}
+		cs.Type = s.Types[typeId]
+	}
+
+	offset, err := m.optInt("offs", 0)
+	if err != nil {
+		return err
+	}
+	cs.Offset = offset
+
+	if _, ok := m["sym"]; ok {
+		symId, err := m.int("sym")
+		if err != nil {
+			return err
+		}
+		cs.Symbol = s.symIds[symId]
+	}
+
+	s.CSymbols[id] = cs
+	return nil
 }
@@ -0,0 +1,246 @@
+package emu
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/zorchenhimer/emu-6502/labels"
+)
+
+func loadTestSymbols(t *testing.T) *Symbols {
+	sym, err := NewSymbols("testdata/game.dbg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sym
+}
+
+func TestSymbolNames(t *testing.T) {
+	sym := loadTestSymbols(t)
+
+	if sym.Version != "2.0" {
+		t.Errorf("expected version 2.0, got %q", sym.Version)
+	}
+
+	names := map[string]uint16{
+		"PPUSTATUS":            0x2002,
+		"Reset":                0xC000,
+		"Reset@wait":           0xC005,
+		"Reset::loop":          0xC00E,
+		"Reset::Nested::value": 5,
+		"Wait":                 0xC017,
+		"palette":              0xC01D,
+	}
+
+	for name, expect := range names {
+		addr, err := sym.GetAddress(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if addr != expect {
+			t.Errorf("%s: expected $%04X, got $%04X", name, expect, addr)
+		}
+	}
+
+	if _, err := sym.GetAddress("loop"); err == nil {
+		t.Errorf("loop should only be found with its scope")
+	}
+
+	reset, _ := sym.GetSymbol("Reset")
+	if reset.Size != 22 || reset.Defined.String() != "src/main.s:10" {
+		t.Errorf("unexpected Reset record: size %d defined at %s", reset.Size, reset.Defined)
+	}
+
+	// The import in main.o shouldn't show up next to the real label.
+	if lbls := sym.LabelsAt(0xC018); len(lbls) != 1 || lbls[0] != "Wait" {
+		t.Errorf("expected only Wait at $C018, got %v", lbls)
+	}
+
+	last := map[uint16]string{
+		0x0000: "frame ($0000)",
+		0x1000: "buffer ($0300)",
+		0xC030: "palette ($C01D)",
+	}
+	for addr, expect := range last {
+		if lbl := sym.GetLastLabel(addr); lbl != expect {
+			t.Errorf("GetLastLabel($%04X): expected %q, got %q", addr, expect, lbl)
+		}
+	}
+
+	provided := map[uint]string{
+		0xC003: "Reset+3",
+		0xC005: "Reset@wait",
+		0xC006: "Reset+6",
+		0xC018: "Wait+1",
+	}
+	for addr, expect := range provided {
+		if lbl := labels.Name(sym.Label(labels.NesMemory, addr)); lbl != expect {
+			t.Errorf("Label($%04X): expected %q, got %q", addr, expect, lbl)
+		}
+	}
+}
+
+func TestSymbolScopes(t *testing.T) {
+	sym := loadTestSymbols(t)
+
+	nested, err := sym.GetScope("Reset::Nested")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nested.Parent.Name != "Reset" || nested.Parent.Label == nil || nested.Parent.Label.Value != 0xC000 {
+		t.Errorf("Nested has the wrong parent: %v", nested.Parent)
+	}
+	if len(nested.Symbols) != 1 || nested.Symbols[0].Name != "Reset::Nested::value" {
+		t.Errorf("unexpected symbols in Nested: %v", nested.Symbols)
+	}
+
+	scopes := map[uint16]string{
+		0xC00A: "Reset",
+		0xC016: "NMI",
+		0xC01C: "Wait",
+	}
+	for addr, expect := range scopes {
+		if sc := sym.ScopeAt(addr); sc == nil || sc.FullName() != expect {
+			t.Errorf("$%04X: expected scope %s, got %v", addr, expect, sc)
+		}
+	}
+}
+
+func TestSymbolLines(t *testing.T) {
+	sym := loadTestSymbols(t)
+
+	lines := map[uint16]string{
+		0xC000: "src/main.s:11",
+		0xC009: "src/main.s:17",
+		0xC012: "src/main.s:26", // macro invocation, not the macro body
+		0xC01B: "src/util.s:7",
+		0xC020: "src/main.s:36",
+	}
+	for addr, expect := range lines {
+		if line := sym.LineAt(addr); line == nil || line.String() != expect {
+			t.Errorf("$%04X: expected %s, got %v", addr, expect, line)
+		}
+	}
+
+	all := sym.LinesAt(0xC011)
+	if len(all) != 2 || all[1].Type != LineMacro || all[1].String() != "src/macros.inc:2" {
+		t.Errorf("expected the macro line at $C011, got %v", all)
+	}
+
+	addrs := []struct {
+		file   string
+		line   int
+		expect uint16
+	}{
+		{"main.s", 17, 0xC008},
+		{"src/util.s", 8, 0xC01C},
+		{"macros.inc", 2, 0xC011},
+	}
+	for _, tc := range addrs {
+		addr, err := sym.AddressForLine(tc.file, tc.line)
+		if err != nil {
+			t.Errorf("%s:%d: %v", tc.file, tc.line, err)
+		} else if addr != tc.expect {
+			t.Errorf("%s:%d: expected $%04X, got $%04X", tc.file, tc.line, tc.expect, addr)
+		}
+	}
+
+	if _, err := sym.AddressForLine("main.s", 19); err == nil {
+		t.Errorf("expected an error for a line without code")
+	}
+	if _, err := sym.AddressForLine("other.s", 1); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+
+	if text, err := sym.SourceText(sym.LineAt(0xC000)); err != nil || text != "    sei" {
+		t.Errorf("expected the sei line, got %q %v", text, err)
+	}
+
+	// A file that can't be read is only tried once.
+	dir, err := ioutil.TempDir("", "symbols")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sym.SourceDir = dir
+	util := sym.LineAt(0xC01B)
+	if _, err := sym.SourceText(util); err == nil {
+		t.Fatalf("expected an error for a missing source file")
+	}
+	os.MkdirAll(filepath.Join(dir, "src"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "src", "util.s"), []byte("a\nb\nc\nd\ne\nf\ng\n"), 0644)
+	if _, err := sym.SourceText(util); err == nil {
+		t.Errorf("expected the failed read to be remembered")
+	}
+}
+
+// Clones share Symbols, so Sweep() with TraceSource can load the same
+// source file from several goroutines.
+func TestSourceTextConcurrent(t *testing.T) {
+	sym := loadTestSymbols(t)
+	lines := []*LineRecord{sym.LineAt(0xC000), sym.LineAt(0xC01B), sym.LineAt(0xC011)}
+
+	start := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for _, lr := range lines {
+				if _, err := sym.SourceText(lr); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+}
+
+func TestSymbolSegments(t *testing.T) {
+	sym := loadTestSymbols(t)
+
+	segs := sym.SegmentsAt(0xC01E)
+	if len(segs) != 1 || segs[0].Name != "RODATA" {
+		t.Fatalf("expected RODATA at $C01E, got %v", segs)
+	}
+
+	if offset, ok := segs[0].FileOffset(0xC01E); !ok || offset != 46 {
+		t.Errorf("expected offset 46 for $C01E, got %d", offset)
+	}
+
+	bss := sym.SegmentsAt(0x0310)
+	if _, ok := bss[0].FileOffset(0x0310); ok {
+		t.Errorf("BSS shouldn't have a file offset")
+	}
+
+	addr, seg, err := sym.AddressForOffset("game.nes", 16394)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != 0xFFFA || seg.Name != "VECTORS" {
+		t.Errorf("expected VECTORS $FFFA, got %s $%04X", seg.Name, addr)
+	}
+}
+
+func TestParseDbgLine(t *testing.T) {
+	typ, rec, err := parseDbgLine(`file	id=3,name="dir, with commas/a.s",size=12,mod=0+2`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if typ != "file" || rec["name"] != "dir, with commas/a.s" || rec["size"] != "12" {
+		t.Errorf("unexpected parse: %s %v", typ, rec)
+	}
+
+	ids, err := rec.ids("mod")
+	if err != nil || len(ids) != 2 || ids[1] != 2 {
+		t.Errorf("unexpected mod ids: %v %v", ids, err)
+	}
+}
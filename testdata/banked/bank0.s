@@ -0,0 +1,7 @@
+; Bank 0 of the MMC1 fixture.  Bank 1 has another Init.
+
+.segment "BANK0"
+.proc Init
+    rts
+    .res 3
+.endproc
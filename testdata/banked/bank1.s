@@ -0,0 +1,12 @@
+; Bank 1 of the MMC1 fixture.
+
+.segment "BANK1"
+.proc Init
+    rts
+    .res 1
+.endproc
+
+.proc Other
+    rts
+    .res 1
+.endproc
@@ -0,0 +1,12 @@
+; The fixed bank of the MMC1 fixture.  Init is in whichever bank is mapped
+; at $8000.
+
+.segment "HEADER"
+    .byte "NES", $1A, 4, 0, $10, 0, 0, 0, 0, 0, 0, 0, 0, 0
+
+.segment "FIXED"
+.proc Reset
+    jsr $8000
+@loop:
+    jmp @loop
+.endproc
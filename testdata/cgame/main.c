@@ -0,0 +1,16 @@
+unsigned char counter;
+unsigned char buf[3];
+int add(int a, int b);
+
+int main(void) {
+    int x = add(counter, -2);
+    unsigned char *p = buf;
+    register int r = *p;
+    return x + r;
+}
+
+int add(int a, int b) {
+    static int total;
+    total += a + b;
+    return total;
+}
@@ -0,0 +1,39 @@
+.include "nes.inc"
+.segment "HEADER"
+    .byte "NES", $1A, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0
+.segment "ZEROPAGE"
+frame: .res 1
+ptr: .res 2
+.segment "BSS"
+buffer: .res 256
+.segment "CODE"
+.proc Reset
+    sei
+    cld
+    ldx #$FF
+    txs
+@wait:
+    bit PPUSTATUS
+    bpl @wait
+.scope Nested
+    value = 5
+.endscope
+    lda #Nested::value
+    sta frame
+.import Wait
+loop:
+    jsr Wait
+    incframe
+    jmp loop
+.endproc
+
+.proc NMI
+    rti
+.endproc
+
+.segment "RODATA"
+palette:
+    .byte $0F, $00, $10, $30, $0F, $06, $16, $26
+
+.segment "VECTORS"
+    .word NMI, Reset, 0
@@ -0,0 +1,9 @@
+.include "nes.inc"
+
+.export Wait
+.segment "CODE"
+.proc Wait
+    bit PPUSTATUS
+    bpl Wait
+    rts
+.endproc
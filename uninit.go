@@ -0,0 +1,39 @@
+package emu
+
+import (
+	"io"
+
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+// UninitializedReads reports every read of RAM that hasn't been written
+// since the last reset.  Only memory managers that implement
+// mmu.InitTracker are checked.
+type UninitializedReads struct {
+	// Ranges that are never reported.
+	Ignore []AddressRange
+
+	// Treat RAM cleared by HardReset() as initialized.
+	ClearIsInit bool
+
+	// If not nil, each read is written here as it happens.
+	Output io.Writer
+
+	Reads []Diagnostic
+}
+
+func (c *Core) checkUninitialized(address uint16) {
+	tracker, ok := c.memory.(mmu.InitTracker)
+	if !ok {
+		return
+	}
+
+	initialized, tracked := tracker.IsInitialized(address)
+	if !tracked || initialized || inRanges(c.UninitializedReads.Ignore, address) {
+		return
+	}
+
+	d := c.newDiagnostic("uninitialized read", address, "")
+	c.UninitializedReads.Reads = append(c.UninitializedReads.Reads, d)
+	c.writeDiagnostic(c.UninitializedReads.Output, d)
+}
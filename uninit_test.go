@@ -0,0 +1,117 @@
+package emu
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zorchenhimer/emu-6502/labels"
+	"github.com/zorchenhimer/emu-6502/mappers"
+	"github.com/zorchenhimer/emu-6502/mmu"
+)
+
+func TestUninitializedReads(t *testing.T) {
+	rom := make([]byte, 0x8000)
+	copy(rom, []byte{
+		0x20, 0x10, 0x80, // JSR ReadCounter
+		0x60, // RTS
+	})
+	copy(rom[0x10:], []byte{
+		0xAD, 0x00, 0x03, // LDA Counter
+		0x85, 0x10, // STA $10
+		0xA5, 0x10, // LDA $10
+		0xAD, 0x00, 0x04, // LDA $0400
+		0x60, // RTS
+	})
+
+	mapper, err := mappers.NewNROM(rom, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lbls := labels.Set{}
+	lbls.Add(labels.NesInternalRam, 0x300, &labels.Label{Name: "Counter"})
+	nes := mmu.NewNES(mapper)
+	nes.SetLabels(lbls)
+
+	out := &bytes.Buffer{}
+	c := NewCore(nes)
+	c.SP = 0xFF
+	// RunRoutine's last RTS pulls a return address that was never pushed.
+	c.UninitializedReads = &UninitializedReads{
+		Ignore: []AddressRange{{0x0100, 0x01FF}, {0x0400, 0x04FF}},
+		Output: out,
+	}
+
+	if err := c.RunRoutine(0x8000); err != nil {
+		t.Fatal(err)
+	}
+
+	reads := c.UninitializedReads.Reads
+	if len(reads) != 1 {
+		t.Fatalf("expected 1 read, got %v", reads)
+	}
+	d := reads[0]
+	if d.PC != 0x8010 || d.Address != 0x0300 || d.Label != "Counter" {
+		t.Errorf("unexpected diagnostic: %+v", d)
+	}
+	if len(d.Backtrace) != 2 || d.Backtrace[0].Routine != 0x8010 || d.Backtrace[0].Caller != 0x8000 || d.Backtrace[1].Routine != 0x8000 {
+		t.Errorf("unexpected backtrace: %+v", d.Backtrace)
+	}
+	if !strings.HasPrefix(out.String(), "[$8010] uninitialized read: $0300 Counter\n") {
+		t.Errorf("unexpected output: %q", out)
+	}
+
+	c.WriteByte(0x0300, 0x01)
+	if c.RunRoutine(0x8000); len(c.UninitializedReads.Reads) != 1 {
+		t.Errorf("expected no new reads after a write, got %v", c.UninitializedReads.Reads)
+	}
+
+	c.UninitializedReads.ClearIsInit = true
+	c.HardReset()
+	c.SP = 0xFF
+	if c.RunRoutine(0x8000); len(c.UninitializedReads.Reads) != 1 {
+		t.Errorf("expected no new reads with ClearIsInit, got %v", c.UninitializedReads.Reads)
+	}
+
+	c.UninitializedReads.ClearIsInit = false
+	c.HardReset()
+	c.SP = 0xFF
+	if c.RunRoutine(0x8000); len(c.UninitializedReads.Reads) != 2 {
+		t.Errorf("expected a new read after a reset, got %v", c.UninitializedReads.Reads)
+	}
+}
+
+func TestUninitializedReadsFullRam(t *testing.T) {
+	// LDA $0300, RTS.  Only the loaded image counts as initialized.
+	image := make([]byte, 0x0210)
+	copy(image[0x0200:], []byte{0xAD, 0x00, 0x03, 0x60})
+
+	fr, err := mmu.NewFullRam(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCore(fr)
+	c.SP = 0xFF
+	c.UninitializedReads = &UninitializedReads{Ignore: []AddressRange{{0x0100, 0x01FF}}}
+
+	if c.RunRoutine(0x0200); len(c.UninitializedReads.Reads) != 1 {
+		t.Fatalf("expected 1 read, got %v", c.UninitializedReads.Reads)
+	}
+
+	c.WriteByte(0x0300, 0x01)
+	if c.RunRoutine(0x0200); len(c.UninitializedReads.Reads) != 1 {
+		t.Errorf("expected no new reads after a write, got %v", c.UninitializedReads.Reads)
+	}
+
+	// The program survives a reset, but the write doesn't count anymore.
+	c.HardReset()
+	c.SP = 0xFF
+	if err := c.RunRoutine(0x0200); err != nil {
+		t.Fatal(err)
+	}
+	if reads := c.UninitializedReads.Reads; len(reads) != 2 || reads[1].Address != 0x0300 {
+		t.Errorf("expected $0300 to be read again after a reset, got %v", reads)
+	}
+}